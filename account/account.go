@@ -1,27 +1,74 @@
 package account
 
 import (
+	"crypto/md5"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/jllopis/try5/hasher"
 )
 
+// Account is the stored account. The password hash is never serialized to JSON and
+// is redacted when the account is formatted, so an Account can be logged safely.
 type Account struct {
 	ID       *int64     `json:"-" db:"id"`
 	UID      *string    `json:"uid" db:"uid"`
 	Email    *string    `json:"email" db:"email"`
-	Name     *string    `json:"name, omitempty" db:"name"`
-	Password *string    `json:"password, omitempty" db:"password"`
+	Name     *string    `json:"name,omitempty" db:"name"`
+	Password *string    `json:"-" db:"password"`
 	Active   *bool      `json:"active" db:"active"`
 	Gravatar *string    `json:"gravatar" db:"gravatar"`
 	Created  *time.Time `json:"created" db:"created"`
 	Updated  *time.Time `json:"updated" db:"updated"`
-	Deleted  *bool      `json:"deleted, omitempty" db:"deleted"`
+	Deleted  *bool      `json:"deleted,omitempty" db:"deleted"`
+	// EmailVerified is false while the owner of a new or changed email has not
+	// confirmed it, nil for the accounts created without verification
+	EmailVerified *bool `json:"email_verified,omitempty" db:"email_verified"`
+	// MFA is the second factor, nil if the account never enrolled one
+	MFA *MFA `json:"-" db:"mfa"`
+	// PasswordHistory holds the previous password hashes, the newest first
+	PasswordHistory Hashes `json:"-" db:"password_history"`
+}
+
+// Hashes is a list of password hashes stored as a JSON array
+type Hashes []string
+
+// Value implements driver.Valuer. It returns a string, the drivers send []byte
+// values as binary data.
+func (h Hashes) Value() (driver.Value, error) {
+	if h == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal([]string(h))
+	return string(data), err
+}
+
+// Scan implements sql.Scanner
+func (h *Hashes) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(h))
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(h))
+	}
+	return fmt.Errorf("can not scan %T into Hashes", src)
 }
 
 var (
+	// PasswordHasher hashes the new passwords and verifies the stored ones
+	PasswordHasher = hasher.Default()
+	// Policy is checked on every new password before it is hashed
+	Policy = DefaultPolicy
+
 	ErrInvalidName     = errors.New("invalid name")
 	ErrInvalidPassword = errors.New("invalid password")
 	ErrInvalidEmail    = errors.New("invalid email address")
@@ -31,56 +78,74 @@ var (
 	RegexpEmail = regexp.MustCompile(`^[^@]+@[^@.]+\.[^@.]+`)
 )
 
+// NormalizeEmail returns the form of the email used to compare addresses: without
+// surrounding spaces and in lower case.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NewAccount creates an account with the password hashed. The password must satisfy
+// the Policy.
 func NewAccount(email, name, password string) (*Account, error) {
 	account := &Account{Email: &email, Name: &name}
-	err := account.hashPassword([]byte(password))
-	if err != nil {
+	if err := account.SetPassword(password); err != nil {
 		return nil, err
 	}
 	return account, nil
 }
 
+// SetPassword hashes the password after checking it against the Policy
 func (account *Account) SetPassword(password string) error {
-	if len(password) < 8 || len(password) > 256 {
-		return ErrInvalidPassword
-	}
-
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
+	if err := account.CheckPassword(password); err != nil {
 		return err
 	}
-	if account.Password == nil {
-		s := string(hash)
-		account.Password = &s
-		return nil
-	}
-	*account.Password = string(hash)
-	return nil
+	return account.hashPassword([]byte(password))
+}
+
+// CheckPassword checks password against the Policy as the new password of the
+// account, with its email, name, password hash and history
+func (account *Account) CheckPassword(password string) error {
+	return Policy.Check(password, account)
 }
 
 // Tiene que devolver nil, sino es que hay error
 func (account *Account) hashPassword(password []byte) error {
-	pass, err := bcrypt.GenerateFromPassword(password, 0)
+	pass, err := PasswordHasher.Hash(password)
 	if err != nil {
 		return err
 	}
-	if account.Password == nil {
-		s := string(pass)
-		account.Password = &s
-		return nil
-	}
-	*account.Password = string(pass)
+	account.Password = &pass
 	return nil
 }
 
-func (account *Account) MatchPassword(password string) error {
-	if err := bcrypt.CompareHashAndPassword([]byte(*account.Password), []byte(password)); err != nil {
-		return err
+// MatchPassword checks the password against the stored hash. When it matches but the
+// hash was made with an outdated algorithm or parameters the password is hashed
+// again and rehashed is true, the caller should then store the new hash.
+func (account *Account) MatchPassword(password string) (rehashed bool, err error) {
+	if account.Password == nil {
+		return false, hasher.ErrMismatch
 	}
-	return nil
+	if err := PasswordHasher.Verify(*account.Password, []byte(password)); err != nil {
+		return false, err
+	}
+	if !PasswordHasher.NeedsRehash(*account.Password) {
+		return false, nil
+	}
+	if err := account.hashPassword([]byte(password)); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
+// UpdatePassword checks newPassword against the Policy and hashes it. The stores call
+// it on save, when Password holds the new password and the hash it replaces is
+// already at the head of the history.
 func (account *Account) UpdatePassword(newPassword string) error {
+	check := *account
+	check.Password = nil
+	if err := check.CheckPassword(newPassword); err != nil {
+		return err
+	}
 	err := account.hashPassword([]byte(newPassword))
 	if err != nil {
 		return err
@@ -89,6 +154,19 @@ func (account *Account) UpdatePassword(newPassword string) error {
 	return nil
 }
 
+// RememberPassword adds the hash to the head of the history, which keeps at most
+// MaxPasswordHistory hashes
+func (account *Account) RememberPassword(hash *string) {
+	if hash == nil {
+		return
+	}
+	history := append(Hashes{*hash}, account.PasswordHistory...)
+	if len(history) > MaxPasswordHistory {
+		history = history[:MaxPasswordHistory]
+	}
+	account.PasswordHistory = history
+}
+
 func (account *Account) DeletePassword() error {
 	account.Password = nil
 	return nil
@@ -104,6 +182,63 @@ func (account *Account) Delete() error {
 	return nil
 }
 
+// redacted replaces the secrets when an account is formatted
+const redacted = "[REDACTED]"
+
+// String formats the account without the password hash
+func (a *Account) String() string {
+	if a == nil {
+		return "<nil>"
+	}
+	password := "<nil>"
+	if a.Password != nil {
+		password = redacted
+	}
+	return fmt.Sprintf("Account{UID:%s Email:%s Name:%s Password:%s Active:%s Created:%s Updated:%s}",
+		format(a.UID), format(a.Email), format(a.Name), password, format(a.Active), format(a.Created), format(a.Updated))
+}
+
+// Unverified tells if the email of the account is waiting to be confirmed
+func (a *Account) Unverified() bool {
+	return a.EmailVerified != nil && !*a.EmailVerified
+}
+
+// GravatarURL returns the gravatar of the account, the one set or else the one of
+// its email with the size in pixels
+func (a *Account) GravatarURL(size int) string {
+	if a.Gravatar != nil && *a.Gravatar != "" {
+		return *a.Gravatar
+	}
+	if a.Email == nil {
+		return ""
+	}
+	sum := md5.Sum([]byte(NormalizeEmail(*a.Email)))
+	return fmt.Sprintf(GravatarURI, hex.EncodeToString(sum[:]), size)
+}
+
+// GoString is used by the %#v verb, it also hides the password hash
+func (a *Account) GoString() string {
+	return a.String()
+}
+
+func format(v interface{}) string {
+	switch p := v.(type) {
+	case *string:
+		if p != nil {
+			return *p
+		}
+	case *bool:
+		if p != nil {
+			return fmt.Sprint(*p)
+		}
+	case *time.Time:
+		if p != nil {
+			return p.Format(time.RFC3339)
+		}
+	}
+	return "<nil>"
+}
+
 func (a *Account) ValidateFields() error {
 	switch {
 	case a.Name == nil:
@@ -1,8 +1,12 @@
 package account
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
+
+	"github.com/jllopis/try5/hasher"
 )
 
 func TestAccount(t *testing.T) {
@@ -12,3 +16,135 @@ func TestAccount(t *testing.T) {
 	}
 	fmt.Printf("%#v\n", account)
 }
+
+func TestPasswordRedacted(t *testing.T) {
+	account, err := NewAccount("testuser@dom.local", "Test Account", "SuperDifficultPass")
+	if err != nil {
+		t.Fatal("Error creating account: ", err)
+	}
+	data, err := json.Marshal(account)
+	if err != nil {
+		t.Fatal("Error marshaling account: ", err)
+	}
+	for _, out := range []string{string(data), fmt.Sprintf("%v", account), fmt.Sprintf("%#v", account)} {
+		if strings.Contains(out, *account.Password) {
+			t.Fatal("Password hash leaked: ", out)
+		}
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	email, name := "john.smith@dom.local", "John Smith"
+	a := &Account{Email: &email, Name: &name}
+	policy, err := NewPasswordPolicy(PolicyConfig{MinClasses: 3, History: 2})
+	if err != nil {
+		t.Fatal("Error creating policy: ", err)
+	}
+	policy.Rules = append(policy.Rules, Banned(map[string]struct{}{"correct-horse-1": {}}))
+	tests := []struct {
+		password string
+		want     error
+	}{
+		{"Sh0rt!", ErrPasswordTooShort},
+		{"alllowercase", ErrPasswordClasses},
+		{"Smith-1234", ErrPasswordSimilar},
+		{"Correct-Horse-1", ErrPasswordBanned},
+		{"Tr0ub4dor&3", nil},
+	}
+	for _, test := range tests {
+		if err := policy.Check(test.password, a); err != test.want {
+			t.Fatalf("Check(%q) = %v, want %v", test.password, err, test.want)
+		}
+	}
+
+	for _, p := range []string{"First-Pass-1", "Second-Pass-2", "Third-Pass-3"} {
+		a.RememberPassword(a.Password)
+		if err := a.hashPassword([]byte(p)); err != nil {
+			t.Fatal("Error hashing password: ", err)
+		}
+	}
+	if err := policy.Check("Third-Pass-3", a); err != ErrPasswordReused {
+		t.Fatal("Current password reused: ", err)
+	}
+	if err := policy.Check("Second-Pass-2", a); err != ErrPasswordReused {
+		t.Fatal("Previous password reused: ", err)
+	}
+	if err := policy.Check("First-Pass-1", a); err != nil {
+		t.Fatal("Password out of the history rejected: ", err)
+	}
+
+	if _, err := NewPasswordPolicy(PolicyConfig{History: MaxPasswordHistory + 1}); err != ErrHistoryTooLong {
+		t.Fatal("History longer than the hashes kept accepted: ", err)
+	}
+}
+
+func TestNotSimilar(t *testing.T) {
+	email, name := "correcthorsebattery@dom.local", "Jonathan Smithers"
+	a := &Account{Email: &email, Name: &name}
+	for _, p := range []string{"CorrectHorseBattery!", "xx-Smithers-xx", "Jonathan"} {
+		if err := NotSimilar().Check(p, a); err != ErrPasswordSimilar {
+			t.Fatalf("Check(%q) = %v, want %v", p, err, ErrPasswordSimilar)
+		}
+	}
+	// a password inside the email or the name does not reveal them
+	for _, p := range []string{"horsebattery", "nathan"} {
+		if err := NotSimilar().Check(p, a); err != nil {
+			t.Fatalf("Check(%q) = %v, want nil", p, err)
+		}
+	}
+}
+
+func TestUpdatePasswordPolicy(t *testing.T) {
+	defer func(p *PasswordPolicy) { Policy = p }(Policy)
+	var err error
+	if Policy, err = NewPasswordPolicy(PolicyConfig{MinClasses: 3, History: 2}); err != nil {
+		t.Fatal("Error creating policy: ", err)
+	}
+	if _, err := NewAccount("testuser@dom.local", "Test Account", "superdifficultpass"); err != ErrPasswordClasses {
+		t.Fatal("NewAccount not checked against the policy: ", err)
+	}
+	a, err := NewAccount("testuser@dom.local", "Test Account", "Super-Difficult-1")
+	if err != nil {
+		t.Fatal("Error creating account: ", err)
+	}
+	// like the stores do: the current hash goes to the history and the new password is hashed
+	next := "Super-Difficult-1"
+	a.RememberPassword(a.Password)
+	a.Password = &next
+	if err := a.UpdatePassword(next); err != ErrPasswordReused {
+		t.Fatal("Current password reused: ", err)
+	}
+	if err := a.UpdatePassword("superdifficultpass"); err != ErrPasswordClasses {
+		t.Fatal("UpdatePassword not checked against the policy: ", err)
+	}
+	if err := a.UpdatePassword("Another-Difficult-2"); err != nil {
+		t.Fatal("Error updating password: ", err)
+	}
+}
+
+func TestRehashOnMatch(t *testing.T) {
+	defer func(h *hasher.Manager) { PasswordHasher = h }(PasswordHasher)
+	PasswordHasher = hasher.New(hasher.NewBcrypt(4))
+	account, err := NewAccount("testuser@dom.local", "Test Account", "SuperDifficultPass")
+	if err != nil {
+		t.Fatal("Error creating account: ", err)
+	}
+	if rehashed, err := account.MatchPassword("SuperDifficultPass"); err != nil || rehashed {
+		t.Fatal("Unexpected rehash or error: ", rehashed, err)
+	}
+	if _, err := account.MatchPassword("WrongPass"); err == nil {
+		t.Fatal("Wrong password matched")
+	}
+
+	PasswordHasher = hasher.New(hasher.NewArgon2id(1024, 1, 1))
+	rehashed, err := account.MatchPassword("SuperDifficultPass")
+	if err != nil || !rehashed {
+		t.Fatal("Outdated bcrypt hash not upgraded: ", rehashed, err)
+	}
+	if hasher.Identify(*account.Password) != "argon2id" {
+		t.Fatal("Password not rehashed with argon2id: ", *account.Password)
+	}
+	if rehashed, err := account.MatchPassword("SuperDifficultPass"); err != nil || rehashed {
+		t.Fatal("Upgraded hash does not match: ", rehashed, err)
+	}
+}
@@ -0,0 +1,108 @@
+package account
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql/driver"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RecoveryCodes is the number of recovery codes generated when the second factor is confirmed
+const RecoveryCodes = 10
+
+// MFA is the second factor of an account. Secret is the TOTP secret encrypted by the
+// server, and RecoveryCodes hold the SHA-256 of the unused recovery codes.
+type MFA struct {
+	Secret string `json:"secret"`
+	// Enabled is false from the enrolment until the first code is confirmed
+	Enabled   bool       `json:"enabled"`
+	Confirmed *time.Time `json:"confirmed,omitempty"`
+	// LastCounter is the TOTP period of the last accepted code, so a code can not be used twice
+	LastCounter   int64    `json:"last_counter"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// MFAEnabled tells if the account needs a second factor to authenticate
+func (a *Account) MFAEnabled() bool {
+	return a.MFA != nil && a.MFA.Enabled
+}
+
+// Value implements driver.Valuer, a nil MFA is stored as NULL
+func (m *MFA) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+// Scan implements sql.Scanner
+func (m *MFA) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	}
+	return fmt.Errorf("can not scan %T into MFA", src)
+}
+
+// NewRecoveryCodes generates n recovery codes. It returns the codes to show to the
+// user and their hashes to store.
+func NewRecoveryCodes(n int) (codes []string, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores the case and the separators. The codes are random so a
+// plain hash is enough.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Same tells if m and o are the same second factor in the same state: the same
+// secret, the same last counter and the same unused recovery codes
+func (m *MFA) Same(o *MFA) bool {
+	if m == nil || o == nil {
+		return m == o
+	}
+	if m.Secret != o.Secret || m.Enabled != o.Enabled || m.LastCounter != o.LastCounter ||
+		len(m.RecoveryCodes) != len(o.RecoveryCodes) {
+		return false
+	}
+	for i := range m.RecoveryCodes {
+		if m.RecoveryCodes[i] != o.RecoveryCodes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// UseRecoveryCode removes the code from the unused ones. It returns false if the
+// code is not one of them.
+func (m *MFA) UseRecoveryCode(code string) bool {
+	h := []byte(hashRecoveryCode(code))
+	for i, stored := range m.RecoveryCodes {
+		if subtle.ConstantTimeCompare(h, []byte(stored)) == 1 {
+			m.RecoveryCodes = append(m.RecoveryCodes[:i:i], m.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+package account
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// MaxPasswordHistory is the number of previous password hashes kept per account
+const MaxPasswordHistory = 24
+
+// ErrHistoryTooLong is returned by NewPasswordPolicy for a History longer than the
+// hashes kept, the older passwords could be reused
+var ErrHistoryTooLong = fmt.Errorf("the password history can not be longer than %d", MaxPasswordHistory)
+
+// PolicyError is returned when a password does not satisfy a rule of the policy.
+// Code identifies the rule.
+type PolicyError struct {
+	Code    string
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+var (
+	ErrPasswordTooShort = &PolicyError{Code: "password_too_short", Message: "password too short"}
+	ErrPasswordTooLong  = &PolicyError{Code: "password_too_long", Message: "password too long"}
+	ErrPasswordClasses  = &PolicyError{Code: "password_missing_classes", Message: "password must mix more kinds of characters"}
+	ErrPasswordBanned   = &PolicyError{Code: "password_banned", Message: "password too common"}
+	ErrPasswordSimilar  = &PolicyError{Code: "password_similar", Message: "password too similar to the email or the name"}
+	ErrPasswordReused   = &PolicyError{Code: "password_reused", Message: "password used recently"}
+)
+
+// Rule is a check applied to a new password of the account a. The account carries
+// the email and name being saved and, when it already exists, its current password
+// hash and history.
+type Rule interface {
+	Check(password string, a *Account) error
+}
+
+// RuleFunc adapts a function to the Rule interface
+type RuleFunc func(password string, a *Account) error
+
+func (f RuleFunc) Check(password string, a *Account) error {
+	return f(password, a)
+}
+
+// PasswordPolicy is the set of rules every new password must satisfy
+type PasswordPolicy struct {
+	Rules []Rule
+}
+
+// DefaultPolicy only checks the length and the similarity to the account data
+var DefaultPolicy = &PasswordPolicy{Rules: []Rule{MinLength(8), MaxLength(256), NotSimilar()}}
+
+// PolicyConfig holds the settings to build a PasswordPolicy with NewPasswordPolicy
+type PolicyConfig struct {
+	MinLength int
+	MaxLength int
+	// MinClasses is the number of character classes (lower case, upper case,
+	// digits and symbols) the password must contain
+	MinClasses int
+	// BannedFile is a file with a banned password per line
+	BannedFile string
+	// History is the number of previous passwords that can not be reused
+	History int
+}
+
+// NewPasswordPolicy builds the policy described by c. The zero values disable the
+// rules, except the lengths that default to 8 and 256. c.History can not be longer
+// than MaxPasswordHistory.
+func NewPasswordPolicy(c PolicyConfig) (*PasswordPolicy, error) {
+	if c.History > MaxPasswordHistory {
+		return nil, ErrHistoryTooLong
+	}
+	if c.MinLength == 0 {
+		c.MinLength = 8
+	}
+	if c.MaxLength == 0 {
+		c.MaxLength = 256
+	}
+	p := &PasswordPolicy{Rules: []Rule{MinLength(c.MinLength), MaxLength(c.MaxLength), NotSimilar()}}
+	if c.MinClasses > 0 {
+		p.Rules = append(p.Rules, CharClasses(c.MinClasses))
+	}
+	if c.BannedFile != "" {
+		banned, err := LoadBannedPasswords(c.BannedFile)
+		if err != nil {
+			return nil, err
+		}
+		p.Rules = append(p.Rules, Banned(banned))
+	}
+	if c.History > 0 {
+		p.Rules = append(p.Rules, NotReused(c.History))
+	}
+	return p, nil
+}
+
+// Check returns the error of the first rule the password does not satisfy
+func (p *PasswordPolicy) Check(password string, a *Account) error {
+	for _, r := range p.Rules {
+		if err := r.Check(password, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MinLength rejects the passwords shorter than n characters
+func MinLength(n int) Rule {
+	return RuleFunc(func(password string, a *Account) error {
+		if len([]rune(password)) < n {
+			return ErrPasswordTooShort
+		}
+		return nil
+	})
+}
+
+// MaxLength rejects the passwords longer than n characters
+func MaxLength(n int) Rule {
+	return RuleFunc(func(password string, a *Account) error {
+		if len([]rune(password)) > n {
+			return ErrPasswordTooLong
+		}
+		return nil
+	})
+}
+
+// CharClasses requires n of the classes lower case, upper case, digit and symbol
+func CharClasses(n int) Rule {
+	return RuleFunc(func(password string, a *Account) error {
+		var lower, upper, digit, symbol int
+		for _, r := range password {
+			switch {
+			case unicode.IsLower(r):
+				lower = 1
+			case unicode.IsUpper(r):
+				upper = 1
+			case unicode.IsDigit(r):
+				digit = 1
+			default:
+				symbol = 1
+			}
+		}
+		if lower+upper+digit+symbol < n {
+			return ErrPasswordClasses
+		}
+		return nil
+	})
+}
+
+// Banned rejects the passwords in the list, compared case insensitively
+func Banned(list map[string]struct{}) Rule {
+	return RuleFunc(func(password string, a *Account) error {
+		if _, ok := list[strings.ToLower(password)]; ok {
+			return ErrPasswordBanned
+		}
+		return nil
+	})
+}
+
+// LoadBannedPasswords reads a file with a password per line. The empty lines and
+// those starting with # are skipped.
+func LoadBannedPasswords(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	list := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// minSimilarToken is the length of the shortest part of the email or name checked
+// by NotSimilar, shorter parts would reject too many passwords
+const minSimilarToken = 4
+
+// NotSimilar rejects the passwords that contain the email, its user or a word of the
+// name, compared case insensitively
+func NotSimilar() Rule {
+	return RuleFunc(func(password string, a *Account) error {
+		password = strings.ToLower(password)
+		var tokens []string
+		if a.Email != nil {
+			email := NormalizeEmail(*a.Email)
+			tokens = append(tokens, email, strings.Split(email, "@")[0])
+		}
+		if a.Name != nil {
+			name := strings.ToLower(*a.Name)
+			tokens = append(tokens, name)
+			tokens = append(tokens, strings.FieldsFunc(name, func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			})...)
+		}
+		for _, t := range tokens {
+			if len(t) < minSimilarToken {
+				continue
+			}
+			if strings.Contains(password, t) {
+				return ErrPasswordSimilar
+			}
+		}
+		return nil
+	})
+}
+
+// NotReused rejects the current password and the n-1 previous ones
+func NotReused(n int) Rule {
+	return RuleFunc(func(password string, a *Account) error {
+		var hashes []string
+		if a.Password != nil {
+			hashes = append(hashes, *a.Password)
+		}
+		hashes = append(hashes, a.PasswordHistory...)
+		if len(hashes) > n {
+			hashes = hashes[:n]
+		}
+		for _, h := range hashes {
+			if PasswordHasher.Verify(h, []byte(password)) == nil {
+				return ErrPasswordReused
+			}
+		}
+		return nil
+	})
+}
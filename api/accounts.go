@@ -4,22 +4,91 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jllopis/aloja"
 	"github.com/jllopis/try5/account"
-	"github.com/lib/pq"
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/store"
 )
 
-// GetAllAccounts devuelve una lista con todos los accounts de la base de datos
-// curl -ks https://b2d:8000/v1/accounts | jp -
+// GetAllAccounts devuelve una página de accounts. Admite los parámetros
+//
+//	limit          número de accounts por página (por defecto 50, máximo 500)
+//	cursor         valor de X-Next-Cursor de la página anterior
+//	sort           created, email o name. Con el prefijo - el orden es descendente
+//	active         true o false
+//	email          prefijo del email
+//	created_after  fecha RFC3339, inclusive
+//	created_before fecha RFC3339, inclusive
+//	q              texto contenido en el nombre
+//
+// Si hay más resultados se devuelven las cabeceras X-Next-Cursor y Link con la url
+// de la página siguiente.
+// curl -ks 'https://b2d:8000/v1/accounts?limit=10&sort=-created&active=true' | jp -
 func (ctx *ApiContext) GetAllAccounts(w http.ResponseWriter, r *http.Request) {
-	var res []*account.Account
-	var err error
-	if res, err = ctx.DB.LoadAllAccounts(); err != nil {
-		ctx.Render.JSON(w, http.StatusInternalServerError, err.Error())
+	q, err := accountQuery(r.URL.Query())
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	page, err := ctx.DB.ListAccounts(q)
+	if err != nil {
+		ctx.renderError(w, r, err)
 		return
 	}
-	ctx.Render.JSON(w, http.StatusOK, res)
+	if page.NextCursor != "" {
+		next := *r.URL
+		params := next.Query()
+		params.Set("cursor", page.NextCursor)
+		next.RawQuery = params.Encode()
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+	ctx.Render.JSON(w, http.StatusOK, newAccountList(page.Accounts))
+}
+
+// accountQuery construye la consulta de GetAllAccounts a partir de los parámetros de la url
+func accountQuery(params url.Values) (*store.AccountQuery, error) {
+	q := &store.AccountQuery{
+		Cursor:      params.Get("cursor"),
+		EmailPrefix: params.Get("email"),
+		Name:        params.Get("q"),
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, invalidParam("limit")
+		}
+		q.Limit = n
+	}
+	if v := params.Get("sort"); v != "" {
+		if strings.HasPrefix(v, "-") {
+			q.Desc = true
+			v = v[1:]
+		}
+		q.Sort = store.SortField(v)
+	}
+	if v := params.Get("active"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, invalidParam("active")
+		}
+		q.Active = &b
+	}
+	for name, t := range map[string]**time.Time{"created_after": &q.CreatedAfter, "created_before": &q.CreatedBefore} {
+		if v := params.Get(name); v != "" {
+			d, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, invalidParam(name)
+			}
+			*t = &d
+		}
+	}
+	return q, nil
 }
 
 // GetAccountByID devuelve el account de la base de datos que coincide con el ID suministrado
@@ -29,90 +98,122 @@ func (ctx *ApiContext) GetAccountByID(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var uid string
 	if uid = aloja.Params(r).ByName("uid"); uid == "" {
-		ctx.Render.JSON(w, http.StatusInternalServerError, &logMessage{Status: "error", Action: "get", Info: "uid cannot be nil"})
+		ctx.renderError(w, r, errMissingUID)
 		return
 	}
 	if res, err = ctx.DB.LoadAccount(uid); err != nil {
-		logger.Info("GetAccountByID", "error", "account not found", "uid", uid)
-		ctx.Render.JSON(w, http.StatusNotFound, &logMessage{Status: "error", Action: "get", Info: err.Error(), Table: "accounts", UID: uid})
+		logger.Info("GetAccountByID", "error", err, "uid", uid)
+		ctx.renderError(w, r, err)
 		return
 	}
-	ctx.Render.JSON(w, http.StatusOK, res)
+	ctx.Render.JSON(w, http.StatusOK, newAccountResponse(res))
 }
 
-// NewAccount crea un nuevo account.
-// curl -k https://b2d:8000/v1/accounts -X POST -d '{"email":"tu2@test.com","name":"test user 2","password":"1234","active":true}'
+// NewAccount crea un nuevo account. Si el email ya pertenece a otro account devuelve 409.
+// La password es obligatoria, nunca se devuelve y debe cumplir la política de passwords.
+// Con la verificación de emails activada el account se crea con email_verified=false y se
+// envía al email un enlace para confirmarlo (ver VerifyAccount).
+// curl -k https://b2d:8000/v1/accounts -X POST -H "Authorization: Bearer ..." -d '{"email":"tu2@test.com","name":"test user 2","password":"Tr0ub4dor&3","active":true}'
 func (ctx *ApiContext) NewAccount(w http.ResponseWriter, r *http.Request) {
-	var data account.Account
-	err := json.NewDecoder(r.Body).Decode(&data)
+	var body accountRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
-		ctx.Render.JSON(w, http.StatusInternalServerError, &logMessage{Status: "error", Action: "create", Info: err.Error(), Table: "accounts"})
+		ctx.renderError(w, r, errInvalidBody)
 		return
 	}
+	data := body.account()
 	if err = data.ValidateFields(); err != nil {
-		ctx.Render.JSON(w, http.StatusBadRequest, &logMessage{Status: "error", Action: "create", Info: err.Error(), Table: "accounts"})
+		ctx.renderError(w, r, err)
 		return
 	}
-	if outdata, err := ctx.DB.SaveAccount(&data); err != nil {
-		if _, ok := err.(*pq.Error); ok {
-			ctx.Render.JSON(w, http.StatusInternalServerError, &logMessage{Status: "error", Action: "create", Info: err.(*pq.Error).Detail, Table: err.(*pq.Error).Table, Code: string(err.(*pq.Error).Code)})
-		} else {
-			ctx.Render.JSON(w, http.StatusInternalServerError, &logMessage{Status: "error", Action: "create", Info: err.Error(), Table: "accounts", Code: "500"})
-		}
-		logger.Error("func NewAccount", "error", err)
+	// only the accounts authenticated somewhere else have no password
+	if data.Password == nil {
+		ctx.renderError(w, r, errMissingPassword)
 		return
-	} else {
-		ctx.Render.JSON(w, http.StatusCreated, outdata)
 	}
+	ctx.unverified(data)
+	outdata, err := ctx.DB.SaveAccount(data)
+	if err != nil {
+		logger.Info("func NewAccount", "error", err)
+		ctx.renderError(w, r, err)
+		return
+	}
+	// the account exists already, a failure is only logged
+	if err := ctx.startVerification(outdata); err != nil {
+		logger.Error("func NewAccount", "error", err, "uid", *outdata.UID, "info", "verification email not sent")
+	}
+	ctx.Render.JSON(w, http.StatusCreated, newAccountResponse(outdata))
 }
 
 // UpdateAccount actualiza los datos del account y devuelve el objeto actualizado.
-// curl -ks https://b2d:8000/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a -X PUT -d '{}' | jp -
+// Si se envía una password nueva debe cumplir la política de passwords, y se revocan los
+// refresh tokens y los tokens OAuth del account. Si quien la cambia es el propio account debe enviar también
+// la actual en current_password; los fallos cuentan para el bloqueo del account. Con la
+// verificación de emails activada, cambiar el email obliga a verificarlo de nuevo.
+// curl -ks https://b2d:8000/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a -X PUT -H "Authorization: Bearer ..." -d '{"email":"tu2@test.com","name":"test user 2","password":"N3w&Tr0ub4dor","current_password":"Tr0ub4dor&3"}' | jp -
 func (ctx *ApiContext) UpdateAccount(w http.ResponseWriter, r *http.Request) {
-	var newdata account.Account
+	var body accountRequest
 	var err error
 	var uid string
 	if uid = aloja.Params(r).ByName("uid"); uid == "" {
-		ctx.Render.JSON(w, http.StatusInternalServerError, &logMessage{Status: "error", Action: "update", Info: "uid cannot be nil", Table: "accounts"})
+		ctx.renderError(w, r, errMissingUID)
 		return
 	}
-	err = json.NewDecoder(r.Body).Decode(&newdata)
+	err = json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
-		ctx.Render.JSON(w, http.StatusInternalServerError, &logMessage{Status: "error", Action: "update", Info: err.Error(), Table: "accounts"})
 		logger.Error("func UpdateAccount", "error", err.Error())
+		ctx.renderError(w, r, errInvalidBody)
 		return
 	}
+	newdata := body.account()
 	if err = newdata.ValidateFields(); err != nil {
-		ctx.Render.JSON(w, http.StatusBadRequest, &logMessage{Status: "error", Action: "update", Info: err.Error(), Table: "accounts"})
+		ctx.renderError(w, r, err)
 		return
 	}
 	if logger.IsDebug() {
 		logger.Info("func UpdateAccount", "updated register", uid)
 	}
 
-	// TODO if newdata.UID is nil check if register exist in db _before_ update.
-	// If do not exist, return error and quit
 	if newdata.UID == nil {
 		newdata.UID = &uid
-	} else {
-		if *newdata.UID != uid {
-			ctx.Render.JSON(w, http.StatusInternalServerError, &logMessage{Status: "error",
-				Action: "update",
-				Info:   fmt.Sprintf("provided uid's does not match: body: %v - path: %v", *newdata.UID, uid),
-				Table:  "accounts"})
-			logger.Error("func UpdateAccount", "error", "uid's does not match", "body", *newdata.UID, "path", uid)
+	} else if *newdata.UID != uid {
+		logger.Error("func UpdateAccount", "error", "uid's does not match", "body", *newdata.UID, "path", uid)
+		ctx.renderError(w, r, errUIDMismatch)
+		return
+	}
+	saved, err := ctx.DB.LoadAccount(uid)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	// the owner proves the current password, a stolen token or cookie is not enough
+	if newdata.Password != nil && isOwner(r, uid) {
+		if err = ctx.checkCurrentPassword(r, saved, body.CurrentPassword); err != nil {
+			ctx.renderError(w, r, err)
 			return
 		}
 	}
-	if _, err := ctx.DB.SaveAccount(&newdata); err != nil {
-		ctx.Render.JSON(w, http.StatusInternalServerError, err.Error())
+	// a new email has to be verified again
+	reverify := ctx.VerifyEmail && account.NormalizeEmail(*saved.Email) != account.NormalizeEmail(*newdata.Email)
+	if reverify {
+		ctx.unverified(newdata)
+	}
+	if _, err := ctx.DB.SaveAccount(newdata); err != nil {
 		logger.Error("func UpdateAccount", "error", err.Error())
+		ctx.renderError(w, r, err)
 		return
-	} else {
-		logger.Info("func UpdateAccount", "updated", "ok", "uid", *newdata.UID)
-		ctx.Render.JSON(w, http.StatusOK, newdata)
-		return
 	}
+	if newdata.Password != nil {
+		ctx.revokeRefreshTokens(uid)
+		ctx.revokeOAuthTokens(uid)
+	}
+	if reverify {
+		if err := ctx.startVerification(newdata); err != nil {
+			logger.Error("func UpdateAccount", "error", err, "uid", uid, "info", "verification email not sent")
+		}
+	}
+	logger.Info("func UpdateAccount", "updated", "ok", "uid", *newdata.UID)
+	ctx.Render.JSON(w, http.StatusOK, newAccountResponse(newdata))
 }
 
 // DeleteAccount elimina el account solicitado.
@@ -120,22 +221,49 @@ func (ctx *ApiContext) UpdateAccount(w http.ResponseWriter, r *http.Request) {
 func (ctx *ApiContext) DeleteAccount(w http.ResponseWriter, r *http.Request) {
 	var uid string
 	if uid = aloja.Params(r).ByName("uid"); uid == "" {
-		ctx.Render.JSON(w, http.StatusInternalServerError, &logMessage{Status: "error", Action: "delete", Info: "uid cannot be nil", Table: "accounts"})
+		ctx.renderError(w, r, errMissingUID)
 		return
 	}
-	if n, err := ctx.DB.DeleteAccount(uid); err != nil {
-		ctx.Render.JSON(w, http.StatusInternalServerError, err.Error())
+	n, err := ctx.DB.DeleteAccount(uid)
+	if err != nil {
 		logger.Error("func DeleteAccount", "error", err)
+		ctx.renderError(w, r, err)
 		return
-	} else {
-		switch n {
-		case 0:
-			logger.Info("func DeleteAccount", "error", "uid no encontrado", "uid", uid)
-			ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "error", Action: "delete", Info: "no se ha encontrado el registro", Table: "accounts", Code: "RNF-11", UID: uid})
-		default:
-			logger.Info("func DeleteAccount", "registro eliminado", uid)
-			ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Info: uid, Table: "accounts", UID: uid})
-		}
+	}
+	if n == 0 {
+		logger.Info("func DeleteAccount", "error", "uid no encontrado", "uid", uid)
+		ctx.renderError(w, r, store.ErrAccountNotFound)
 		return
 	}
+	logger.Info("func DeleteAccount", "registro eliminado", uid)
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Info: uid, Table: "accounts", UID: uid})
+}
+
+// isOwner tells if the authenticated account of the request is the account uid
+func isOwner(r *http.Request, uid string) bool {
+	acc := CurrentAccount(r)
+	return acc != nil && acc.UID != nil && *acc.UID == uid
+}
+
+// checkCurrentPassword verifies the current password sent by the owner of the account
+// a. The wrong ones are counted as failed authentications of the account.
+func (ctx *ApiContext) checkCurrentPassword(r *http.Request, a *account.Account, current *string) error {
+	if current == nil || *current == "" {
+		return errMissingCurrentPassword
+	}
+	keys := []string{lockout.AccountKey(*a.Email)}
+	wait, err := ctx.lockedOut(keys)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return errTooManyAttempts
+	}
+	// the rehash is not stored, the account is saved with the new password anyway
+	if _, err := a.MatchPassword(*current); err != nil {
+		logger.Info("func UpdateAccount", "error", "wrong current password", "uid", *a.UID)
+		ctx.countFailure(keys)
+		return errWrongCurrentPassword
+	}
+	return nil
 }
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/lockout"
+)
+
+func TestAccountAccess(t *testing.T) {
+	s := newTestServer(t, nil)
+	admin := s.account("admin@example.com", "Correct horse battery 1", "*")
+	user := s.account("jdoe@example.com", "Correct horse battery 2")
+	other := s.account("other@example.com", "Correct horse battery 3")
+	adminToken, userToken := s.token(admin), s.token(user)
+	otherPath := "/api/v1/accounts/" + *other.UID
+	email, name := "taken@example.com", "Taken Over"
+
+	// the accounts of others are out of reach without the permissions
+	refused := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{"GET", "/api/v1/accounts", nil},
+		{"GET", otherPath, nil},
+		{"PUT", otherPath, &accountRequest{Email: &email, Name: &name}},
+		{"DELETE", otherPath, nil},
+		{"POST", "/api/v1/accounts", &accountRequest{Email: &email, Name: &name}},
+	}
+	for _, r := range refused {
+		var e apiError
+		if res := s.do(r.method, r.path, userToken, r.body, &e); res.StatusCode != http.StatusForbidden || e.Code != "permission_denied" {
+			t.Fatalf("%s %s not refused: %d %+v", r.method, r.path, res.StatusCode, e)
+		}
+	}
+	if acc, err := s.ctx.DB.LoadAccount(*other.UID); err != nil || *acc.Email != "other@example.com" {
+		t.Fatal("Account of another user changed: ", err)
+	}
+
+	// an account reads and updates itself
+	ownPath := "/api/v1/accounts/" + *user.UID
+	if res := s.do("GET", ownPath, userToken, nil, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Own account not readable: ", res.StatusCode)
+	}
+	newName := "Jane Doe"
+	if res := s.do("PUT", ownPath, userToken, &accountRequest{Email: user.Email, Name: &newName}, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Own account not updatable: ", res.StatusCode)
+	}
+
+	// the permissions give access to every account
+	var list []accountResponse
+	if res := s.do("GET", "/api/v1/accounts", adminToken, nil, &list); res.StatusCode != http.StatusOK || len(list) != 3 {
+		t.Fatal("Admin can not list the accounts: ", res.StatusCode, len(list))
+	}
+	if res := s.do("GET", otherPath, adminToken, nil, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Admin can not read another account: ", res.StatusCode)
+	}
+	if res := s.do("DELETE", otherPath, adminToken, nil, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Admin can not delete another account: ", res.StatusCode)
+	}
+}
+
+func TestAccountPermissions(t *testing.T) {
+	s := newTestServer(t, nil)
+	reader := s.account("reader@example.com", "Correct horse battery 1", "accounts:read")
+	editor := s.account("editor@example.com", "Correct horse battery 2", "accounts:*")
+	other := s.account("other@example.com", "Correct horse battery 3")
+	readerToken, editorToken := s.token(reader), s.token(editor)
+	otherPath := "/api/v1/accounts/" + *other.UID
+	name := "New Name"
+
+	if res := s.do("GET", "/api/v1/accounts", readerToken, nil, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("accounts:read can not list the accounts: ", res.StatusCode)
+	}
+	if res := s.do("GET", otherPath, readerToken, nil, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("accounts:read can not read another account: ", res.StatusCode)
+	}
+	for _, method := range []string{"PUT", "DELETE"} {
+		if res := s.do(method, otherPath, readerToken, &accountRequest{Email: other.Email, Name: &name}, nil); res.StatusCode != http.StatusForbidden {
+			t.Fatalf("accounts:read allowed %s: %d", method, res.StatusCode)
+		}
+	}
+	if res := s.do("PUT", otherPath, editorToken, &accountRequest{Email: other.Email, Name: &name}, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("accounts:* can not update another account: ", res.StatusCode)
+	}
+	if acc, err := s.ctx.DB.LoadAccount(*other.UID); err != nil || *acc.Name != name {
+		t.Fatal("Account not updated: ", err)
+	}
+}
+
+func TestUpdateOwnPassword(t *testing.T) {
+	s := newTestServer(t, func(ctx *ApiContext) {
+		ctx.Lockout = lockout.Policy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	})
+	admin := s.account("admin@example.com", "Correct horse battery 1", "accounts:write")
+	user := s.account("jdoe@example.com", "Correct horse battery 2")
+	path := "/api/v1/accounts/" + *user.UID
+	password, wrong, current := "Correct horse battery 9", "Wrong horse battery 2", "Correct horse battery 2"
+
+	// the token alone does not change the password, the current one is required
+	refused := []struct {
+		current *string
+		status  int
+		code    string
+	}{
+		{nil, http.StatusBadRequest, "missing_current_password"},
+		{&wrong, http.StatusForbidden, "wrong_current_password"},
+	}
+	for _, r := range refused {
+		var e apiError
+		body := &accountRequest{Email: user.Email, Name: user.Name, Password: &password, CurrentPassword: r.current}
+		if res := s.do("PUT", path, s.token(user), body, &e); res.StatusCode != r.status || e.Code != r.code {
+			t.Fatalf("Password changed without the current one: %d %+v", res.StatusCode, e)
+		}
+	}
+	if acc, _ := s.ctx.DB.LoadAccount(*user.UID); acc == nil || acc.Password == nil {
+		t.Fatal("Error loading account")
+	} else if _, err := acc.MatchPassword(current); err != nil {
+		t.Fatal("Password changed by a refused request: ", err)
+	}
+
+	// with the current password the owner changes it, once the delay of the failure is over
+	time.Sleep(10 * time.Millisecond)
+	body := &accountRequest{Email: user.Email, Name: user.Name, Password: &password, CurrentPassword: &current}
+	if res := s.do("PUT", path, s.token(user), body, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Own password not changed: ", res.StatusCode)
+	}
+	// and an admin changes it without it
+	body = &accountRequest{Email: user.Email, Name: user.Name, Password: &current}
+	if res := s.do("PUT", path, s.token(admin), body, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Admin can not change the password: ", res.StatusCode)
+	}
+}
+
+func TestPasswordPolicyEnforced(t *testing.T) {
+	defer func(p *account.PasswordPolicy) { account.Policy = p }(account.Policy)
+	var err error
+	if account.Policy, err = account.NewPasswordPolicy(account.PolicyConfig{MinClasses: 3, History: 2}); err != nil {
+		t.Fatal("Error creating policy: ", err)
+	}
+	s := newTestServer(t, nil)
+	admin := s.account("admin@example.com", "Correct horse battery 1", "accounts:write")
+	bearer := s.token(admin)
+
+	// the configured policy, not the default one, is checked on create and update
+	email, name, weak := "jdoe@example.com", "Jane Doe", "correcthorsebattery"
+	var e apiError
+	// the stores take accounts without password, the api does not
+	if res := s.do("POST", "/api/v1/accounts", bearer, &accountRequest{Email: &email, Name: &name}, &e); res.StatusCode != http.StatusBadRequest || e.Code != "missing_password" {
+		t.Fatalf("Account created without password: %d %+v", res.StatusCode, e)
+	}
+	if res := s.do("POST", "/api/v1/accounts", bearer, &accountRequest{Email: &email, Name: &name, Password: &weak}, &e); res.StatusCode != http.StatusBadRequest || e.Code != "password_missing_classes" {
+		t.Fatalf("Account created with a weak password: %d %+v", res.StatusCode, e)
+	}
+	user := s.account(email, "Correct horse battery 2")
+	path := "/api/v1/accounts/" + *user.UID
+	for _, p := range []string{weak, "Correct horse battery 2"} {
+		e = apiError{}
+		if res := s.do("PUT", path, bearer, &accountRequest{Email: &email, Name: &name, Password: &p}, &e); res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Password %q accepted: %d %+v", p, res.StatusCode, e)
+		}
+	}
+	if e.Code != "password_reused" {
+		t.Fatal("Expected password_reused, got ", e.Code)
+	}
+}
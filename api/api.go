@@ -1,8 +1,19 @@
 package api
 
 import (
+	"time"
+
 	"github.com/gorilla/securecookie"
+	"github.com/jllopis/try5/authn"
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/mailer"
+	"github.com/jllopis/try5/oidc"
+	"github.com/jllopis/try5/seal"
+	"github.com/jllopis/try5/session"
 	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/token"
+	"github.com/jllopis/try5/webauthn"
 	"github.com/mgutz/logxi/v1"
 	"github.com/unrolled/render"
 )
@@ -10,7 +21,51 @@ import (
 type ApiContext struct {
 	DB            store.Storer
 	Render        *render.Render
-	CookieHandler *securecookie.SecureCookie
+	CookieHandler securecookie.Codec
+	Tokens        *token.Manager
+	// SignatureSkew is the allowed clock skew for HMAC signed requests
+	SignatureSkew time.Duration
+	// KeySealer encrypts the signing keys of the api keys, they can not be created if nil
+	KeySealer *seal.Sealer
+	Sessions  session.Options
+	// Lockout limits the failed authentications, the zero values take lockout.DefaultPolicy
+	Lockout lockout.Policy
+	// Mailer sends the password reset links to ResetURL, valid for ResetTTL
+	// (DefaultResetTTL if 0) and written with ResetTemplate (DefaultResetTemplate if nil)
+	Mailer        mailer.Mailer
+	ResetURL      string
+	ResetTTL      time.Duration
+	ResetTemplate *mailer.Template
+	// VerifyEmail marks the new and changed emails as unverified and mails a link to
+	// VerifyURL, valid for VerifyTTL (DefaultVerifyTTL if 0) and written with
+	// VerifyTemplate (DefaultVerifyTemplate if nil). RequireVerified refuses to
+	// authenticate the accounts until they verify the email.
+	VerifyEmail     bool
+	RequireVerified bool
+	VerifyURL       string
+	VerifyTTL       time.Duration
+	VerifyTemplate  *mailer.Template
+	// MFASealer encrypts the TOTP secrets, the second factor is not available if nil.
+	// MFAIssuer names the service in the authenticator apps (DefaultMFAIssuer if empty).
+	MFASealer *seal.Sealer
+	MFAIssuer string
+	// WebAuthn is the relying party of the passkeys, they are not available if nil
+	WebAuthn *webauthn.RelyingParty
+	// OAuthLoginURL is the page the users without a session are sent to from the
+	// OAuth authorization endpoint, with the request to resume in return_to
+	OAuthLoginURL string
+	// OIDC issues the OpenID Connect ID tokens, OpenID Connect is not available if nil
+	OIDC *oidc.Provider
+	// Federation logs the users in with the configured identity providers, it is not
+	// available if nil. PublicURL is the url of the server the providers send the users
+	// back to and FederationURL the page they land on after the login or with the error.
+	Federation    *federation.RelyingParty
+	PublicURL     string
+	FederationURL string
+	// Authenticator verifies the credentials of Authenticate, RequireAuth and every
+	// other endpoint. If nil the passwords of the accounts, the api keys, the tokens
+	// and the sessions are accepted, see DefaultAuthenticator.
+	Authenticator authn.Authenticator
 }
 
 type logMessage struct {
@@ -22,6 +77,13 @@ type logMessage struct {
 	UID    string `json:"id,omitempty"`
 }
 
+// the errors of the authenticators, kept here for the clients of the api package
+var (
+	ErrNoCredentials      = authn.ErrNoCredentials
+	ErrInvalidCredentials = authn.ErrInvalidCredentials
+	ErrAccountDisabled    = authn.ErrAccountDisabled
+)
+
 var logger log.Logger
 
 func init() {
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jllopis/aloja"
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/keyring"
+	"github.com/jllopis/try5/rbac"
+	"github.com/jllopis/try5/store/backend/mem"
+	"github.com/jllopis/try5/token"
+	"github.com/unrolled/render"
+)
+
+// testServer serves the api of ctx like try5d does, on a free local port
+type testServer struct {
+	t   *testing.T
+	ctx *ApiContext
+	url string
+}
+
+// newTestServer starts a server on a memory store. setup, if not nil, configures the
+// context before the routes are added.
+func newTestServer(t *testing.T, setup func(ctx *ApiContext)) *testServer {
+	tm, err := token.NewManager(&token.Options{Secret: []byte("SuperDifficultSecret")})
+	if err != nil {
+		t.Fatal("Error creating token manager: ", err)
+	}
+	ctx := &ApiContext{
+		DB:            mem.NewMemStore(),
+		Render:        render.New(),
+		Tokens:        tm,
+		CookieHandler: keyring.New(keyring.NewKey()),
+	}
+	if setup != nil {
+		setup(ctx)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error finding a free port: ", err)
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	server := aloja.New().Host("127.0.0.1").Port(port)
+	pub := server.NewSubrouter("/api/v1")
+	pub.Post("/authenticate", http.HandlerFunc(ctx.Authenticate))
+	pub.Post("/authenticate/mfa", http.HandlerFunc(ctx.AuthenticateMFA))
+	pub.Post("/token/refresh", http.HandlerFunc(ctx.RefreshToken))
+	pub.Post("/logout", http.HandlerFunc(ctx.Logout))
+	pub.Post("/accounts/:uid/verify", http.HandlerFunc(ctx.VerifyAccount))
+	pub.Post("/password/forgot", http.HandlerFunc(ctx.ForgotPassword))
+	pub.Post("/password/reset", http.HandlerFunc(ctx.ResetPassword))
+	pub.Post("/webauthn/login/begin", http.HandlerFunc(ctx.BeginWebAuthnLogin))
+	pub.Post("/webauthn/login/finish", http.HandlerFunc(ctx.FinishWebAuthnLogin))
+	pub.Post("/webauthn/mfa/begin", http.HandlerFunc(ctx.BeginWebAuthnMFA))
+	pub.Post("/webauthn/mfa/finish", http.HandlerFunc(ctx.FinishWebAuthnMFA))
+	pub.Get("/federation/:provider/login", http.HandlerFunc(ctx.FederationLogin))
+	pub.Get("/federation/:provider/callback", http.HandlerFunc(ctx.FederationCallback))
+	auth := server.NewSubrouter("/api/v1")
+	auth.Use(ctx.RequireAuth)
+	allow := func(perm rbac.Permission, h http.HandlerFunc) http.Handler { return ctx.RequirePermission(perm)(h) }
+	ownerOr := func(perm rbac.Permission, h http.HandlerFunc) http.Handler {
+		return ctx.RequireOwnerOrPermission(perm)(h)
+	}
+	auth.Get("/accounts", allow("accounts:read", ctx.GetAllAccounts))
+	auth.Get("/accounts/:uid", ownerOr("accounts:read", ctx.GetAccountByID))
+	auth.Post("/accounts", allow("accounts:write", ctx.NewAccount))
+	auth.Put("/accounts/:uid", ownerOr("accounts:write", ctx.UpdateAccount))
+	auth.Delete("/accounts/:uid", allow("accounts:write", ctx.DeleteAccount))
+	auth.Get("/accounts/:uid/keys", http.HandlerFunc(ctx.GetAccountKeys))
+	auth.Post("/accounts/:uid/keys", http.HandlerFunc(ctx.NewAccountKey))
+	auth.Delete("/accounts/:uid/keys/:kid", http.HandlerFunc(ctx.RevokeAccountKey))
+	auth.Get("/accounts/:uid/sessions", http.HandlerFunc(ctx.GetAccountSessions))
+	auth.Delete("/accounts/:uid/sessions", http.HandlerFunc(ctx.RevokeAllAccountSessions))
+	auth.Delete("/accounts/:uid/sessions/:sid", http.HandlerFunc(ctx.RevokeAccountSession))
+	auth.Post("/mfa/totp", http.HandlerFunc(ctx.EnrollTOTP))
+	auth.Post("/mfa/totp/confirm", http.HandlerFunc(ctx.ConfirmTOTP))
+	auth.Post("/mfa/totp/disable", http.HandlerFunc(ctx.DisableTOTP))
+	auth.Post("/mfa/recovery-codes", http.HandlerFunc(ctx.NewRecoveryCodes))
+	auth.Get("/federation/:provider/link", http.HandlerFunc(ctx.LinkFederationIdentity))
+	auth.Delete("/federation/identities/:provider/:subject", http.HandlerFunc(ctx.DeleteFederationIdentity))
+	auth.Post("/webauthn/register/begin", http.HandlerFunc(ctx.BeginWebAuthnRegistration))
+	auth.Post("/webauthn/register/finish", http.HandlerFunc(ctx.FinishWebAuthnRegistration))
+	auth.Get("/webauthn/credentials", http.HandlerFunc(ctx.GetWebAuthnCredentials))
+	auth.Delete("/webauthn/credentials/:id", http.HandlerFunc(ctx.DeleteWebAuthnCredential))
+	oauthsrv := server.NewSubrouter("/oauth")
+	oauthsrv.Get("/authorize", http.HandlerFunc(ctx.Authorize))
+	oauthsrv.Post("/token", http.HandlerFunc(ctx.OAuthToken))
+	oauthsrv.Post("/revoke", http.HandlerFunc(ctx.OAuthRevoke))
+	oauthsrv.Post("/introspect", http.HandlerFunc(ctx.OAuthIntrospect))
+	server.Get("/.well-known/openid-configuration", http.HandlerFunc(ctx.OpenIDConfiguration))
+	server.Get("/jwks.json", http.HandlerFunc(ctx.JWKS))
+	server.Get("/userinfo", http.HandlerFunc(ctx.UserInfo))
+	server.Post("/userinfo", http.HandlerFunc(ctx.UserInfo))
+	go server.Run()
+
+	s := &testServer{t: t, ctx: ctx, url: "http://127.0.0.1:" + port}
+	for i := 0; ; i++ {
+		res, err := http.Get(s.url + "/time")
+		if err == nil {
+			res.Body.Close()
+			break
+		}
+		if i == 50 {
+			t.Fatal("Server not started: ", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return s
+}
+
+// account creates an account with the permissions given, through a role of its own
+func (s *testServer) account(email, password string, perms ...rbac.Permission) *account.Account {
+	name := "Test User"
+	acc, err := s.ctx.DB.SaveAccount(&account.Account{Email: &email, Name: &name, Password: &password})
+	if err != nil {
+		s.t.Fatal("Error creating account: ", err)
+	}
+	if len(perms) > 0 {
+		role, err := s.ctx.DB.SaveRole(rbac.NewRole("role-"+*acc.UID, "Role", perms...))
+		if err != nil {
+			s.t.Fatal("Error creating role: ", err)
+		}
+		if err := s.ctx.DB.AssignRole(*acc.UID, *role.ID); err != nil {
+			s.t.Fatal("Error assigning role: ", err)
+		}
+	}
+	return acc
+}
+
+// token returns an access token of the account
+func (s *testServer) token(acc *account.Account) string {
+	pair, err := s.ctx.Tokens.Issue(acc)
+	if err != nil {
+		s.t.Fatal("Error issuing tokens: ", err)
+	}
+	return pair.AccessToken
+}
+
+// do sends the request with the bearer token, if any, and decodes the json response in out
+func (s *testServer) do(method, path, bearer string, body interface{}, out interface{}) *http.Response {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			s.t.Fatal("Error encoding body: ", err)
+		}
+	}
+	req, err := http.NewRequest(method, s.url+path, &buf)
+	if err != nil {
+		s.t.Fatal("Error creating request: ", err)
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	return s.send(req, out)
+}
+
+// send sends the request and decodes the json response in out
+func (s *testServer) send(req *http.Request, out interface{}) *http.Response {
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatal("Error sending request: ", err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		s.t.Fatal("Error reading response: ", err)
+	}
+	if out != nil && len(b) > 0 {
+		if err := json.Unmarshal(b, out); err != nil {
+			s.t.Fatalf("Error decoding response %q: %v", b, err)
+		}
+	}
+	return res
+}
+
+// post sends a form with the bearer token, if any, and decodes the json response in out
+func (s *testServer) post(path, bearer string, form url.Values, out interface{}) *http.Response {
+	req, err := http.NewRequest("POST", s.url+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		s.t.Fatal("Error creating request: ", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	return s.send(req, out)
+}
+
+// login authenticates with the password and returns the response and its tokens
+func (s *testServer) login(email, password string) (*http.Response, *token.Pair) {
+	var body struct {
+		Token *token.Pair `json:"token"`
+	}
+	res := s.post("/api/v1/authenticate", "", url.Values{"email": {email}, "password": {password}}, &body)
+	return res, body.Token
+}
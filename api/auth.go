@@ -2,33 +2,165 @@ package api
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/authn"
+	"github.com/jllopis/try5/ticket"
+	"github.com/jllopis/try5/token"
 )
 
+// Authenticate comprueba el email y la password y devuelve un par de tokens. Si el account
+// tiene un segundo factor devuelve en su lugar un mfa_token con el que completar la
+// autenticación en AuthenticateMFA. Los intentos fallidos se cuentan por account y por
+// dirección: cada fallo retrasa la respuesta y al alcanzar el máximo se bloquean durante
+// un tiempo (429). El email y la password los verifica la cadena de Authenticator del
+// contexto, el email es el login del directorio con el authenticator ldap.
+// curl -ks https://b2d:8000/api/v1/authenticate -X POST -d "email=tu4@test.com" -d "password=..."
 func (ctx *ApiContext) Authenticate(w http.ResponseWriter, r *http.Request) {
 	var res *account.Account
 	var err error
 	var email, password string
 	if email = r.FormValue("email"); email == "" {
-		ctx.Render.JSON(w, http.StatusBadRequest, &logMessage{Status: "error", Action: "authenticate", Info: "email cannot be nil"})
+		ctx.renderError(w, r, errMissingEmail)
 		return
 	}
 	if password = r.FormValue("password"); password == "" {
-		ctx.Render.JSON(w, http.StatusBadRequest, &logMessage{Status: "error", Action: "authenticate", Info: "password cannot be nil"})
+		ctx.renderError(w, r, errMissingPassword)
 		return
 	}
-	if res, err = ctx.DB.GetAccountByEmail(email); err != nil {
-		logger.Error("func Authenticate", "error", "account no encontrado", "email", email)
-		ctx.Render.JSON(w, http.StatusInternalServerError, err.Error())
+	keys := lockoutKeys(r, email)
+	if wait, err := ctx.lockedOut(keys); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	} else if wait > 0 {
+		ctx.renderLockedOut(w, r, wait)
 		return
 	}
-
-	err = res.MatchPassword(password)
+	if res, err = ctx.authenticator().Authenticate(&authn.Credentials{Login: email, Password: password}); err != nil {
+		if err != ErrInvalidCredentials {
+			ctx.renderError(w, r, err)
+			return
+		}
+		ctx.authFailed(w, r, keys)
+		return
+	}
+	if err := ctx.checkVerified(res); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	methods, err := ctx.mfaMethods(res)
 	if err != nil {
-		ctx.Render.JSON(w, http.StatusForbidden, map[string]interface{}{"status": "fail", "reason": err.Error()})
+		ctx.renderError(w, r, err)
 		return
 	}
+	if len(methods) > 0 {
+		// the failed attempts are kept until the second factor is presented
+		ctx.requireMFA(w, r, res, methods)
+		return
+	}
+	ctx.loggedIn(w, r, res, keys)
+}
+
+// loggedIn clears the failed attempts of the account and answers with the tokens,
+// and the session cookie when requested, once all the factors are verified
+func (ctx *ApiContext) loggedIn(w http.ResponseWriter, r *http.Request, res *account.Account, keys []string) {
+	if _, err := ctx.DB.DeleteAttempts(keys[0]); err != nil {
+		logger.Warn("func loggedIn", "error", err, "uid", *res.UID, "info", "failed attempts not cleared")
+	}
 	res.Password = nil
-	ctx.Render.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "account": res})
+	tokens, err := ctx.issueTokens(res)
+	if err != nil {
+		logger.Error("func loggedIn", "error", err, "uid", *res.UID)
+		ctx.renderError(w, r, err)
+		return
+	}
+	// with session=true the client also gets a session cookie
+	if withSession, _ := strconv.ParseBool(r.FormValue("session")); withSession {
+		if err := ctx.startSession(w, r, res); err != nil {
+			logger.Error("func loggedIn", "error", err, "uid", *res.UID)
+			ctx.renderError(w, r, err)
+			return
+		}
+	}
+	ctx.Render.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "account": newAccountResponse(res), "token": tokens})
+}
+
+// issueTokens issues a token pair for the account and records its refresh token, so
+// RefreshToken accepts it once
+func (ctx *ApiContext) issueTokens(a *account.Account) (*token.Pair, error) {
+	tokens, err := ctx.Tokens.Issue(a)
+	if err != nil {
+		return nil, err
+	}
+	t := &ticket.Ticket{
+		ID:         ticket.ID(tokens.RefreshID),
+		Purpose:    ticket.RefreshToken,
+		AccountUID: *a.UID,
+		Created:    time.Now().UTC(),
+		Expires:    tokens.RefreshExpires,
+	}
+	if err := ctx.DB.SaveTicket(t); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// revokeRefreshTokens revokes every refresh token of the account
+func (ctx *ApiContext) revokeRefreshTokens(uid string) {
+	n, err := ctx.DB.DeleteAccountTickets(uid, ticket.RefreshToken)
+	if err != nil {
+		logger.Warn("func revokeRefreshTokens", "error", err, "uid", uid, "info", "refresh tokens not revoked")
+		return
+	}
+	logger.Info("func revokeRefreshTokens", "revoked", n, "uid", uid)
+}
+
+// RefreshToken emite un nuevo par de tokens a partir de un refresh token válido. Cada
+// refresh token se puede usar una sola vez: se sustituye por el del nuevo par, y si se
+// presenta otra vez, porque se ha filtrado, se revocan todos los del account.
+// curl -ks https://b2d:8000/api/v1/token/refresh -X POST -d "refresh_token=eyJhbGciOi..."
+func (ctx *ApiContext) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var refresh string
+	if refresh = r.FormValue("refresh_token"); refresh == "" {
+		ctx.renderError(w, r, errMissingRefresh)
+		return
+	}
+	claims, err := ctx.Tokens.Validate(refresh, token.RefreshToken)
+	if err != nil {
+		ctx.renderError(w, r, ErrInvalidCredentials)
+		return
+	}
+	// only the request that deletes the recorded token can use it
+	if n, err := ctx.DB.DeleteTicket(ticket.ID(claims.Id)); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	} else if n == 0 {
+		logger.Warn("func RefreshToken", "uid", claims.Subject, "info", "refresh token reused or revoked")
+		ctx.revokeRefreshTokens(claims.Subject)
+		ctx.renderError(w, r, ErrInvalidCredentials)
+		return
+	}
+	res, err := ctx.DB.LoadAccount(claims.Subject)
+	if err != nil || res == nil {
+		logger.Info("func RefreshToken", "error", "account not found", "uid", claims.Subject)
+		ctx.renderError(w, r, ErrInvalidCredentials)
+		return
+	}
+	if res.Active != nil && !*res.Active {
+		ctx.renderError(w, r, ErrAccountDisabled)
+		return
+	}
+	if err := ctx.checkVerified(res); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	tokens, err := ctx.issueTokens(res)
+	if err != nil {
+		logger.Error("func RefreshToken", "error", err, "uid", claims.Subject)
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "token": tokens})
 }
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/jllopis/try5/token"
+)
+
+// refresh sends the refresh token and returns the response and its tokens
+func (s *testServer) refresh(refresh string) (*http.Response, *token.Pair) {
+	var body struct {
+		Token *token.Pair `json:"token"`
+	}
+	res := s.post("/api/v1/token/refresh", "", url.Values{"refresh_token": {refresh}}, &body)
+	return res, body.Token
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.account("jdoe@example.com", "SuperDifficultPass")
+	res, first := s.login("jdoe@example.com", "SuperDifficultPass")
+	if res.StatusCode != http.StatusOK || first == nil {
+		t.Fatal("Error authenticating: ", res.StatusCode)
+	}
+
+	res, second := s.refresh(first.RefreshToken)
+	if res.StatusCode != http.StatusOK || second == nil || second.RefreshToken == first.RefreshToken {
+		t.Fatal("Refresh token not rotated: ", res.StatusCode)
+	}
+	// the first token was used, presenting it again revokes the second one too
+	if res, _ := s.refresh(first.RefreshToken); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Expected 401 for a used refresh token, got ", res.StatusCode)
+	}
+	if res, _ := s.refresh(second.RefreshToken); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Refresh token not revoked after the reuse of another one: ", res.StatusCode)
+	}
+	// a token not recorded by the server, ie. issued by a previous version, is refused
+	acc, _ := s.ctx.DB.GetAccountByEmail("jdoe@example.com")
+	unrecorded, _ := s.ctx.Tokens.Issue(acc)
+	if res, _ := s.refresh(unrecorded.RefreshToken); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Expected 401 for a refresh token not recorded, got ", res.StatusCode)
+	}
+}
+
+func TestRefreshTokenRevocation(t *testing.T) {
+	s := newTestServer(t, nil)
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+	path := "/api/v1/accounts/" + *acc.UID
+
+	// changing the password revokes the refresh tokens
+	_, pair := s.login("jdoe@example.com", "SuperDifficultPass")
+	body := map[string]interface{}{"uid": *acc.UID, "email": "jdoe@example.com", "name": "Jane Doe", "password": "AnotherDifficultPass1", "current_password": "SuperDifficultPass"}
+	if res := s.do("PUT", path, pair.AccessToken, body, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Error changing password: ", res.StatusCode)
+	}
+	if res, _ := s.refresh(pair.RefreshToken); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Refresh token valid after a password change: ", res.StatusCode)
+	}
+
+	// and so does the logout
+	_, pair = s.login("jdoe@example.com", "AnotherDifficultPass1")
+	if res := s.post("/api/v1/logout", "", url.Values{"refresh_token": {pair.RefreshToken}}, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Error logging out: ", res.StatusCode)
+	}
+	if res, _ := s.refresh(pair.RefreshToken); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Refresh token valid after the logout: ", res.StatusCode)
+	}
+}
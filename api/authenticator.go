@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/authn"
+	"github.com/jllopis/try5/ldap"
+)
+
+// DefaultAuthenticator returns the authenticators of the context when Authenticator
+// is nil: the passwords of the accounts, the api keys, the tokens and the sessions,
+// the first that accepts the credentials wins
+func DefaultAuthenticator(ctx *ApiContext) authn.Authenticator {
+	return authn.FirstMatch(
+		&authn.Local{DB: ctx.DB},
+		&authn.APIKey{DB: ctx.DB, Skew: ctx.SignatureSkew, Sealer: ctx.KeySealer},
+		&authn.Token{DB: ctx.DB, Tokens: ctx.Tokens},
+		&authn.Session{DB: ctx.DB, Options: ctx.Sessions},
+	)
+}
+
+// NewLDAPAuthenticator returns an authenticator for the users of the directory. The
+// users without an account get one without password and with the email verified.
+func NewLDAPAuthenticator(ctx *ApiContext, dir *ldap.Directory) *authn.LDAP {
+	return &authn.LDAP{
+		DB:        ctx.DB,
+		Directory: dir,
+		Signup: func(email, name string) (*account.Account, error) {
+			return ctx.passwordlessSignup(email, name, true)
+		},
+	}
+}
+
+// authenticator returns the Authenticator of the context, DefaultAuthenticator if none
+func (ctx *ApiContext) authenticator() authn.Authenticator {
+	if ctx.Authenticator != nil {
+		return ctx.Authenticator
+	}
+	return DefaultAuthenticator(ctx)
+}
+
+// requestCredentials extracts the credentials of the request: an HMAC signature, or
+// else a bearer token, or else a session cookie
+func (ctx *ApiContext) requestCredentials(r *http.Request) (*authn.Credentials, error) {
+	if isSigned(r) {
+		s, err := signedRequest(r)
+		if err != nil {
+			return nil, err
+		}
+		return &authn.Credentials{Signature: s}, nil
+	}
+	if raw := bearerToken(r); raw != "" {
+		return &authn.Credentials{Token: raw}, nil
+	}
+	if sid := ctx.sessionID(r); sid != "" {
+		return &authn.Credentials{Session: sid}, nil
+	}
+	return nil, ErrNoCredentials
+}
+
+// signedRequest reads the signature of the request, the body is put back for the handlers
+func signedRequest(r *http.Request) (*authn.SignedRequest, error) {
+	id, sig, err := apikey.ParseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return nil, err
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	return &authn.SignedRequest{
+		KeyID:     id,
+		Signature: sig,
+		Method:    r.Method,
+		URI:       r.URL.RequestURI(),
+		Date:      r.Header.Get("Date"),
+		Digest:    r.Header.Get("Digest"),
+		Body:      body,
+	}, nil
+}
+
+// passwordlessSignup creates an account authenticated somewhere else, a provider or
+// a directory. The account has no password, the user can set one with the password
+// reset.
+func (ctx *ApiContext) passwordlessSignup(email, name string, verified bool) (*account.Account, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = strings.SplitN(email, "@", 2)[0]
+	}
+	a := &account.Account{Email: &email, Name: &name}
+	if err := a.ValidateFields(); err != nil {
+		return nil, err
+	}
+	if ctx.VerifyEmail {
+		a.EmailVerified = &verified
+	}
+	a, err := ctx.DB.SaveAccount(a)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.startVerification(a); err != nil {
+		logger.Error("func passwordlessSignup", "error", err, "uid", *a.UID, "info", "verification email not sent")
+	}
+	logger.Info("func passwordlessSignup", "account created", *a.UID)
+	return a, nil
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/authn"
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/ldap"
+	"github.com/jllopis/try5/oauth"
+	"github.com/jllopis/try5/rbac"
+	"github.com/jllopis/try5/session"
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/token"
+)
+
+// apiError is the body of every error response. Code is stable and meant to be
+// checked by the clients, Message is meant for humans and may change.
+type apiError struct {
+	Status  string `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// httpError is an error with the response it must produce
+type httpError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+func newError(status int, code, message string) *httpError {
+	return &httpError{status: status, code: code, message: message}
+}
+
+var (
+	errInvalidBody            = newError(http.StatusBadRequest, "invalid_body", "the body is not a valid json document")
+	errMissingUID             = newError(http.StatusBadRequest, "missing_uid", "uid cannot be nil")
+	errUIDMismatch            = newError(http.StatusBadRequest, "uid_mismatch", "the uid in the body does not match the uid in the path")
+	errInvalidID              = newError(http.StatusBadRequest, "invalid_id", "id must be a number")
+	errMissingRoleID          = newError(http.StatusBadRequest, "missing_role_id", "role_id cannot be nil")
+	errMissingEmail           = newError(http.StatusBadRequest, "missing_email", "email cannot be nil")
+	errMissingPassword        = newError(http.StatusBadRequest, "missing_password", "password cannot be nil")
+	errMissingCurrentPassword = newError(http.StatusBadRequest, "missing_current_password", "current_password cannot be nil")
+	errWrongCurrentPassword   = newError(http.StatusForbidden, "wrong_current_password", "current_password does not match")
+	errMissingRefresh         = newError(http.StatusBadRequest, "missing_refresh_token", "refresh_token cannot be nil")
+	errForbidden              = newError(http.StatusForbidden, "forbidden", "forbidden")
+	errPermissionDenied       = newError(http.StatusForbidden, "permission_denied", "permission denied")
+	errGrantNotFound          = newError(http.StatusNotFound, "grant_not_found", "grant not found")
+	errRoleNotAssigned        = newError(http.StatusNotFound, "role_not_assigned", "role not assigned")
+	errUnauthorized           = newError(http.StatusUnauthorized, "unauthorized", "unauthorized")
+	errInternal               = newError(http.StatusInternalServerError, "internal_error", "internal error")
+)
+
+// invalidParam is the error for a query parameter with an invalid value
+func invalidParam(name string) *httpError {
+	return newError(http.StatusBadRequest, "invalid_parameter", "invalid value for parameter "+name)
+}
+
+// knownErrors maps the errors of the other packages to their response
+var knownErrors = map[error]*httpError{
+	account.ErrInvalidName:          newError(http.StatusBadRequest, "invalid_name", account.ErrInvalidName.Error()),
+	account.ErrInvalidEmail:         newError(http.StatusBadRequest, "invalid_email", account.ErrInvalidEmail.Error()),
+	account.ErrInvalidPassword:      newError(http.StatusBadRequest, "invalid_password", account.ErrInvalidPassword.Error()),
+	rbac.ErrInvalidSlug:             newError(http.StatusBadRequest, "invalid_slug", rbac.ErrInvalidSlug.Error()),
+	rbac.ErrInvalidPermission:       newError(http.StatusBadRequest, "invalid_permission", rbac.ErrInvalidPermission.Error()),
+	rbac.ErrInvalidGrant:            newError(http.StatusBadRequest, "invalid_grant", rbac.ErrInvalidGrant.Error()),
+	ErrNoCredentials:                newError(http.StatusUnauthorized, "no_credentials", ErrNoCredentials.Error()),
+	ErrInvalidCredentials:           newError(http.StatusUnauthorized, "invalid_credentials", ErrInvalidCredentials.Error()),
+	ErrAccountDisabled:              newError(http.StatusUnauthorized, "account_disabled", ErrAccountDisabled.Error()),
+	token.ErrInvalidToken:           newError(http.StatusUnauthorized, "invalid_token", token.ErrInvalidToken.Error()),
+	token.ErrInvalidTokenType:       newError(http.StatusUnauthorized, "invalid_token", token.ErrInvalidTokenType.Error()),
+	session.ErrExpired:              newError(http.StatusUnauthorized, "session_expired", session.ErrExpired.Error()),
+	session.ErrInvalidSession:       newError(http.StatusUnauthorized, "invalid_session", session.ErrInvalidSession.Error()),
+	apikey.ErrInvalidKey:            newError(http.StatusUnauthorized, "invalid_key", apikey.ErrInvalidKey.Error()),
+	apikey.ErrRevokedKey:            newError(http.StatusUnauthorized, "revoked_key", apikey.ErrRevokedKey.Error()),
+	apikey.ErrInvalidSignature:      newError(http.StatusUnauthorized, "invalid_signature", apikey.ErrInvalidSignature.Error()),
+	apikey.ErrInvalidAuthHeader:     newError(http.StatusUnauthorized, "invalid_authorization", apikey.ErrInvalidAuthHeader.Error()),
+	apikey.ErrInvalidDigest:         newError(http.StatusUnauthorized, "invalid_digest", apikey.ErrInvalidDigest.Error()),
+	apikey.ErrRequestExpired:        newError(http.StatusUnauthorized, "request_expired", apikey.ErrRequestExpired.Error()),
+	apikey.ErrReplayedSignature:     newError(http.StatusUnauthorized, "replayed_signature", apikey.ErrReplayedSignature.Error()),
+	apikey.ErrMissingDateHeader:     newError(http.StatusUnauthorized, "missing_date", apikey.ErrMissingDateHeader.Error()),
+	apikey.ErrInvalidAccountUID:     newError(http.StatusBadRequest, "invalid_account_uid", apikey.ErrInvalidAccountUID.Error()),
+	apikey.ErrSecretNotAvailable:    newError(http.StatusUnauthorized, "invalid_key", apikey.ErrSecretNotAvailable.Error()),
+	apikey.ErrNoSealer:              newError(http.StatusNotImplemented, "apikeys_unavailable", "api keys are not configured"),
+	oauth.ErrInvalidClientName:      newError(http.StatusBadRequest, "invalid_client_name", oauth.ErrInvalidClientName.Error()),
+	oauth.ErrInvalidRedirectURI:     newError(http.StatusBadRequest, "invalid_redirect_uri", oauth.ErrInvalidRedirectURI.Error()),
+	oauth.ErrInvalidGrantType:       newError(http.StatusBadRequest, "invalid_grant_type", oauth.ErrInvalidGrantType.Error()),
+	oauth.ErrInvalidScopeName:       newError(http.StatusBadRequest, "invalid_scope", oauth.ErrInvalidScopeName.Error()),
+	federation.ErrInvalidProviderID: newError(http.StatusBadRequest, "invalid_provider_id", federation.ErrInvalidProviderID.Error()),
+	federation.ErrInvalidIssuer:     newError(http.StatusBadRequest, "invalid_issuer", federation.ErrInvalidIssuer.Error()),
+	federation.ErrInvalidClientID:   newError(http.StatusBadRequest, "invalid_client_id", federation.ErrInvalidClientID.Error()),
+	federation.ErrInvalidScope:      newError(http.StatusBadRequest, "invalid_scope", federation.ErrInvalidScope.Error()),
+	federation.ErrUnavailable:       newError(http.StatusBadGateway, "provider_unavailable", federation.ErrUnavailable.Error()),
+	federation.ErrRejected:          newError(http.StatusUnauthorized, "provider_rejected", federation.ErrRejected.Error()),
+	federation.ErrInvalidIDToken:    newError(http.StatusUnauthorized, "invalid_id_token", federation.ErrInvalidIDToken.Error()),
+	authn.ErrNoDirectoryEmail:       newError(http.StatusForbidden, "missing_directory_email", authn.ErrNoDirectoryEmail.Error()),
+	ldap.ErrUnavailable:             newError(http.StatusServiceUnavailable, "directory_unavailable", ldap.ErrUnavailable.Error()),
+	ldap.ErrServiceBind:             newError(http.StatusServiceUnavailable, "directory_unavailable", ldap.ErrServiceBind.Error()),
+}
+
+// storeStatus is the response status for every kind of store error
+var storeStatus = map[store.Kind]int{
+	store.NotFound:    http.StatusNotFound,
+	store.Conflict:    http.StatusConflict,
+	store.Unavailable: http.StatusServiceUnavailable,
+	store.Invalid:     http.StatusBadRequest,
+}
+
+// toHTTPError returns the response for err. The unknown errors become a generic
+// 500 so no internal details reach the client.
+func toHTTPError(err error) *httpError {
+	switch e := err.(type) {
+	case *httpError:
+		return e
+	case *account.PolicyError:
+		return newError(http.StatusBadRequest, e.Code, e.Message)
+	case *store.Error:
+		if status, ok := storeStatus[e.Kind]; ok {
+			return newError(status, e.Code, e.Message)
+		}
+		return errInternal
+	}
+	if e, ok := knownErrors[err]; ok {
+		return e
+	}
+	return errInternal
+}
+
+// authError is the response for a request whose credentials could not be verified.
+// Apart from an unavailable store every failure is a 401, a key or account that is
+// not found must not be told apart from a wrong one.
+func authError(err error) error {
+	if store.KindOf(err) == store.Unavailable {
+		return err
+	}
+	if e := toHTTPError(err); e.status == http.StatusUnauthorized {
+		return e
+	}
+	return errUnauthorized
+}
+
+// renderError is the single path to write error responses. The server side errors
+// are logged with the request so they can be traced.
+func (ctx *ApiContext) renderError(w http.ResponseWriter, r *http.Request, err error) {
+	e := toHTTPError(err)
+	if e.status >= http.StatusInternalServerError {
+		logger.Error("request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	}
+	ctx.Render.JSON(w, e.status, &apiError{Status: "error", Code: e.code, Message: e.message})
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/token"
+)
+
+func TestToHTTPError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{errMissingUID, http.StatusBadRequest, "missing_uid"},
+		{store.ErrAccountNotFound, http.StatusNotFound, "account_not_found"},
+		{store.ErrKeyNotFound, http.StatusNotFound, "key_not_found"},
+		{store.ErrEmailTaken, http.StatusConflict, "email_taken"},
+		{store.ErrSlugTaken, http.StatusConflict, "role_slug_taken"},
+		{store.ErrMissingPassword, http.StatusBadRequest, "missing_password"},
+		{store.UnavailableError(errors.New("connection refused")), http.StatusServiceUnavailable, "store_unavailable"},
+		{store.InvalidError(errors.New("value too long")), http.StatusBadRequest, "invalid_data"},
+		{&store.Error{Kind: store.Internal, Code: "broken", Message: "broken"}, http.StatusInternalServerError, "internal_error"},
+		{&account.PolicyError{Code: "password_too_short", Message: "too short"}, http.StatusBadRequest, "password_too_short"},
+		{account.ErrInvalidEmail, http.StatusBadRequest, "invalid_email"},
+		{token.ErrInvalidToken, http.StatusUnauthorized, "invalid_token"},
+		{apikey.ErrNoSealer, http.StatusNotImplemented, "apikeys_unavailable"},
+		{errors.New("pq: relation does not exist"), http.StatusInternalServerError, "internal_error"},
+	}
+	for _, tt := range tests {
+		e := toHTTPError(tt.err)
+		if e.status != tt.status || e.code != tt.code {
+			t.Errorf("%v: expected %d %s, got %d %s", tt.err, tt.status, tt.code, e.status, e.code)
+		}
+	}
+	// the internal details are not given to the client
+	if e := toHTTPError(errors.New("pq: password authentication failed")); e.message != errInternal.message {
+		t.Error("Internal error message exposed: ", e.message)
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+		{token.ErrInvalidTokenType, http.StatusUnauthorized, "invalid_token"},
+		{store.ErrAccountNotFound, http.StatusUnauthorized, "unauthorized"},
+		{store.ErrKeyNotFound, http.StatusUnauthorized, "unauthorized"},
+		{errors.New("unexpected"), http.StatusUnauthorized, "unauthorized"},
+		{store.UnavailableError(errors.New("timeout")), http.StatusServiceUnavailable, "store_unavailable"},
+	}
+	for _, tt := range tests {
+		e := toHTTPError(authError(tt.err))
+		if e.status != tt.status || e.code != tt.code {
+			t.Errorf("%v: expected %d %s, got %d %s", tt.err, tt.status, tt.code, e.status, e.code)
+		}
+	}
+}
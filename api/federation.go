@@ -0,0 +1,502 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jllopis/aloja"
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/store"
+)
+
+// FederationCookieName is the name of the cookie that holds the state of a login
+// with an identity provider while the user is away
+const FederationCookieName = "try5_federation"
+
+// FederationTTL is how long the user has to complete the login at the identity provider
+var FederationTTL = 10 * time.Minute
+
+var (
+	errFederationUnavailable = newError(http.StatusNotImplemented, "federation_unavailable", "federation is not configured")
+	errInvalidState          = newError(http.StatusBadRequest, "invalid_state", "the login state is missing, expired or does not match")
+	errProviderError         = newError(http.StatusUnauthorized, "provider_error", "the identity provider did not authenticate the user")
+	errIdentityNotLinked     = newError(http.StatusForbidden, "identity_not_linked", "the identity is not linked to any account")
+	errIdentityLinked        = newError(http.StatusConflict, "identity_linked", "the identity is linked to another account")
+	errAccountExists         = newError(http.StatusConflict, "account_exists", "an account with the email exists, log in and link the provider to it")
+	errLastIdentity          = newError(http.StatusConflict, "last_identity", "the account has no other way to authenticate")
+	errProviderExists        = newError(http.StatusConflict, "provider_exists", "there is already a provider with the id")
+	errMissingProviderEmail  = newError(http.StatusForbidden, "missing_email", "the identity provider did not give an email")
+	errMFAEnrolled           = newError(http.StatusForbidden, "mfa_enrolled", "the account has a second factor, log in and link the provider to it")
+)
+
+// federationState is kept in the signed cookie between the redirect to the
+// provider and the callback, so the callback is bound to the same browser
+type federationState struct {
+	State    string
+	Provider string
+	Nonce    string
+	Verifier string
+	ReturnTo string
+	LinkUID  string
+	Expires  time.Time
+}
+
+// providerRequest is the body to create or update a provider, the only place
+// where the client secret is read
+type providerRequest struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	Scope        string `json:"scope"`
+	AllowSignup  bool   `json:"allow_signup"`
+	LinkByEmail  bool   `json:"link_by_email"`
+}
+
+// federationCallback is the redirect_uri registered at the providers
+func (ctx *ApiContext) federationCallback(id string) string {
+	return strings.TrimSuffix(ctx.PublicURL, "/") + "/api/v1/federation/" + id + "/callback"
+}
+
+// localPath tells if return_to is a path of this site, the only ones the users
+// are sent back to
+func localPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.HasPrefix(p, "/\\")
+}
+
+func (ctx *ApiContext) setFederationCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     FederationCookieName,
+		Value:    value,
+		Path:     "/api/v1/federation",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// the callback is a top level navigation from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// federationFailed sends the user to FederationURL with the code of the error, or
+// renders it when there is no page to land on
+func (ctx *ApiContext) federationFailed(w http.ResponseWriter, r *http.Request, err error) {
+	if ctx.FederationURL == "" {
+		ctx.renderError(w, r, err)
+		return
+	}
+	e := toHTTPError(err)
+	if e.status >= http.StatusInternalServerError {
+		logger.Error("request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	}
+	http.Redirect(w, r, ticketLink(ctx.FederationURL, map[string]string{"error": e.code}), http.StatusFound)
+}
+
+// startFederation sends the user to the provider, linkUID is the account to link
+// the identity to or empty to log in
+func (ctx *ApiContext) startFederation(w http.ResponseWriter, r *http.Request, linkUID string) {
+	if ctx.Federation == nil {
+		ctx.renderError(w, r, errFederationUnavailable)
+		return
+	}
+	p, err := ctx.DB.LoadFederationProvider(aloja.Params(r).ByName("provider"))
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	st := &federationState{Provider: p.ID, LinkUID: linkUID, Expires: time.Now().Add(FederationTTL)}
+	if rt := r.FormValue("return_to"); localPath(rt) {
+		st.ReturnTo = rt
+	}
+	var challenge string
+	if st.State, err = federation.Random(); err == nil {
+		if st.Nonce, err = federation.Random(); err == nil {
+			st.Verifier, challenge, err = federation.NewPKCE()
+		}
+	}
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	auth, err := ctx.Federation.AuthURL(p, ctx.federationCallback(p.ID), st.State, st.Nonce, challenge)
+	if err != nil {
+		logger.Warn("func startFederation", "error", err, "provider", p.ID)
+		ctx.renderError(w, r, err)
+		return
+	}
+	encoded, err := ctx.CookieHandler.Encode(FederationCookieName, st)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.setFederationCookie(w, r, encoded, int(FederationTTL/time.Second))
+	http.Redirect(w, r, auth, http.StatusFound)
+}
+
+// GetFederationLogins devuelve el id y el nombre de los proveedores de identidad con
+// los que se puede iniciar sesión, para mostrarlos en la página de login.
+// curl -ks https://b2d:8000/api/v1/federation | jp -
+func (ctx *ApiContext) GetFederationLogins(w http.ResponseWriter, r *http.Request) {
+	providers, err := ctx.DB.LoadAllFederationProviders()
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	res := []map[string]string{}
+	for _, p := range providers {
+		res = append(res, map[string]string{"id": p.ID, "name": p.Name})
+	}
+	ctx.Render.JSON(w, http.StatusOK, res)
+}
+
+// FederationLogin redirige al usuario al proveedor de identidad para iniciar sesión.
+// Al volver, el usuario se envía a return_to (sólo rutas de este sitio) o a la página
+// configurada en FederationURL.
+// curl -ksi "https://b2d:8000/api/v1/federation/corp/login?return_to=/app"
+func (ctx *ApiContext) FederationLogin(w http.ResponseWriter, r *http.Request) {
+	ctx.startFederation(w, r, "")
+}
+
+// LinkFederationIdentity redirige al account autenticado al proveedor de identidad para
+// vincular su identidad en el proveedor al account.
+// curl -ksi https://b2d:8000/api/v1/federation/corp/link -b "try5_session=..."
+func (ctx *ApiContext) LinkFederationIdentity(w http.ResponseWriter, r *http.Request) {
+	acc := CurrentAccount(r)
+	if acc == nil || acc.UID == nil {
+		ctx.renderError(w, r, errUnauthorized)
+		return
+	}
+	ctx.startFederation(w, r, *acc.UID)
+}
+
+// FederationCallback es el redirect_uri registrado en los proveedores de identidad.
+// Canjea el código por el ID token del proveedor y busca el account vinculado al
+// sujeto. Si no lo hay, lo vincula al account con el mismo email cuando el proveedor
+// tiene link_by_email y el email está verificado por el proveedor, o crea un account
+// sin password cuando tiene allow_signup. Inicia una sesión con la cookie de sesión.
+// El segundo factor de try5 no se pide: es responsabilidad del proveedor. Por eso un
+// account con segundo factor (TOTP o passkeys) no se vincula por el email (403
+// mfa_enrolled): su dueño debe iniciar sesión y vincularlo con /federation/:provider/link.
+// curl -ksi "https://b2d:8000/api/v1/federation/corp/callback?code=...&state=..." -b "try5_federation=..."
+func (ctx *ApiContext) FederationCallback(w http.ResponseWriter, r *http.Request) {
+	if ctx.Federation == nil {
+		ctx.renderError(w, r, errFederationUnavailable)
+		return
+	}
+	var st federationState
+	cookie, err := r.Cookie(FederationCookieName)
+	if err == nil {
+		err = ctx.CookieHandler.Decode(FederationCookieName, cookie.Value, &st)
+	}
+	// the state can only be used once
+	ctx.setFederationCookie(w, r, "", -1)
+	id := aloja.Params(r).ByName("provider")
+	if err != nil || st.State == "" || st.State != r.FormValue("state") || st.Provider != id || time.Now().After(st.Expires) {
+		ctx.federationFailed(w, r, errInvalidState)
+		return
+	}
+	if e := r.FormValue("error"); e != "" {
+		logger.Info("func FederationCallback", "provider", id, "error", e, "description", r.FormValue("error_description"))
+		ctx.federationFailed(w, r, errProviderError)
+		return
+	}
+	p, err := ctx.DB.LoadFederationProvider(id)
+	if err != nil {
+		ctx.federationFailed(w, r, err)
+		return
+	}
+	claims, err := ctx.Federation.Exchange(p, r.FormValue("code"), ctx.federationCallback(p.ID), st.Verifier, st.Nonce)
+	if err != nil {
+		logger.Warn("func FederationCallback", "error", err, "provider", p.ID)
+		ctx.federationFailed(w, r, err)
+		return
+	}
+	acc, ident, err := ctx.federatedAccount(p, claims, st.LinkUID)
+	if err != nil {
+		ctx.federationFailed(w, r, err)
+		return
+	}
+	if acc.Active != nil && !*acc.Active {
+		ctx.federationFailed(w, r, ErrAccountDisabled)
+		return
+	}
+	if err := ctx.checkVerified(acc); err != nil {
+		ctx.federationFailed(w, r, err)
+		return
+	}
+	ident.Email, ident.LastLogin = claims.Email, time.Now().UTC()
+	if err := ctx.DB.SaveIdentity(ident); err != nil {
+		ctx.federationFailed(w, r, err)
+		return
+	}
+	if err := ctx.startSession(w, r, acc); err != nil {
+		ctx.federationFailed(w, r, err)
+		return
+	}
+	logger.Info("func FederationCallback", "provider", p.ID, "subject", claims.Subject, "uid", *acc.UID, "link", st.LinkUID != "")
+	switch {
+	case st.ReturnTo != "":
+		http.Redirect(w, r, st.ReturnTo, http.StatusFound)
+	case ctx.FederationURL != "":
+		http.Redirect(w, r, ctx.FederationURL, http.StatusFound)
+	default:
+		acc.Password = nil
+		ctx.Render.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "account": newAccountResponse(acc)})
+	}
+}
+
+// federatedAccount returns the account of the subject of the claims and its identity,
+// linking or creating them as allowed by the provider
+func (ctx *ApiContext) federatedAccount(p *federation.Provider, claims *federation.Claims, linkUID string) (*account.Account, *federation.Identity, error) {
+	ident, err := ctx.DB.LoadIdentity(p.ID, claims.Subject)
+	if err != nil && !store.IsNotFound(err) {
+		return nil, nil, err
+	}
+	if ident != nil {
+		if linkUID != "" && ident.AccountUID != linkUID {
+			return nil, nil, errIdentityLinked
+		}
+		acc, err := ctx.DB.LoadAccount(ident.AccountUID)
+		if err == nil {
+			return acc, ident, nil
+		}
+		if !store.IsNotFound(err) {
+			return nil, nil, err
+		}
+		// the account was deleted, the identity is stale
+		if _, err := ctx.DB.DeleteIdentity(p.ID, claims.Subject); err != nil {
+			return nil, nil, err
+		}
+	}
+	ident = &federation.Identity{ProviderID: p.ID, Subject: claims.Subject, Created: time.Now().UTC()}
+	if linkUID != "" {
+		acc, err := ctx.DB.LoadAccount(linkUID)
+		if err != nil {
+			return nil, nil, err
+		}
+		ident.AccountUID = linkUID
+		return acc, ident, nil
+	}
+	if claims.Email == "" {
+		if p.AllowSignup || p.LinkByEmail {
+			return nil, nil, errMissingProviderEmail
+		}
+		return nil, nil, errIdentityNotLinked
+	}
+	acc, err := ctx.DB.GetAccountByEmail(claims.Email)
+	if err != nil && !store.IsNotFound(err) {
+		return nil, nil, err
+	}
+	switch {
+	case acc != nil && p.LinkByEmail && claims.EmailVerified:
+		// the session would skip the second factor of the account
+		methods, err := ctx.mfaMethods(acc)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(methods) > 0 {
+			logger.Warn("func federatedAccount", "provider", p.ID, "uid", *acc.UID, "info", "link by email refused, second factor enrolled")
+			return nil, nil, errMFAEnrolled
+		}
+		ident.AccountUID = *acc.UID
+		return acc, ident, nil
+	case acc != nil && p.AllowSignup:
+		return nil, nil, errAccountExists
+	case !p.AllowSignup:
+		return nil, nil, errIdentityNotLinked
+	}
+	if acc, err = ctx.passwordlessSignup(claims.Email, claims.Name, claims.EmailVerified); err != nil {
+		return nil, nil, err
+	}
+	ident.AccountUID = *acc.UID
+	return acc, ident, nil
+}
+
+// GetFederationIdentities devuelve las identidades de proveedores externos vinculadas
+// al account autenticado.
+// curl -ks https://b2d:8000/api/v1/federation/identities -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetFederationIdentities(w http.ResponseWriter, r *http.Request) {
+	acc := CurrentAccount(r)
+	if acc == nil || acc.UID == nil {
+		ctx.renderError(w, r, errUnauthorized)
+		return
+	}
+	identities, err := ctx.DB.LoadAccountIdentities(*acc.UID)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if identities == nil {
+		identities = []*federation.Identity{}
+	}
+	ctx.Render.JSON(w, http.StatusOK, identities)
+}
+
+// DeleteFederationIdentity desvincula una identidad del account autenticado. No se
+// permite desvincular la última forma de autenticarse de un account sin password ni
+// passkeys (409).
+// curl -ks https://b2d:8000/api/v1/federation/identities/corp/248289761001 -X DELETE -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) DeleteFederationIdentity(w http.ResponseWriter, r *http.Request) {
+	acc := CurrentAccount(r)
+	if acc == nil || acc.UID == nil {
+		ctx.renderError(w, r, errUnauthorized)
+		return
+	}
+	provider, subject := aloja.Params(r).ByName("provider"), aloja.Params(r).ByName("subject")
+	ident, err := ctx.DB.LoadIdentity(provider, subject)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	// the identities of other accounts are not even acknowledged
+	if ident.AccountUID != *acc.UID {
+		ctx.renderError(w, r, store.ErrIdentityNotFound)
+		return
+	}
+	if ok, err := ctx.canAuthenticateWithout(*acc.UID, ident); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	} else if !ok {
+		ctx.renderError(w, r, errLastIdentity)
+		return
+	}
+	if _, err := ctx.DB.DeleteIdentity(provider, subject); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func DeleteFederationIdentity", "deleted", provider, "subject", subject, "uid", *acc.UID)
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Table: "identities", UID: provider})
+}
+
+// canAuthenticateWithout tells if the account keeps a password, a passkey or
+// another identity once ident is unlinked
+func (ctx *ApiContext) canAuthenticateWithout(uid string, ident *federation.Identity) (bool, error) {
+	a, err := ctx.DB.LoadAccount(uid)
+	if err != nil {
+		return false, err
+	}
+	if a.Password != nil && *a.Password != "" {
+		return true, nil
+	}
+	identities, err := ctx.DB.LoadAccountIdentities(uid)
+	if err != nil {
+		return false, err
+	}
+	for _, i := range identities {
+		if i.ProviderID != ident.ProviderID || i.Subject != ident.Subject {
+			return true, nil
+		}
+	}
+	creds, err := ctx.DB.LoadAccountCredentials(uid)
+	if err != nil {
+		return false, err
+	}
+	return len(creds) > 0, nil
+}
+
+// GetFederationProviders devuelve los proveedores de identidad configurados, sin el client_secret.
+// curl -ks https://b2d:8000/api/v1/federation/providers -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetFederationProviders(w http.ResponseWriter, r *http.Request) {
+	providers, err := ctx.DB.LoadAllFederationProviders()
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if providers == nil {
+		providers = []*federation.Provider{}
+	}
+	ctx.Render.JSON(w, http.StatusOK, providers)
+}
+
+// GetFederationProvider devuelve el proveedor de identidad indicado.
+// curl -ks https://b2d:8000/api/v1/federation/providers/corp -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetFederationProvider(w http.ResponseWriter, r *http.Request) {
+	p, err := ctx.DB.LoadFederationProvider(aloja.Params(r).ByName("id"))
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusOK, p)
+}
+
+// NewFederationProvider configura un proveedor de identidad OpenID Connect. El id es
+// el nombre del proveedor en las urls y el redirect_uri a registrar en el proveedor es
+// <TRY5_PUBLIC_URL>/api/v1/federation/<id>/callback.
+// curl -ks https://b2d:8000/api/v1/federation/providers -X POST -H "Authorization: Bearer ..." -d '{"id":"corp","name":"Corp SSO","issuer":"https://login.corp.com","client_id":"try5","client_secret":"...","allow_signup":true}' | jp -
+func (ctx *ApiContext) NewFederationProvider(w http.ResponseWriter, r *http.Request) {
+	var body providerRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		ctx.renderError(w, r, errInvalidBody)
+		return
+	}
+	if _, err := ctx.DB.LoadFederationProvider(body.ID); err == nil {
+		ctx.renderError(w, r, errProviderExists)
+		return
+	} else if !store.IsNotFound(err) {
+		ctx.renderError(w, r, err)
+		return
+	}
+	p := &federation.Provider{ID: body.ID}
+	ctx.saveFederationProvider(w, r, p, &body, http.StatusCreated)
+}
+
+// UpdateFederationProvider actualiza un proveedor de identidad. Sin client_secret se
+// mantiene el actual.
+// curl -ks https://b2d:8000/api/v1/federation/providers/corp -X PUT -H "Authorization: Bearer ..." -d '{"issuer":"https://login.corp.com","client_id":"try5","link_by_email":true}' | jp -
+func (ctx *ApiContext) UpdateFederationProvider(w http.ResponseWriter, r *http.Request) {
+	p, err := ctx.DB.LoadFederationProvider(aloja.Params(r).ByName("id"))
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	var body providerRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		ctx.renderError(w, r, errInvalidBody)
+		return
+	}
+	if body.ID != "" && body.ID != p.ID {
+		ctx.renderError(w, r, newError(http.StatusBadRequest, "id_mismatch", "the id in the body does not match the id in the path"))
+		return
+	}
+	ctx.saveFederationProvider(w, r, p, &body, http.StatusOK)
+}
+
+func (ctx *ApiContext) saveFederationProvider(w http.ResponseWriter, r *http.Request, p *federation.Provider, body *providerRequest, status int) {
+	p.Name, p.Issuer, p.ClientID, p.Scope = body.Name, strings.TrimSpace(body.Issuer), strings.TrimSpace(body.ClientID), body.Scope
+	p.AllowSignup, p.LinkByEmail = body.AllowSignup, body.LinkByEmail
+	if body.ClientSecret != "" {
+		p.ClientSecret = body.ClientSecret
+	}
+	if err := p.Check(); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if err := ctx.DB.SaveFederationProvider(p); err != nil {
+		logger.Error("func saveFederationProvider", "error", err)
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func saveFederationProvider", "provider saved", p.ID, "by", *CurrentAccount(r).UID)
+	ctx.Render.JSON(w, status, p)
+}
+
+// DeleteFederationProvider elimina un proveedor de identidad junto con las identidades
+// vinculadas a los accounts.
+// curl -ks https://b2d:8000/api/v1/federation/providers/corp -X DELETE -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) DeleteFederationProvider(w http.ResponseWriter, r *http.Request) {
+	id := aloja.Params(r).ByName("id")
+	n, err := ctx.DB.DeleteFederationProvider(id)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if n == 0 {
+		ctx.renderError(w, r, store.ErrProviderNotFound)
+		return
+	}
+	logger.Info("func DeleteFederationProvider", "provider deleted", id, "by", *CurrentAccount(r).UID)
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Table: "federation_providers", UID: id})
+}
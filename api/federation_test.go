@@ -0,0 +1,329 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/oidc"
+	"github.com/jllopis/try5/seal"
+)
+
+// fakeIssuer is an OpenID Connect provider that issues an ID token for sub and email
+// to the code "good", with the nonce of the last login sent to it
+type fakeIssuer struct {
+	*httptest.Server
+	keys     *oidc.KeySet
+	sub      string
+	email    string
+	verified bool
+	nonce    string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	oidc.KeyBits = 1024
+	k, err := oidc.NewKey()
+	if err != nil {
+		t.Fatal("Error generating key: ", err)
+	}
+	f := &fakeIssuer{sub: "248289761001", email: "jdoe@example.com", verified: true}
+	if f.keys, err = oidc.NewKeySet(k); err != nil {
+		t.Fatal("Error creating key set: ", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&federation.Metadata{Issuer: f.URL, AuthorizationEndpoint: f.URL + "/authorize", TokenEndpoint: f.URL + "/token", JWKSURI: f.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(f.keys.JWKS())
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "good" || r.PostFormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		tok, err := f.keys.Sign(&oidc.Claims{
+			Nonce:          f.nonce,
+			Email:          f.email,
+			EmailVerified:  &f.verified,
+			Name:           "Jane Doe",
+			StandardClaims: jwt.StandardClaims{Issuer: f.URL, Subject: f.sub, Audience: "try5", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()},
+		})
+		if err != nil {
+			t.Fatal("Error signing id token: ", err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "x", "token_type": "Bearer", "id_token": tok})
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+// newFederationServer starts a server with the identity provider f as "fake",
+// configured by setup
+func newFederationServer(t *testing.T, f *fakeIssuer, setup func(p *federation.Provider)) *testServer {
+	s := newTestServer(t, func(ctx *ApiContext) {
+		var err error
+		if ctx.MFASealer, err = seal.New([]byte("0123456789abcdef0123456789abcdef")); err != nil {
+			t.Fatal("Error creating sealer: ", err)
+		}
+		ctx.Federation = federation.NewRelyingParty(5 * time.Second)
+		ctx.PublicURL = "https://try5.local"
+	})
+	p := &federation.Provider{ID: "fake", Issuer: f.URL, ClientID: "try5", ClientSecret: "s3cr3t"}
+	if setup != nil {
+		setup(p)
+	}
+	if err := p.Check(); err != nil {
+		t.Fatal("Error checking provider: ", err)
+	}
+	if err := s.ctx.DB.SaveFederationProvider(p); err != nil {
+		t.Fatal("Error saving provider: ", err)
+	}
+	return s
+}
+
+// noRedirect sends the requests without following the redirects
+var noRedirect = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}}
+
+// get sends a GET with the cookies without following the redirects
+func (s *testServer) get(path, bearer string, cookies ...*http.Cookie) *http.Response {
+	req, err := http.NewRequest("GET", s.url+path, nil)
+	if err != nil {
+		s.t.Fatal("Error creating request: ", err)
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	res, err := noRedirect.Do(req)
+	if err != nil {
+		s.t.Fatal("Error sending request: ", err)
+	}
+	res.Body.Close()
+	return res
+}
+
+// cookie returns the cookie of the response with the name
+func cookie(res *http.Response, name string) *http.Cookie {
+	for _, c := range res.Cookies() {
+		if c.Name == name && c.Value != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+// startFederation opens the login or link path, sends the nonce to f and returns
+// the state and the cookie that holds it
+func (s *testServer) startFederation(f *fakeIssuer, path, bearer string) (string, *http.Cookie) {
+	res := s.get(path, bearer)
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if res.StatusCode != http.StatusFound || err != nil {
+		s.t.Fatal("Not sent to the provider: ", res.StatusCode, err)
+	}
+	c := cookie(res, FederationCookieName)
+	if c == nil {
+		s.t.Fatal("No federation cookie")
+	}
+	f.nonce = loc.Query().Get("nonce")
+	return loc.Query().Get("state"), c
+}
+
+// callback comes back from the provider with the code and decodes the json response in out
+func (s *testServer) callback(state, code string, c *http.Cookie, out interface{}) *http.Response {
+	req, err := http.NewRequest("GET", s.url+"/api/v1/federation/fake/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil)
+	if err != nil {
+		s.t.Fatal("Error creating request: ", err)
+	}
+	if c != nil {
+		req.AddCookie(c)
+	}
+	if out == nil {
+		res, err := noRedirect.Do(req)
+		if err != nil {
+			s.t.Fatal("Error sending request: ", err)
+		}
+		res.Body.Close()
+		return res
+	}
+	return s.send(req, out)
+}
+
+func TestFederationCallbackState(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+	s := newFederationServer(t, f, func(p *federation.Provider) { p.AllowSignup = true })
+
+	state, c := s.startFederation(f, "/api/v1/federation/fake/login", "")
+	for _, bad := range []struct {
+		state  string
+		cookie *http.Cookie
+	}{
+		{"other", c},
+		{state, nil},
+		{state, &http.Cookie{Name: FederationCookieName, Value: "forged"}},
+	} {
+		var e apiError
+		if res := s.callback(bad.state, "good", bad.cookie, &e); res.StatusCode != http.StatusBadRequest || e.Code != "invalid_state" {
+			t.Fatal("Expected 400 invalid_state, got ", res.StatusCode, e.Code)
+		}
+	}
+	if _, err := s.ctx.DB.GetAccountByEmail("jdoe@example.com"); err == nil {
+		t.Fatal("Account created with an invalid state")
+	}
+	// a code refused by the provider
+	var e apiError
+	if res := s.callback(state, "bad", c, &e); res.StatusCode != http.StatusUnauthorized || e.Code != "provider_rejected" {
+		t.Fatal("Expected 401 provider_rejected, got ", res.StatusCode, e.Code)
+	}
+}
+
+func TestFederationReturnTo(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+	s := newFederationServer(t, f, func(p *federation.Provider) { p.AllowSignup = true })
+
+	// only the paths of the site are followed
+	for returnTo, want := range map[string]string{"/app": "/app", "//evil.example.com": "", "https://evil.example.com": "", "/\\evil.example.com": ""} {
+		state, c := s.startFederation(f, "/api/v1/federation/fake/login?return_to="+url.QueryEscape(returnTo), "")
+		res := s.callback(state, "good", c, nil)
+		if res.Header.Get("Location") != want {
+			t.Fatalf("return_to %q sent to %q", returnTo, res.Header.Get("Location"))
+		}
+		if cookie(res, SessionCookieName) == nil {
+			t.Fatal("No session started for return_to ", returnTo)
+		}
+	}
+}
+
+func TestFederationAccounts(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+
+	// without signup nor link the identity is refused
+	s := newFederationServer(t, f, nil)
+	s.account("jdoe@example.com", "SuperDifficultPass")
+	state, c := s.startFederation(f, "/api/v1/federation/fake/login", "")
+	var e apiError
+	if res := s.callback(state, "good", c, &e); res.StatusCode != http.StatusForbidden || e.Code != "identity_not_linked" {
+		t.Fatal("Expected 403 identity_not_linked, got ", res.StatusCode, e.Code)
+	}
+
+	// with signup an account is created for an unknown email
+	s = newFederationServer(t, f, func(p *federation.Provider) { p.AllowSignup = true })
+	state, c = s.startFederation(f, "/api/v1/federation/fake/login", "")
+	var body struct {
+		Account accountResponse `json:"account"`
+	}
+	if res := s.callback(state, "good", c, &body); res.StatusCode != http.StatusOK || body.Account.UID == nil {
+		t.Fatal("Account not created: ", res.StatusCode)
+	}
+	if ident, err := s.ctx.DB.LoadIdentity("fake", f.sub); err != nil || ident.AccountUID != *body.Account.UID {
+		t.Fatal("Identity not linked to the new account: ", err)
+	}
+	// but not for the email of an account, its owner has to link it
+	f.sub, f.email = "248289761002", "other@example.com"
+	s.account("other@example.com", "SuperDifficultPass")
+	state, c = s.startFederation(f, "/api/v1/federation/fake/login", "")
+	if res := s.callback(state, "good", c, &e); res.StatusCode != http.StatusConflict || e.Code != "account_exists" {
+		t.Fatal("Expected 409 account_exists, got ", res.StatusCode, e.Code)
+	}
+}
+
+func TestFederationLinkByEmail(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+	s := newFederationServer(t, f, func(p *federation.Provider) { p.LinkByEmail = true })
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+
+	// an email not verified by the provider is not linked
+	f.verified = false
+	state, c := s.startFederation(f, "/api/v1/federation/fake/login", "")
+	var e apiError
+	if res := s.callback(state, "good", c, &e); res.StatusCode != http.StatusForbidden || e.Code != "identity_not_linked" {
+		t.Fatal("Expected 403 identity_not_linked, got ", res.StatusCode, e.Code)
+	}
+
+	// an account with a second factor is not linked, the provider would skip it
+	f.verified = true
+	s.enableTOTP(s.token(acc))
+	state, c = s.startFederation(f, "/api/v1/federation/fake/login", "")
+	res := s.callback(state, "good", c, &e)
+	if res.StatusCode != http.StatusForbidden || e.Code != "mfa_enrolled" || cookie(res, SessionCookieName) != nil {
+		t.Fatal("Expected 403 mfa_enrolled, got ", res.StatusCode, e.Code)
+	}
+	if _, err := s.ctx.DB.LoadIdentity("fake", f.sub); err == nil {
+		t.Fatal("Identity linked to an account with a second factor")
+	}
+	// its owner links it once logged in
+	state, c = s.startFederation(f, "/api/v1/federation/fake/link", s.token(acc))
+	if res := s.callback(state, "good", c, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Identity not linked by the owner: ", res.StatusCode)
+	}
+	if ident, err := s.ctx.DB.LoadIdentity("fake", f.sub); err != nil || ident.AccountUID != *acc.UID {
+		t.Fatal("Identity not linked to the account: ", err)
+	}
+
+	// an account without second factor is linked by the email
+	f.sub, f.email = "248289761002", "other@example.com"
+	other := s.account("other@example.com", "SuperDifficultPass")
+	state, c = s.startFederation(f, "/api/v1/federation/fake/login", "")
+	if res := s.callback(state, "good", c, nil); res.StatusCode != http.StatusOK || cookie(res, SessionCookieName) == nil {
+		t.Fatal("Identity not linked by the email: ", res.StatusCode)
+	}
+	if ident, err := s.ctx.DB.LoadIdentity("fake", f.sub); err != nil || ident.AccountUID != *other.UID {
+		t.Fatal("Identity not linked to the account of the email: ", err)
+	}
+}
+
+func TestDeleteLastIdentity(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+	s := newFederationServer(t, f, func(p *federation.Provider) { p.AllowSignup = true })
+	state, c := s.startFederation(f, "/api/v1/federation/fake/login", "")
+	var body struct {
+		Account accountResponse `json:"account"`
+	}
+	if res := s.callback(state, "good", c, &body); res.StatusCode != http.StatusOK {
+		t.Fatal("Account not created: ", res.StatusCode)
+	}
+	acc, err := s.ctx.DB.LoadAccount(*body.Account.UID)
+	if err != nil {
+		t.Fatal("Error loading account: ", err)
+	}
+	if acc.Password != nil {
+		t.Fatal("Account created with a password: ", *acc.Password)
+	}
+	path := "/api/v1/federation/identities/fake/" + f.sub
+
+	// the identity is the only way to authenticate of an account without password
+	var e apiError
+	if res := s.do("DELETE", path, s.token(acc), nil, &e); res.StatusCode != http.StatusConflict || e.Code != "last_identity" {
+		t.Fatal("Expected 409 last_identity, got ", res.StatusCode, e.Code)
+	}
+	// the identities of others are not found
+	other := s.account("other@example.com", "SuperDifficultPass")
+	if res := s.do("DELETE", path, s.token(other), nil, nil); res.StatusCode != http.StatusNotFound {
+		t.Fatal("Expected 404 for the identity of another account, got ", res.StatusCode)
+	}
+	// once the account has a password it can be unlinked
+	if err := acc.UpdatePassword("SuperDifficultPass"); err != nil {
+		t.Fatal("Error hashing password: ", err)
+	}
+	if err := s.ctx.DB.SetPasswordHash(*acc.UID, *acc.Password); err != nil {
+		t.Fatal("Error setting password: ", err)
+	}
+	if res := s.do("DELETE", path, s.token(acc), nil, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Identity not unlinked: ", res.StatusCode)
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jllopis/aloja"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/store"
+)
+
+// GetAccountKeys devuelve las api keys del account. Los secretos no se devuelven nunca.
+// curl -ks https://b2d:8000/api/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a/keys -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetAccountKeys(w http.ResponseWriter, r *http.Request) {
+	uid, ok := ctx.ownAccountUID(w, r, "get")
+	if !ok {
+		return
+	}
+	keys, err := ctx.DB.LoadAccountKeys(uid)
+	if err != nil {
+		logger.Error("func GetAccountKeys", "error", err, "uid", uid)
+		ctx.renderError(w, r, err)
+		return
+	}
+	if keys == nil {
+		keys = []*apikey.Key{}
+	}
+	ctx.Render.JSON(w, http.StatusOK, keys)
+}
+
+// NewAccountKey crea un nuevo par de api key para el account. El secreto sólo se devuelve
+// en esta respuesta y no puede recuperarse posteriormente. Sin KeySealer devuelve 501.
+// curl -ks https://b2d:8000/api/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a/keys -X POST -d '{"name":"backup job"}' -H "Authorization: Bearer ..."
+func (ctx *ApiContext) NewAccountKey(w http.ResponseWriter, r *http.Request) {
+	uid, ok := ctx.ownAccountUID(w, r, "create")
+	if !ok {
+		return
+	}
+	var data struct {
+		Name string `json:"name"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			ctx.renderError(w, r, errInvalidBody)
+			return
+		}
+	}
+	if ctx.KeySealer == nil {
+		ctx.renderError(w, r, apikey.ErrNoSealer)
+		return
+	}
+	key, secret, err := apikey.New(uid, data.Name, ctx.KeySealer)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if _, err := ctx.DB.SaveKey(key); err != nil {
+		logger.Error("func NewAccountKey", "error", err, "uid", uid)
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func NewAccountKey", "key created", *key.ID, "uid", uid)
+	ctx.Render.JSON(w, http.StatusCreated, map[string]interface{}{"key": key, "secret": secret})
+}
+
+// RevokeAccountKey revoca la api key indicada. La key se mantiene almacenada pero deja de ser válida.
+// curl -ks https://b2d:8000/api/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a/keys/0b3e... -X DELETE -H "Authorization: Bearer ..."
+func (ctx *ApiContext) RevokeAccountKey(w http.ResponseWriter, r *http.Request) {
+	uid, ok := ctx.ownAccountUID(w, r, "delete")
+	if !ok {
+		return
+	}
+	kid := aloja.Params(r).ByName("kid")
+	key, err := ctx.DB.LoadKey(kid)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if key.AccountUID == nil || *key.AccountUID != uid {
+		ctx.renderError(w, r, store.ErrKeyNotFound)
+		return
+	}
+	if !key.IsRevoked() {
+		key.Revoke()
+		if _, err := ctx.DB.SaveKey(key); err != nil {
+			logger.Error("func RevokeAccountKey", "error", err, "key", kid)
+			ctx.renderError(w, r, err)
+			return
+		}
+	}
+	logger.Info("func RevokeAccountKey", "key revoked", kid, "uid", uid)
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Info: kid, Table: "apikeys", UID: kid})
+}
+
+// ownAccountUID returns the uid in the path if it belongs to the authenticated account.
+// Otherwise it writes the error response and returns false.
+func (ctx *ApiContext) ownAccountUID(w http.ResponseWriter, r *http.Request, action string) (string, bool) {
+	uid := aloja.Params(r).ByName("uid")
+	if uid == "" {
+		ctx.renderError(w, r, errMissingUID)
+		return "", false
+	}
+	if acc := CurrentAccount(r); acc == nil || acc.UID == nil || *acc.UID != uid {
+		ctx.renderError(w, r, errForbidden)
+		return "", false
+	}
+	return uid, true
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/seal"
+)
+
+// signed sends a GET request signed with the api key id and secret, and returns the status
+func (s *testServer) signed(path, id, secret string) int {
+	req, err := http.NewRequest("GET", s.url+path, nil)
+	if err != nil {
+		s.t.Fatal("Error creating request: ", err)
+	}
+	date, digest := time.Now().UTC().Format(http.TimeFormat), apikey.BodyDigest(nil)
+	req.Header.Set("Date", date)
+	req.Header.Set("Digest", digest)
+	sig := apikey.Sign(secret, apikey.StringToSign("GET", path, date, digest))
+	req.Header.Set("Authorization", apikey.Scheme+" KeyId="+id+",Signature="+sig)
+	return s.send(req, nil).StatusCode
+}
+
+func TestAccountKeys(t *testing.T) {
+	if res := newTestServer(t, nil).do("POST", "/api/v1/accounts/none/keys", "", nil, nil); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Expected 401 without credentials, got ", res.StatusCode)
+	}
+	s := newTestServer(t, func(ctx *ApiContext) {
+		var err error
+		if ctx.KeySealer, err = seal.New([]byte("0123456789abcdef0123456789abcdef")); err != nil {
+			t.Fatal("Error creating sealer: ", err)
+		}
+		ctx.SignatureSkew = time.Minute
+	})
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+	other := s.account("other@example.com", "SuperDifficultPass")
+	path := "/api/v1/accounts/" + *acc.UID + "/keys"
+	bearer := s.token(acc)
+
+	var created struct {
+		Key    apikey.Key `json:"key"`
+		Secret string     `json:"secret"`
+	}
+	if res := s.do("POST", path, bearer, map[string]string{"name": "backup job"}, &created); res.StatusCode != http.StatusCreated || created.Secret == "" || created.Key.ID == nil {
+		t.Fatal("Error creating key: ", res.StatusCode)
+	}
+	id := *created.Key.ID
+	if stored, _ := s.ctx.DB.LoadKey(id); stored == nil || !stored.Sealed() || stored.SecretHash != nil {
+		t.Fatal("Signing key not stored sealed")
+	}
+	// the keys of an account are only managed by the account
+	if res := s.do("POST", path, s.token(other), nil, nil); res.StatusCode != http.StatusForbidden {
+		t.Fatal("Expected 403 creating a key of another account, got ", res.StatusCode)
+	}
+
+	var keys []map[string]interface{}
+	if res := s.do("GET", path, bearer, nil, &keys); res.StatusCode != http.StatusOK || len(keys) != 1 || keys[0]["id"] != id || keys[0]["name"] != "backup job" {
+		t.Fatalf("Wrong keys listed: %d %v", res.StatusCode, keys)
+	}
+	for _, k := range keys {
+		if _, ok := k["secret_hash"]; ok {
+			t.Fatal("Secret listed")
+		}
+	}
+
+	// a signed request authenticates as the owner until the key is revoked
+	if status := s.signed("/api/v1/accounts/"+*acc.UID, id, created.Secret); status != http.StatusOK {
+		t.Fatal("Signed request refused: ", status)
+	}
+	if status := s.signed("/api/v1/accounts/"+*acc.UID+"?wrong", id, "not-the-secret"); status != http.StatusUnauthorized {
+		t.Fatal("Expected 401 for a wrong signature, got ", status)
+	}
+	if res := s.do("DELETE", path+"/"+id, s.token(other), nil, nil); res.StatusCode != http.StatusForbidden {
+		t.Fatal("Expected 403 revoking a key of another account, got ", res.StatusCode)
+	}
+	if res := s.do("DELETE", path+"/"+id, bearer, nil, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Error revoking key: ", res.StatusCode)
+	}
+	if status := s.signed("/api/v1/accounts/"+*acc.UID+"?revoked", id, created.Secret); status != http.StatusUnauthorized {
+		t.Fatal("Signed request accepted after the revocation: ", status)
+	}
+	keys = nil
+	if s.do("GET", path, bearer, nil, &keys); len(keys) != 1 || keys[0]["revoked"] == nil {
+		t.Fatalf("Revoked key not listed as revoked: %v", keys)
+	}
+}
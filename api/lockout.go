@@ -0,0 +1,118 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jllopis/aloja"
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/store"
+)
+
+var errTooManyAttempts = newError(http.StatusTooManyRequests, "too_many_attempts", "too many failed attempts, try again later")
+
+// lockoutKeys are the keys whose failed attempts are counted for an authentication
+func lockoutKeys(r *http.Request, email string) []string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return []string{lockout.AccountKey(email), lockout.IPKey(ip)}
+}
+
+// lockedOut returns how long any of the keys stays locked out, or waits for the delay
+// after its last failure, 0 if none does
+func (ctx *ApiContext) lockedOut(keys []string) (time.Duration, error) {
+	p := ctx.Lockout.WithDefaults()
+	var wait time.Duration
+	now := time.Now()
+	for _, key := range keys {
+		a, err := ctx.DB.LoadAttempts(key)
+		if err != nil {
+			if store.IsNotFound(err) {
+				continue
+			}
+			return 0, err
+		}
+		if d := p.Wait(a, now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// renderLockedOut answers a request for a locked out key
+func (ctx *ApiContext) renderLockedOut(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+	ctx.renderError(w, r, errTooManyAttempts)
+}
+
+// authFailed records a failed attempt for every key and answers with the same
+// response whatever the cause of the failure. The attempts made before the delay of
+// the failure is over are refused by lockedOut, the request is not held.
+func (ctx *ApiContext) authFailed(w http.ResponseWriter, r *http.Request, keys []string) {
+	ctx.countFailure(keys)
+	ctx.renderError(w, r, ErrInvalidCredentials)
+}
+
+// countFailure records a failed attempt for every key, the first one is the account's
+func (ctx *ApiContext) countFailure(keys []string) {
+	p := ctx.Lockout.WithDefaults()
+	now := time.Now()
+	for i, key := range keys {
+		max := p.MaxFailures
+		if i > 0 {
+			max = p.IPMaxFailures
+		}
+		a, err := ctx.DB.IncrementAttempts(p, key, max, now)
+		if err != nil {
+			logger.Error("func countFailure", "error", err, "key", key)
+			continue
+		}
+		if a.LockedUntil != nil && a.Failures == max {
+			logger.Warn("func countFailure", "locked out", key, "until", *a.LockedUntil)
+		}
+	}
+}
+
+// lockoutResponse is an entry of GetLockouts
+type lockoutResponse struct {
+	*lockout.Attempts
+	Locked bool `json:"locked"`
+}
+
+// GetLockouts devuelve los intentos fallidos de autenticación registrados, por account
+// (account:<email>) y por dirección (ip:<address>), indicando si están bloqueados.
+// curl -ks https://b2d:8000/api/v1/lockouts -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetLockouts(w http.ResponseWriter, r *http.Request) {
+	attempts, err := ctx.DB.LoadAllAttempts()
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	now := time.Now()
+	res := make([]*lockoutResponse, len(attempts))
+	for i, a := range attempts {
+		res[i] = &lockoutResponse{Attempts: a, Locked: a.Locked(now) > 0}
+	}
+	ctx.Render.JSON(w, http.StatusOK, res)
+}
+
+// DeleteLockout elimina los intentos fallidos de la clave indicada, desbloqueándola.
+// curl -ks https://b2d:8000/api/v1/lockouts/account:tu4@test.com -X DELETE -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) DeleteLockout(w http.ResponseWriter, r *http.Request) {
+	key := aloja.Params(r).ByName("key")
+	n, err := ctx.DB.DeleteAttempts(key)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if n == 0 {
+		ctx.renderError(w, r, store.ErrLockoutNotFound)
+		return
+	}
+	logger.Info("func DeleteLockout", "cleared", key, "by", *CurrentAccount(r).UID)
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Table: "lockouts", UID: key})
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/lockout"
+)
+
+func TestAuthFailedDelay(t *testing.T) {
+	s := newTestServer(t, func(ctx *ApiContext) {
+		ctx.Lockout = lockout.Policy{BaseDelay: 2 * time.Second, MaxDelay: 2 * time.Second}
+	})
+	s.account("jdoe@example.com", "SuperDifficultPass")
+
+	if res, _ := s.login("jdoe@example.com", "WrongPassword"); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Expected 401 for a wrong password, got ", res.StatusCode)
+	}
+	// the next attempt is refused until the delay is over, even with the password
+	res, pair := s.login("jdoe@example.com", "SuperDifficultPass")
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") == "" || pair != nil {
+		t.Fatal("Expected 429 with Retry-After before the delay, got ", res.StatusCode)
+	}
+}
+
+func TestAuthFailedParallel(t *testing.T) {
+	s := newTestServer(t, func(ctx *ApiContext) {
+		ctx.Lockout = lockout.Policy{MaxFailures: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	})
+	s.account("jdoe@example.com", "SuperDifficultPass")
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, _ := s.login("jdoe@example.com", "WrongPassword"); res.StatusCode == http.StatusUnauthorized {
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every guess checked is counted, none is lost to a concurrent one
+	a, err := s.ctx.DB.LoadAttempts(lockout.AccountKey("jdoe@example.com"))
+	if err != nil || a.Failures != failed {
+		t.Fatal("Expected ", failed, " failures, got ", a, err)
+	}
+	// some guesses may have been refused by the delay, the rest lock the account
+	for i := a.Failures; i < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		s.login("jdoe@example.com", "WrongPassword")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if res, _ := s.login("jdoe@example.com", "SuperDifficultPass"); res.StatusCode != http.StatusTooManyRequests {
+		t.Fatal("Expected 429 for a locked account, got ", res.StatusCode)
+	}
+}
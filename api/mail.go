@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/mailer"
+	"github.com/jllopis/try5/ticket"
+)
+
+// ticketLink returns base, the page of the client application, with params added
+// to its query
+func ticketLink(base string, params map[string]string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		u = &url.URL{Path: base}
+	}
+	q := u.Query()
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// issueTicket invalidates the tickets of the account for purpose and stores a new
+// one valid for ttl. It returns the ticket and the token to send to the user.
+func (ctx *ApiContext) issueTicket(uid string, purpose ticket.Purpose, ttl time.Duration) (*ticket.Ticket, string, error) {
+	if _, err := ctx.DB.DeleteAccountTickets(uid, purpose); err != nil {
+		return nil, "", err
+	}
+	t, token, err := ticket.New(purpose, uid, ttl)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := ctx.DB.SaveTicket(t); err != nil {
+		return nil, "", err
+	}
+	return t, token, nil
+}
+
+// sendMail renders the template for the account and sends it in the background, so
+// the response does not depend on the mail server. The template gets the Name and
+// Email of the account besides data.
+func (ctx *ApiContext) sendMail(tpl *mailer.Template, a *account.Account, data map[string]interface{}) error {
+	data["Email"] = *a.Email
+	data["Name"] = ""
+	if a.Name != nil {
+		data["Name"] = *a.Name
+	}
+	msg, err := tpl.Message(*a.Email, data)
+	if err != nil {
+		return err
+	}
+	if ctx.Mailer == nil {
+		logger.Error("func sendMail", "error", "no mailer configured", "uid", *a.UID, "subject", msg.Subject)
+		return nil
+	}
+	go func(uid string) {
+		if err := ctx.Mailer.Send(msg); err != nil {
+			logger.Error("func sendMail", "error", err, "uid", uid, "subject", msg.Subject)
+		}
+	}(*a.UID)
+	return nil
+}
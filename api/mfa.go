@@ -0,0 +1,360 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jllopis/aloja"
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/seal"
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/token"
+	"github.com/jllopis/try5/totp"
+)
+
+// DefaultMFAIssuer names the service in the authenticator apps when MFAIssuer is not set
+const DefaultMFAIssuer = "try5"
+
+var (
+	errMFAUnavailable  = newError(http.StatusNotImplemented, "mfa_unavailable", "two-factor authentication is not configured")
+	errMFAEnabled      = newError(http.StatusConflict, "mfa_enabled", "two-factor authentication already enabled")
+	errMFANotEnrolled  = newError(http.StatusConflict, "mfa_not_enrolled", "two-factor authentication not enrolled")
+	errMFANotEnabled   = newError(http.StatusNotFound, "mfa_not_enabled", "two-factor authentication not enabled")
+	errInvalidMFACode  = newError(http.StatusBadRequest, "invalid_mfa_code", "invalid code")
+	errMissingCode     = newError(http.StatusBadRequest, "missing_code", "code cannot be nil")
+	errMissingMFAToken = newError(http.StatusBadRequest, "missing_mfa_token", "mfa_token cannot be nil")
+)
+
+// mfaMethods returns the second factors of the account, none if it only needs the password
+func (ctx *ApiContext) mfaMethods(a *account.Account) ([]string, error) {
+	var methods []string
+	if a.MFAEnabled() {
+		methods = append(methods, "totp", "recovery_code")
+	}
+	creds, err := ctx.DB.LoadAccountCredentials(*a.UID)
+	if err != nil {
+		return nil, err
+	}
+	if len(creds) > 0 {
+		methods = append(methods, "webauthn")
+	}
+	return methods, nil
+}
+
+// requireMFA answers the first step of the authentication of an account with a
+// second factor, with the token to present it
+func (ctx *ApiContext) requireMFA(w http.ResponseWriter, r *http.Request, a *account.Account, methods []string) {
+	mfaToken, err := ctx.Tokens.IssueMFA(a)
+	if err != nil {
+		logger.Error("func requireMFA", "error", err, "uid", *a.UID)
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "mfa_required",
+		"mfa_token":  mfaToken,
+		"expires_in": int64(token.MFATTL / time.Second),
+		"methods":    methods,
+	})
+}
+
+// verifyMFA checks a TOTP code or, if code is empty, a recovery code and stores the
+// counter of the code or the remaining recovery codes, so none can be used twice. The
+// store is only updated if the second factor did not change since it was loaded, the
+// code is refused if a concurrent request used it.
+func (ctx *ApiContext) verifyMFA(a *account.Account, code, recovery string) error {
+	if ctx.MFASealer == nil {
+		return errMFAUnavailable
+	}
+	m := *a.MFA
+	switch {
+	case code != "":
+		c, err := totp.ValidateSealed(ctx.MFASealer, m.Secret, code, time.Now(), m.LastCounter)
+		if err == seal.ErrSealed {
+			logger.Error("func verifyMFA", "error", err, "uid", *a.UID)
+			return err
+		}
+		if err != nil {
+			return errInvalidMFACode
+		}
+		m.LastCounter = c
+	case recovery != "":
+		if !m.UseRecoveryCode(recovery) {
+			return errInvalidMFACode
+		}
+		logger.Info("func verifyMFA", "recovery code used", *a.UID, "remaining", len(m.RecoveryCodes))
+	default:
+		return errMissingCode
+	}
+	swapped, err := ctx.DB.SwapMFA(*a.UID, a.MFA, &m)
+	if err != nil {
+		return err
+	}
+	if !swapped {
+		logger.Warn("func verifyMFA", "uid", *a.UID, "info", "second factor changed by a concurrent request")
+		return errInvalidMFACode
+	}
+	a.MFA = &m
+	return nil
+}
+
+// checkMFA verifies a code of the authenticated account as verifyMFA does, counting
+// the wrong ones as failed authentications so they can not be guessed with a stolen
+// token. It answers the request and returns false if the code is not accepted.
+func (ctx *ApiContext) checkMFA(w http.ResponseWriter, r *http.Request, acc *account.Account, code, recovery string) bool {
+	keys := lockoutKeys(r, *acc.Email)
+	if wait, err := ctx.lockedOut(keys); err != nil {
+		ctx.renderError(w, r, err)
+		return false
+	} else if wait > 0 {
+		ctx.renderLockedOut(w, r, wait)
+		return false
+	}
+	if err := ctx.verifyMFA(acc, code, recovery); err != nil {
+		if err == errInvalidMFACode {
+			ctx.countFailure(keys)
+		}
+		ctx.renderError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// AuthenticateMFA completa la autenticación de un account con segundo factor presentando
+// el mfa_token devuelto por Authenticate y un código TOTP (code) o un código de
+// recuperación (recovery_code), que solo se puede usar una vez. Los códigos erróneos
+// cuentan como intentos fallidos de autenticación.
+// curl -ks https://b2d:8000/api/v1/authenticate/mfa -X POST -d "mfa_token=eyJhbGciOi..." -d "code=123456"
+func (ctx *ApiContext) AuthenticateMFA(w http.ResponseWriter, r *http.Request) {
+	var mfaToken string
+	if mfaToken = r.FormValue("mfa_token"); mfaToken == "" {
+		ctx.renderError(w, r, errMissingMFAToken)
+		return
+	}
+	code, recovery := r.FormValue("code"), r.FormValue("recovery_code")
+	if code == "" && recovery == "" {
+		ctx.renderError(w, r, errMissingCode)
+		return
+	}
+	claims, err := ctx.Tokens.Validate(mfaToken, token.MFAToken)
+	if err != nil {
+		ctx.renderError(w, r, ErrInvalidCredentials)
+		return
+	}
+	res, err := ctx.DB.LoadAccount(claims.Subject)
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = ErrInvalidCredentials
+		}
+		ctx.renderError(w, r, err)
+		return
+	}
+	if res.Active != nil && !*res.Active {
+		ctx.renderError(w, r, ErrAccountDisabled)
+		return
+	}
+	keys := lockoutKeys(r, *res.Email)
+	if wait, err := ctx.lockedOut(keys); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	} else if wait > 0 {
+		ctx.renderLockedOut(w, r, wait)
+		return
+	}
+	if !res.MFAEnabled() {
+		methods, err := ctx.mfaMethods(res)
+		if err != nil {
+			ctx.renderError(w, r, err)
+			return
+		}
+		if len(methods) > 0 {
+			// the account has other second factors, it must present one of them
+			ctx.renderError(w, r, errMFANotEnabled)
+			return
+		}
+		// disabled after the password was checked, the password is enough
+		ctx.loggedIn(w, r, res, keys)
+		return
+	}
+	if err := ctx.verifyMFA(res, code, recovery); err != nil {
+		if err == errInvalidMFACode {
+			ctx.authFailed(w, r, keys)
+			return
+		}
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.loggedIn(w, r, res, keys)
+}
+
+// currentAccount reloads the account of the request, so it has the latest second factor
+func (ctx *ApiContext) currentAccount(r *http.Request) (*account.Account, error) {
+	acc := CurrentAccount(r)
+	if acc == nil || acc.UID == nil {
+		return nil, errUnauthorized
+	}
+	return ctx.DB.LoadAccount(*acc.UID)
+}
+
+// EnrollTOTP genera un nuevo secreto TOTP para el account autenticado y devuelve la URI
+// otpauth:// para registrarlo en la aplicación de autenticación. El segundo factor no
+// se activa hasta confirmarlo con un primer código en ConfirmTOTP.
+// curl -ks https://b2d:8000/api/v1/mfa/totp -X POST -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if ctx.MFASealer == nil {
+		ctx.renderError(w, r, errMFAUnavailable)
+		return
+	}
+	acc, err := ctx.currentAccount(r)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if acc.MFAEnabled() {
+		ctx.renderError(w, r, errMFAEnabled)
+		return
+	}
+	secret, sealed, err := totp.NewSealedSecret(ctx.MFASealer)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if err := ctx.DB.SetMFA(*acc.UID, &account.MFA{Secret: sealed}); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	issuer := ctx.MFAIssuer
+	if issuer == "" {
+		issuer = DefaultMFAIssuer
+	}
+	logger.Info("func EnrollTOTP", "enrolled", *acc.UID)
+	ctx.Render.JSON(w, http.StatusOK, map[string]string{"secret": secret, "uri": totp.URI(issuer, *acc.Email, secret)})
+}
+
+// ConfirmTOTP activa el segundo factor enrolado con EnrollTOTP comprobando un primer
+// código y devuelve los códigos de recuperación, que solo se muestran esta vez.
+// curl -ks https://b2d:8000/api/v1/mfa/totp/confirm -X POST -H "Authorization: Bearer ..." -d "code=123456" | jp -
+func (ctx *ApiContext) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var code string
+	if code = r.FormValue("code"); code == "" {
+		ctx.renderError(w, r, errMissingCode)
+		return
+	}
+	acc, err := ctx.currentAccount(r)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	switch {
+	case acc.MFA == nil:
+		ctx.renderError(w, r, errMFANotEnrolled)
+		return
+	case acc.MFA.Enabled:
+		ctx.renderError(w, r, errMFAEnabled)
+		return
+	}
+	if !ctx.checkMFA(w, r, acc, code, "") {
+		return
+	}
+	codes, hashes, err := account.NewRecoveryCodes(account.RecoveryCodes)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	now := time.Now().UTC()
+	m := *acc.MFA
+	m.Enabled, m.Confirmed, m.RecoveryCodes = true, &now, hashes
+	if err := ctx.DB.SetMFA(*acc.UID, &m); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func ConfirmTOTP", "mfa enabled", *acc.UID)
+	ctx.Render.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "recovery_codes": codes})
+}
+
+// DisableTOTP desactiva el segundo factor del account autenticado. Requiere un código
+// TOTP (code) o de recuperación (recovery_code), los erróneos cuentan como intentos
+// fallidos de autenticación.
+// curl -ks https://b2d:8000/api/v1/mfa/totp/disable -X POST -H "Authorization: Bearer ..." -d "code=123456" | jp -
+func (ctx *ApiContext) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	acc, err := ctx.currentAccount(r)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if !acc.MFAEnabled() {
+		ctx.renderError(w, r, errMFANotEnabled)
+		return
+	}
+	if !ctx.checkMFA(w, r, acc, r.FormValue("code"), r.FormValue("recovery_code")) {
+		return
+	}
+	if err := ctx.DB.SetMFA(*acc.UID, nil); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func DisableTOTP", "mfa disabled", *acc.UID)
+	ctx.Render.JSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// NewRecoveryCodes reemplaza los códigos de recuperación del account autenticado por
+// otros nuevos. Requiere un código TOTP, los erróneos cuentan como intentos fallidos
+// de autenticación.
+// curl -ks https://b2d:8000/api/v1/mfa/recovery-codes -X POST -H "Authorization: Bearer ..." -d "code=123456" | jp -
+func (ctx *ApiContext) NewRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var code string
+	if code = r.FormValue("code"); code == "" {
+		ctx.renderError(w, r, errMissingCode)
+		return
+	}
+	acc, err := ctx.currentAccount(r)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if !acc.MFAEnabled() {
+		ctx.renderError(w, r, errMFANotEnabled)
+		return
+	}
+	if !ctx.checkMFA(w, r, acc, code, "") {
+		return
+	}
+	codes, hashes, err := account.NewRecoveryCodes(account.RecoveryCodes)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	m := *acc.MFA
+	m.RecoveryCodes = hashes
+	if err := ctx.DB.SetMFA(*acc.UID, &m); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "recovery_codes": codes})
+}
+
+// ResetAccountMFA elimina el segundo factor de un account, ie. si ha perdido el
+// dispositivo y los códigos de recuperación.
+// curl -ks https://b2d:8000/api/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a/mfa -X DELETE -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) ResetAccountMFA(w http.ResponseWriter, r *http.Request) {
+	var uid string
+	if uid = aloja.Params(r).ByName("uid"); uid == "" {
+		ctx.renderError(w, r, errMissingUID)
+		return
+	}
+	acc, err := ctx.DB.LoadAccount(uid)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if acc.MFA == nil {
+		ctx.renderError(w, r, errMFANotEnabled)
+		return
+	}
+	if err := ctx.DB.SetMFA(uid, nil); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func ResetAccountMFA", "mfa removed", uid, "by", *CurrentAccount(r).UID)
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Table: "mfa", UID: uid})
+}
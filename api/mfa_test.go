@@ -0,0 +1,128 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/seal"
+	"github.com/jllopis/try5/totp"
+)
+
+// newMFAServer starts a server with the second factor, whose lockout locks an
+// account after 3 failures
+func newMFAServer(t *testing.T) *testServer {
+	return newTestServer(t, func(ctx *ApiContext) {
+		var err error
+		if ctx.MFASealer, err = seal.New([]byte("0123456789abcdef0123456789abcdef")); err != nil {
+			t.Fatal("Error creating sealer: ", err)
+		}
+		ctx.Lockout = lockout.Policy{MaxFailures: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	})
+}
+
+// enableTOTP enrols and confirms the second factor of the account of bearer and
+// returns its secret and recovery codes
+func (s *testServer) enableTOTP(bearer string) (string, []string) {
+	var enrol struct {
+		Secret string `json:"secret"`
+	}
+	if res := s.post("/api/v1/mfa/totp", bearer, nil, &enrol); res.StatusCode != http.StatusOK {
+		s.t.Fatal("Error enrolling TOTP: ", res.StatusCode)
+	}
+	var confirm struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	if res := s.post("/api/v1/mfa/totp/confirm", bearer, url.Values{"code": {s.code(enrol.Secret, 0)}}, &confirm); res.StatusCode != http.StatusOK {
+		s.t.Fatal("Error confirming TOTP: ", res.StatusCode)
+	}
+	return enrol.Secret, confirm.RecoveryCodes
+}
+
+// code returns the TOTP code of the secret for the period off periods from now
+func (s *testServer) code(secret string, off int64) string {
+	c, err := totp.Code(secret, totp.Counter(time.Now())+off)
+	if err != nil {
+		s.t.Fatal("Error generating code: ", err)
+	}
+	return c
+}
+
+func TestMFAWrongCodesLockout(t *testing.T) {
+	s := newMFAServer(t)
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+	bearer := s.token(acc)
+	secret, _ := s.enableTOTP(bearer)
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		var e apiError
+		if res := s.post("/api/v1/mfa/totp/disable", bearer, url.Values{"code": {"000000"}}, &e); res.StatusCode != http.StatusBadRequest || e.Code != "invalid_mfa_code" {
+			t.Fatal("Expected 400 invalid_mfa_code for a wrong code, got ", res.StatusCode, e.Code)
+		}
+	}
+	a, err := s.ctx.DB.LoadAttempts(lockout.AccountKey("jdoe@example.com"))
+	if err != nil || a.Failures != 3 {
+		t.Fatal("Wrong codes not counted: ", a, err)
+	}
+	// the account is locked, the right code is refused too
+	time.Sleep(10 * time.Millisecond)
+	if res := s.post("/api/v1/mfa/recovery-codes", bearer, url.Values{"code": {s.code(secret, 1)}}, nil); res.StatusCode != http.StatusTooManyRequests {
+		t.Fatal("Expected 429 for a locked account, got ", res.StatusCode)
+	}
+	if loaded, _ := s.ctx.DB.LoadAccount(*acc.UID); !loaded.MFAEnabled() {
+		t.Fatal("Second factor disabled by a locked account")
+	}
+}
+
+func TestMFACodeUsedOnce(t *testing.T) {
+	s := newMFAServer(t)
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+	_, codes := s.enableTOTP(s.token(acc))
+
+	// a stale copy of the account can not use a code once the second factor changed
+	stale, err := s.ctx.DB.LoadAccount(*acc.UID)
+	if err != nil {
+		t.Fatal("Error loading account: ", err)
+	}
+	current := *stale
+	if err := s.ctx.verifyMFA(&current, "", codes[0]); err != nil {
+		t.Fatal("Recovery code refused: ", err)
+	}
+	if err := s.ctx.verifyMFA(stale, "", codes[1]); err != errInvalidMFACode {
+		t.Fatal("Code accepted on a stale second factor: ", err)
+	}
+
+	// the same recovery code sent by concurrent authentications is accepted once
+	var body struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	s.post("/api/v1/authenticate", "", url.Values{"email": {"jdoe@example.com"}, "password": {"SuperDifficultPass"}}, &body)
+	if body.MFAToken == "" {
+		t.Fatal("No mfa_token returned")
+	}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		ok int
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			form := url.Values{"mfa_token": {body.MFAToken}, "recovery_code": {codes[2]}}
+			if res := s.post("/api/v1/authenticate/mfa", "", form, nil); res.StatusCode == http.StatusOK {
+				mu.Lock()
+				ok++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if ok != 1 {
+		t.Fatal("Recovery code accepted ", ok, " times")
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jllopis/try5/account"
+	"github.com/nbio/httpcontext"
+)
+
+type key int
+
+const accountKey key = 0
+
+// RequireAuth is a middleware that only lets through the requests carrying valid
+// credentials, either a bearer token in the Authorization header, an HMAC signature
+// (see RequireSignature) or a session cookie.
+// The authenticated account is stored in the request context and can be retrieved
+// with CurrentAccount. Requests without valid credentials get a 401 response.
+func (ctx *ApiContext) RequireAuth(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		acc, err := ctx.authenticateRequest(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="try5"`)
+			ctx.renderError(w, r, authError(err))
+			return
+		}
+		httpcontext.Set(r, accountKey, acc)
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// CurrentAccount returns the account authenticated by RequireAuth or nil if
+// the request has not been authenticated.
+func CurrentAccount(r *http.Request) *account.Account {
+	if acc, ok := httpcontext.GetOk(r, accountKey); ok {
+		return acc.(*account.Account)
+	}
+	return nil
+}
+
+// authenticateRequest extracts the credentials from the request and returns the
+// account they belong to.
+func (ctx *ApiContext) authenticateRequest(r *http.Request) (*account.Account, error) {
+	c, err := ctx.requestCredentials(r)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.authenticator().Authenticate(c)
+}
+
+// bearerToken returns the token from the Authorization header if present
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
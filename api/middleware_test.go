@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRequireAuth(t *testing.T) {
+	s := newTestServer(t, nil)
+	acc := s.account("jdoe@example.com", "Correct horse battery 1")
+	path := "/api/v1/accounts/" + *acc.UID
+
+	var e apiError
+	res := s.do("GET", path, "", nil, &e)
+	if res.StatusCode != http.StatusUnauthorized || e.Code != "no_credentials" {
+		t.Fatalf("Request without credentials not refused: %d %+v", res.StatusCode, e)
+	}
+	if res.Header.Get("WWW-Authenticate") == "" {
+		t.Fatal("WWW-Authenticate header missing")
+	}
+	if res := s.do("GET", path, "not-a-token", nil, nil); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Invalid token accepted: ", res.StatusCode)
+	}
+
+	// the token of the login is accepted
+	res, pair := s.login("jdoe@example.com", "Correct horse battery 1")
+	if res.StatusCode != http.StatusOK || pair == nil {
+		t.Fatal("Error authenticating: ", res.StatusCode)
+	}
+	var got accountResponse
+	if res := s.do("GET", path, pair.AccessToken, nil, &got); res.StatusCode != http.StatusOK || *got.UID != *acc.UID {
+		t.Fatal("Valid token refused: ", res.StatusCode)
+	}
+
+	// the tokens of the disabled accounts are refused
+	acc.Active, acc.Password = new(bool), nil
+	if _, err := s.ctx.DB.SaveAccount(acc); err != nil {
+		t.Fatal("Error disabling account: ", err)
+	}
+	if res := s.do("GET", path, pair.AccessToken, nil, nil); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Token of a disabled account accepted: ", res.StatusCode)
+	}
+}
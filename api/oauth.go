@@ -0,0 +1,598 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jllopis/aloja"
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/oauth"
+	"github.com/jllopis/try5/oidc"
+	"github.com/jllopis/try5/store"
+)
+
+// oauthTokenResponse is the successful response of the token endpoint (RFC 6749 section 5.1)
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// introspection is the response of the introspection endpoint (RFC 7662 section 2.2)
+type introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+}
+
+// noStore forbids caching the responses that carry tokens
+func noStore(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+}
+
+// renderOAuthError writes the error responses of the token, revocation and
+// introspection endpoints (RFC 6749 section 5.2)
+func (ctx *ApiContext) renderOAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	e, ok := err.(*oauth.Error)
+	if !ok {
+		logger.Error("request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		e = oauth.ErrServerError
+	}
+	status := http.StatusBadRequest
+	switch e.Code {
+	case oauth.ErrInvalidClient.Code:
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="try5"`)
+	case oauth.ErrServerError.Code:
+		status = http.StatusInternalServerError
+	}
+	noStore(w)
+	ctx.Render.JSON(w, status, map[string]string{"error": e.Code, "error_description": e.Description})
+}
+
+// oauthRedirect sends the user agent back to the client with the non empty params
+func oauthRedirect(w http.ResponseWriter, r *http.Request, uri string, params map[string]string) {
+	u, _ := url.Parse(uri)
+	q := u.Query()
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// oauthClient authenticates the client of the request, with HTTP Basic or with
+// the client_id and client_secret parameters. The public clients only send their
+// client_id.
+func (ctx *ApiContext) oauthClient(r *http.Request) (*oauth.Client, error) {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		// the credentials are form encoded before the basic encoding (RFC 6749 section 2.3.1)
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return nil, oauth.ErrInvalidClient
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, oauth.ErrInvalidClient
+		}
+		if r.PostFormValue("client_secret") != "" {
+			return nil, oauth.ErrInvalidRequest.WithDescription("more than one client authentication method")
+		}
+	} else {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if id == "" {
+		return nil, oauth.ErrInvalidClient
+	}
+	c, err := ctx.DB.LoadClient(id)
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = oauth.ErrInvalidClient
+		}
+		return nil, err
+	}
+	if c.Public {
+		if secret != "" {
+			return nil, oauth.ErrInvalidClient
+		}
+		return c, nil
+	}
+	if !c.CheckSecret(secret) {
+		return nil, oauth.ErrInvalidClient
+	}
+	return c, nil
+}
+
+// oauthAccount loads the resource owner of a grant, which must still be allowed to authenticate
+func (ctx *ApiContext) oauthAccount(uid string) (*account.Account, error) {
+	a, err := ctx.DB.LoadAccount(uid)
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = oauth.ErrInvalidGrant
+		}
+		return nil, err
+	}
+	if a.Active != nil && !*a.Active || ctx.checkVerified(a) != nil {
+		return nil, oauth.ErrInvalidGrant
+	}
+	return a, nil
+}
+
+// oauthToken loads the code or token value of type typ issued to the client c
+func (ctx *ApiContext) oauthToken(value string, typ oauth.TokenType, c *oauth.Client) (*oauth.Token, error) {
+	t, err := ctx.DB.LoadOAuthToken(oauth.Hash(value))
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = oauth.ErrInvalidGrant
+		}
+		return nil, err
+	}
+	if !t.Valid(typ) || t.ClientID != c.ID {
+		return nil, oauth.ErrInvalidGrant
+	}
+	return t, nil
+}
+
+// consumeOAuthToken deletes a code or refresh token, only the request that deletes it can use it
+func (ctx *ApiContext) consumeOAuthToken(t *oauth.Token) error {
+	n, err := ctx.DB.DeleteOAuthToken(t.ID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return oauth.ErrInvalidGrant
+	}
+	return nil
+}
+
+// issueOAuthTokens stores a new access token with scope for the grant of from and,
+// when refresh is true, a refresh token with the scope of from. The resource owner
+// a gets an ID token when OpenID Connect is enabled and the scope includes openid.
+func (ctx *ApiContext) issueOAuthTokens(from *oauth.Token, scope string, refresh bool, a *account.Account) (*oauthTokenResponse, error) {
+	access, value, err := oauth.NewToken(oauth.Access, from.ClientID, from.AccountUID, scope, from.GrantID, ctx.Tokens.AccessTTL)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.DB.SaveOAuthToken(access); err != nil {
+		return nil, err
+	}
+	res := &oauthTokenResponse{AccessToken: value, TokenType: "Bearer", ExpiresIn: int64(ctx.Tokens.AccessTTL / time.Second), Scope: scope}
+	if refresh {
+		rt, value, err := oauth.NewToken(oauth.Refresh, from.ClientID, from.AccountUID, from.Scope, from.GrantID, ctx.Tokens.RefreshTTL)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.DB.SaveOAuthToken(rt); err != nil {
+			return nil, err
+		}
+		res.RefreshToken = value
+	}
+	if a != nil && ctx.OIDC != nil && oidc.HasScope(scope, oidc.ScopeOpenID) {
+		if res.IDToken, err = ctx.OIDC.IDToken(a, from.ClientID, scope, from.Nonce); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Authorize es el punto de autorización OAuth 2.0 (RFC 6749) con authorization code y
+// PKCE (code_challenge_method S256 obligatorio). El usuario debe tener una sesión; si no
+// la tiene se le envía a OAuthLoginURL con la petición en return_to, o se devuelve
+// login_required con prompt=none. Los clientes los registran los administradores, por
+// lo que no se pide consentimiento. El código se devuelve al redirect_uri del cliente y
+// caduca en un minuto. Con el scope openid el nonce se devuelve en el ID token.
+// curl -ks "https://b2d:8000/oauth/authorize?response_type=code&client_id=...&redirect_uri=...&state=...&code_challenge=...&code_challenge_method=S256" -b "try5_session=..."
+func (ctx *ApiContext) Authorize(w http.ResponseWriter, r *http.Request) {
+	client, err := ctx.DB.LoadClient(r.FormValue("client_id"))
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = newError(http.StatusBadRequest, "invalid_client", "unknown client_id")
+		}
+		ctx.renderError(w, r, err)
+		return
+	}
+	// without a valid redirect uri the errors can not be sent back to the client
+	redirect, ok := client.RedirectURI(r.FormValue("redirect_uri"))
+	if !ok {
+		ctx.renderError(w, r, newError(http.StatusBadRequest, "invalid_redirect_uri", "redirect_uri not registered for the client"))
+		return
+	}
+	state := r.FormValue("state")
+	fail := func(e *oauth.Error) {
+		oauthRedirect(w, r, redirect, map[string]string{"error": e.Code, "error_description": e.Description, "state": state})
+	}
+	if r.FormValue("response_type") != "code" {
+		fail(oauth.ErrUnsupportedResponseType)
+		return
+	}
+	if !client.AllowsGrant(oauth.AuthorizationCode) {
+		fail(oauth.ErrUnauthorizedClient)
+		return
+	}
+	scope, err := client.Scope(r.FormValue("scope"))
+	if err != nil {
+		fail(oauth.ErrInvalidScope)
+		return
+	}
+	challenge := r.FormValue("code_challenge")
+	if challenge == "" || r.FormValue("code_challenge_method") != "S256" {
+		fail(oauth.ErrInvalidRequest.WithDescription("code_challenge with code_challenge_method S256 is required"))
+		return
+	}
+	acc, err := ctx.authenticateRequest(r)
+	if err != nil {
+		// OpenID Connect clients can ask to not show any login page
+		if r.FormValue("prompt") == "none" {
+			fail(oauth.ErrLoginRequired)
+			return
+		}
+		if ctx.OAuthLoginURL != "" {
+			http.Redirect(w, r, ticketLink(ctx.OAuthLoginURL, map[string]string{"return_to": r.URL.RequestURI()}), http.StatusFound)
+			return
+		}
+		fail(oauth.ErrAccessDenied.WithDescription("the user is not authenticated"))
+		return
+	}
+	if ctx.checkVerified(acc) != nil {
+		fail(oauth.ErrAccessDenied.WithDescription("the email of the user is not verified"))
+		return
+	}
+	grantID, err := oauth.NewGrantID()
+	if err != nil {
+		fail(oauth.ErrServerError)
+		return
+	}
+	code, value, err := oauth.NewToken(oauth.Code, client.ID, *acc.UID, scope, grantID, oauth.CodeTTL)
+	if err != nil {
+		fail(oauth.ErrServerError)
+		return
+	}
+	// the redirect uri is only checked on the exchange when the client sent it
+	code.RedirectURI, code.Challenge, code.Nonce = r.FormValue("redirect_uri"), challenge, r.FormValue("nonce")
+	if err := ctx.DB.SaveOAuthToken(code); err != nil {
+		logger.Error("func Authorize", "error", err, "client", client.ID)
+		fail(oauth.ErrServerError)
+		return
+	}
+	logger.Info("func Authorize", "client", client.ID, "uid", *acc.UID, "scope", scope)
+	oauthRedirect(w, r, redirect, map[string]string{"code": value, "state": state})
+}
+
+// OAuthToken es el punto de emisión de tokens OAuth 2.0. Acepta los grants
+// authorization_code (con code_verifier), refresh_token y client_credentials. Los
+// clientes confidenciales se autentican con HTTP Basic o client_id y client_secret.
+// Cada refresh token solo se puede usar una vez y se sustituye por uno nuevo. Si un
+// código se canjea dos veces se revocan los tokens emitidos con él.
+// curl -ks https://b2d:8000/oauth/token -X POST -u "client_id:client_secret" -d "grant_type=authorization_code" -d "code=..." -d "redirect_uri=..." -d "code_verifier=..."
+func (ctx *ApiContext) OAuthToken(w http.ResponseWriter, r *http.Request) {
+	client, err := ctx.oauthClient(r)
+	if err != nil {
+		ctx.renderOAuthError(w, r, err)
+		return
+	}
+	var res *oauthTokenResponse
+	switch grant := r.PostFormValue("grant_type"); {
+	case grant == "":
+		err = oauth.ErrInvalidRequest.WithDescription("missing grant_type")
+	case grant != oauth.AuthorizationCode && grant != oauth.RefreshToken && grant != oauth.ClientCredentials:
+		err = oauth.ErrUnsupportedGrantType
+	case !client.AllowsGrant(grant):
+		err = oauth.ErrUnauthorizedClient
+	case grant == oauth.AuthorizationCode:
+		res, err = ctx.exchangeCode(client, r)
+	case grant == oauth.RefreshToken:
+		res, err = ctx.refreshOAuthToken(client, r)
+	default:
+		res, err = ctx.clientCredentials(client, r)
+	}
+	if err != nil {
+		ctx.renderOAuthError(w, r, err)
+		return
+	}
+	noStore(w)
+	ctx.Render.JSON(w, http.StatusOK, res)
+}
+
+func (ctx *ApiContext) exchangeCode(c *oauth.Client, r *http.Request) (*oauthTokenResponse, error) {
+	value, verifier := r.PostFormValue("code"), r.PostFormValue("code_verifier")
+	if value == "" || verifier == "" {
+		return nil, oauth.ErrInvalidRequest.WithDescription("code and code_verifier are required")
+	}
+	code, err := ctx.oauthToken(value, oauth.Code, c)
+	if err == oauth.ErrInvalidGrant {
+		ctx.codeReused(value, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// a failed exchange also uses up the code
+	if err := ctx.consumeOAuthToken(code); err != nil {
+		if err == oauth.ErrInvalidGrant {
+			// a concurrent request used it
+			ctx.revokeOAuthGrant(code.GrantID, c)
+		}
+		return nil, err
+	}
+	used := *code
+	used.Type, used.Expires = oauth.UsedCode, time.Now().UTC().Add(ctx.Tokens.RefreshTTL)
+	if err := ctx.DB.SaveOAuthToken(&used); err != nil {
+		logger.Warn("func exchangeCode", "error", err, "client", c.ID, "info", "reuse of the code will not be detected")
+	}
+	if code.RedirectURI != "" && r.PostFormValue("redirect_uri") != code.RedirectURI {
+		return nil, oauth.ErrInvalidGrant.WithDescription("redirect_uri does not match the authorization request")
+	}
+	if !oauth.VerifyPKCE(code.Challenge, verifier) {
+		return nil, oauth.ErrInvalidGrant.WithDescription("invalid code_verifier")
+	}
+	a, err := ctx.oauthAccount(code.AccountUID)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.issueOAuthTokens(code, code.Scope, c.AllowsGrant(oauth.RefreshToken), a)
+}
+
+// codeReused revokes the tokens issued from the code value if it was already
+// exchanged by the client c (RFC 6749 section 4.1.2)
+func (ctx *ApiContext) codeReused(value string, c *oauth.Client) {
+	t, err := ctx.DB.LoadOAuthToken(oauth.Hash(value))
+	if err != nil || t.Type != oauth.UsedCode || t.ClientID != c.ID {
+		return
+	}
+	ctx.revokeOAuthGrant(t.GrantID, c)
+}
+
+// revokeOAuthGrant revokes the tokens of an authorization whose code was used twice
+func (ctx *ApiContext) revokeOAuthGrant(grantID string, c *oauth.Client) {
+	n, err := ctx.DB.DeleteOAuthGrant(grantID)
+	if err != nil {
+		logger.Error("func revokeOAuthGrant", "error", err, "client", c.ID, "grant", grantID, "info", "tokens of a reused code not revoked")
+		return
+	}
+	logger.Warn("func revokeOAuthGrant", "client", c.ID, "grant", grantID, "info", "code reused, tokens revoked", "revoked", n)
+}
+
+// revokeOAuthTokens revokes every OAuth code and token of the account
+func (ctx *ApiContext) revokeOAuthTokens(uid string) {
+	n, err := ctx.DB.DeleteAccountOAuthTokens(uid)
+	if err != nil {
+		logger.Warn("func revokeOAuthTokens", "error", err, "uid", uid, "info", "oauth tokens not revoked")
+		return
+	}
+	logger.Info("func revokeOAuthTokens", "revoked", n, "uid", uid)
+}
+
+func (ctx *ApiContext) refreshOAuthToken(c *oauth.Client, r *http.Request) (*oauthTokenResponse, error) {
+	value := r.PostFormValue("refresh_token")
+	if value == "" {
+		return nil, oauth.ErrInvalidRequest.WithDescription("missing refresh_token")
+	}
+	rt, err := ctx.oauthToken(value, oauth.Refresh, c)
+	if err != nil {
+		return nil, err
+	}
+	// the access token can have less scope, the new refresh token keeps the original one
+	scope := rt.Scope
+	if s := r.PostFormValue("scope"); s != "" {
+		if !oauth.SubScope(s, rt.Scope) {
+			return nil, oauth.ErrInvalidScope
+		}
+		scope = s
+	}
+	a, err := ctx.oauthAccount(rt.AccountUID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.consumeOAuthToken(rt); err != nil {
+		return nil, err
+	}
+	return ctx.issueOAuthTokens(rt, scope, true, a)
+}
+
+func (ctx *ApiContext) clientCredentials(c *oauth.Client, r *http.Request) (*oauthTokenResponse, error) {
+	if c.Public {
+		return nil, oauth.ErrUnauthorizedClient
+	}
+	scope, err := c.Scope(r.PostFormValue("scope"))
+	if err != nil {
+		return nil, err
+	}
+	grantID, err := oauth.NewGrantID()
+	if err != nil {
+		return nil, err
+	}
+	// the client acts on its own behalf, the tokens have no account
+	return ctx.issueOAuthTokens(&oauth.Token{ClientID: c.ID, Scope: scope, GrantID: grantID}, scope, false, nil)
+}
+
+// OAuthRevoke revoca un access token o un refresh token del cliente (RFC 7009). Revocar
+// un refresh token revoca también los access tokens emitidos en la misma autorización.
+// La respuesta es siempre 200, exista o no el token.
+// curl -ks https://b2d:8000/oauth/revoke -X POST -u "client_id:client_secret" -d "token=..."
+func (ctx *ApiContext) OAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	client, err := ctx.oauthClient(r)
+	if err != nil {
+		ctx.renderOAuthError(w, r, err)
+		return
+	}
+	value := r.PostFormValue("token")
+	if value == "" {
+		ctx.renderOAuthError(w, r, oauth.ErrInvalidRequest.WithDescription("missing token"))
+		return
+	}
+	t, err := ctx.DB.LoadOAuthToken(oauth.Hash(value))
+	if err != nil && !store.IsNotFound(err) {
+		ctx.renderOAuthError(w, r, err)
+		return
+	}
+	// the unknown tokens and those of other clients are ignored, the response does not
+	// tell whether a token exists
+	if err == nil && t.ClientID == client.ID {
+		switch t.Type {
+		case oauth.Refresh:
+			_, err = ctx.DB.DeleteOAuthGrant(t.GrantID)
+		case oauth.Access:
+			_, err = ctx.DB.DeleteOAuthToken(t.ID)
+		}
+		if err != nil {
+			ctx.renderOAuthError(w, r, err)
+			return
+		}
+		logger.Info("func OAuthRevoke", "client", client.ID, "type", t.Type, "grant", t.GrantID)
+	}
+	noStore(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// OAuthIntrospect devuelve el estado de un access token o refresh token (RFC 7662) a
+// un cliente confidencial, ie. a un servidor de recursos. Los tokens caducados,
+// revocados o de accounts desactivados devuelven {"active": false}.
+// curl -ks https://b2d:8000/oauth/introspect -X POST -u "client_id:client_secret" -d "token=..."
+func (ctx *ApiContext) OAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	client, err := ctx.oauthClient(r)
+	if err == nil && client.Public {
+		err = oauth.ErrUnauthorizedClient.WithDescription("introspection requires a confidential client")
+	}
+	if err != nil {
+		ctx.renderOAuthError(w, r, err)
+		return
+	}
+	value := r.PostFormValue("token")
+	if value == "" {
+		ctx.renderOAuthError(w, r, oauth.ErrInvalidRequest.WithDescription("missing token"))
+		return
+	}
+	res, err := ctx.introspect(value)
+	if err != nil {
+		ctx.renderOAuthError(w, r, err)
+		return
+	}
+	noStore(w)
+	ctx.Render.JSON(w, http.StatusOK, res)
+}
+
+func (ctx *ApiContext) introspect(value string) (*introspection, error) {
+	inactive := &introspection{}
+	t, err := ctx.DB.LoadOAuthToken(oauth.Hash(value))
+	if err != nil {
+		if store.IsNotFound(err) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+	if t.Type != oauth.Access && t.Type != oauth.Refresh || !t.Valid(t.Type) {
+		return inactive, nil
+	}
+	res := &introspection{
+		Active:   true,
+		Scope:    t.Scope,
+		ClientID: t.ClientID,
+		Exp:      t.Expires.Unix(),
+		Iat:      t.Created.Unix(),
+		Sub:      t.ClientID,
+		Iss:      ctx.Tokens.Issuer,
+	}
+	if t.Type == oauth.Access {
+		res.TokenType = "Bearer"
+	}
+	if t.AccountUID != "" {
+		a, err := ctx.oauthAccount(t.AccountUID)
+		if err != nil {
+			if err == oauth.ErrInvalidGrant {
+				return inactive, nil
+			}
+			return nil, err
+		}
+		res.Sub, res.Username = *a.UID, *a.Email
+	}
+	return res, nil
+}
+
+// GetOAuthClients devuelve los clientes OAuth registrados.
+// curl -ks https://b2d:8000/api/v1/oauth/clients -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetOAuthClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := ctx.DB.LoadAllClients()
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if clients == nil {
+		clients = []*oauth.Client{}
+	}
+	ctx.Render.JSON(w, http.StatusOK, clients)
+}
+
+// GetOAuthClient devuelve el cliente OAuth indicado.
+// curl -ks https://b2d:8000/api/v1/oauth/clients/5f0c... -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetOAuthClient(w http.ResponseWriter, r *http.Request) {
+	c, err := ctx.DB.LoadClient(aloja.Params(r).ByName("id"))
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusOK, c)
+}
+
+// NewOAuthClient registra un cliente OAuth. Los clientes confidenciales reciben un
+// client_secret que sólo se devuelve en esta respuesta; los públicos (public=true,
+// ie. aplicaciones SPA o móviles) no tienen secreto y no pueden usar client_credentials.
+// curl -ks https://b2d:8000/api/v1/oauth/clients -X POST -H "Authorization: Bearer ..." -d '{"name":"wiki","redirect_uris":["https://wiki.local/callback"],"scopes":["openid","profile"]}' | jp -
+func (ctx *ApiContext) NewOAuthClient(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Name         string   `json:"name"`
+		Public       bool     `json:"public"`
+		RedirectURIs []string `json:"redirect_uris"`
+		GrantTypes   []string `json:"grant_types"`
+		Scopes       []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		ctx.renderError(w, r, errInvalidBody)
+		return
+	}
+	c, secret, err := oauth.NewClient(data.Name, data.Public, data.RedirectURIs, data.GrantTypes, data.Scopes)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if err := ctx.DB.SaveClient(c); err != nil {
+		logger.Error("func NewOAuthClient", "error", err)
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func NewOAuthClient", "client created", c.ID, "by", *CurrentAccount(r).UID)
+	res := map[string]interface{}{"client": c}
+	if secret != "" {
+		res["client_secret"] = secret
+	}
+	ctx.Render.JSON(w, http.StatusCreated, res)
+}
+
+// DeleteOAuthClient elimina un cliente OAuth junto con todos sus tokens.
+// curl -ks https://b2d:8000/api/v1/oauth/clients/5f0c... -X DELETE -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) DeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	id := aloja.Params(r).ByName("id")
+	n, err := ctx.DB.DeleteClient(id)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if n == 0 {
+		ctx.renderError(w, r, store.ErrClientNotFound)
+		return
+	}
+	logger.Info("func DeleteOAuthClient", "client deleted", id, "by", *CurrentAccount(r).UID)
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Table: "oauth_clients", UID: id})
+}
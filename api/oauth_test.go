@@ -0,0 +1,242 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/oauth"
+)
+
+// oauthApp is a registered client with its secret
+type oauthApp struct {
+	*oauth.Client
+	secret string
+}
+
+// oauthClient registers a confidential client with the redirect uris
+func (s *testServer) oauthClient(redirectURIs ...string) *oauthApp {
+	c, secret, err := oauth.NewClient("app", false, redirectURIs, nil, []string{"read", "write"})
+	if err != nil {
+		s.t.Fatal("Error creating client: ", err)
+	}
+	if err := s.ctx.DB.SaveClient(c); err != nil {
+		s.t.Fatal("Error saving client: ", err)
+	}
+	return &oauthApp{Client: c, secret: secret}
+}
+
+// authorize gets a code for the account of bearer with the PKCE challenge
+func (s *testServer) authorize(app *oauthApp, bearer, redirectURI, challenge string) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {app.ID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	res := s.get("/oauth/authorize?"+q.Encode(), bearer)
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if res.StatusCode != http.StatusFound || err != nil || loc.Query().Get("code") == "" {
+		s.t.Fatal("No code issued: ", res.StatusCode, res.Header.Get("Location"))
+	}
+	return loc.Query().Get("code")
+}
+
+// oauthPost sends the form to the oauth endpoint authenticated as app and decodes
+// the json response in out
+func (s *testServer) oauthPost(path string, app *oauthApp, form url.Values, out interface{}) *http.Response {
+	req, err := http.NewRequest("POST", s.url+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		s.t.Fatal("Error creating request: ", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(app.ID, app.secret)
+	return s.send(req, out)
+}
+
+// oauthError is the error response of the oauth endpoints
+type oauthError struct {
+	Error string `json:"error"`
+}
+
+// exchange redeems the code and returns the response and its tokens or error
+func (s *testServer) exchange(app *oauthApp, code, redirectURI, verifier string) (*http.Response, *oauthTokenResponse, string) {
+	var body struct {
+		oauthTokenResponse
+		oauthError
+	}
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}, "code_verifier": {verifier}}
+	res := s.oauthPost("/oauth/token", app, form, &body)
+	return res, &body.oauthTokenResponse, body.Error
+}
+
+// refreshOAuth uses the refresh token and returns the response and its error, if any
+func (s *testServer) refreshOAuth(app *oauthApp, refresh string) (*http.Response, string) {
+	var e oauthError
+	res := s.oauthPost("/oauth/token", app, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}}, &e)
+	return res, e.Error
+}
+
+// introspect tells if the token is active for the resource server rs
+func (s *testServer) introspect(rs *oauthApp, value string) *introspection {
+	var res introspection
+	if r := s.oauthPost("/oauth/introspect", rs, url.Values{"token": {value}}, &res); r.StatusCode != http.StatusOK {
+		s.t.Fatal("Error introspecting token: ", r.StatusCode)
+	}
+	return &res
+}
+
+// pkce returns a code verifier and its challenge
+func (s *testServer) pkce() (string, string) {
+	verifier, challenge, err := federation.NewPKCE()
+	if err != nil {
+		s.t.Fatal("Error creating PKCE: ", err)
+	}
+	return verifier, challenge
+}
+
+func TestOAuthCodeExchange(t *testing.T) {
+	s := newTestServer(t, nil)
+	bearer := s.token(s.account("jdoe@example.com", "SuperDifficultPass"))
+	cb := "https://app.local/cb"
+	app := s.oauthClient(cb, "https://app.local/other")
+	other := s.oauthClient(cb)
+	verifier, challenge := s.pkce()
+
+	// a redirect uri not registered is refused before any code is issued
+	q := url.Values{"response_type": {"code"}, "client_id": {app.ID}, "redirect_uri": {"https://evil.local/cb"}, "code_challenge": {challenge}, "code_challenge_method": {"S256"}}
+	if res := s.get("/oauth/authorize?"+q.Encode(), bearer); res.StatusCode != http.StatusBadRequest || res.Header.Get("Location") != "" {
+		t.Fatal("Expected 400 for an unregistered redirect_uri, got ", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	// the verifier must match the S256 challenge
+	wrong, _ := s.pkce()
+	code := s.authorize(app, bearer, cb, challenge)
+	if res, _, e := s.exchange(app, code, cb, wrong); res.StatusCode != http.StatusBadRequest || e != "invalid_grant" {
+		t.Fatal("Expected invalid_grant for a wrong code_verifier, got ", res.StatusCode, e)
+	}
+	// and the redirect uri the one of the authorization
+	code = s.authorize(app, bearer, cb, challenge)
+	if res, _, e := s.exchange(app, code, "https://app.local/other", verifier); res.StatusCode != http.StatusBadRequest || e != "invalid_grant" {
+		t.Fatal("Expected invalid_grant for another redirect_uri, got ", res.StatusCode, e)
+	}
+
+	// another client can not use the code
+	code = s.authorize(app, bearer, cb, challenge)
+	if res, _, e := s.exchange(other, code, cb, verifier); res.StatusCode != http.StatusBadRequest || e != "invalid_grant" {
+		t.Fatal("Expected invalid_grant for the code of another client, got ", res.StatusCode, e)
+	}
+	res, tokens, e := s.exchange(app, code, cb, verifier)
+	if res.StatusCode != http.StatusOK || tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatal("Error exchanging code: ", res.StatusCode, e)
+	}
+	if !s.introspect(app, tokens.AccessToken).Active {
+		t.Fatal("Access token not active")
+	}
+
+	// a code used twice revokes the tokens issued with it
+	if res, _, e := s.exchange(app, code, cb, verifier); res.StatusCode != http.StatusBadRequest || e != "invalid_grant" {
+		t.Fatal("Expected invalid_grant for a used code, got ", res.StatusCode, e)
+	}
+	if s.introspect(app, tokens.AccessToken).Active {
+		t.Fatal("Access token active after the reuse of its code")
+	}
+	if res, e := s.refreshOAuth(app, tokens.RefreshToken); res.StatusCode != http.StatusBadRequest || e != "invalid_grant" {
+		t.Fatal("Expected invalid_grant for the refresh token of a reused code, got ", res.StatusCode, e)
+	}
+}
+
+func TestOAuthRevoke(t *testing.T) {
+	s := newTestServer(t, nil)
+	bearer := s.token(s.account("jdoe@example.com", "SuperDifficultPass"))
+	cb := "https://app.local/cb"
+	app := s.oauthClient(cb)
+	verifier, challenge := s.pkce()
+
+	_, tokens, _ := s.exchange(app, s.authorize(app, bearer, cb, challenge), cb, verifier)
+	var refreshed oauthTokenResponse
+	if res := s.oauthPost("/oauth/token", app, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}, &refreshed); res.StatusCode != http.StatusOK {
+		t.Fatal("Error refreshing token: ", res.StatusCode)
+	}
+	// the refresh token was used up
+	if res, e := s.refreshOAuth(app, tokens.RefreshToken); res.StatusCode != http.StatusBadRequest || e != "invalid_grant" {
+		t.Fatal("Expected invalid_grant for a used refresh token, got ", res.StatusCode, e)
+	}
+
+	// revoking the refresh token revokes the whole authorization
+	if res := s.oauthPost("/oauth/revoke", app, url.Values{"token": {refreshed.RefreshToken}}, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Error revoking token: ", res.StatusCode)
+	}
+	if res, e := s.refreshOAuth(app, refreshed.RefreshToken); res.StatusCode != http.StatusBadRequest || e != "invalid_grant" {
+		t.Fatal("Expected invalid_grant for a revoked refresh token, got ", res.StatusCode, e)
+	}
+	for _, value := range []string{tokens.AccessToken, refreshed.AccessToken} {
+		if s.introspect(app, value).Active {
+			t.Fatal("Access token active after the revocation")
+		}
+	}
+}
+
+func TestOAuthIntrospect(t *testing.T) {
+	s := newTestServer(t, nil)
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+	app := s.oauthClient("https://app.local/cb")
+	issue := func(ttl time.Duration) string {
+		tk, value, err := oauth.NewToken(oauth.Access, app.ID, *acc.UID, "read", "grant-1", ttl)
+		if err != nil {
+			t.Fatal("Error creating token: ", err)
+		}
+		if err := s.ctx.DB.SaveOAuthToken(tk); err != nil {
+			t.Fatal("Error saving token: ", err)
+		}
+		return value
+	}
+
+	if res := s.introspect(app, issue(time.Hour)); !res.Active || res.Sub != *acc.UID || res.Username != "jdoe@example.com" || res.Scope != "read" {
+		t.Fatalf("Wrong introspection of an active token: %+v", res)
+	}
+	if res := s.introspect(app, issue(-time.Second)); res.Active || res.Sub != "" {
+		t.Fatalf("Expired token active: %+v", res)
+	}
+	if res := s.introspect(app, "unknown"); res.Active {
+		t.Fatal("Unknown token active")
+	}
+
+	// the public clients can not introspect
+	public, _, err := oauth.NewClient("spa", true, []string{"https://spa.local/cb"}, nil, nil)
+	if err != nil || s.ctx.DB.SaveClient(public) != nil {
+		t.Fatal("Error creating public client: ", err)
+	}
+	var e oauthError
+	form := url.Values{"client_id": {public.ID}, "token": {issue(time.Hour)}}
+	if res := s.post("/oauth/introspect", "", form, &e); res.StatusCode != http.StatusBadRequest || e.Error != "unauthorized_client" {
+		t.Fatal("Expected unauthorized_client for a public client, got ", res.StatusCode, e.Error)
+	}
+}
+
+func TestOAuthRevokedOnPasswordChange(t *testing.T) {
+	s := newTestServer(t, nil)
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+	bearer := s.token(acc)
+	cb := "https://app.local/cb"
+	app := s.oauthClient(cb)
+	verifier, challenge := s.pkce()
+	_, tokens, _ := s.exchange(app, s.authorize(app, bearer, cb, challenge), cb, verifier)
+
+	body := map[string]interface{}{"email": "jdoe@example.com", "name": "Jane Doe", "password": "AnotherDifficultPass1", "current_password": "SuperDifficultPass"}
+	if res := s.do("PUT", "/api/v1/accounts/"+*acc.UID, bearer, body, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Error changing password: ", res.StatusCode)
+	}
+	if s.introspect(app, tokens.AccessToken).Active {
+		t.Fatal("Access token active after a password change")
+	}
+	if res, e := s.refreshOAuth(app, tokens.RefreshToken); res.StatusCode != http.StatusBadRequest || e != "invalid_grant" {
+		t.Fatal("Expected invalid_grant after a password change, got ", res.StatusCode, e)
+	}
+}
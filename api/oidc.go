@@ -0,0 +1,123 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jllopis/try5/oauth"
+	"github.com/jllopis/try5/oidc"
+	"github.com/jllopis/try5/store"
+)
+
+var errOIDCUnavailable = newError(http.StatusNotImplemented, "oidc_unavailable", "openid connect is not configured")
+
+// discovery is the OpenID Provider metadata (OpenID Connect Discovery 1.0 section 3)
+type discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// OpenIDConfiguration devuelve los metadatos del proveedor OpenID Connect con los
+// puntos de acceso bajo el issuer configurado.
+// curl -ks https://b2d:8000/.well-known/openid-configuration | jp -
+func (ctx *ApiContext) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	if ctx.OIDC == nil {
+		ctx.renderError(w, r, errOIDCUnavailable)
+		return
+	}
+	base := strings.TrimSuffix(ctx.OIDC.Issuer, "/")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	ctx.Render.JSON(w, http.StatusOK, &discovery{
+		Issuer:                            ctx.OIDC.Issuer,
+		AuthorizationEndpoint:             base + "/oauth/authorize",
+		TokenEndpoint:                     base + "/oauth/token",
+		UserinfoEndpoint:                  base + "/userinfo",
+		JWKSURI:                           base + "/jwks.json",
+		RevocationEndpoint:                base + "/oauth/revoke",
+		IntrospectionEndpoint:             base + "/oauth/introspect",
+		ScopesSupported:                   []string{oidc.ScopeOpenID, oidc.ScopeProfile, oidc.ScopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{oauth.AuthorizationCode, oauth.RefreshToken, oauth.ClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "nonce", "email", "email_verified", "name", "picture", "updated_at"},
+	})
+}
+
+// JWKS devuelve las claves públicas que firman los ID tokens. Tras rotar las claves
+// las anteriores se siguen publicando; el kid del token indica la clave a usar.
+// curl -ks https://b2d:8000/jwks.json | jp -
+func (ctx *ApiContext) JWKS(w http.ResponseWriter, r *http.Request) {
+	if ctx.OIDC == nil {
+		ctx.renderError(w, r, errOIDCUnavailable)
+		return
+	}
+	// short, so the clients see a rotated key soon
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	ctx.Render.JSON(w, http.StatusOK, ctx.OIDC.Keys.JWKS())
+}
+
+// renderBearerError writes the errors of a resource protected with OAuth access tokens (RFC 6750 section 3)
+func (ctx *ApiContext) renderBearerError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="try5", error=%q, error_description=%q`, code, description))
+	ctx.Render.JSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// UserInfo devuelve los claims del account dueño del access token OAuth, según los
+// scopes concedidos: sub siempre, email y email_verified con email, y name, picture
+// (el gravatar) y updated_at con profile. El token debe incluir el scope openid.
+// curl -ks https://b2d:8000/userinfo -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) UserInfo(w http.ResponseWriter, r *http.Request) {
+	if ctx.OIDC == nil {
+		ctx.renderError(w, r, errOIDCUnavailable)
+		return
+	}
+	value := bearerToken(r)
+	if value == "" {
+		value = r.PostFormValue("access_token")
+	}
+	if value == "" {
+		ctx.renderBearerError(w, http.StatusUnauthorized, "invalid_request", "missing access token")
+		return
+	}
+	t, err := ctx.DB.LoadOAuthToken(oauth.Hash(value))
+	if err != nil && !store.IsNotFound(err) {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if err != nil || !t.Valid(oauth.Access) || t.AccountUID == "" {
+		ctx.renderBearerError(w, http.StatusUnauthorized, "invalid_token", "the access token is invalid or expired")
+		return
+	}
+	if !oidc.HasScope(t.Scope, oidc.ScopeOpenID) {
+		ctx.renderBearerError(w, http.StatusForbidden, "insufficient_scope", "the access token has no openid scope")
+		return
+	}
+	a, err := ctx.oauthAccount(t.AccountUID)
+	if err == oauth.ErrInvalidGrant {
+		ctx.renderBearerError(w, http.StatusUnauthorized, "invalid_token", "the account can not authenticate")
+		return
+	}
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	noStore(w)
+	ctx.Render.JSON(w, http.StatusOK, oidc.AccountClaims(a, t.Scope))
+}
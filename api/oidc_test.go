@@ -0,0 +1,113 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/oauth"
+	"github.com/jllopis/try5/oidc"
+)
+
+// newOIDCServer starts a server that is an OpenID provider with issuer https://try5.local
+func newOIDCServer(t *testing.T) *testServer {
+	oidc.KeyBits = 1024
+	k, err := oidc.NewKey()
+	if err != nil {
+		t.Fatal("Error generating key: ", err)
+	}
+	ks, err := oidc.NewKeySet(k)
+	if err != nil {
+		t.Fatal("Error creating key set: ", err)
+	}
+	return newTestServer(t, func(ctx *ApiContext) {
+		ctx.OIDC = &oidc.Provider{Issuer: "https://try5.local", Keys: ks, TTL: time.Minute}
+	})
+}
+
+// accessToken stores an OAuth access token of the account with the scope and returns its value
+func (s *testServer) accessToken(acc *account.Account, scope string, ttl time.Duration) string {
+	t, value, err := oauth.NewToken(oauth.Access, "client", *acc.UID, scope, "grant", ttl)
+	if err != nil {
+		s.t.Fatal("Error creating token: ", err)
+	}
+	if err := s.ctx.DB.SaveOAuthToken(t); err != nil {
+		s.t.Fatal("Error saving token: ", err)
+	}
+	return value
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	if res := newTestServer(t, nil).do("GET", "/.well-known/openid-configuration", "", nil, nil); res.StatusCode != http.StatusNotImplemented {
+		t.Fatal("Expected 501 without openid connect, got ", res.StatusCode)
+	}
+
+	s := newOIDCServer(t)
+	var d discovery
+	if res := s.do("GET", "/.well-known/openid-configuration", "", nil, &d); res.StatusCode != http.StatusOK {
+		t.Fatal("Error getting the configuration: ", res.StatusCode)
+	}
+	if d.Issuer != "https://try5.local" || d.JWKSURI != "https://try5.local/jwks.json" || d.UserinfoEndpoint != "https://try5.local/userinfo" || d.TokenEndpoint != "https://try5.local/oauth/token" {
+		t.Fatalf("Wrong configuration: %+v", d)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		} `json:"keys"`
+	}
+	if res := s.do("GET", "/jwks.json", "", nil, &set); res.StatusCode != http.StatusOK {
+		t.Fatal("Error getting the JWK set: ", res.StatusCode)
+	}
+	if len(set.Keys) != 1 || set.Keys[0].Kid != s.ctx.OIDC.Keys.Keys()[0].ID || set.Keys[0].Kty != "RSA" || set.Keys[0].N == "" {
+		t.Fatalf("Wrong JWK set: %+v", set)
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	s := newOIDCServer(t)
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+
+	var e struct {
+		Error string `json:"error"`
+	}
+	res := s.do("GET", "/userinfo", "", nil, &e)
+	if res.StatusCode != http.StatusUnauthorized || e.Error != "invalid_request" || !strings.HasPrefix(res.Header.Get("WWW-Authenticate"), "Bearer") {
+		t.Fatal("Expected 401 invalid_request without a token, got ", res.StatusCode, e.Error)
+	}
+	for _, bearer := range []string{"V4TqqCPyNotIssued", s.token(acc), s.accessToken(acc, "openid", -time.Second)} {
+		if res := s.do("GET", "/userinfo", bearer, nil, &e); res.StatusCode != http.StatusUnauthorized || e.Error != "invalid_token" {
+			t.Fatal("Expected 401 invalid_token, got ", res.StatusCode, e.Error)
+		}
+	}
+	if res := s.do("GET", "/userinfo", s.accessToken(acc, "email profile", time.Minute), nil, &e); res.StatusCode != http.StatusForbidden || e.Error != "insufficient_scope" {
+		t.Fatal("Expected 403 insufficient_scope without openid, got ", res.StatusCode, e.Error)
+	}
+
+	// the claims depend on the scope
+	var c oidc.Claims
+	if res := s.do("GET", "/userinfo", s.accessToken(acc, "openid", time.Minute), nil, &c); res.StatusCode != http.StatusOK {
+		t.Fatal("Error getting userinfo: ", res.StatusCode)
+	}
+	if c.Subject != *acc.UID || c.Email != "" || c.Name != "" || c.Picture != "" {
+		t.Fatalf("Claims outside the openid scope: %+v", c)
+	}
+	c = oidc.Claims{}
+	s.do("GET", "/userinfo", s.accessToken(acc, "openid email", time.Minute), nil, &c)
+	if c.Subject != *acc.UID || c.Email != "jdoe@example.com" || c.Name != "" {
+		t.Fatalf("Wrong claims for the email scope: %+v", c)
+	}
+	c = oidc.Claims{}
+	form := url.Values{"access_token": {s.accessToken(acc, "openid profile", time.Minute)}}
+	if res := s.post("/userinfo", "", form, &c); res.StatusCode != http.StatusOK {
+		t.Fatal("Error posting userinfo: ", res.StatusCode)
+	}
+	if c.Email != "" || c.Name != "Test User" || c.Picture == "" {
+		t.Fatalf("Wrong claims for the profile scope: %+v", c)
+	}
+}
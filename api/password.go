@@ -0,0 +1,151 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/mailer"
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/ticket"
+)
+
+// DefaultResetTTL is how long a password reset link is valid when ResetTTL is not set
+const DefaultResetTTL = time.Hour
+
+var (
+	errInvalidResetToken = newError(http.StatusBadRequest, "invalid_reset_token", "invalid or expired reset token")
+	errMissingToken      = newError(http.StatusBadRequest, "missing_token", "token cannot be nil")
+
+	// DefaultResetTemplate is the email sent with the reset link when ResetTemplate is
+	// not set. It gets the Name and Email of the account, the Link and its Expires date.
+	DefaultResetTemplate = mailer.MustTemplate("password_reset", "Reset your password", `Hello {{.Name}},
+
+somebody, hopefully you, asked to reset the password of the account {{.Email}}.
+Follow this link to choose a new one:
+
+{{.Link}}
+
+The link can be used once and expires on {{.Expires.Format "2006-01-02 15:04 MST"}}.
+If you did not ask for it you can ignore this email, your password has not changed.
+`)
+)
+
+// ForgotPassword envía al email indicado un enlace para restablecer la password. La
+// respuesta es siempre la misma, exista o no el account, para no revelar qué emails
+// están registrados. Cada petición invalida los enlaces enviados anteriormente.
+// curl -ks https://b2d:8000/api/v1/password/forgot -X POST -d "email=tu4@test.com"
+func (ctx *ApiContext) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var email string
+	if email = r.FormValue("email"); email == "" {
+		ctx.renderError(w, r, errMissingEmail)
+		return
+	}
+	ok := map[string]string{"status": "ok"}
+	acc, err := ctx.DB.GetAccountByEmail(email)
+	if err != nil {
+		if !store.IsNotFound(err) {
+			ctx.renderError(w, r, err)
+			return
+		}
+		logger.Info("func ForgotPassword", "info", "unknown email")
+		ctx.Render.JSON(w, http.StatusOK, ok)
+		return
+	}
+	if acc.Active != nil && !*acc.Active {
+		logger.Info("func ForgotPassword", "info", "account disabled", "uid", *acc.UID)
+		ctx.Render.JSON(w, http.StatusOK, ok)
+		return
+	}
+	ttl := ctx.ResetTTL
+	if ttl == 0 {
+		ttl = DefaultResetTTL
+	}
+	t, token, err := ctx.issueTicket(*acc.UID, ticket.PasswordReset, ttl)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	tpl := ctx.ResetTemplate
+	if tpl == nil {
+		tpl = DefaultResetTemplate
+	}
+	link := ticketLink(ctx.ResetURL, map[string]string{"token": token})
+	if err := ctx.sendMail(tpl, acc, map[string]interface{}{"Link": link, "Expires": t.Expires}); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func ForgotPassword", "reset requested", *acc.UID, "expires", t.Expires)
+	ctx.Render.JSON(w, http.StatusOK, ok)
+}
+
+// ResetPassword cambia la password del account usando el token de un enlace enviado por
+// ForgotPassword. El token solo se puede usar una vez; al cambiar la password se
+// cierran las sesiones del account, se revocan sus refresh tokens y sus tokens OAuth y
+// se desbloquean sus intentos fallidos.
+// curl -ks https://b2d:8000/api/v1/password/reset -X POST -d "token=3q2-7wQk..." -d "password=..."
+func (ctx *ApiContext) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var token, password string
+	if token = r.FormValue("token"); token == "" {
+		ctx.renderError(w, r, errMissingToken)
+		return
+	}
+	if password = r.FormValue("password"); password == "" {
+		ctx.renderError(w, r, errMissingPassword)
+		return
+	}
+	t, err := ctx.DB.LoadTicket(ticket.ID(token))
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = errInvalidResetToken
+		}
+		ctx.renderError(w, r, err)
+		return
+	}
+	if t.Check(ticket.PasswordReset) != nil {
+		ctx.renderError(w, r, errInvalidResetToken)
+		return
+	}
+	saved, err := ctx.DB.LoadAccount(t.AccountUID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = errInvalidResetToken
+		}
+		ctx.renderError(w, r, err)
+		return
+	}
+	if saved.Active != nil && !*saved.Active {
+		ctx.renderError(w, r, ErrAccountDisabled)
+		return
+	}
+	// the policy is checked before using the token, so the user can try another password
+	if err := saved.CheckPassword(password); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	// only the request that deletes the ticket can use it
+	if n, err := ctx.DB.DeleteTicket(t.ID); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	} else if n == 0 {
+		ctx.renderError(w, r, errInvalidResetToken)
+		return
+	}
+	update := *saved
+	update.Password = &password
+	if _, err := ctx.DB.SaveAccount(&update); err != nil {
+		logger.Error("func ResetPassword", "error", err, "uid", t.AccountUID)
+		ctx.renderError(w, r, err)
+		return
+	}
+	if _, err := ctx.DB.DeleteAccountSessions(t.AccountUID); err != nil {
+		logger.Warn("func ResetPassword", "error", err, "uid", t.AccountUID, "info", "sessions not revoked")
+	}
+	ctx.revokeRefreshTokens(t.AccountUID)
+	ctx.revokeOAuthTokens(t.AccountUID)
+	if _, err := ctx.DB.DeleteAttempts(lockout.AccountKey(*saved.Email)); err != nil {
+		logger.Warn("func ResetPassword", "error", err, "uid", t.AccountUID, "info", "failed attempts not cleared")
+	}
+	logger.Info("func ResetPassword", "password reset", t.AccountUID)
+	ctx.Render.JSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/mailer"
+	"github.com/jllopis/try5/ticket"
+)
+
+// newResetServer starts a server that writes the emails to a temporary directory,
+// removed with the returned func
+func newResetServer(t *testing.T) (*testServer, string, func()) {
+	dir, err := ioutil.TempDir("", "try5-mail")
+	if err != nil {
+		t.Fatal("Error creating mail dir: ", err)
+	}
+	s := newTestServer(t, func(ctx *ApiContext) {
+		ctx.Mailer = &mailer.File{Dir: dir, From: "try5@localhost"}
+		ctx.ResetURL = "https://try5.test/reset"
+	})
+	return s, dir, func() { os.RemoveAll(dir) }
+}
+
+// forgot asks for a reset link and returns the response and its body
+func (s *testServer) forgot(email string) (*http.Response, map[string]string) {
+	var body map[string]string
+	res := s.post("/api/v1/password/forgot", "", url.Values{"email": {email}}, &body)
+	return res, body
+}
+
+// reset sends the reset token with the new password
+func (s *testServer) reset(token, password string) (*http.Response, *apiError) {
+	var e apiError
+	res := s.post("/api/v1/password/reset", "", url.Values{"token": {token}, "password": {password}}, &e)
+	return res, &e
+}
+
+func TestForgotPassword(t *testing.T) {
+	s, dir, cleanup := newResetServer(t)
+	defer cleanup()
+	s.account("jdoe@example.com", "SuperDifficultPass")
+	disabled := s.account("off@example.com", "SuperDifficultPass")
+	off := false
+	disabled.Active, disabled.Password = &off, nil
+	if _, err := s.ctx.DB.SaveAccount(disabled); err != nil {
+		t.Fatal("Error disabling account: ", err)
+	}
+
+	// the response does not tell which emails are registered
+	known, knownBody := s.forgot("jdoe@example.com")
+	for _, email := range []string{"nobody@example.com", "off@example.com"} {
+		res, body := s.forgot(email)
+		if res.StatusCode != known.StatusCode || len(body) != len(knownBody) || body["status"] != knownBody["status"] {
+			t.Fatalf("Different response for %s: %d %v, want %d %v", email, res.StatusCode, body, known.StatusCode, knownBody)
+		}
+	}
+	if known.StatusCode != http.StatusOK {
+		t.Fatal("Error asking for a reset link: ", known.StatusCode)
+	}
+	// only the registered and active account gets the link
+	verifyToken(t, dir, "jdoe@example.com", 1)
+	for _, email := range []string{"nobody_at_example.com", "off_at_example.com"} {
+		if files, _ := filepath.Glob(filepath.Join(dir, "*-"+email+".eml")); len(files) != 0 {
+			t.Fatal("Reset link sent to ", email)
+		}
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	defer func(p *account.PasswordPolicy) { account.Policy = p }(account.Policy)
+	var err error
+	if account.Policy, err = account.NewPasswordPolicy(account.PolicyConfig{MinClasses: 3}); err != nil {
+		t.Fatal("Error creating policy: ", err)
+	}
+	s, dir, cleanup := newResetServer(t)
+	defer cleanup()
+	acc := s.account("jdoe@example.com", "Super-Difficult-1")
+
+	// the session and the refresh token of a login before the reset
+	res := s.post("/api/v1/authenticate", "", url.Values{"email": {"jdoe@example.com"}, "password": {"Super-Difficult-1"}, "session": {"true"}}, nil)
+	session := cookie(res, SessionCookieName)
+	_, pair := s.login("jdoe@example.com", "Super-Difficult-1")
+	if session == nil || pair == nil {
+		t.Fatal("Error logging in")
+	}
+	if res := s.get("/api/v1/accounts/"+*acc.UID, "", session); res.StatusCode != http.StatusOK {
+		t.Fatal("Session refused before the reset: ", res.StatusCode)
+	}
+
+	// a new link invalidates the previous one
+	s.forgot("jdoe@example.com")
+	first := verifyToken(t, dir, "jdoe@example.com", 1)
+	s.forgot("jdoe@example.com")
+	token := verifyToken(t, dir, "jdoe@example.com", 2)
+	if res, e := s.reset(first, "Another-Difficult-2"); res.StatusCode != http.StatusBadRequest || e.Code != "invalid_reset_token" {
+		t.Fatal("Expected invalid_reset_token for a replaced link, got ", res.StatusCode, e.Code)
+	}
+
+	// the policy is checked and a rejected password does not use up the token
+	if res, e := s.reset(token, "superdifficult"); res.StatusCode != http.StatusBadRequest || e.Code != "password_missing_classes" {
+		t.Fatal("Expected password_missing_classes, got ", res.StatusCode, e.Code)
+	}
+	if res, e := s.reset(token, "Another-Difficult-2"); res.StatusCode != http.StatusOK {
+		t.Fatal("Error resetting password: ", res.StatusCode, e.Code)
+	}
+	if res, e := s.reset(token, "Third-Difficult-3"); res.StatusCode != http.StatusBadRequest || e.Code != "invalid_reset_token" {
+		t.Fatal("Reset token used twice: ", res.StatusCode, e.Code)
+	}
+	if res, _ := s.login("jdoe@example.com", "Another-Difficult-2"); res.StatusCode != http.StatusOK {
+		t.Fatal("New password refused: ", res.StatusCode)
+	}
+
+	// the reset ends the sessions and revokes the refresh tokens
+	if res := s.get("/api/v1/accounts/"+*acc.UID, "", session); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Session valid after the reset: ", res.StatusCode)
+	}
+	if sessions, _ := s.ctx.DB.LoadAccountSessions(*acc.UID); len(sessions) != 0 {
+		t.Fatal("Sessions kept after the reset: ", len(sessions))
+	}
+	if res, _ := s.refresh(pair.RefreshToken); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Refresh token valid after the reset: ", res.StatusCode)
+	}
+
+	// an expired token is refused
+	_, expired, err := s.ctx.issueTicket(*acc.UID, ticket.PasswordReset, -time.Second)
+	if err != nil {
+		t.Fatal("Error issuing ticket: ", err)
+	}
+	if res, e := s.reset(expired, "Third-Difficult-3"); res.StatusCode != http.StatusBadRequest || e.Code != "invalid_reset_token" {
+		t.Fatal("Expired reset token accepted: ", res.StatusCode, e.Code)
+	}
+	if res, _ := s.login("jdoe@example.com", "Another-Difficult-2"); res.StatusCode != http.StatusOK {
+		t.Fatal("Password changed by an expired token: ", res.StatusCode)
+	}
+}
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/jllopis/aloja"
+	"github.com/jllopis/try5/rbac"
+	"github.com/jllopis/try5/store"
+)
+
+// RequirePermission returns a middleware that only lets through the requests whose
+// authenticated account holds perm. It must be used after RequireAuth.
+func (ctx *ApiContext) RequirePermission(perm rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ok, err := rbac.Authorize(ctx.DB, CurrentAccount(r), perm)
+			if err != nil {
+				logger.Error("func RequirePermission", "error", err, "permission", perm)
+				ctx.renderError(w, r, err)
+				return
+			}
+			if !ok {
+				ctx.renderError(w, r, newError(http.StatusForbidden, errPermissionDenied.code, errPermissionDenied.message+": "+string(perm)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RequireOwnerOrPermission is RequirePermission for the requests on the accounts of
+// others, the requests of the authenticated account on its own uid are let through.
+// It must be used after RequireAuth on the routes with a :uid parameter.
+func (ctx *ApiContext) RequireOwnerOrPermission(perm rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		check := ctx.RequirePermission(perm)(next)
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if isOwner(r, aloja.Params(r).ByName("uid")) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			check.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// GetAllRoles devuelve la lista de roles
+// curl -ks https://b2d:8000/api/v1/roles -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetAllRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := ctx.DB.LoadAllRoles()
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if roles == nil {
+		roles = []*rbac.Role{}
+	}
+	ctx.Render.JSON(w, http.StatusOK, roles)
+}
+
+// GetRoleByID devuelve el rol solicitado
+// curl -ks https://b2d:8000/api/v1/roles/1 -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetRoleByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := ctx.pathID(w, r, "id", "get")
+	if !ok {
+		return
+	}
+	role, err := ctx.DB.LoadRole(id)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusOK, role)
+}
+
+// NewRole crea un nuevo rol.
+// curl -ks https://b2d:8000/api/v1/roles -X POST -d '{"slug":"operator","name":"Operator","permissions":["accounts:read"]}' -H "Authorization: Bearer ..."
+func (ctx *ApiContext) NewRole(w http.ResponseWriter, r *http.Request) {
+	var data rbac.Role
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		ctx.renderError(w, r, errInvalidBody)
+		return
+	}
+	data.ID = nil
+	if err := data.ValidateFields(); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	role, err := ctx.DB.SaveRole(&data)
+	if err != nil {
+		logger.Error("func NewRole", "error", err)
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusCreated, role)
+}
+
+// UpdateRole actualiza el rol y devuelve el objeto actualizado.
+// curl -ks https://b2d:8000/api/v1/roles/2 -X PUT -d '{"slug":"operator","permissions":["accounts:*"]}' -H "Authorization: Bearer ..."
+func (ctx *ApiContext) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := ctx.pathID(w, r, "id", "update")
+	if !ok {
+		return
+	}
+	var data rbac.Role
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		ctx.renderError(w, r, errInvalidBody)
+		return
+	}
+	data.ID = &id
+	if err := data.ValidateFields(); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	role, err := ctx.DB.SaveRole(&data)
+	if err != nil {
+		logger.Error("func UpdateRole", "error", err, "id", id)
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusOK, role)
+}
+
+// DeleteRole elimina el rol junto con sus grants y asignaciones.
+// curl -ks https://b2d:8000/api/v1/roles/2 -X DELETE -H "Authorization: Bearer ..."
+func (ctx *ApiContext) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := ctx.pathID(w, r, "id", "delete")
+	if !ok {
+		return
+	}
+	sid := strconv.FormatInt(id, 10)
+	n, err := ctx.DB.DeleteRole(id)
+	if err != nil {
+		logger.Error("func DeleteRole", "error", err, "id", id)
+		ctx.renderError(w, r, err)
+		return
+	}
+	if n == 0 {
+		ctx.renderError(w, r, store.ErrRoleNotFound)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Info: sid, Table: "roles", UID: sid})
+}
+
+// GetRoleGrants devuelve los grants del rol, es decir, los roles de los que hereda permisos.
+// curl -ks https://b2d:8000/api/v1/roles/2/grants -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetRoleGrants(w http.ResponseWriter, r *http.Request) {
+	id, ok := ctx.pathID(w, r, "id", "get")
+	if !ok {
+		return
+	}
+	grants, err := ctx.DB.LoadGrants(id)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if grants == nil {
+		grants = []*rbac.Grant{}
+	}
+	ctx.Render.JSON(w, http.StatusOK, grants)
+}
+
+// NewRoleGrant hace que el rol herede los permisos del rol from_role.
+// curl -ks https://b2d:8000/api/v1/roles/2/grants -X POST -d '{"from_role":3}' -H "Authorization: Bearer ..."
+func (ctx *ApiContext) NewRoleGrant(w http.ResponseWriter, r *http.Request) {
+	id, ok := ctx.pathID(w, r, "id", "create")
+	if !ok {
+		return
+	}
+	var data rbac.Grant
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		ctx.renderError(w, r, errInvalidBody)
+		return
+	}
+	data.ID = nil
+	data.ToRole = &id
+	if data.FromRole == nil || *data.FromRole == id {
+		ctx.renderError(w, r, rbac.ErrInvalidGrant)
+		return
+	}
+	grant, err := ctx.DB.SaveGrant(&data)
+	if err != nil {
+		logger.Error("func NewRoleGrant", "error", err, "id", id)
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusCreated, grant)
+}
+
+// DeleteRoleGrant elimina el grant indicado.
+// curl -ks https://b2d:8000/api/v1/roles/2/grants/5 -X DELETE -H "Authorization: Bearer ..."
+func (ctx *ApiContext) DeleteRoleGrant(w http.ResponseWriter, r *http.Request) {
+	gid, ok := ctx.pathID(w, r, "gid", "delete")
+	if !ok {
+		return
+	}
+	sid := strconv.FormatInt(gid, 10)
+	n, err := ctx.DB.DeleteGrant(gid)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if n == 0 {
+		ctx.renderError(w, r, errGrantNotFound)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Info: sid, Table: "grants", UID: sid})
+}
+
+// GetAccountRoles devuelve los roles asignados al account.
+// curl -ks https://b2d:8000/api/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a/roles -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetAccountRoles(w http.ResponseWriter, r *http.Request) {
+	uid := aloja.Params(r).ByName("uid")
+	roles, err := ctx.DB.LoadAccountRoles(uid)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if roles == nil {
+		roles = []*rbac.Role{}
+	}
+	ctx.Render.JSON(w, http.StatusOK, roles)
+}
+
+// AssignAccountRole asigna un rol al account.
+// curl -ks https://b2d:8000/api/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a/roles -X POST -d '{"role_id":2}' -H "Authorization: Bearer ..."
+func (ctx *ApiContext) AssignAccountRole(w http.ResponseWriter, r *http.Request) {
+	uid := aloja.Params(r).ByName("uid")
+	var data struct {
+		RoleID *int64 `json:"role_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.RoleID == nil {
+		ctx.renderError(w, r, errMissingRoleID)
+		return
+	}
+	if err := ctx.DB.AssignRole(uid, *data.RoleID); err != nil {
+		logger.Error("func AssignAccountRole", "error", err, "uid", uid, "role", *data.RoleID)
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func AssignAccountRole", "role assigned", *data.RoleID, "uid", uid)
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "create", Info: strconv.FormatInt(*data.RoleID, 10), Table: "account_roles", UID: uid})
+}
+
+// UnassignAccountRole retira el rol al account.
+// curl -ks https://b2d:8000/api/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a/roles/2 -X DELETE -H "Authorization: Bearer ..."
+func (ctx *ApiContext) UnassignAccountRole(w http.ResponseWriter, r *http.Request) {
+	uid := aloja.Params(r).ByName("uid")
+	id, ok := ctx.pathID(w, r, "rid", "delete")
+	if !ok {
+		return
+	}
+	n, err := ctx.DB.UnassignRole(uid, id)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if n == 0 {
+		ctx.renderError(w, r, errRoleNotAssigned)
+		return
+	}
+	logger.Info("func UnassignAccountRole", "role unassigned", id, "uid", uid)
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Info: strconv.FormatInt(id, 10), Table: "account_roles", UID: uid})
+}
+
+// pathID parses the numeric path parameter name. On error it writes the response and returns false.
+func (ctx *ApiContext) pathID(w http.ResponseWriter, r *http.Request, name, action string) (int64, bool) {
+	id, err := strconv.ParseInt(aloja.Params(r).ByName(name), 10, 64)
+	if err != nil {
+		ctx.renderError(w, r, newError(http.StatusBadRequest, errInvalidID.code, name+" must be a number"))
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jllopis/aloja"
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/session"
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/token"
+)
+
+// SessionCookieName is the name of the cookie that holds the encoded session id
+const SessionCookieName = "try5_session"
+
+// GetAccountSessions devuelve las sesiones activas del account.
+// curl -ks https://b2d:8000/api/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a/sessions -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetAccountSessions(w http.ResponseWriter, r *http.Request) {
+	uid, ok := ctx.ownAccountUID(w, r, "get")
+	if !ok {
+		return
+	}
+	sessions, err := ctx.DB.LoadAccountSessions(uid)
+	if err != nil {
+		logger.Error("func GetAccountSessions", "error", err, "uid", uid)
+		ctx.renderError(w, r, err)
+		return
+	}
+	active := []*session.Session{}
+	for _, s := range sessions {
+		if s.Check(ctx.Sessions) == nil {
+			active = append(active, s)
+		}
+	}
+	ctx.Render.JSON(w, http.StatusOK, active)
+}
+
+// RevokeAccountSession elimina la sesión indicada del account.
+// curl -ks https://b2d:8000/api/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a/sessions/Vd3... -X DELETE -H "Authorization: Bearer ..."
+func (ctx *ApiContext) RevokeAccountSession(w http.ResponseWriter, r *http.Request) {
+	uid, ok := ctx.ownAccountUID(w, r, "delete")
+	if !ok {
+		return
+	}
+	sid := aloja.Params(r).ByName("sid")
+	s, err := ctx.DB.LoadSession(sid)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if s.AccountUID == nil || *s.AccountUID != uid {
+		ctx.renderError(w, r, store.ErrSessionNotFound)
+		return
+	}
+	if _, err := ctx.DB.DeleteSession(sid); err != nil {
+		logger.Error("func RevokeAccountSession", "error", err, "uid", uid)
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func RevokeAccountSession", "session revoked", "ok", "uid", uid)
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Table: "sessions", UID: uid})
+}
+
+// RevokeAllAccountSessions elimina todas las sesiones del account.
+// curl -ks https://b2d:8000/api/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a/sessions -X DELETE -H "Authorization: Bearer ..."
+func (ctx *ApiContext) RevokeAllAccountSessions(w http.ResponseWriter, r *http.Request) {
+	uid, ok := ctx.ownAccountUID(w, r, "delete")
+	if !ok {
+		return
+	}
+	n, err := ctx.DB.DeleteAccountSessions(uid)
+	if err != nil {
+		logger.Error("func RevokeAllAccountSessions", "error", err, "uid", uid)
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func RevokeAllAccountSessions", "sessions revoked", n, "uid", uid)
+	ctx.Render.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "action": "delete", "table": "sessions", "id": uid, "revoked": n})
+}
+
+// Logout elimina la sesión asociada a la cookie y borra la cookie del navegador. Se
+// revocan los refresh tokens del account de la sesión o del refresh_token enviado.
+// curl -ks https://b2d:8000/api/v1/logout -X POST -b "try5_session=..."
+// curl -ks https://b2d:8000/api/v1/logout -X POST -d "refresh_token=eyJhbGciOi..."
+func (ctx *ApiContext) Logout(w http.ResponseWriter, r *http.Request) {
+	var uid string
+	if sid := ctx.sessionID(r); sid != "" {
+		if s, err := ctx.DB.LoadSession(sid); err == nil && s.AccountUID != nil {
+			uid = *s.AccountUID
+		}
+		if _, err := ctx.DB.DeleteSession(sid); err != nil {
+			logger.Error("func Logout", "error", err)
+		}
+	}
+	if refresh := r.FormValue("refresh_token"); refresh != "" {
+		if claims, err := ctx.Tokens.Validate(refresh, token.RefreshToken); err == nil {
+			uid = claims.Subject
+		}
+	}
+	if uid != "" {
+		ctx.revokeRefreshTokens(uid)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	ctx.Render.JSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// startSession creates a new session for the account and sets the encrypted cookie
+func (ctx *ApiContext) startSession(w http.ResponseWriter, r *http.Request, acc *account.Account) error {
+	s, err := session.New(*acc.UID, r.RemoteAddr, r.UserAgent(), ctx.Sessions)
+	if err != nil {
+		return err
+	}
+	if _, err := ctx.DB.SaveSession(s); err != nil {
+		return err
+	}
+	encoded, err := ctx.CookieHandler.Encode(SessionCookieName, *s.ID)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    encoded,
+		Path:     "/",
+		Expires:  *s.Expires,
+		MaxAge:   int(s.Expires.Sub(time.Now()) / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// sessionID returns the session id stored in the cookie or an empty string
+func (ctx *ApiContext) sessionID(r *http.Request) string {
+	var sid string
+	if ctx.CookieHandler == nil {
+		return ""
+	}
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return ""
+	}
+	if err := ctx.CookieHandler.Decode(SessionCookieName, cookie.Value, &sid); err != nil {
+		return ""
+	}
+	return sid
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/session"
+)
+
+// sessionLogin authenticates with the password asking for a session and returns its
+// cookie and id
+func (s *testServer) sessionLogin(email, password string) (*http.Cookie, string) {
+	res := s.post("/api/v1/authenticate", "", url.Values{"email": {email}, "password": {password}, "session": {"true"}}, nil)
+	c := cookie(res, SessionCookieName)
+	if res.StatusCode != http.StatusOK || c == nil {
+		s.t.Fatal("No session cookie: ", res.StatusCode)
+	}
+	if !c.HttpOnly || c.Path != "/" {
+		s.t.Fatalf("Unsafe session cookie: %+v", c)
+	}
+	var sid string
+	if err := s.ctx.CookieHandler.Decode(SessionCookieName, c.Value, &sid); err != nil {
+		s.t.Fatal("Error decoding session cookie: ", err)
+	}
+	return c, sid
+}
+
+func TestSessions(t *testing.T) {
+	s := newTestServer(t, nil)
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+	other := s.account("other@example.com", "SuperDifficultPass")
+	path := "/api/v1/accounts/" + *acc.UID
+
+	first, firstID := s.sessionLogin("jdoe@example.com", "SuperDifficultPass")
+	second, secondID := s.sessionLogin("jdoe@example.com", "SuperDifficultPass")
+	if res := s.get(path, "", first); res.StatusCode != http.StatusOK {
+		t.Fatal("Session cookie refused: ", res.StatusCode)
+	}
+	tampered := *first
+	tampered.Value = first.Value[:len(first.Value)-4] + "AAAA"
+	if res := s.get(path, "", &tampered); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Tampered cookie accepted: ", res.StatusCode)
+	}
+
+	var sessions []session.Session
+	if res := s.do("GET", path+"/sessions", s.token(acc), nil, &sessions); res.StatusCode != http.StatusOK || len(sessions) != 2 {
+		t.Fatal("Wrong sessions listed: ", res.StatusCode, len(sessions))
+	}
+	for _, x := range sessions {
+		if *x.ID != firstID && *x.ID != secondID {
+			t.Fatal("Unknown session listed: ", *x.ID)
+		}
+	}
+	// the sessions of an account are only seen and revoked by the account
+	if res := s.do("GET", path+"/sessions", s.token(other), nil, nil); res.StatusCode != http.StatusForbidden {
+		t.Fatal("Expected 403 listing the sessions of another account, got ", res.StatusCode)
+	}
+	otherCookie, otherID := s.sessionLogin("other@example.com", "SuperDifficultPass")
+	if res := s.do("DELETE", path+"/sessions/"+otherID, s.token(acc), nil, nil); res.StatusCode != http.StatusNotFound {
+		t.Fatal("Expected 404 revoking a session of another account, got ", res.StatusCode)
+	}
+	if res := s.get("/api/v1/accounts/"+*other.UID, "", otherCookie); res.StatusCode != http.StatusOK {
+		t.Fatal("Session of another account revoked: ", res.StatusCode)
+	}
+
+	// a revoked session is rejected, the others go on
+	if res := s.do("DELETE", path+"/sessions/"+firstID, s.token(acc), nil, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Error revoking session: ", res.StatusCode)
+	}
+	if res := s.get(path, "", first); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Revoked session accepted: ", res.StatusCode)
+	}
+	if res := s.get(path, "", second); res.StatusCode != http.StatusOK {
+		t.Fatal("Session revoked with another one: ", res.StatusCode)
+	}
+
+	// the logout ends the session of the cookie and clears it
+	req, _ := http.NewRequest("POST", s.url+"/api/v1/logout", nil)
+	req.AddCookie(second)
+	res := s.send(req, nil)
+	var cleared bool
+	for _, c := range res.Cookies() {
+		cleared = cleared || c.Name == SessionCookieName && c.Value == "" && c.MaxAge < 0
+	}
+	if res.StatusCode != http.StatusOK || !cleared {
+		t.Fatal("Session cookie not cleared by the logout: ", res.StatusCode)
+	}
+	if res := s.get(path, "", second); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("Session valid after the logout: ", res.StatusCode)
+	}
+	if sessions, _ := s.ctx.DB.LoadAccountSessions(*acc.UID); len(sessions) != 0 {
+		t.Fatal("Sessions kept: ", len(sessions))
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	s := newTestServer(t, nil)
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+	path := "/api/v1/accounts/" + *acc.UID
+
+	past := time.Now().UTC().Add(-time.Minute)
+	idle := time.Now().UTC().Add(-session.DefaultIdleTimeout - time.Minute)
+	for _, expire := range []func(*session.Session){
+		func(x *session.Session) { x.Expires = &past },
+		func(x *session.Session) { x.LastSeen = &idle },
+	} {
+		c, sid := s.sessionLogin("jdoe@example.com", "SuperDifficultPass")
+		x, err := s.ctx.DB.LoadSession(sid)
+		if err != nil {
+			t.Fatal("Error loading session: ", err)
+		}
+		expire(x)
+		if _, err := s.ctx.DB.SaveSession(x); err != nil {
+			t.Fatal("Error saving session: ", err)
+		}
+		if res := s.get(path, "", c); res.StatusCode != http.StatusUnauthorized {
+			t.Fatal("Expired session accepted: ", res.StatusCode)
+		}
+		if _, err := s.ctx.DB.LoadSession(sid); err == nil {
+			t.Fatal("Expired session kept in the store")
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/authn"
+	"github.com/nbio/httpcontext"
+)
+
+// DefaultSignatureSkew is the maximum allowed difference between the Date header of a
+// signed request and the server clock when ApiContext.SignatureSkew is not set.
+const DefaultSignatureSkew = authn.DefaultSignatureSkew
+
+// RequireSignature is a middleware that only accepts requests signed with an api key.
+// The request must carry the headers
+//
+//	Date: <RFC1123 date>
+//	Digest: SHA-256=<base64 sha256 of the body>
+//	Authorization: HMAC-SHA256 KeyId=<key id>,Signature=<base64 signature>
+//
+// where the signature is the HMAC-SHA256 of apikey.StringToSign. The account that
+// owns the key is stored in the request context.
+func (ctx *ApiContext) RequireSignature(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		acc, err := ctx.verifySignature(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", apikey.Scheme)
+			ctx.renderError(w, r, authError(err))
+			return
+		}
+		httpcontext.Set(r, accountKey, acc)
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// isSigned tells if the request carries an HMAC Authorization header
+func isSigned(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), apikey.Scheme+" ")
+}
+
+// verifySignature checks the request signature and returns the account owning the key
+func (ctx *ApiContext) verifySignature(r *http.Request) (*account.Account, error) {
+	s, err := signedRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.authenticator().Authenticate(&authn.Credentials{Signature: s})
+}
+
+// readBody reads the whole request body and puts it back so it can be read again
+// by the handlers. The values stored in the request context are preserved.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return []byte{}, nil
+	}
+	values := httpcontext.GetAll(r)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	for k, v := range values {
+		httpcontext.Set(r, k, v)
+	}
+	return body, nil
+}
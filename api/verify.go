@@ -0,0 +1,123 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jllopis/aloja"
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/mailer"
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/ticket"
+)
+
+// DefaultVerifyTTL is how long a verification link is valid when VerifyTTL is not set
+const DefaultVerifyTTL = 48 * time.Hour
+
+var (
+	errInvalidVerifyToken = newError(http.StatusBadRequest, "invalid_verification_token", "invalid or expired verification token")
+	errEmailNotVerified   = newError(http.StatusForbidden, "email_not_verified", "the email of the account has not been verified")
+
+	// DefaultVerifyTemplate is the email sent with the verification link when
+	// VerifyTemplate is not set. It gets the Name and Email of the account, the Link
+	// and its Expires date.
+	DefaultVerifyTemplate = mailer.MustTemplate("email_verification", "Confirm your email", `Hello {{.Name}},
+
+follow this link to confirm that {{.Email}} is your email address:
+
+{{.Link}}
+
+The link expires on {{.Expires.Format "2006-01-02 15:04 MST"}}.
+If you did not create an account you can ignore this email.
+`)
+)
+
+// unverified marks the email of the account as pending of verification when the
+// verification is enabled
+func (ctx *ApiContext) unverified(a *account.Account) {
+	if ctx.VerifyEmail {
+		f := false
+		a.EmailVerified = &f
+	}
+}
+
+// checkVerified refuses the accounts whose email is not verified when RequireVerified is set
+func (ctx *ApiContext) checkVerified(a *account.Account) error {
+	if ctx.RequireVerified && a.Unverified() {
+		return errEmailNotVerified
+	}
+	return nil
+}
+
+// startVerification mails a verification link to an account whose email is not verified
+func (ctx *ApiContext) startVerification(a *account.Account) error {
+	if !a.Unverified() {
+		return nil
+	}
+	ttl := ctx.VerifyTTL
+	if ttl == 0 {
+		ttl = DefaultVerifyTTL
+	}
+	t, token, err := ctx.issueTicket(*a.UID, ticket.EmailVerification, ttl)
+	if err != nil {
+		return err
+	}
+	tpl := ctx.VerifyTemplate
+	if tpl == nil {
+		tpl = DefaultVerifyTemplate
+	}
+	link := ticketLink(ctx.VerifyURL, map[string]string{"uid": *a.UID, "token": token})
+	return ctx.sendMail(tpl, a, map[string]interface{}{"Link": link, "Expires": t.Expires})
+}
+
+// VerifyAccount confirma el email del account con el token del enlace enviado al crearlo
+// o al cambiar su email. El token solo se puede usar una vez.
+// curl -ks https://b2d:8000/api/v1/accounts/342947fd-6c4b-4d2b-85ab-da14b37d047a/verify -X POST -d "token=3q2-7wQk..."
+func (ctx *ApiContext) VerifyAccount(w http.ResponseWriter, r *http.Request) {
+	var uid, token string
+	if uid = aloja.Params(r).ByName("uid"); uid == "" {
+		ctx.renderError(w, r, errMissingUID)
+		return
+	}
+	if token = r.FormValue("token"); token == "" {
+		ctx.renderError(w, r, errMissingToken)
+		return
+	}
+	t, err := ctx.DB.LoadTicket(ticket.ID(token))
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = errInvalidVerifyToken
+		}
+		ctx.renderError(w, r, err)
+		return
+	}
+	if t.Check(ticket.EmailVerification) != nil || t.AccountUID != uid {
+		ctx.renderError(w, r, errInvalidVerifyToken)
+		return
+	}
+	saved, err := ctx.DB.LoadAccount(uid)
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = errInvalidVerifyToken
+		}
+		ctx.renderError(w, r, err)
+		return
+	}
+	if n, err := ctx.DB.DeleteTicket(t.ID); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	} else if n == 0 {
+		ctx.renderError(w, r, errInvalidVerifyToken)
+		return
+	}
+	verified := true
+	update := *saved
+	update.EmailVerified = &verified
+	if _, err := ctx.DB.SaveAccount(&update); err != nil {
+		logger.Error("func VerifyAccount", "error", err, "uid", uid)
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func VerifyAccount", "email verified", uid)
+	ctx.Render.JSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
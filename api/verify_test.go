@@ -0,0 +1,145 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/mailer"
+)
+
+var tokenParam = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+// newVerifyServer starts a server that requires verified emails and writes the
+// emails to a temporary directory, removed with the returned func
+func newVerifyServer(t *testing.T) (*testServer, string, func()) {
+	dir, err := ioutil.TempDir("", "try5-mail")
+	if err != nil {
+		t.Fatal("Error creating mail dir: ", err)
+	}
+	s := newTestServer(t, func(ctx *ApiContext) {
+		ctx.Mailer = &mailer.File{Dir: dir, From: "try5@localhost"}
+		ctx.VerifyEmail, ctx.RequireVerified = true, true
+		ctx.VerifyURL = "https://try5.test/verify"
+	})
+	return s, dir, func() { os.RemoveAll(dir) }
+}
+
+// verifyToken waits for the n-th email sent to the address, they are sent in the
+// background, and returns the token of its link
+func verifyToken(t *testing.T, dir, to string, n int) string {
+	pattern := filepath.Join(dir, "*-"+strings.Replace(to, "@", "_at_", -1)+".eml")
+	for i := 0; i < 100; i++ {
+		files, _ := filepath.Glob(pattern)
+		if len(files) >= n {
+			data, err := ioutil.ReadFile(files[n-1])
+			if err != nil {
+				t.Fatal("Error reading email: ", err)
+			}
+			m := tokenParam.FindSubmatch(data)
+			if m == nil {
+				t.Fatalf("No token in the email: %s", data)
+			}
+			return string(m[1])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("No verification email sent to ", to)
+	return ""
+}
+
+// verify sends the verification token of the account
+func (s *testServer) verify(uid, token string) (*http.Response, *apiError) {
+	var e apiError
+	res := s.post("/api/v1/accounts/"+uid+"/verify", "", url.Values{"token": {token}}, &e)
+	return res, &e
+}
+
+func TestVerifyAccount(t *testing.T) {
+	s, dir, cleanup := newVerifyServer(t)
+	defer cleanup()
+	admin := s.account("admin@example.com", "SuperDifficultPass", "accounts:*")
+
+	var created accountResponse
+	body := map[string]string{"email": "jdoe@example.com", "name": "Jane Doe", "password": "SuperDifficultPass"}
+	if res := s.do("POST", "/api/v1/accounts", s.token(admin), body, &created); res.StatusCode != http.StatusCreated {
+		t.Fatal("Error creating account: ", res.StatusCode)
+	}
+	if created.EmailVerified == nil || *created.EmailVerified {
+		t.Fatal("New account not pending of verification")
+	}
+	uid := *created.UID
+
+	// the unverified account can not authenticate
+	var e apiError
+	if res := s.post("/api/v1/authenticate", "", url.Values{"email": {"jdoe@example.com"}, "password": {"SuperDifficultPass"}}, &e); res.StatusCode != http.StatusForbidden || e.Code != "email_not_verified" {
+		t.Fatal("Expected 403 email_not_verified, got ", res.StatusCode, e.Code)
+	}
+
+	token := verifyToken(t, dir, "jdoe@example.com", 1)
+	if res, e := s.verify(uid, "wrong"+token); res.StatusCode != http.StatusBadRequest || e.Code != "invalid_verification_token" {
+		t.Fatal("Expected 400 for a wrong token, got ", res.StatusCode, e.Code)
+	}
+	if res, e := s.verify(*admin.UID, token); res.StatusCode != http.StatusBadRequest || e.Code != "invalid_verification_token" {
+		t.Fatal("Expected 400 for the token of another account, got ", res.StatusCode, e.Code)
+	}
+	if res, _ := s.verify(uid, token); res.StatusCode != http.StatusOK {
+		t.Fatal("Error verifying account: ", res.StatusCode)
+	}
+	if res, _ := s.verify(uid, token); res.StatusCode != http.StatusBadRequest {
+		t.Fatal("Verification token used twice: ", res.StatusCode)
+	}
+	if res, pair := s.login("jdoe@example.com", "SuperDifficultPass"); res.StatusCode != http.StatusOK || pair == nil {
+		t.Fatal("Verified account can not authenticate: ", res.StatusCode)
+	}
+	// the verification is stored
+	if acc, _ := s.ctx.DB.LoadAccount(uid); acc.Unverified() {
+		t.Fatal("Account not verified in the store")
+	}
+}
+
+func TestReverifyOnEmailChange(t *testing.T) {
+	s, dir, cleanup := newVerifyServer(t)
+	defer cleanup()
+	verified := true
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+	acc.EmailVerified = &verified
+	if _, err := s.ctx.DB.SaveAccount(acc); err != nil {
+		t.Fatal("Error verifying account: ", err)
+	}
+	_, pair := s.login("jdoe@example.com", "SuperDifficultPass")
+	if pair == nil {
+		t.Fatal("Error authenticating")
+	}
+
+	// the name can change without a new verification
+	path := "/api/v1/accounts/" + *acc.UID
+	var updated accountResponse
+	body := map[string]string{"uid": *acc.UID, "email": "jdoe@example.com", "name": "Jane"}
+	if res := s.do("PUT", path, pair.AccessToken, body, &updated); res.StatusCode != http.StatusOK || updated.EmailVerified == nil || !*updated.EmailVerified {
+		t.Fatal("Account unverified by a change of name: ", res.StatusCode)
+	}
+
+	body["email"] = "jane@example.com"
+	if res := s.do("PUT", path, pair.AccessToken, body, &updated); res.StatusCode != http.StatusOK {
+		t.Fatal("Error changing email: ", res.StatusCode)
+	}
+	if updated.EmailVerified == nil || *updated.EmailVerified {
+		t.Fatal("New email not pending of verification")
+	}
+	if res, _ := s.login("jane@example.com", "SuperDifficultPass"); res.StatusCode != http.StatusForbidden {
+		t.Fatal("Expected 403 for the new unverified email, got ", res.StatusCode)
+	}
+	if res, _ := s.verify(*acc.UID, verifyToken(t, dir, "jane@example.com", 1)); res.StatusCode != http.StatusOK {
+		t.Fatal("Error verifying the new email: ", res.StatusCode)
+	}
+	if res, _ := s.login("jane@example.com", "SuperDifficultPass"); res.StatusCode != http.StatusOK {
+		t.Fatal("Verified email can not authenticate: ", res.StatusCode)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"time"
+
+	"github.com/jllopis/try5/account"
+)
+
+// accountRequest is the body accepted to create or update an account. The password
+// is write only: it is accepted here but accountResponse never returns it.
+type accountRequest struct {
+	UID      *string `json:"uid"`
+	Email    *string `json:"email"`
+	Name     *string `json:"name"`
+	Password *string `json:"password"`
+	Active   *bool   `json:"active"`
+	Gravatar *string `json:"gravatar"`
+	// CurrentPassword is required when an account changes its own password
+	CurrentPassword *string `json:"current_password"`
+}
+
+func (r *accountRequest) account() *account.Account {
+	return &account.Account{
+		UID:      r.UID,
+		Email:    r.Email,
+		Name:     r.Name,
+		Password: r.Password,
+		Active:   r.Active,
+		Gravatar: r.Gravatar,
+	}
+}
+
+// accountResponse is the representation of an account returned by the api
+type accountResponse struct {
+	UID      *string    `json:"uid"`
+	Email    *string    `json:"email"`
+	Name     *string    `json:"name,omitempty"`
+	Active   *bool      `json:"active"`
+	Gravatar *string    `json:"gravatar"`
+	Created  *time.Time `json:"created"`
+	Updated  *time.Time `json:"updated"`
+	// EmailVerified is omitted for the accounts created without email verification
+	EmailVerified *bool `json:"email_verified,omitempty"`
+}
+
+func newAccountResponse(a *account.Account) *accountResponse {
+	return &accountResponse{
+		UID:      a.UID,
+		Email:    a.Email,
+		Name:     a.Name,
+		Active:   a.Active,
+		Gravatar: a.Gravatar,
+		Created:  a.Created,
+		Updated:  a.Updated,
+
+		EmailVerified: a.EmailVerified,
+	}
+}
+
+func newAccountList(accounts []*account.Account) []*accountResponse {
+	res := make([]*accountResponse, len(accounts))
+	for i, a := range accounts {
+		res[i] = newAccountResponse(a)
+	}
+	return res
+}
@@ -0,0 +1,427 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jllopis/aloja"
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/ticket"
+	"github.com/jllopis/try5/token"
+	"github.com/jllopis/try5/webauthn"
+)
+
+// DefaultCredentialName names the credentials registered without a name
+const DefaultCredentialName = "passkey"
+
+var (
+	errWebAuthnUnavailable = newError(http.StatusNotImplemented, "webauthn_unavailable", "webauthn is not configured")
+	errInvalidChallenge    = newError(http.StatusBadRequest, "invalid_challenge", "invalid or expired challenge")
+	errInvalidAttestation  = newError(http.StatusBadRequest, "invalid_attestation", "the credential could not be verified")
+	errCredentialExists    = newError(http.StatusConflict, "credential_exists", "credential already registered")
+	errMissingCredential   = newError(http.StatusBadRequest, "missing_credential", "credential cannot be nil")
+)
+
+// webauthnRequest is the body of the finish steps of the ceremonies: the
+// PublicKeyCredential returned by the browser and, depending on the ceremony, the
+// name of a new credential or the mfa_token of the authentication
+type webauthnRequest struct {
+	Name       string             `json:"name"`
+	MFAToken   string             `json:"mfa_token"`
+	Credential *webauthn.Response `json:"credential"`
+}
+
+func decodeWebAuthnRequest(r *http.Request) (*webauthnRequest, error) {
+	var body webauthnRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, errInvalidBody
+	}
+	if body.Credential == nil {
+		return nil, errMissingCredential
+	}
+	return &body, nil
+}
+
+// useChallenge finds the ticket of the challenge signed in the response and deletes
+// it, so every ceremony can only be finished once. It returns the ticket and the
+// challenge to verify the response with.
+func (ctx *ApiContext) useChallenge(res *webauthn.Response, purpose ticket.Purpose) (*ticket.Ticket, string, error) {
+	challenge, err := webauthn.Challenge(res)
+	if err != nil || challenge == "" {
+		return nil, "", errInvalidChallenge
+	}
+	t, err := ctx.DB.LoadTicket(ticket.ID(challenge))
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = errInvalidChallenge
+		}
+		return nil, "", err
+	}
+	if t.Check(purpose) != nil {
+		return nil, "", errInvalidChallenge
+	}
+	if n, err := ctx.DB.DeleteTicket(t.ID); err != nil {
+		return nil, "", err
+	} else if n == 0 {
+		return nil, "", errInvalidChallenge
+	}
+	return t, challenge, nil
+}
+
+// credentialUsed stores the signature counter of the last assertion of the credential
+func (ctx *ApiContext) credentialUsed(c *webauthn.Credential, count int64) error {
+	update := *c
+	now := time.Now().UTC()
+	update.SignCount, update.LastUsed = count, &now
+	return ctx.DB.SaveCredential(&update)
+}
+
+// assertionFailed logs why an assertion was rejected and counts it as a failed attempt
+func (ctx *ApiContext) assertionFailed(w http.ResponseWriter, r *http.Request, c *webauthn.Credential, keys []string, err error) {
+	if err == webauthn.ErrSignCount {
+		logger.Warn("webauthn assertion", "error", err, "uid", c.AccountUID, "credential", c.ID)
+	} else {
+		logger.Info("webauthn assertion", "error", err, "uid", c.AccountUID, "credential", c.ID)
+	}
+	ctx.authFailed(w, r, keys)
+}
+
+// BeginWebAuthnRegistration devuelve las opciones para registrar una nueva credencial
+// (passkey o llave de seguridad) del account autenticado con navigator.credentials.create.
+// El challenge caduca en el timeout de las opciones.
+// curl -ks https://b2d:8000/api/v1/webauthn/register/begin -X POST -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	if ctx.WebAuthn == nil {
+		ctx.renderError(w, r, errWebAuthnUnavailable)
+		return
+	}
+	acc, err := ctx.currentAccount(r)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	creds, err := ctx.DB.LoadAccountCredentials(*acc.UID)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	_, challenge, err := ctx.issueTicket(*acc.UID, ticket.WebAuthnRegistration, ctx.WebAuthn.TTL())
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	user := webauthn.User{ID: *acc.UID, Name: *acc.Email, DisplayName: *acc.Email}
+	if acc.Name != nil && *acc.Name != "" {
+		user.DisplayName = *acc.Name
+	}
+	ctx.Render.JSON(w, http.StatusOK, map[string]interface{}{"publicKey": ctx.WebAuthn.CreationOptions(challenge, user, creds)})
+}
+
+// FinishWebAuthnRegistration verifica la respuesta de navigator.credentials.create y
+// guarda la credencial en el account autenticado. Desde ese momento el account puede
+// autenticarse con ella y la necesita como segundo factor tras la password.
+// curl -ks https://b2d:8000/api/v1/webauthn/register/finish -X POST -H "Authorization: Bearer ..." -d '{"name":"laptop","credential":{"id":"...","rawId":"...","type":"public-key","response":{...}}}' | jp -
+func (ctx *ApiContext) FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	if ctx.WebAuthn == nil {
+		ctx.renderError(w, r, errWebAuthnUnavailable)
+		return
+	}
+	body, err := decodeWebAuthnRequest(r)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	acc, err := ctx.currentAccount(r)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	t, challenge, err := ctx.useChallenge(body.Credential, ticket.WebAuthnRegistration)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if t.AccountUID != *acc.UID {
+		ctx.renderError(w, r, errInvalidChallenge)
+		return
+	}
+	cred, err := ctx.WebAuthn.VerifyRegistration(body.Credential, challenge, false)
+	if err != nil {
+		logger.Info("func FinishWebAuthnRegistration", "error", err, "uid", *acc.UID)
+		ctx.renderError(w, r, errInvalidAttestation)
+		return
+	}
+	if _, err := ctx.DB.LoadCredential(cred.ID); err == nil {
+		ctx.renderError(w, r, errCredentialExists)
+		return
+	} else if !store.IsNotFound(err) {
+		ctx.renderError(w, r, err)
+		return
+	}
+	cred.AccountUID, cred.Name = *acc.UID, body.Name
+	if cred.Name == "" {
+		cred.Name = DefaultCredentialName
+	}
+	if err := ctx.DB.SaveCredential(cred); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func FinishWebAuthnRegistration", "registered", cred.ID, "uid", *acc.UID)
+	ctx.Render.JSON(w, http.StatusCreated, cred)
+}
+
+// GetWebAuthnCredentials devuelve las credenciales registradas por el account autenticado.
+// curl -ks https://b2d:8000/api/v1/webauthn/credentials -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) GetWebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+	acc := CurrentAccount(r)
+	if acc == nil || acc.UID == nil {
+		ctx.renderError(w, r, errUnauthorized)
+		return
+	}
+	creds, err := ctx.DB.LoadAccountCredentials(*acc.UID)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if creds == nil {
+		creds = []*webauthn.Credential{}
+	}
+	ctx.Render.JSON(w, http.StatusOK, creds)
+}
+
+// DeleteWebAuthnCredential elimina una credencial del account autenticado.
+// curl -ks https://b2d:8000/api/v1/webauthn/credentials/AQIDBAUGBwg -X DELETE -H "Authorization: Bearer ..." | jp -
+func (ctx *ApiContext) DeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	acc := CurrentAccount(r)
+	if acc == nil || acc.UID == nil {
+		ctx.renderError(w, r, errUnauthorized)
+		return
+	}
+	id := aloja.Params(r).ByName("id")
+	cred, err := ctx.DB.LoadCredential(id)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	// the credentials of other accounts are not even acknowledged
+	if cred.AccountUID != *acc.UID {
+		ctx.renderError(w, r, store.ErrCredentialNotFound)
+		return
+	}
+	if _, err := ctx.DB.DeleteCredential(id); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	logger.Info("func DeleteWebAuthnCredential", "deleted", id, "uid", *acc.UID)
+	ctx.Render.JSON(w, http.StatusOK, &logMessage{Status: "ok", Action: "delete", Table: "webauthn_credentials", UID: id})
+}
+
+// BeginWebAuthnLogin devuelve las opciones para autenticarse con una passkey, sin email
+// ni password, con navigator.credentials.get. El navegador ofrece las passkeys
+// registradas para el dominio.
+// curl -ks https://b2d:8000/api/v1/webauthn/login/begin -X POST | jp -
+func (ctx *ApiContext) BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if ctx.WebAuthn == nil {
+		ctx.renderError(w, r, errWebAuthnUnavailable)
+		return
+	}
+	// the account is not known yet, the ticket is not bound to any
+	t, challenge, err := ticket.New(ticket.WebAuthnLogin, "", ctx.WebAuthn.TTL())
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if err := ctx.DB.SaveTicket(t); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusOK, map[string]interface{}{"publicKey": ctx.WebAuthn.RequestOptions(challenge, nil, "required")})
+}
+
+// FinishWebAuthnLogin verifica la respuesta de navigator.credentials.get y devuelve un par
+// de tokens como Authenticate. La passkey debe verificar al usuario (PIN o biometría),
+// por lo que vale como ambos factores. Con ?session=true también inicia una sesión.
+// curl -ks https://b2d:8000/api/v1/webauthn/login/finish -X POST -d '{"credential":{"id":"...","rawId":"...","type":"public-key","response":{...}}}' | jp -
+func (ctx *ApiContext) FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if ctx.WebAuthn == nil {
+		ctx.renderError(w, r, errWebAuthnUnavailable)
+		return
+	}
+	body, err := decodeWebAuthnRequest(r)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	_, challenge, err := ctx.useChallenge(body.Credential, ticket.WebAuthnLogin)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	id, err := webauthn.CredentialID(body.Credential)
+	if err != nil {
+		ctx.renderError(w, r, ErrInvalidCredentials)
+		return
+	}
+	cred, err := ctx.DB.LoadCredential(id)
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = ErrInvalidCredentials
+		}
+		ctx.renderError(w, r, err)
+		return
+	}
+	res, err := ctx.DB.LoadAccount(cred.AccountUID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = ErrInvalidCredentials
+		}
+		ctx.renderError(w, r, err)
+		return
+	}
+	keys := lockoutKeys(r, *res.Email)
+	if wait, err := ctx.lockedOut(keys); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	} else if wait > 0 {
+		ctx.renderLockedOut(w, r, wait)
+		return
+	}
+	count, err := ctx.WebAuthn.VerifyAssertion(body.Credential, challenge, cred, true)
+	if err != nil {
+		ctx.assertionFailed(w, r, cred, keys, err)
+		return
+	}
+	if handle, err := webauthn.UserHandle(body.Credential); err != nil || handle != "" && handle != cred.AccountUID {
+		ctx.assertionFailed(w, r, cred, keys, webauthn.ErrCredentialMismatch)
+		return
+	}
+	if res.Active != nil && !*res.Active {
+		ctx.renderError(w, r, ErrAccountDisabled)
+		return
+	}
+	if err := ctx.checkVerified(res); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if err := ctx.credentialUsed(cred, count); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.loggedIn(w, r, res, keys)
+}
+
+// mfaAccount returns the account of an mfa_token issued by Authenticate
+func (ctx *ApiContext) mfaAccount(mfaToken string) (*account.Account, error) {
+	if mfaToken == "" {
+		return nil, errMissingMFAToken
+	}
+	claims, err := ctx.Tokens.Validate(mfaToken, token.MFAToken)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	res, err := ctx.DB.LoadAccount(claims.Subject)
+	if err != nil {
+		if store.IsNotFound(err) {
+			err = ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if res.Active != nil && !*res.Active {
+		return nil, ErrAccountDisabled
+	}
+	return res, nil
+}
+
+// BeginWebAuthnMFA devuelve las opciones para presentar una credencial del account como
+// segundo factor, con el mfa_token devuelto por Authenticate.
+// curl -ks https://b2d:8000/api/v1/webauthn/mfa/begin -X POST -d "mfa_token=eyJhbGciOi..." | jp -
+func (ctx *ApiContext) BeginWebAuthnMFA(w http.ResponseWriter, r *http.Request) {
+	if ctx.WebAuthn == nil {
+		ctx.renderError(w, r, errWebAuthnUnavailable)
+		return
+	}
+	res, err := ctx.mfaAccount(r.FormValue("mfa_token"))
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	creds, err := ctx.DB.LoadAccountCredentials(*res.UID)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if len(creds) == 0 {
+		ctx.renderError(w, r, errMFANotEnabled)
+		return
+	}
+	_, challenge, err := ctx.issueTicket(*res.UID, ticket.WebAuthnMFA, ctx.WebAuthn.TTL())
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.Render.JSON(w, http.StatusOK, map[string]interface{}{"publicKey": ctx.WebAuthn.RequestOptions(challenge, creds, "discouraged")})
+}
+
+// FinishWebAuthnMFA completa la autenticación de un account con segundo factor verificando
+// la respuesta de navigator.credentials.get. Las credenciales erróneas cuentan como
+// intentos fallidos de autenticación. Con ?session=true también inicia una sesión.
+// curl -ks https://b2d:8000/api/v1/webauthn/mfa/finish -X POST -d '{"mfa_token":"eyJhbGciOi...","credential":{"id":"...","rawId":"...","type":"public-key","response":{...}}}' | jp -
+func (ctx *ApiContext) FinishWebAuthnMFA(w http.ResponseWriter, r *http.Request) {
+	if ctx.WebAuthn == nil {
+		ctx.renderError(w, r, errWebAuthnUnavailable)
+		return
+	}
+	body, err := decodeWebAuthnRequest(r)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	res, err := ctx.mfaAccount(body.MFAToken)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	t, challenge, err := ctx.useChallenge(body.Credential, ticket.WebAuthnMFA)
+	if err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if t.AccountUID != *res.UID {
+		ctx.renderError(w, r, errInvalidChallenge)
+		return
+	}
+	keys := lockoutKeys(r, *res.Email)
+	if wait, err := ctx.lockedOut(keys); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	} else if wait > 0 {
+		ctx.renderLockedOut(w, r, wait)
+		return
+	}
+	id, err := webauthn.CredentialID(body.Credential)
+	if err != nil {
+		ctx.authFailed(w, r, keys)
+		return
+	}
+	cred, err := ctx.DB.LoadCredential(id)
+	if err != nil && !store.IsNotFound(err) {
+		ctx.renderError(w, r, err)
+		return
+	}
+	if err != nil || cred.AccountUID != *res.UID {
+		ctx.authFailed(w, r, keys)
+		return
+	}
+	count, err := ctx.WebAuthn.VerifyAssertion(body.Credential, challenge, cred, false)
+	if err != nil {
+		ctx.assertionFailed(w, r, cred, keys, err)
+		return
+	}
+	if err := ctx.credentialUsed(cred, count); err != nil {
+		ctx.renderError(w, r, err)
+		return
+	}
+	ctx.loggedIn(w, r, res, keys)
+}
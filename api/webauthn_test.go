@@ -0,0 +1,200 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/token"
+	"github.com/jllopis/try5/webauthn"
+	"github.com/jllopis/try5/webauthn/webauthntest"
+)
+
+const (
+	rpID     = "try5.local"
+	rpOrigin = "https://try5.local"
+)
+
+// newWebAuthnServer starts a server that is the relying party of try5.local
+func newWebAuthnServer(t *testing.T) *testServer {
+	return newTestServer(t, func(ctx *ApiContext) {
+		ctx.WebAuthn = &webauthn.RelyingParty{ID: rpID, Name: "try5", Origins: []string{rpOrigin}}
+		ctx.Lockout = lockout.Policy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	})
+}
+
+// authenticator returns a software authenticator that gives back uid as user handle
+func (s *testServer) authenticator(uid string) *webauthntest.Authenticator {
+	a, err := webauthntest.New(false)
+	if err != nil {
+		s.t.Fatal("Error creating authenticator: ", err)
+	}
+	a.UserHandle = uid
+	return a
+}
+
+// challenge starts the ceremony of path and returns its challenge
+func (s *testServer) challenge(path, bearer string, form url.Values) string {
+	var options struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	if res := s.post(path, bearer, form, &options); res.StatusCode != http.StatusOK || options.PublicKey.Challenge == "" {
+		s.t.Fatal("Error starting the ceremony: ", res.StatusCode)
+	}
+	return options.PublicKey.Challenge
+}
+
+// finish sends the response of the authenticator to path and returns the status and
+// the code of the error, if any
+func (s *testServer) finish(path, bearer string, body *webauthnRequest) (int, string) {
+	var e apiError
+	res := s.do("POST", path, bearer, body, &e)
+	return res.StatusCode, e.Code
+}
+
+// register registers the credential of a for the account of bearer
+func (s *testServer) register(a *webauthntest.Authenticator, bearer string) {
+	c := s.challenge("/api/v1/webauthn/register/begin", bearer, nil)
+	if status, code := s.finish("/api/v1/webauthn/register/finish", bearer, &webauthnRequest{Credential: a.Create(rpID, rpOrigin, c)}); status != http.StatusCreated {
+		s.t.Fatal("Error registering credential: ", status, code)
+	}
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	s := newWebAuthnServer(t)
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+	other := s.account("other@example.com", "SuperDifficultPass")
+	bearer := s.token(acc)
+	a := s.authenticator(*acc.UID)
+	finish := "/api/v1/webauthn/register/finish"
+
+	c := s.challenge("/api/v1/webauthn/register/begin", bearer, nil)
+	r := a.Create(rpID, rpOrigin, c)
+	if status, code := s.finish(finish, bearer, &webauthnRequest{Name: "laptop", Credential: r}); status != http.StatusCreated {
+		t.Fatal("Error registering credential: ", status, code)
+	}
+	// the challenge is used once
+	if status, code := s.finish(finish, bearer, &webauthnRequest{Credential: r}); status != http.StatusBadRequest || code != "invalid_challenge" {
+		t.Fatal("Challenge used twice: ", status, code)
+	}
+	// the challenge belongs to the account that asked for it
+	c = s.challenge("/api/v1/webauthn/register/begin", s.token(other), nil)
+	if status, code := s.finish(finish, bearer, &webauthnRequest{Credential: s.authenticator(*acc.UID).Create(rpID, rpOrigin, c)}); status != http.StatusBadRequest || code != "invalid_challenge" {
+		t.Fatal("Challenge of another account accepted: ", status, code)
+	}
+	// the credentials created for another origin or relying party are refused
+	for _, rp := range [][2]string{{rpID, "https://evil.local"}, {"evil.local", rpOrigin}} {
+		c = s.challenge("/api/v1/webauthn/register/begin", bearer, nil)
+		if status, code := s.finish(finish, bearer, &webauthnRequest{Credential: s.authenticator(*acc.UID).Create(rp[0], rp[1], c)}); status != http.StatusBadRequest || code != "invalid_attestation" {
+			t.Fatalf("Credential of %v registered: %d %s", rp, status, code)
+		}
+	}
+	// a credential is registered once
+	c = s.challenge("/api/v1/webauthn/register/begin", bearer, nil)
+	if status, code := s.finish(finish, bearer, &webauthnRequest{Credential: a.Create(rpID, rpOrigin, c)}); status != http.StatusConflict || code != "credential_exists" {
+		t.Fatal("Credential registered twice: ", status, code)
+	}
+
+	var creds []*webauthn.Credential
+	if res := s.do("GET", "/api/v1/webauthn/credentials", bearer, nil, &creds); res.StatusCode != http.StatusOK || len(creds) != 1 || creds[0].ID != a.CredentialID() || creds[0].Name != "laptop" {
+		t.Fatal("Wrong credentials listed: ", res.StatusCode, len(creds))
+	}
+	if res := s.do("DELETE", "/api/v1/webauthn/credentials/"+a.CredentialID(), s.token(other), nil, nil); res.StatusCode != http.StatusNotFound {
+		t.Fatal("Credential deleted by another account: ", res.StatusCode)
+	}
+	if res := s.do("DELETE", "/api/v1/webauthn/credentials/"+a.CredentialID(), bearer, nil, nil); res.StatusCode != http.StatusOK {
+		t.Fatal("Error deleting credential: ", res.StatusCode)
+	}
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	s := newWebAuthnServer(t)
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+	a := s.authenticator(*acc.UID)
+	s.register(a, s.token(acc))
+	finish := "/api/v1/webauthn/login/finish"
+
+	var body struct {
+		Token *token.Pair `json:"token"`
+	}
+	c := s.challenge("/api/v1/webauthn/login/begin", "", nil)
+	r := a.Get(rpID, rpOrigin, c, webauthntest.FlagUserPresent|webauthntest.FlagUserVerified)
+	if res := s.do("POST", finish, "", &webauthnRequest{Credential: r}, &body); res.StatusCode != http.StatusOK || body.Token == nil {
+		t.Fatal("Error logging in with the passkey: ", res.StatusCode)
+	}
+	if status, code := s.finish(finish, "", &webauthnRequest{Credential: r}); status != http.StatusBadRequest || code != "invalid_challenge" {
+		t.Fatal("Assertion replayed: ", status, code)
+	}
+
+	// each refused assertion uses up its challenge and counts as a failure, the
+	// counter regression goes last as it leaves the authenticator behind
+	var uv byte = webauthntest.FlagUserPresent | webauthntest.FlagUserVerified
+	refused := []struct {
+		name string
+		get  func(c string) *webauthn.Response
+	}{
+		{"wrong origin", func(c string) *webauthn.Response { return a.Get(rpID, "https://evil.local", c, uv) }},
+		{"wrong rp id", func(c string) *webauthn.Response { return a.Get("evil.local", rpOrigin, c, uv) }},
+		{"user not verified", func(c string) *webauthn.Response { return a.Get(rpID, rpOrigin, c, webauthntest.FlagUserPresent) }},
+		{"another user handle", func(c string) *webauthn.Response {
+			other := *a
+			other.UserHandle = "another"
+			return other.Get(rpID, rpOrigin, c, uv)
+		}},
+		{"counter regression", func(c string) *webauthn.Response {
+			a.Count = 0
+			return a.Get(rpID, rpOrigin, c, uv)
+		}},
+	}
+	for _, tc := range refused {
+		time.Sleep(10 * time.Millisecond)
+		c := s.challenge("/api/v1/webauthn/login/begin", "", nil)
+		if status, code := s.finish(finish, "", &webauthnRequest{Credential: tc.get(c)}); status != http.StatusUnauthorized {
+			t.Fatalf("Assertion with %s accepted: %d %s", tc.name, status, code)
+		}
+	}
+	if cred, _ := s.ctx.DB.LoadCredential(a.CredentialID()); cred == nil || cred.SignCount != 2 {
+		t.Fatal("Signature counter changed by a refused assertion: ", cred)
+	}
+}
+
+func TestWebAuthnMFA(t *testing.T) {
+	s := newWebAuthnServer(t)
+	acc := s.account("jdoe@example.com", "SuperDifficultPass")
+	a := s.authenticator("")
+	s.register(a, s.token(acc))
+	finish := "/api/v1/webauthn/mfa/finish"
+
+	// the password is not enough once a credential is registered
+	var auth struct {
+		Token    *token.Pair `json:"token"`
+		MFAToken string      `json:"mfa_token"`
+	}
+	s.post("/api/v1/authenticate", "", url.Values{"email": {"jdoe@example.com"}, "password": {"SuperDifficultPass"}}, &auth)
+	if auth.Token != nil || auth.MFAToken == "" {
+		t.Fatal("Second factor not required")
+	}
+	form := url.Values{"mfa_token": {auth.MFAToken}}
+
+	// the security key does not need to verify the user, a counter regression is refused
+	c := s.challenge("/api/v1/webauthn/mfa/begin", "", form)
+	count := a.Count
+	a.Count = 0
+	if status, code := s.finish(finish, "", &webauthnRequest{MFAToken: auth.MFAToken, Credential: a.Get(rpID, rpOrigin, c, webauthntest.FlagUserPresent)}); status != http.StatusUnauthorized {
+		t.Fatal("Counter regression accepted: ", status, code)
+	}
+	a.Count = count
+	time.Sleep(10 * time.Millisecond)
+	c = s.challenge("/api/v1/webauthn/mfa/begin", "", form)
+	r := a.Get(rpID, rpOrigin, c, webauthntest.FlagUserPresent)
+	if status, code := s.finish(finish, "", &webauthnRequest{MFAToken: auth.MFAToken, Credential: r}); status != http.StatusOK {
+		t.Fatal("Error completing the second factor: ", status, code)
+	}
+	if status, code := s.finish(finish, "", &webauthnRequest{MFAToken: auth.MFAToken, Credential: r}); status != http.StatusBadRequest || code != "invalid_challenge" {
+		t.Fatal("Challenge used twice: ", status, code)
+	}
+}
@@ -0,0 +1,209 @@
+package apikey
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jllopis/try5/seal"
+)
+
+// Key is an API key pair used by machine clients to sign their requests.
+// The secret is only known by the client. The requests are signed with the
+// SHA-256 of the secret, so the server needs that signing key: it is stored
+// in SealedKey, encrypted with the server Sealer. Anyone who reads the store
+// and has the key of the Sealer can sign requests as the owner of the key.
+// SecretHash is the signing key in clear of the keys created by the older
+// versions, see Seal.
+type Key struct {
+	ID         *string    `json:"id" db:"id"`
+	AccountUID *string    `json:"account_uid" db:"account_uid"`
+	Name       *string    `json:"name,omitempty" db:"name"`
+	SecretHash *string    `json:"-" db:"secret_hash"`
+	SealedKey  *string    `json:"-" db:"sealed_key"`
+	Created    *time.Time `json:"created" db:"created"`
+	LastUsed   *time.Time `json:"last_used,omitempty" db:"last_used"`
+	Revoked    *time.Time `json:"revoked,omitempty" db:"revoked"`
+}
+
+const (
+	// Scheme is the authorization scheme used in the Authorization header
+	Scheme = "HMAC-SHA256"
+)
+
+var (
+	ErrInvalidKey         = errors.New("invalid api key")
+	ErrRevokedKey         = errors.New("revoked api key")
+	ErrInvalidSignature   = errors.New("invalid signature")
+	ErrInvalidAuthHeader  = errors.New("invalid authorization header")
+	ErrInvalidDigest      = errors.New("invalid body digest")
+	ErrRequestExpired     = errors.New("request date outside the allowed window")
+	ErrReplayedSignature  = errors.New("signature already used")
+	ErrMissingDateHeader  = errors.New("missing date header")
+	ErrInvalidAccountUID  = errors.New("invalid account uid")
+	ErrSecretNotAvailable = errors.New("secret not available")
+	ErrNoSealer           = errors.New("no key to encrypt the api keys")
+)
+
+// New generates a new key pair for the account, its signing key is encrypted with s.
+// The returned secret must be handed to the client as it can not be recovered later.
+func New(accountUID, name string, s *seal.Sealer) (*Key, string, error) {
+	if accountUID == "" {
+		return nil, "", ErrInvalidAccountUID
+	}
+	if s == nil {
+		return nil, "", ErrNoSealer
+	}
+	id, err := randomString(16, hex.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	sealed, err := s.Seal(HashSecret(secret))
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	k := &Key{ID: &id, AccountUID: &accountUID, SealedKey: &sealed, Created: &now}
+	if name != "" {
+		k.Name = &name
+	}
+	return k, secret, nil
+}
+
+// HashSecret returns the hex encoded SHA-256 of the secret
+func HashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// IsRevoked tells if the key has been revoked
+func (k *Key) IsRevoked() bool {
+	return k.Revoked != nil
+}
+
+// Revoke marks the key as revoked
+func (k *Key) Revoke() {
+	now := time.Now().UTC()
+	k.Revoked = &now
+}
+
+// StringToSign builds the canonical string that is signed by the client:
+// the method, the path with the query string, the Date header and the body digest
+// separated by new lines.
+func StringToSign(method, path, date, digest string) string {
+	return strings.Join([]string{strings.ToUpper(method), path, date, digest}, "\n")
+}
+
+// BodyDigest returns the value of the Digest header for body
+func BodyDigest(body []byte) string {
+	h := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Sign computes the base64 encoded signature of the string to sign using the client secret.
+func Sign(secret, stringToSign string) string {
+	return signWithHash(HashSecret(secret), stringToSign)
+}
+
+// Verify checks the signature against the stored key using a constant time comparison.
+// s opens the sealed signing key, it is not used for the keys in clear.
+func (k *Key) Verify(s *seal.Sealer, stringToSign, signature string) error {
+	hash, err := k.signingKey(s)
+	if err != nil {
+		return err
+	}
+	expected := signWithHash(hash, stringToSign)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) != 1 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Sealed tells if the signing key is stored encrypted
+func (k *Key) Sealed() bool {
+	return k.SealedKey != nil
+}
+
+// Seal encrypts the signing key of a key stored in clear by an older version
+func (k *Key) Seal(s *seal.Sealer) error {
+	if k.Sealed() {
+		return nil
+	}
+	if k.SecretHash == nil {
+		return ErrSecretNotAvailable
+	}
+	if s == nil {
+		return ErrNoSealer
+	}
+	sealed, err := s.Seal(*k.SecretHash)
+	if err != nil {
+		return err
+	}
+	k.SealedKey, k.SecretHash = &sealed, nil
+	return nil
+}
+
+func (k *Key) signingKey(s *seal.Sealer) (string, error) {
+	switch {
+	case k.SealedKey != nil:
+		if s == nil {
+			return "", ErrNoSealer
+		}
+		return s.Open(*k.SealedKey)
+	case k.SecretHash != nil:
+		return *k.SecretHash, nil
+	}
+	return "", ErrSecretNotAvailable
+}
+
+func signWithHash(hash, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(hash))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ParseAuthorization parses an Authorization header in the form
+//
+//	HMAC-SHA256 KeyId=<key id>,Signature=<base64 signature>
+//
+// and returns the key id and the signature.
+func ParseAuthorization(h string) (string, string, error) {
+	if !strings.HasPrefix(h, Scheme+" ") {
+		return "", "", ErrInvalidAuthHeader
+	}
+	var id, sig string
+	for _, p := range strings.Split(h[len(Scheme)+1:], ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 {
+			return "", "", ErrInvalidAuthHeader
+		}
+		switch kv[0] {
+		case "KeyId":
+			id = kv[1]
+		case "Signature":
+			sig = kv[1]
+		}
+	}
+	if id == "" || sig == "" {
+		return "", "", ErrInvalidAuthHeader
+	}
+	return id, sig, nil
+}
+
+func randomString(n int, enc func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return enc(b), nil
+}
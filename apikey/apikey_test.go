@@ -0,0 +1,69 @@
+package apikey
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/seal"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	sealer, err := seal.New([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal("Error creating sealer: ", err)
+	}
+	if _, _, err := New("7ecee355-537b-492c-ab23-6a41219959d1", "test key", nil); err != ErrNoSealer {
+		t.Fatal("Key created without sealer: ", err)
+	}
+	key, secret, err := New("7ecee355-537b-492c-ab23-6a41219959d1", "test key", sealer)
+	if err != nil {
+		t.Fatal("Error creating key: ", err)
+	}
+	date := time.Now().UTC().Format(http.TimeFormat)
+	sts := StringToSign("post", "/api/v1/accounts?x=1", date, BodyDigest([]byte(`{"name":"x"}`)))
+	sig := Sign(secret, sts)
+
+	id, got, err := ParseAuthorization(Scheme + " KeyId=" + *key.ID + ",Signature=" + sig)
+	if err != nil {
+		t.Fatal("Error parsing authorization header: ", err)
+	}
+	if id != *key.ID || got != sig {
+		t.Fatalf("Wrong values parsed. Got %s %s", id, got)
+	}
+	if err := key.Verify(sealer, sts, sig); err != nil {
+		t.Fatal("Error verifying signature: ", err)
+	}
+	if err := key.Verify(sealer, sts+"x", sig); err != ErrInvalidSignature {
+		t.Fatal("Tampered request accepted")
+	}
+	if err := CheckDate(date, time.Minute); err != nil {
+		t.Fatal("Error checking date: ", err)
+	}
+	if err := CheckDate(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), time.Minute); err != ErrRequestExpired {
+		t.Fatal("Old request accepted")
+	}
+
+	if !key.Sealed() || key.SecretHash != nil || *key.SealedKey == HashSecret(secret) {
+		t.Fatal("Signing key stored in clear")
+	}
+	if err := key.Verify(nil, sts, sig); err != ErrNoSealer {
+		t.Fatal("Sealed key verified without sealer: ", err)
+	}
+
+	// the keys in clear of the older versions are verified and sealed
+	hash := HashSecret(secret)
+	old := &Key{ID: key.ID, AccountUID: key.AccountUID, SecretHash: &hash}
+	if err := old.Verify(nil, sts, sig); err != nil {
+		t.Fatal("Error verifying key in clear: ", err)
+	}
+	if err := old.Seal(sealer); err != nil || !old.Sealed() || old.SecretHash != nil {
+		t.Fatal("Error sealing key: ", err)
+	}
+	if err := old.Verify(sealer, sts, sig); err != nil {
+		t.Fatal("Error verifying sealed key: ", err)
+	}
+	if ReplayID(sig) == ReplayID(sig+"x") || len(ReplayID(sig)) != 64 {
+		t.Fatal("Wrong replay id")
+	}
+}
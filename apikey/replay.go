@@ -0,0 +1,32 @@
+package apikey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// ReplayID is the id under which the signature of a request is recorded, so a
+// captured request can not be sent again. The signatures are recorded in the
+// store, shared by every server, until the Date check rejects them anyway.
+func ReplayID(signature string) string {
+	h := sha256.Sum256([]byte(signature))
+	return hex.EncodeToString(h[:])
+}
+
+// CheckDate verifies that the request date is inside the allowed clock skew
+func CheckDate(date string, skew time.Duration) error {
+	if date == "" {
+		return ErrMissingDateHeader
+	}
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return ErrMissingDateHeader
+	}
+	d := time.Now().Sub(t)
+	if d > skew || d < -skew {
+		return ErrRequestExpired
+	}
+	return nil
+}
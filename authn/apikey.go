@@ -0,0 +1,77 @@
+package authn
+
+import (
+	"time"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/seal"
+	"github.com/jllopis/try5/store"
+)
+
+// DefaultSignatureSkew is the maximum allowed difference between the date of a
+// signed request and the server clock when APIKey.Skew is not set
+const DefaultSignatureSkew = 5 * time.Minute
+
+// APIKey verifies the requests signed with an api key. Sealer opens the signing keys,
+// the keys stored in clear by the older versions are sealed when used. The signatures
+// are recorded in the store so a request can not be replayed, to any server.
+type APIKey struct {
+	DB     store.Storer
+	Skew   time.Duration
+	Sealer *seal.Sealer
+}
+
+// Authenticate checks the signature of the request and returns the account that
+// owns the key
+func (k *APIKey) Authenticate(c *Credentials) (*account.Account, error) {
+	s := c.Signature
+	if s == nil {
+		return nil, ErrNoCredentials
+	}
+	skew := k.Skew
+	if skew == 0 {
+		skew = DefaultSignatureSkew
+	}
+	if err := apikey.CheckDate(s.Date, skew); err != nil {
+		return nil, err
+	}
+	if s.Digest != apikey.BodyDigest(s.Body) {
+		return nil, apikey.ErrInvalidDigest
+	}
+	key, err := k.DB.LoadKey(s.KeyID)
+	if err != nil || key == nil {
+		logger.Info("func APIKey.Authenticate", "error", "key not found", "key", s.KeyID)
+		return nil, apikey.ErrInvalidKey
+	}
+	if key.IsRevoked() {
+		return nil, apikey.ErrRevokedKey
+	}
+	if err := key.Verify(k.Sealer, apikey.StringToSign(s.Method, s.URI, s.Date, s.Digest), s.Signature); err != nil {
+		return nil, err
+	}
+	// the date is checked against the skew both ways, the signature is refused until then
+	fresh, err := k.DB.RecordSignature(apikey.ReplayID(s.Signature), time.Now().Add(2*skew))
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		logger.Warn("func APIKey.Authenticate", "error", "replayed signature", "key", s.KeyID)
+		return nil, apikey.ErrReplayedSignature
+	}
+	acc, err := activeAccount(k.DB, *key.AccountUID, apikey.ErrInvalidKey)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	key.LastUsed = &now
+	if !key.Sealed() && k.Sealer != nil {
+		if err := key.Seal(k.Sealer); err != nil {
+			logger.Warn("func APIKey.Authenticate", "error", err, "key", s.KeyID, "info", "signing key not sealed")
+		}
+	}
+	if _, err := k.DB.SaveKey(key); err != nil {
+		logger.Warn("func APIKey.Authenticate", "error", err, "key", s.KeyID)
+	}
+	return acc, nil
+}
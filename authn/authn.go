@@ -0,0 +1,144 @@
+// Package authn verifies the credentials presented to try5, a password, an api key
+// signature, a bearer token or a session, and returns the account they belong to.
+// The Authenticators are chained with FirstMatch or RequireAll so every endpoint,
+// REST or not, goes through the same pipeline.
+package authn
+
+import (
+	"errors"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/store"
+	"github.com/mgutz/logxi/v1"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the credentials are not
+	// of its kind, ie. a password for the token authenticator
+	ErrNoCredentials = errors.New("no credentials provided")
+	// ErrInvalidCredentials is returned for the wrong credentials and the unknown logins
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrAccountDisabled is returned for the valid credentials of a disabled account
+	ErrAccountDisabled = errors.New("account disabled")
+)
+
+var logger = log.New("authn")
+
+// Credentials are what a client presents to authenticate. Only the fields of the
+// kinds presented are set, each Authenticator looks at its own.
+type Credentials struct {
+	// Login and Password are checked by Local and LDAP, the login is the email of the
+	// account for Local
+	Login    string
+	Password string
+	// Token is a bearer access token
+	Token string
+	// Signature is a request signed with an api key
+	Signature *SignedRequest
+	// Session is the id of a session
+	Session string
+}
+
+// SignedRequest is a request signed with an api key, see apikey.StringToSign
+type SignedRequest struct {
+	KeyID     string
+	Signature string
+	Method    string
+	URI       string
+	Date      string
+	Digest    string
+	Body      []byte
+}
+
+// Authenticator verifies the credentials and returns their account, with no
+// password. It returns ErrNoCredentials for the credentials it does not handle.
+type Authenticator interface {
+	Authenticate(c *Credentials) (*account.Account, error)
+}
+
+type firstMatch []Authenticator
+
+// FirstMatch returns an Authenticator that tries the authenticators in order and
+// returns the account of the first one that accepts the credentials. If none does
+// the error is, in order of preference, the first one other than invalid or missing
+// credentials, ie. an unreachable directory or a revoked key, ErrInvalidCredentials
+// or ErrNoCredentials.
+func FirstMatch(auths ...Authenticator) Authenticator {
+	return firstMatch(auths)
+}
+
+func (f firstMatch) Authenticate(c *Credentials) (*account.Account, error) {
+	var failed error = ErrNoCredentials
+	for _, a := range f {
+		acc, err := a.Authenticate(c)
+		switch {
+		case err == nil:
+			return acc, nil
+		case err == ErrNoCredentials:
+		case err == ErrInvalidCredentials:
+			if failed == ErrNoCredentials {
+				failed = err
+			}
+		default:
+			if failed == ErrNoCredentials || failed == ErrInvalidCredentials {
+				failed = err
+			}
+		}
+	}
+	return nil, failed
+}
+
+type requireAll []Authenticator
+
+// RequireAll returns an Authenticator that needs every authenticator that handles
+// the credentials to accept them for the same account. The ones that do not handle
+// them are skipped, but at least one must. The account of the first one is returned.
+func RequireAll(auths ...Authenticator) Authenticator {
+	return requireAll(auths)
+}
+
+func (r requireAll) Authenticate(c *Credentials) (*account.Account, error) {
+	var res *account.Account
+	for _, a := range r {
+		acc, err := a.Authenticate(c)
+		if err == ErrNoCredentials {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			res = acc
+		} else if *res.UID != *acc.UID {
+			logger.Warn("func RequireAll", "error", "credentials of different accounts", "uid", *res.UID, "other", *acc.UID)
+			return nil, ErrInvalidCredentials
+		}
+	}
+	if res == nil {
+		return nil, ErrNoCredentials
+	}
+	return res, nil
+}
+
+// activeAccount loads the account uid, notFound is returned if it does not exist
+func activeAccount(db store.Storer, uid string, notFound error) (*account.Account, error) {
+	acc, err := db.LoadAccount(uid)
+	if err != nil || acc == nil {
+		if store.KindOf(err) == store.Unavailable {
+			return nil, err
+		}
+		return nil, notFound
+	}
+	if acc.Active != nil && !*acc.Active {
+		return nil, ErrAccountDisabled
+	}
+	return withoutPassword(acc), nil
+}
+
+// withoutPassword returns a copy of the account without the password, the stores
+// that keep the accounts in memory return their own
+func withoutPassword(a *account.Account) *account.Account {
+	c := *a
+	c.Password = nil
+	return &c
+}
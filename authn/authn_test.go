@@ -0,0 +1,158 @@
+package authn
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/seal"
+	"github.com/jllopis/try5/session"
+	"github.com/jllopis/try5/store/backend/mem"
+	"github.com/jllopis/try5/token"
+)
+
+// fixed accepts the credentials with the password it was given
+type fixed struct {
+	uid      string
+	password string
+	err      error
+}
+
+func (f *fixed) Authenticate(c *Credentials) (*account.Account, error) {
+	switch {
+	case c.Password == "":
+		return nil, ErrNoCredentials
+	case f.err != nil:
+		return nil, f.err
+	case c.Password != f.password:
+		return nil, ErrInvalidCredentials
+	}
+	uid := f.uid
+	return &account.Account{UID: &uid}, nil
+}
+
+func TestChain(t *testing.T) {
+	a := &fixed{uid: "a", password: "pa"}
+	b := &fixed{uid: "b", password: "pb"}
+	a2 := &fixed{uid: "a", password: "pb"}
+	down := &fixed{err: errors.New("directory unavailable")}
+
+	first := FirstMatch(a, down, b)
+	if acc, err := first.Authenticate(&Credentials{Password: "pb"}); err != nil || *acc.UID != "b" {
+		t.Fatal("FirstMatch did not go on to the next authenticator: ", err)
+	}
+	if _, err := first.Authenticate(&Credentials{Password: "wrong"}); err != down.err {
+		t.Fatal("FirstMatch hid the error of an authenticator: ", err)
+	}
+	if _, err := FirstMatch(a, b).Authenticate(&Credentials{Password: "wrong"}); err != ErrInvalidCredentials {
+		t.Fatal("Wrong credentials accepted: ", err)
+	}
+	if _, err := FirstMatch(a, b).Authenticate(&Credentials{Token: "x"}); err != ErrNoCredentials {
+		t.Fatal("Credentials of another kind not reported: ", err)
+	}
+
+	if acc, err := RequireAll(b, a2).Authenticate(&Credentials{Password: "pb"}); err == nil {
+		t.Fatal("RequireAll accepted credentials of different accounts: ", *acc.UID)
+	}
+	if _, err := RequireAll(a, a2).Authenticate(&Credentials{Password: "pb"}); err != ErrInvalidCredentials {
+		t.Fatal("RequireAll accepted credentials refused by one: ", err)
+	}
+	both := &fixed{uid: "a", password: "pb"}
+	if acc, err := RequireAll(a2, both).Authenticate(&Credentials{Password: "pb"}); err != nil || *acc.UID != "a" {
+		t.Fatal("RequireAll refused valid credentials: ", err)
+	}
+	if _, err := RequireAll(a, b).Authenticate(&Credentials{Session: "x"}); err != ErrNoCredentials {
+		t.Fatal("RequireAll accepted no credentials: ", err)
+	}
+}
+
+func TestAuthenticators(t *testing.T) {
+	db := mem.NewMemStore()
+	email, name, password := "jdoe@example.com", "Jane Doe", "Correct horse battery 1"
+	acc, err := db.SaveAccount(&account.Account{Email: &email, Name: &name, Password: &password})
+	if err != nil {
+		t.Fatal("Error creating account: ", err)
+	}
+	uid := *acc.UID
+
+	local := &Local{DB: db}
+	if acc, err := local.Authenticate(&Credentials{Login: email, Password: password}); err != nil || *acc.UID != uid || acc.Password != nil {
+		t.Fatal("Error authenticating with the password: ", err)
+	}
+	if _, err := local.Authenticate(&Credentials{Login: email, Password: "wrong"}); err != ErrInvalidCredentials {
+		t.Fatal("Wrong password accepted: ", err)
+	}
+	if _, err := local.Authenticate(&Credentials{Login: "nobody@example.com", Password: password}); err != ErrInvalidCredentials {
+		t.Fatal("Unknown email accepted: ", err)
+	}
+	if _, err := local.Authenticate(&Credentials{Token: "x"}); err != ErrNoCredentials {
+		t.Fatal("Token taken as a password: ", err)
+	}
+
+	tm, _ := token.NewManager(&token.Options{Secret: []byte("SuperDifficultSecret")})
+	pair, err := tm.Issue(acc)
+	if err != nil {
+		t.Fatal("Error issuing tokens: ", err)
+	}
+	tok := &Token{DB: db, Tokens: tm}
+	if acc, err := tok.Authenticate(&Credentials{Token: pair.AccessToken}); err != nil || *acc.UID != uid {
+		t.Fatal("Error authenticating with the token: ", err)
+	}
+	if _, err := tok.Authenticate(&Credentials{Token: pair.RefreshToken}); err == nil {
+		t.Fatal("Refresh token accepted as access token")
+	}
+
+	s, err := session.New(uid, "127.0.0.1", "test", session.Options{})
+	if err != nil {
+		t.Fatal("Error creating session: ", err)
+	}
+	if s, err = db.SaveSession(s); err != nil {
+		t.Fatal("Error saving session: ", err)
+	}
+	sess := &Session{DB: db}
+	if acc, err := sess.Authenticate(&Credentials{Session: *s.ID}); err != nil || *acc.UID != uid {
+		t.Fatal("Error authenticating with the session: ", err)
+	}
+	if _, err := sess.Authenticate(&Credentials{Session: "unknown"}); err != session.ErrInvalidSession {
+		t.Fatal("Unknown session accepted: ", err)
+	}
+
+	sealer, err := seal.New([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal("Error creating sealer: ", err)
+	}
+	key, secret, _ := apikey.New(uid, "test", sealer)
+	if _, err := db.SaveKey(key); err != nil {
+		t.Fatal("Error saving key: ", err)
+	}
+	body := []byte(`{"name":"x"}`)
+	req := &SignedRequest{KeyID: *key.ID, Method: "POST", URI: "/api/v1/accounts", Date: time.Now().UTC().Format(http.TimeFormat), Digest: apikey.BodyDigest(body), Body: body}
+	req.Signature = apikey.Sign(secret, apikey.StringToSign(req.Method, req.URI, req.Date, req.Digest))
+	keys := &APIKey{DB: db, Sealer: sealer}
+	if acc, err := keys.Authenticate(&Credentials{Signature: req}); err != nil || *acc.UID != uid {
+		t.Fatal("Error authenticating with the signature: ", err)
+	}
+	if _, err := keys.Authenticate(&Credentials{Signature: req}); err != apikey.ErrReplayedSignature {
+		t.Fatal("Replayed signature accepted: ", err)
+	}
+	// the signatures are recorded in the store, the other servers refuse them too
+	other := &APIKey{DB: db, Sealer: sealer}
+	if _, err := other.Authenticate(&Credentials{Signature: req}); err != apikey.ErrReplayedSignature {
+		t.Fatal("Replayed signature accepted by another authenticator: ", err)
+	}
+
+	// the disabled accounts are refused by every authenticator
+	acc.Active = new(bool)
+	if _, err := db.SaveAccount(acc); err != nil {
+		t.Fatal("Error disabling account: ", err)
+	}
+	chain := FirstMatch(local, tok, sess)
+	for _, c := range []*Credentials{{Login: email, Password: password}, {Token: pair.AccessToken}, {Session: *s.ID}} {
+		if _, err := chain.Authenticate(c); err != ErrAccountDisabled {
+			t.Fatalf("Disabled account accepted: %v %+v", err, c)
+		}
+	}
+}
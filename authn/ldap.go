@@ -0,0 +1,110 @@
+package authn
+
+import (
+	"errors"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/ldap"
+	"github.com/jllopis/try5/store"
+)
+
+// ErrNoDirectoryEmail is returned for the directory users without an email, the
+// accounts of try5 need one
+var ErrNoDirectoryEmail = errors.New("the directory user has no email")
+
+// LDAP binds to a directory with the login and the password. The users get an
+// account, found by the email of the directory or created with Signup, whose name
+// and mapped roles follow the ones of the directory. The users without an account
+// are refused if Signup is nil.
+type LDAP struct {
+	DB        store.Storer
+	Directory *ldap.Directory
+	Signup    func(email, name string) (*account.Account, error)
+}
+
+// Authenticate binds to the directory and returns the account of the user
+func (l *LDAP) Authenticate(c *Credentials) (*account.Account, error) {
+	if c.Login == "" || c.Password == "" {
+		return nil, ErrNoCredentials
+	}
+	u, err := l.Directory.Authenticate(c.Login, c.Password)
+	if err != nil {
+		if err == ldap.ErrInvalidCredentials || err == ldap.ErrUserNotFound {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if u.Email == "" {
+		logger.Warn("func LDAP.Authenticate", "dn", u.DN, "info", "directory user without email")
+		return nil, ErrNoDirectoryEmail
+	}
+	acc, err := l.DB.GetAccountByEmail(u.Email)
+	switch {
+	case store.IsNotFound(err):
+		if l.Signup == nil {
+			logger.Info("func LDAP.Authenticate", "dn", u.DN, "info", "directory user without account")
+			return nil, ErrInvalidCredentials
+		}
+		if acc, err = l.Signup(u.Email, u.Name); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case u.Name != "" && (acc.Name == nil || *acc.Name != u.Name):
+		// the password is kept as it is
+		a, name := *acc, u.Name
+		a.Name, a.Password = &name, nil
+		if acc, err = l.DB.SaveAccount(&a); err != nil {
+			return nil, err
+		}
+	}
+	if acc.Active != nil && !*acc.Active {
+		return nil, ErrAccountDisabled
+	}
+	if err := l.syncRoles(*acc.UID, l.Directory.Roles(u)); err != nil {
+		return nil, err
+	}
+	return withoutPassword(acc), nil
+}
+
+// syncRoles assigns the mapped roles of the user's groups and removes the other
+// mapped roles. The roles not mapped to a group are left as they are.
+func (l *LDAP) syncRoles(uid string, slugs []string) error {
+	want := map[string]bool{}
+	for _, s := range slugs {
+		want[s] = true
+	}
+	current, err := l.DB.LoadAccountRoles(uid)
+	if err != nil {
+		return err
+	}
+	has := map[string]bool{}
+	for _, r := range current {
+		if r.Slug != nil {
+			has[*r.Slug] = true
+		}
+	}
+	for _, slug := range l.Directory.MappedRoles() {
+		if want[slug] == has[slug] {
+			continue
+		}
+		role, err := l.DB.GetRoleBySlug(slug)
+		if err != nil {
+			if store.IsNotFound(err) {
+				logger.Warn("func syncRoles", "role", slug, "info", "mapped role does not exist")
+				continue
+			}
+			return err
+		}
+		if want[slug] {
+			err = l.DB.AssignRole(uid, *role.ID)
+		} else {
+			_, err = l.DB.UnassignRole(uid, *role.ID)
+		}
+		if err != nil {
+			return err
+		}
+		logger.Info("func syncRoles", "uid", uid, "role", slug, "assigned", want[slug])
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package authn
+
+import (
+	"sync"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/store"
+)
+
+// Local checks the login and password against the email and password of the accounts
+type Local struct {
+	DB store.Storer
+}
+
+// dummyHash is verified when the email is unknown, so the response takes as long as
+// for a wrong password
+var dummyHash struct {
+	once sync.Once
+	hash string
+}
+
+// VerifyDummy verifies the password against a hash that matches nothing, for the
+// failures that must take as long as a wrong password
+func VerifyDummy(password string) {
+	dummyHash.once.Do(func() {
+		dummyHash.hash, _ = account.PasswordHasher.Hash([]byte("not the password of any account"))
+	})
+	account.PasswordHasher.Verify(dummyHash.hash, []byte(password))
+}
+
+// Authenticate finds the account by email and matches its password. The outdated
+// hashes are upgraded.
+func (l *Local) Authenticate(c *Credentials) (*account.Account, error) {
+	if c.Login == "" || c.Password == "" {
+		return nil, ErrNoCredentials
+	}
+	res, err := l.DB.GetAccountByEmail(c.Login)
+	if err != nil {
+		if !store.IsNotFound(err) {
+			return nil, err
+		}
+		VerifyDummy(c.Password)
+		return nil, ErrInvalidCredentials
+	}
+	rehashed, err := res.MatchPassword(c.Password)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if rehashed {
+		// the hash was outdated, the login goes on even if it can not be upgraded now
+		if err := l.DB.SetPasswordHash(*res.UID, *res.Password); err != nil {
+			logger.Warn("func Local.Authenticate", "error", err, "uid", *res.UID, "info", "password hash not upgraded")
+		}
+	}
+	if res.Active != nil && !*res.Active {
+		return nil, ErrAccountDisabled
+	}
+	return withoutPassword(res), nil
+}
@@ -0,0 +1,35 @@
+package authn
+
+import (
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/session"
+	"github.com/jllopis/try5/store"
+)
+
+// Session verifies the sessions started by the logins. The sessions that expired
+// are deleted and the ones in use are kept alive.
+type Session struct {
+	DB      store.Storer
+	Options session.Options
+}
+
+// Authenticate loads the session, checks it is still valid and returns its account
+func (s *Session) Authenticate(c *Credentials) (*account.Account, error) {
+	if c.Session == "" {
+		return nil, ErrNoCredentials
+	}
+	sess, err := s.DB.LoadSession(c.Session)
+	if err != nil || sess == nil {
+		return nil, session.ErrInvalidSession
+	}
+	if err := sess.Check(s.Options); err != nil {
+		s.DB.DeleteSession(c.Session)
+		return nil, err
+	}
+	if sess.Touch() {
+		if _, err := s.DB.SaveSession(sess); err != nil {
+			logger.Warn("func Session.Authenticate", "error", err)
+		}
+	}
+	return activeAccount(s.DB, *sess.AccountUID, ErrInvalidCredentials)
+}
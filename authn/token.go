@@ -0,0 +1,29 @@
+package authn
+
+import (
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/token"
+)
+
+// Token verifies the bearer access tokens issued by Tokens
+type Token struct {
+	DB     store.Storer
+	Tokens *token.Manager
+}
+
+// Authenticate validates the token and returns the account of its subject
+func (t *Token) Authenticate(c *Credentials) (*account.Account, error) {
+	if c.Token == "" {
+		return nil, ErrNoCredentials
+	}
+	claims, err := t.Tokens.Validate(c.Token, token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	acc, err := activeAccount(t.DB, claims.Subject, ErrInvalidCredentials)
+	if err == ErrInvalidCredentials {
+		logger.Info("func Token.Authenticate", "error", "account not found", "uid", claims.Subject)
+	}
+	return acc, err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jllopis/try5/keyring"
+	"github.com/jllopis/try5/oidc"
+	"github.com/jllopis/try5/seal"
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/token"
+)
+
+// cookieRing carga las claves de las cookies. Si están en la configuración se usan éstas,
+// si no se cargan del store (y se generan la primera vez) para que se compartan entre réplicas.
+// El segundo valor indica si las claves provienen del store.
+func cookieRing(s store.Storer) (*keyring.Ring, bool, error) {
+	if hk := config.GetString("CookieHashKeys"); hk != "" {
+		ring, err := keyring.FromConfig(hk, config.GetString("CookieBlockKeys"))
+		return ring, false, err
+	}
+	ring, err := keyring.Load(s, s)
+	return ring, true, err
+}
+
+// watchKeys recarga periódicamente las claves del store para que los servidores
+// en marcha acepten las claves rotadas por otros.
+func watchKeys(what string, reload func() error, every time.Duration) {
+	go func() {
+		for range time.Tick(every) {
+			if err := reload(); err != nil {
+				logger.Warn(what, "reload error", err)
+			}
+		}
+	}()
+}
+
+// oidcProvider carga las claves de firma de los ID tokens del store (se generan la
+// primera vez), nil si no se ha indicado el issuer. Las claves privadas se guardan
+// cifradas con sealer, la clave de TRY5_APIKEY_KEY.
+func oidcProvider(s store.Storer, tm *token.Manager, sealer *seal.Sealer) *oidc.Provider {
+	issuer := config.GetString("OIDCIssuer")
+	if issuer == "" {
+		logger.Warn("OpenID Connect", "issuer", "not set", "info", "set TRY5_OIDC_ISSUER to enable openid connect")
+		return nil
+	}
+	if sealer == nil {
+		logger.Fatal("Cannot load signing keys", "error", oidc.ErrNoSealer, "info", "set TRY5_APIKEY_KEY to encrypt the signing keys")
+	}
+	keys, err := oidc.Load(s, s, sealer)
+	if err != nil {
+		logger.Fatal("Cannot load signing keys", "error", err)
+	}
+	watchKeys("signing keys", func() error { return keys.Reload(s, sealer) }, time.Minute)
+	logger.Info("OpenID Connect", "issuer", issuer, "signing keys", len(keys.Keys()))
+	return &oidc.Provider{Issuer: issuer, Keys: keys, TTL: tm.AccessTTL}
+}
+
+const usage = `available commands:
+  keys rotate           add a new cookie key to the key ring
+  keys rotate signing   add a new ID token signing key to the JWK set
+  migrate [up]          apply the pending database migrations
+  migrate to <version>  apply or revert the migrations up to version (0 reverts all)
+  migrate version       show the current and the latest schema version`
+
+// runCommand ejecuta los subcomandos de try5d. Devuelve false si args no es un comando conocido.
+func runCommand(args []string) bool {
+	switch {
+	case len(args) == 2 && args[0] == "keys" && args[1] == "rotate":
+		rotateKeys()
+	case len(args) == 3 && args[0] == "keys" && args[1] == "rotate" && args[2] == "signing":
+		rotateSigningKeys()
+	case args[0] == "migrate":
+		return migrate(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %v\n", args)
+		fmt.Fprintln(os.Stderr, usage)
+		return false
+	}
+	return true
+}
+
+// rotateKeys añade una nueva clave al key ring. Las claves antiguas se mantienen para poder
+// decodificar las cookies ya emitidas.
+func rotateKeys() {
+	if config.GetString("CookieHashKeys") != "" {
+		// keys live in the configuration so we can only hand out a new pair
+		k := keyring.NewKey()
+		fmt.Println("cookie keys are set in the configuration. Prepend this pair to TRY5_COOKIE_HASH_KEYS and TRY5_COOKIE_BLOCK_KEYS:")
+		fmt.Println("hash: ", base64.StdEncoding.EncodeToString(k.HashKey))
+		fmt.Println("block:", base64.StdEncoding.EncodeToString(k.BlockKey))
+		return
+	}
+	ring, err := keyring.Load(apiCtx.DB, apiCtx.DB)
+	if err != nil {
+		logger.Fatal("keys rotate", "error", err)
+	}
+	k := ring.Rotate()
+	if err := apiCtx.DB.SaveCookieKeys(ring.Keys()); err != nil {
+		logger.Fatal("keys rotate", "error", err)
+	}
+	logger.Info("keys rotate", "new key", k.ID, "keys in ring", len(ring.Keys()))
+}
+
+// rotateSigningKeys añade una nueva clave de firma de los ID tokens. Las anteriores se
+// siguen publicando en el JWK set para poder verificar los tokens ya emitidos. Todas
+// se guardan cifradas, también las que una versión anterior guardó en claro.
+func rotateSigningKeys() {
+	sealer, err := keySealer()
+	if err != nil {
+		logger.Fatal("keys rotate signing", "error", err)
+	}
+	if sealer == nil {
+		logger.Fatal("keys rotate signing", "error", oidc.ErrNoSealer)
+	}
+	keys, err := oidc.Load(apiCtx.DB, apiCtx.DB, sealer)
+	if err != nil {
+		logger.Fatal("keys rotate signing", "error", err)
+	}
+	k, err := keys.Rotate()
+	if err != nil {
+		logger.Fatal("keys rotate signing", "error", err)
+	}
+	sealed, err := oidc.SealKeys(keys.Keys(), sealer)
+	if err != nil {
+		logger.Fatal("keys rotate signing", "error", err)
+	}
+	if err := apiCtx.DB.SaveSigningKeys(sealed); err != nil {
+		logger.Fatal("keys rotate signing", "error", err)
+	}
+	logger.Info("keys rotate signing", "new key", k.ID, "keys in set", len(keys.Keys()))
+}
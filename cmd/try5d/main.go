@@ -1,6 +1,12 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,29 +16,149 @@ import (
 	"time"
 
 	"bitbucket.org/jllopis/getconf"
-	"github.com/gorilla/securecookie"
 	"github.com/jllopis/aloja"
 	"github.com/jllopis/aloja/mw"
+	"github.com/jllopis/try5/account"
 	"github.com/jllopis/try5/api"
+	"github.com/jllopis/try5/authn"
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/hasher"
+	"github.com/jllopis/try5/ldap"
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/mailer"
+	"github.com/jllopis/try5/rbac"
+	"github.com/jllopis/try5/seal"
+	"github.com/jllopis/try5/session"
+	"github.com/jllopis/try5/store"
 	"github.com/jllopis/try5/store/backend/boltdb"
+	"github.com/jllopis/try5/store/backend/mem"
+	"github.com/jllopis/try5/store/backend/postgres"
+	"github.com/jllopis/try5/token"
+	"github.com/jllopis/try5/webauthn"
 	"github.com/mgutz/logxi/v1"
 	"github.com/unrolled/render"
 )
 
 // Config proporciona la configuración del servicio para ser utilizado por getconf
 type Config struct {
-	SslCert      string `getconf:"etcd app/try5/conf/sslcert" env TRY5_SSLCERT, flag sslcert`
-	SslKey       string `getconf:"etcd app/try5/conf/sslkey" env TRY5_SSLKEY, flag sslkey`
+	SslCert      string `getconf:"etcd app/try5/conf/sslcert, env TRY5_SSLCERT, flag sslcert"`
+	SslKey       string `getconf:"etcd app/try5/conf/sslkey, env TRY5_SSLKEY, flag sslkey"`
 	Port         string `getconf:"etcd app/try5/conf/port, env TRY5_PORT, flag port"`
 	Origins      string `getconf:"etcd app/try5/conf/origins, env TRY5_ORIGINS, flag origins"`
 	Verbose      bool   `getconf:"etcd app/try5/conf/verbose, env TRY5_VERBOSE, flag verbose"`
 	StorePath    string `getconf:"etcd app/try5/conf/storepath, env TRY5_STORE_PATH, flag storepath"`
 	StoreTimeout int    `getconf:"etcd app/try5/conf/storetimeout, env TRY5_STORE_TIMEOUT, flag storetimeout"`
-	//	StoreHost    string        `getconf:"etcd app/try5/conf/storehost, env TRY5_STORE_HOST, flag storehost"`
-	//	StorePort    int           `getconf:"etcd app/try5/conf/storeport, env TRY5_STORE_PORT, flag storeport"`
-	//	StoreName    string        `getconf:"etcd app/try5/conf/storename, env TRY5_STORE_NAME, flag storename"`
-	//	StoreUser    string        `getconf:"etcd app/try5/conf/storeaccount, env TRY5_STORE_USER, flag storeuser"`
-	//	StorePass    string        `getconf:"etcd app/try5/conf/storepass, env TRY5_STORE_PASS, flag storepass"`
+	// Store backend: bolt (default), postgres or memory
+	StoreDriver string `getconf:"etcd app/try5/conf/storedriver, env TRY5_STORE_DRIVER, flag storedriver"`
+	StoreHost   string `getconf:"etcd app/try5/conf/storehost, env TRY5_STORE_HOST, flag storehost"`
+	StorePort   int    `getconf:"etcd app/try5/conf/storeport, env TRY5_STORE_PORT, flag storeport"`
+	StoreName   string `getconf:"etcd app/try5/conf/storename, env TRY5_STORE_NAME, flag storename"`
+	StoreUser   string `getconf:"etcd app/try5/conf/storeuser, env TRY5_STORE_USER, flag storeuser"`
+	StorePass   string `getconf:"etcd app/try5/conf/storepass, env TRY5_STORE_PASS, flag storepass"`
+	StoreSSL    string `getconf:"etcd app/try5/conf/storessl, env TRY5_STORE_SSLMODE, flag storessl"`
+	// Do not apply the pending PostgreSQL migrations at startup (use try5d migrate)
+	StoreSkipMigrations bool `getconf:"etcd app/try5/conf/storeskipmigrations, env TRY5_STORE_SKIP_MIGRATIONS, flag storeskipmigrations"`
+	// JWT token issuance. TokenMethod can be HS256 (TokenSecret) or RS256/ES256 (TokenKeyFile, PEM private key)
+	TokenMethod     string `getconf:"etcd app/try5/conf/tokenmethod, env TRY5_TOKEN_METHOD, flag tokenmethod"`
+	TokenSecret     string `getconf:"etcd app/try5/conf/tokensecret, env TRY5_TOKEN_SECRET, flag tokensecret"`
+	TokenKeyFile    string `getconf:"etcd app/try5/conf/tokenkeyfile, env TRY5_TOKEN_KEYFILE, flag tokenkeyfile"`
+	TokenIssuer     string `getconf:"etcd app/try5/conf/tokenissuer, env TRY5_TOKEN_ISSUER, flag tokenissuer"`
+	TokenAccessTTL  int    `getconf:"etcd app/try5/conf/tokenaccessttl, env TRY5_TOKEN_ACCESS_TTL, flag tokenaccessttl"`
+	TokenRefreshTTL int    `getconf:"etcd app/try5/conf/tokenrefreshttl, env TRY5_TOKEN_REFRESH_TTL, flag tokenrefreshttl"`
+	// Comma separated list of account emails that get the admin role at startup
+	AdminAccounts string `getconf:"etcd app/try5/conf/adminaccounts, env TRY5_ADMIN_ACCOUNTS, flag adminaccounts"`
+	// Session cookies absolute lifetime and idle timeout (seconds)
+	SessionMaxAge      int `getconf:"etcd app/try5/conf/sessionmaxage, env TRY5_SESSION_MAX_AGE, flag sessionmaxage"`
+	SessionIdleTimeout int `getconf:"etcd app/try5/conf/sessionidletimeout, env TRY5_SESSION_IDLE_TIMEOUT, flag sessionidletimeout"`
+	// Comma separated base64 cookie keys, newest first. If empty the keys are kept in the store
+	CookieHashKeys  string `getconf:"etcd app/try5/conf/cookiehashkeys, env TRY5_COOKIE_HASH_KEYS, flag cookiehashkeys"`
+	CookieBlockKeys string `getconf:"etcd app/try5/conf/cookieblockkeys, env TRY5_COOKIE_BLOCK_KEYS, flag cookieblockkeys"`
+	// Allowed clock skew (seconds) for HMAC signed requests
+	SignatureSkew int `getconf:"etcd app/try5/conf/signatureskew, env TRY5_SIGNATURE_SKEW, flag signatureskew"`
+	// Base64 AES key (16, 24 or 32 bytes) that encrypts the signing keys of the api keys, they can not be
+	// created without it
+	APIKeyKey string `getconf:"etcd app/try5/conf/apikeykey, env TRY5_APIKEY_KEY, flag apikeykey"`
+	// Password policy: lengths, required character classes (0-4), file of banned passwords and previous passwords that can not be reused
+	PasswordMinLength  int    `getconf:"etcd app/try5/conf/passwordminlength, env TRY5_PASSWORD_MIN_LENGTH, flag passwordminlength"`
+	PasswordMaxLength  int    `getconf:"etcd app/try5/conf/passwordmaxlength, env TRY5_PASSWORD_MAX_LENGTH, flag passwordmaxlength"`
+	PasswordMinClasses int    `getconf:"etcd app/try5/conf/passwordminclasses, env TRY5_PASSWORD_MIN_CLASSES, flag passwordminclasses"`
+	PasswordBannedFile string `getconf:"etcd app/try5/conf/passwordbannedfile, env TRY5_PASSWORD_BANNED_FILE, flag passwordbannedfile"`
+	PasswordHistory    int    `getconf:"etcd app/try5/conf/passwordhistory, env TRY5_PASSWORD_HISTORY, flag passwordhistory"`
+	// Password hashing: argon2id (default), scrypt or bcrypt and their parameters. The hashes made with other
+	// parameters are upgraded when the account authenticates
+	PasswordHash          string `getconf:"etcd app/try5/conf/passwordhash, env TRY5_PASSWORD_HASH, flag passwordhash"`
+	PasswordBcryptCost    int    `getconf:"etcd app/try5/conf/passwordbcryptcost, env TRY5_PASSWORD_BCRYPT_COST, flag passwordbcryptcost"`
+	PasswordArgon2Memory  int    `getconf:"etcd app/try5/conf/passwordargon2memory, env TRY5_PASSWORD_ARGON2_MEMORY, flag passwordargon2memory"`
+	PasswordArgon2Time    int    `getconf:"etcd app/try5/conf/passwordargon2time, env TRY5_PASSWORD_ARGON2_TIME, flag passwordargon2time"`
+	PasswordArgon2Threads int    `getconf:"etcd app/try5/conf/passwordargon2threads, env TRY5_PASSWORD_ARGON2_THREADS, flag passwordargon2threads"`
+	PasswordScryptLogN    int    `getconf:"etcd app/try5/conf/passwordscryptlogn, env TRY5_PASSWORD_SCRYPT_LOGN, flag passwordscryptlogn"`
+	// Failed authentications allowed per account and per address before locking them out, and the
+	// lockout duration and the window the failures are counted in (seconds)
+	LockoutMaxFailures   int `getconf:"etcd app/try5/conf/lockoutmaxfailures, env TRY5_LOCKOUT_MAX_FAILURES, flag lockoutmaxfailures"`
+	LockoutIPMaxFailures int `getconf:"etcd app/try5/conf/lockoutipmaxfailures, env TRY5_LOCKOUT_IP_MAX_FAILURES, flag lockoutipmaxfailures"`
+	LockoutDuration      int `getconf:"etcd app/try5/conf/lockoutduration, env TRY5_LOCKOUT_DURATION, flag lockoutduration"`
+	LockoutWindow        int `getconf:"etcd app/try5/conf/lockoutwindow, env TRY5_LOCKOUT_WINDOW, flag lockoutwindow"`
+	// Mail delivery: log (default, development only), file (MailDir) or smtp
+	MailDriver string `getconf:"etcd app/try5/conf/maildriver, env TRY5_MAIL_DRIVER, flag maildriver"`
+	MailFrom   string `getconf:"etcd app/try5/conf/mailfrom, env TRY5_MAIL_FROM, flag mailfrom"`
+	MailDir    string `getconf:"etcd app/try5/conf/maildir, env TRY5_MAIL_DIR, flag maildir"`
+	SMTPAddr   string `getconf:"etcd app/try5/conf/smtpaddr, env TRY5_SMTP_ADDR, flag smtpaddr"`
+	SMTPUser   string `getconf:"etcd app/try5/conf/smtpuser, env TRY5_SMTP_USER, flag smtpuser"`
+	SMTPPass   string `getconf:"etcd app/try5/conf/smtppass, env TRY5_SMTP_PASS, flag smtppass"`
+	// Page of the client application the reset links point to and how long they are valid (seconds)
+	PasswordResetURL string `getconf:"etcd app/try5/conf/passwordreseturl, env TRY5_PASSWORD_RESET_URL, flag passwordreseturl"`
+	PasswordResetTTL int    `getconf:"etcd app/try5/conf/passwordresetttl, env TRY5_PASSWORD_RESET_TTL, flag passwordresetttl"`
+	// Email verification of the new and changed emails: the page of the client application the links point to,
+	// how long they are valid (seconds) and whether the unverified accounts can authenticate
+	EmailVerification         bool   `getconf:"etcd app/try5/conf/emailverification, env TRY5_EMAIL_VERIFICATION, flag emailverification"`
+	EmailVerificationURL      string `getconf:"etcd app/try5/conf/emailverificationurl, env TRY5_EMAIL_VERIFICATION_URL, flag emailverificationurl"`
+	EmailVerificationTTL      int    `getconf:"etcd app/try5/conf/emailverificationttl, env TRY5_EMAIL_VERIFICATION_TTL, flag emailverificationttl"`
+	EmailVerificationRequired bool   `getconf:"etcd app/try5/conf/emailverificationrequired, env TRY5_EMAIL_VERIFICATION_REQUIRED, flag emailverificationrequired"`
+	// Two-factor authentication: base64 AES key (16, 24 or 32 bytes) that encrypts the TOTP secrets, TOTP is
+	// not available without it, and the name of the service shown by the authenticator apps
+	MFAKey    string `getconf:"etcd app/try5/conf/mfakey, env TRY5_MFA_KEY, flag mfakey"`
+	MFAIssuer string `getconf:"etcd app/try5/conf/mfaissuer, env TRY5_MFA_ISSUER, flag mfaissuer"`
+	// WebAuthn (passkeys): the domain of the credentials, the name shown by the browsers and the comma
+	// separated origins of the pages allowed to use them. WebAuthn is not available without the domain.
+	WebAuthnRPID    string `getconf:"etcd app/try5/conf/webauthnrpid, env TRY5_WEBAUTHN_RP_ID, flag webauthnrpid"`
+	WebAuthnRPName  string `getconf:"etcd app/try5/conf/webauthnrpname, env TRY5_WEBAUTHN_RP_NAME, flag webauthnrpname"`
+	WebAuthnOrigins string `getconf:"etcd app/try5/conf/webauthnorigins, env TRY5_WEBAUTHN_ORIGINS, flag webauthnorigins"`
+	// OAuth 2.0: the login page of the client application the users without a session are sent to
+	// from /oauth/authorize, with the authorization request in return_to
+	OAuthLoginURL string `getconf:"etcd app/try5/conf/oauthloginurl, env TRY5_OAUTH_LOGIN_URL, flag oauthloginurl"`
+	// OpenID Connect: the public https URL of the server, the iss of the ID tokens. OpenID Connect is not
+	// available without it.
+	OIDCIssuer string `getconf:"etcd app/try5/conf/oidcissuer, env TRY5_OIDC_ISSUER, flag oidcissuer"`
+	// Federation: the public URL of the server the identity providers send the users back to (the OIDC issuer
+	// if not set), federation is not available without it, and the page of the client application the users
+	// land on after the login
+	PublicURL     string `getconf:"etcd app/try5/conf/publicurl, env TRY5_PUBLIC_URL, flag publicurl"`
+	FederationURL string `getconf:"etcd app/try5/conf/federationurl, env TRY5_FEDERATION_URL, flag federationurl"`
+	// Authenticators: comma separated list of local (the passwords of the accounts), ldap, apikey, token and
+	// session, default "local,apikey,token,session". With mode first (default) the first one that accepts the
+	// credentials wins, with all every one that handles them must accept them.
+	Authenticators    string `getconf:"etcd app/try5/conf/authenticators, env TRY5_AUTHENTICATORS, flag authenticators"`
+	AuthenticatorMode string `getconf:"etcd app/try5/conf/authenticatormode, env TRY5_AUTHENTICATOR_MODE, flag authenticatormode"`
+	// LDAP directory of the ldap authenticator. The users are found with the DN template (ie.
+	// uid=%s,ou=people,dc=example,dc=com) or searched under the base DN with the filter, binding first with
+	// the service account if set
+	LDAPURL          string `getconf:"etcd app/try5/conf/ldapurl, env TRY5_LDAP_URL, flag ldapurl"`
+	LDAPStartTLS     bool   `getconf:"etcd app/try5/conf/ldapstarttls, env TRY5_LDAP_START_TLS, flag ldapstarttls"`
+	LDAPCAFile       string `getconf:"etcd app/try5/conf/ldapcafile, env TRY5_LDAP_CA_FILE, flag ldapcafile"`
+	LDAPUserDN       string `getconf:"etcd app/try5/conf/ldapuserdn, env TRY5_LDAP_USER_DN, flag ldapuserdn"`
+	LDAPBindDN       string `getconf:"etcd app/try5/conf/ldapbinddn, env TRY5_LDAP_BIND_DN, flag ldapbinddn"`
+	LDAPBindPassword string `getconf:"etcd app/try5/conf/ldapbindpassword, env TRY5_LDAP_BIND_PASSWORD, flag ldapbindpassword"`
+	LDAPBaseDN       string `getconf:"etcd app/try5/conf/ldapbasedn, env TRY5_LDAP_BASE_DN, flag ldapbasedn"`
+	LDAPUserFilter   string `getconf:"etcd app/try5/conf/ldapuserfilter, env TRY5_LDAP_USER_FILTER, flag ldapuserfilter"`
+	// LDAP attributes of the email and name of the accounts, and the groups of the users: the values of the
+	// group attribute (ie. memberOf) and the groups found under the group base DN with the group filter
+	// (ie. (member=%s)). The group roles map groups to roles as "groupDN:role;groupDN:role"
+	LDAPEmailAttribute string `getconf:"etcd app/try5/conf/ldapemailattribute, env TRY5_LDAP_EMAIL_ATTRIBUTE, flag ldapemailattribute"`
+	LDAPNameAttribute  string `getconf:"etcd app/try5/conf/ldapnameattribute, env TRY5_LDAP_NAME_ATTRIBUTE, flag ldapnameattribute"`
+	LDAPGroupAttribute string `getconf:"etcd app/try5/conf/ldapgroupattribute, env TRY5_LDAP_GROUP_ATTRIBUTE, flag ldapgroupattribute"`
+	LDAPGroupBaseDN    string `getconf:"etcd app/try5/conf/ldapgroupbasedn, env TRY5_LDAP_GROUP_BASE_DN, flag ldapgroupbasedn"`
+	LDAPGroupFilter    string `getconf:"etcd app/try5/conf/ldapgroupfilter, env TRY5_LDAP_GROUP_FILTER, flag ldapgroupfilter"`
+	LDAPGroupRoles     string `getconf:"etcd app/try5/conf/ldapgrouproles, env TRY5_LDAP_GROUP_ROLES, flag ldapgrouproles"`
 }
 
 var (
@@ -54,46 +180,474 @@ func init() {
 	config = getconf.New(&Config{}, "TRY5", false, "")
 	config.Parse()
 	logger = log.New("try5api")
-	//	dbPort := 5432
-	//	if p, err := config.GetInt("StorePort"); err == nil {
-	//		logger.Info("Store", "port", p)
-	//		dbPort = int(p)
-	//	}
-	//	rs, err := psql.OpenPgSQLStore(&psql.PsqlStoreOptions{
-	//		Host:     config.GetString("StoreHost"),
-	//		Port:     dbPort,
-	//		DBName:   config.GetString("StoreName"),
-	//		User:     config.GetString("StoreUser"),
-	//		Password: config.GetString("StorePass"),
-	//	})
-	timeout := 5 * time.Second
-	if to, err := config.GetInt("StoreTimeout"); err == nil {
-		logger.Info("Store", "connect timeout (s)", to)
-		timeout = time.Duration(to) * time.Second
-	}
-	rs := bolt.NewBoltStore(&bolt.BoltStoreOptions{
-		Dbpath:  config.GetString("StorePath"),
-		Timeout: timeout,
-	})
-	if rs == nil {
-		logger.Fatal("Cannot connect to file store", "db file path", rs.Dbpath)
-	} else {
-		logger.Info("Connected to store backend", "driver", "boltdb", "db file path", rs.Dbpath)
+	rs := openStore()
+	if flag.Arg(0) == "migrate" {
+		// the schema may not exist yet, nothing else can be loaded from the store
+		apiCtx = &api.ApiContext{DB: rs}
+		return
 	}
 	r := render.New(render.Options{
 		Charset:    "UTF-8",
 		PrefixXML:  []byte("<?xml version='1.0' encoding='UTF-8'?>"),
 		IndentJSON: true,
 	})
-	apiCtx = &api.ApiContext{rs, r, securecookie.New(
-		securecookie.GenerateRandomKey(64),
-		securecookie.GenerateRandomKey(32))}
+	tm, err := token.NewManager(tokenOptions())
+	if err != nil {
+		logger.Fatal("Cannot setup token manager", "error", err)
+	}
+	logger.Info("Token manager", "method", tm.Method, "access ttl", tm.AccessTTL, "refresh ttl", tm.RefreshTTL)
+	if tm.Method == "HS256" && config.GetString("TokenSecret") == "" {
+		logger.Warn("Token manager", "secret", "random", "info", "tokens will not survive a restart")
+	}
+	skew := api.DefaultSignatureSkew
+	if sk, err := config.GetInt("SignatureSkew"); err == nil {
+		skew = time.Duration(sk) * time.Second
+	}
+	ring, stored, err := cookieRing(rs)
+	if err != nil {
+		logger.Fatal("Cannot load cookie keys", "error", err)
+	}
+	logger.Info("Cookie keys", "keys in ring", len(ring.Keys()), "from store", stored)
+	if stored {
+		watchKeys("keyring", func() error { return ring.Reload(rs) }, time.Minute)
+	}
+	account.PasswordHasher = passwordHasher()
+	logger.Info("Password hasher", "algorithm", account.PasswordHasher.Preferred().ID())
+	if account.Policy, err = account.NewPasswordPolicy(policyConfig()); err != nil {
+		logger.Fatal("Cannot setup password policy", "error", err)
+	}
+	apiCtx = &api.ApiContext{
+		DB:            rs,
+		Render:        r,
+		CookieHandler: ring,
+		Tokens:        tm,
+		SignatureSkew: skew,
+		Sessions:      sessionOptions(),
+		Lockout:       lockoutPolicy(),
+		Mailer:        setupMailer(),
+		ResetURL:      config.GetString("PasswordResetURL"),
+	}
+	if ttl, err := config.GetInt("PasswordResetTTL"); err == nil {
+		apiCtx.ResetTTL = time.Duration(ttl) * time.Second
+	}
+	apiCtx.VerifyEmail, _ = config.GetBool("EmailVerification")
+	apiCtx.RequireVerified, _ = config.GetBool("EmailVerificationRequired")
+	apiCtx.VerifyURL = config.GetString("EmailVerificationURL")
+	if ttl, err := config.GetInt("EmailVerificationTTL"); err == nil {
+		apiCtx.VerifyTTL = time.Duration(ttl) * time.Second
+	}
+	if apiCtx.VerifyEmail {
+		logger.Info("Email verification", "enabled", true, "required", apiCtx.RequireVerified)
+		if apiCtx.VerifyURL == "" {
+			logger.Warn("Email verification", "url", "not set", "info", "set TRY5_EMAIL_VERIFICATION_URL to the page of the client application")
+		}
+	}
+	if apiCtx.MFASealer, err = mfaSealer(); err != nil {
+		logger.Fatal("Cannot setup two-factor authentication", "error", err)
+	}
+	if apiCtx.KeySealer, err = keySealer(); err != nil {
+		logger.Fatal("Cannot setup api keys", "error", err)
+	}
+	apiCtx.MFAIssuer = config.GetString("MFAIssuer")
+	apiCtx.WebAuthn = relyingParty()
+	apiCtx.OAuthLoginURL = config.GetString("OAuthLoginURL")
+	apiCtx.OIDC = oidcProvider(rs, tm, apiCtx.KeySealer)
+	if apiCtx.PublicURL = config.GetString("PublicURL"); apiCtx.PublicURL == "" {
+		apiCtx.PublicURL = config.GetString("OIDCIssuer")
+	}
+	if apiCtx.PublicURL != "" {
+		apiCtx.Federation = federation.NewRelyingParty(10 * time.Second)
+		apiCtx.FederationURL = config.GetString("FederationURL")
+		logger.Info("Federation", "callbacks", apiCtx.PublicURL+"/api/v1/federation/<provider>/callback")
+	} else {
+		logger.Warn("Federation", "public url", "not set", "info", "set TRY5_PUBLIC_URL to enable the login with identity providers")
+	}
+	if apiCtx.Authenticator, err = authenticator(apiCtx); err != nil {
+		logger.Fatal("Cannot setup authenticator", "error", err)
+	}
+	if apiCtx.ResetURL == "" {
+		logger.Warn("Password reset", "url", "not set", "info", "set TRY5_PASSWORD_RESET_URL to the page of the client application")
+	}
+}
+
+// openStore abre el backend de almacenamiento indicado en StoreDriver
+func openStore() store.Storer {
+	driver := config.GetString("StoreDriver")
+	switch driver {
+	case "", "bolt", "boltdb":
+		timeout := 5 * time.Second
+		if to, err := config.GetInt("StoreTimeout"); err == nil {
+			logger.Info("Store", "connect timeout (s)", to)
+			timeout = time.Duration(to) * time.Second
+		}
+		rs := bolt.NewBoltStore(&bolt.BoltStoreOptions{
+			Dbpath:  config.GetString("StorePath"),
+			Timeout: timeout,
+		})
+		if rs == nil {
+			logger.Fatal("Cannot connect to file store", "db file path", config.GetString("StorePath"))
+		}
+		logger.Info("Connected to store backend", "driver", "boltdb", "db file path", rs.Dbpath)
+		return rs
+	case "postgres", "postgresql":
+		// the migrate command applies them itself
+		skip, _ := config.GetBool("StoreSkipMigrations")
+		dbPort := 5432
+		if p, err := config.GetInt("StorePort"); err == nil && p != 0 {
+			dbPort = int(p)
+		}
+		rs, err := psql.OpenPgSQLStore(&psql.PsqlStoreOptions{
+			Host:           config.GetString("StoreHost"),
+			Port:           dbPort,
+			DBName:         config.GetString("StoreName"),
+			User:           config.GetString("StoreUser"),
+			Password:       config.GetString("StorePass"),
+			SSLMode:        config.GetString("StoreSSL"),
+			SkipMigrations: skip || flag.Arg(0) == "migrate",
+		})
+		if err != nil {
+			logger.Fatal("Cannot connect to database", "host", config.GetString("StoreHost"), "port", dbPort, "error", err)
+		}
+		v, err := rs.SchemaVersion()
+		if err != nil {
+			logger.Fatal("Cannot read schema version", "error", err)
+		}
+		logger.Info("Connected to store backend", "driver", "postgres", "host", rs.Host, "port", rs.Port, "db", rs.DBName, "schema version", v)
+		if v < psql.LatestVersion() && flag.Arg(0) != "migrate" {
+			logger.Warn("Store", "schema version", v, "expected", psql.LatestVersion(), "info", "run try5d migrate")
+		}
+		return rs
+	case "memory", "mem":
+		logger.Warn("Connected to store backend", "driver", "memory", "info", "data will be lost on exit")
+		return mem.NewMemStore()
+	}
+	logger.Fatal("Unknown store driver", "driver", driver)
+	return nil
+}
+
+// setupAdmins se asegura de que existe el rol admin y lo asigna a los accounts
+// indicados en la configuración
+func setupAdmins() {
+	admin, err := apiCtx.DB.GetRoleBySlug(rbac.AdminRole)
+	if err != nil || admin == nil {
+		admin, err = apiCtx.DB.SaveRole(rbac.NewRole(rbac.AdminRole, "Administrator", rbac.Any))
+		if err != nil {
+			logger.Fatal("Cannot create admin role", "error", err)
+		}
+		logger.Info("RBAC", "role created", rbac.AdminRole)
+	}
+	for _, email := range strings.Split(config.GetString("AdminAccounts"), ",") {
+		if email = strings.TrimSpace(email); email == "" {
+			continue
+		}
+		acc, err := apiCtx.DB.GetAccountByEmail(email)
+		if err != nil || acc == nil {
+			logger.Warn("RBAC", "admin account not found", email)
+			continue
+		}
+		if err := apiCtx.DB.AssignRole(*acc.UID, *admin.ID); err != nil {
+			logger.Error("RBAC", "error", err, "email", email)
+			continue
+		}
+		logger.Info("RBAC", "admin", email)
+	}
+}
+
+// sessionOptions lee de la configuración la duración de las sesiones
+func sessionOptions() session.Options {
+	var opts session.Options
+	if age, err := config.GetInt("SessionMaxAge"); err == nil {
+		opts.MaxAge = time.Duration(age) * time.Second
+	}
+	if idle, err := config.GetInt("SessionIdleTimeout"); err == nil {
+		opts.IdleTimeout = time.Duration(idle) * time.Second
+	}
+	return opts.WithDefaults()
+}
+
+// passwordHasher crea el hasher de passwords indicado en PasswordHash
+func passwordHasher() *hasher.Manager {
+	param := func(name string) int {
+		if n, err := config.GetInt(name); err == nil {
+			return int(n)
+		}
+		return 0
+	}
+	switch alg := config.GetString("PasswordHash"); alg {
+	case "", "argon2id":
+		return hasher.New(hasher.NewArgon2id(uint32(param("PasswordArgon2Memory")), uint32(param("PasswordArgon2Time")), uint8(param("PasswordArgon2Threads"))))
+	case "scrypt":
+		return hasher.New(hasher.NewScrypt(uint8(param("PasswordScryptLogN")), 0, 0))
+	case "bcrypt":
+		return hasher.New(hasher.NewBcrypt(param("PasswordBcryptCost")))
+	default:
+		logger.Fatal("Unknown password hash algorithm", "algorithm", alg)
+	}
+	return nil
+}
+
+// policyConfig lee de la configuración la política de passwords
+func policyConfig() account.PolicyConfig {
+	c := account.PolicyConfig{BannedFile: config.GetString("PasswordBannedFile")}
+	for name, v := range map[string]*int{
+		"PasswordMinLength":  &c.MinLength,
+		"PasswordMaxLength":  &c.MaxLength,
+		"PasswordMinClasses": &c.MinClasses,
+		"PasswordHistory":    &c.History,
+	} {
+		if n, err := config.GetInt(name); err == nil {
+			*v = int(n)
+		}
+	}
+	return c
+}
+
+// lockoutPolicy lee de la configuración los límites de intentos fallidos de autenticación
+func lockoutPolicy() lockout.Policy {
+	var p lockout.Policy
+	if n, err := config.GetInt("LockoutMaxFailures"); err == nil {
+		p.MaxFailures = int(n)
+	}
+	if n, err := config.GetInt("LockoutIPMaxFailures"); err == nil {
+		p.IPMaxFailures = int(n)
+	}
+	if n, err := config.GetInt("LockoutDuration"); err == nil {
+		p.Lockout = time.Duration(n) * time.Second
+	}
+	if n, err := config.GetInt("LockoutWindow"); err == nil {
+		p.Window = time.Duration(n) * time.Second
+	}
+	return p.WithDefaults()
+}
+
+// setupMailer crea el Mailer indicado en MailDriver
+func setupMailer() mailer.Mailer {
+	from := config.GetString("MailFrom")
+	if from == "" {
+		from = "try5@localhost"
+	}
+	switch driver := config.GetString("MailDriver"); driver {
+	case "":
+		// los enlaces de los emails dan acceso a los accounts, no se escriben en el log
+		logger.Warn("Mailer", "driver", "none", "info", "emails are not sent, set TRY5_MAIL_DRIVER")
+		return &mailer.Log{Logger: logger}
+	case "log":
+		logger.Warn("Mailer", "driver", "log", "info", "emails are logged with their links, only for development")
+		return &mailer.Log{Logger: logger, Body: true}
+	case "file":
+		dir := config.GetString("MailDir")
+		if dir == "" {
+			dir = "mail"
+		}
+		logger.Info("Mailer", "driver", "file", "dir", dir)
+		return &mailer.File{Dir: dir, From: from}
+	case "smtp":
+		addr := config.GetString("SMTPAddr")
+		if addr == "" {
+			logger.Fatal("Mailer", "error", "missing SMTP server address")
+		}
+		logger.Info("Mailer", "driver", "smtp", "server", addr, "from", from)
+		return &mailer.SMTP{Addr: addr, From: from, Username: config.GetString("SMTPUser"), Password: config.GetString("SMTPPass")}
+	default:
+		logger.Fatal("Unknown mail driver", "driver", driver)
+	}
+	return nil
+}
+
+// mfaSealer crea a partir de MFAKey el cifrador de los secretos TOTP
+func mfaSealer() (*seal.Sealer, error) {
+	k := config.GetString("MFAKey")
+	if k == "" {
+		logger.Warn("Two-factor authentication", "key", "not set", "info", "set TRY5_MFA_KEY to enable TOTP")
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(k)
+	if err != nil {
+		return nil, err
+	}
+	return seal.New(key)
+}
+
+// keySealer crea a partir de APIKeyKey el cifrador de las claves de firma de las api keys
+// y de las claves privadas que firman los ID tokens
+func keySealer() (*seal.Sealer, error) {
+	k := config.GetString("APIKeyKey")
+	if k == "" {
+		logger.Warn("API keys", "key", "not set", "info", "set TRY5_APIKEY_KEY to create api keys")
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(k)
+	if err != nil {
+		return nil, err
+	}
+	return seal.New(key)
+}
+
+// relyingParty crea la configuración de WebAuthn, nil si no se ha indicado el dominio
+func relyingParty() *webauthn.RelyingParty {
+	id := config.GetString("WebAuthnRPID")
+	if id == "" {
+		logger.Warn("WebAuthn", "rp id", "not set", "info", "set TRY5_WEBAUTHN_RP_ID to enable passkeys")
+		return nil
+	}
+	rp := &webauthn.RelyingParty{ID: id, Name: config.GetString("WebAuthnRPName")}
+	if rp.Name == "" {
+		rp.Name = id
+	}
+	for _, o := range strings.Split(config.GetString("WebAuthnOrigins"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			rp.Origins = append(rp.Origins, o)
+		}
+	}
+	if len(rp.Origins) == 0 {
+		rp.Origins = []string{"https://" + id}
+	}
+	logger.Info("WebAuthn", "rp id", rp.ID, "origins", rp.Origins)
+	return rp
+}
+
+// defaultAuthenticators son los authenticators si no se indica Authenticators
+const defaultAuthenticators = "local,apikey,token,session"
+
+// authenticator crea la cadena de authenticators indicada en Authenticators y
+// AuthenticatorMode
+func authenticator(ctx *api.ApiContext) (authn.Authenticator, error) {
+	kinds := config.GetString("Authenticators")
+	if kinds == "" {
+		kinds = defaultAuthenticators
+	}
+	var auths []authn.Authenticator
+	for _, kind := range strings.Split(kinds, ",") {
+		switch kind = strings.TrimSpace(kind); kind {
+		case "local":
+			auths = append(auths, &authn.Local{DB: ctx.DB})
+		case "ldap":
+			dir, err := ldapDirectory()
+			if err != nil {
+				return nil, err
+			}
+			logger.Info("Authenticator", "kind", kind, "url", config.GetString("LDAPURL"))
+			auths = append(auths, api.NewLDAPAuthenticator(ctx, dir))
+		case "apikey":
+			auths = append(auths, &authn.APIKey{DB: ctx.DB, Skew: ctx.SignatureSkew, Sealer: ctx.KeySealer})
+		case "token":
+			auths = append(auths, &authn.Token{DB: ctx.DB, Tokens: ctx.Tokens})
+		case "session":
+			auths = append(auths, &authn.Session{DB: ctx.DB, Options: ctx.Sessions})
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown authenticator %q", kind)
+		}
+	}
+	if len(auths) == 0 {
+		return nil, fmt.Errorf("no authenticators")
+	}
+	mode := config.GetString("AuthenticatorMode")
+	logger.Info("Authenticators", "kinds", kinds, "mode", mode)
+	switch mode {
+	case "", "first":
+		return authn.FirstMatch(auths...), nil
+	case "all":
+		return authn.RequireAll(auths...), nil
+	default:
+		return nil, fmt.Errorf("unknown authenticator mode %q", mode)
+	}
+}
+
+// ldapDirectory crea el directorio LDAP a partir de la configuración
+func ldapDirectory() (*ldap.Directory, error) {
+	c := ldap.Config{
+		URL:            config.GetString("LDAPURL"),
+		UserDN:         config.GetString("LDAPUserDN"),
+		BindDN:         config.GetString("LDAPBindDN"),
+		BindPassword:   config.GetString("LDAPBindPassword"),
+		BaseDN:         config.GetString("LDAPBaseDN"),
+		UserFilter:     config.GetString("LDAPUserFilter"),
+		EmailAttribute: config.GetString("LDAPEmailAttribute"),
+		NameAttribute:  config.GetString("LDAPNameAttribute"),
+		GroupAttribute: config.GetString("LDAPGroupAttribute"),
+		GroupBaseDN:    config.GetString("LDAPGroupBaseDN"),
+		GroupFilter:    config.GetString("LDAPGroupFilter"),
+		GroupRoles:     map[string]string{},
+	}
+	c.StartTLS, _ = config.GetBool("LDAPStartTLS")
+	if f := config.GetString("LDAPCAFile"); f != "" {
+		pem, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", f)
+		}
+		c.TLSConfig = &tls.Config{RootCAs: pool}
+	}
+	// los DN de los grupos contienen ':' en raras ocasiones, el rol es lo que sigue al último
+	for _, m := range strings.Split(config.GetString("LDAPGroupRoles"), ";") {
+		if m = strings.TrimSpace(m); m == "" {
+			continue
+		}
+		i := strings.LastIndex(m, ":")
+		if i < 1 || i == len(m)-1 {
+			return nil, fmt.Errorf("invalid group role %q, it must be groupDN:role", m)
+		}
+		c.GroupRoles[strings.TrimSpace(m[:i])] = strings.TrimSpace(m[i+1:])
+	}
+	return ldap.New(c)
+}
+
+// purgeExpired elimina periódicamente los tickets caducados, ie. los challenges de
+// WebAuthn que no se llegaron a usar, y los códigos y tokens OAuth caducados
+func purgeExpired(s store.Storer, every time.Duration) {
+	purge := func(what string, del func() (int, error)) {
+		n, err := del()
+		if err != nil {
+			logger.Warn(what, "purge error", err)
+			return
+		}
+		if n > 0 {
+			logger.Info(what, "expired deleted", n)
+		}
+	}
+	go func() {
+		for range time.Tick(every) {
+			purge("tickets", s.DeleteExpiredTickets)
+			purge("oauth tokens", s.DeleteExpiredOAuthTokens)
+			purge("signatures", s.DeleteExpiredSignatures)
+		}
+	}()
+}
+
+// tokenOptions lee de la configuración las opciones para emitir los tokens JWT
+func tokenOptions() *token.Options {
+	opts := &token.Options{
+		Method:  config.GetString("TokenMethod"),
+		Secret:  []byte(config.GetString("TokenSecret")),
+		KeyFile: config.GetString("TokenKeyFile"),
+		Issuer:  config.GetString("TokenIssuer"),
+	}
+	if ttl, err := config.GetInt("TokenAccessTTL"); err == nil {
+		opts.AccessTTL = time.Duration(ttl) * time.Second
+	}
+	if ttl, err := config.GetInt("TokenRefreshTTL"); err == nil {
+		opts.RefreshTTL = time.Duration(ttl) * time.Second
+	}
+	return opts
 }
 
 func main() {
 	// Be sure we close the database when exit
 	defer apiCtx.DB.Close()
+	if args := flag.Args(); len(args) > 0 {
+		if !runCommand(args) {
+			apiCtx.DB.Close()
+			os.Exit(2)
+		}
+		return
+	}
 	setupSignals()
+	setupAdmins()
+	purgeExpired(apiCtx.DB, 10*time.Minute)
 	port := config.GetString("Port")
 	if port == "" {
 		logger.Warn("can't get Port value from config", "USING:", 8000)
@@ -122,22 +676,126 @@ func main() {
 	// serve the V1 REST API from /api/v1
 	apisrv := server.NewSubrouter("/api/v1")
 	setupAPIRoutes(apisrv)
+	// the protected routes share the prefix but require valid credentials
+	authsrv := server.NewSubrouter("/api/v1")
+	authsrv.Use(apiCtx.RequireAuth)
+	setupProtectedRoutes(authsrv)
+	// the OAuth 2.0 authorization server
+	oauthsrv := server.NewSubrouter("/oauth")
+	setupOAuthRoutes(oauthsrv)
+	// the OpenID Connect endpoints live at the root of the issuer
+	server.Get("/.well-known/openid-configuration", http.HandlerFunc(apiCtx.OpenIDConfiguration))
+	server.Get("/jwks.json", http.HandlerFunc(apiCtx.JWKS))
+	server.Get("/userinfo", http.HandlerFunc(apiCtx.UserInfo))
+	server.Post("/userinfo", http.HandlerFunc(apiCtx.UserInfo))
 
 	// run the server
 	server.Run()
 }
 
-// setupAPIRoutes añade al router los puntos de acceso a los servicios ofrecidos
+// setupAPIRoutes añade al router los puntos de acceso públicos a los servicios ofrecidos
 func setupAPIRoutes(apisrv *aloja.Subrouter) {
-	// accounts
-	apisrv.Get("/accounts", http.HandlerFunc(apiCtx.GetAllAccounts))
-	apisrv.Get("/accounts/:uid", http.HandlerFunc(apiCtx.GetAccountByID))
-	apisrv.Post("/accounts", http.HandlerFunc(apiCtx.NewAccount))
-	apisrv.Put("/accounts/:uid", http.HandlerFunc(apiCtx.UpdateAccount))
-	apisrv.Delete("/accounts/:uid", http.HandlerFunc(apiCtx.DeleteAccount))
-
 	// authentication
 	apisrv.Post("/authenticate", http.HandlerFunc(apiCtx.Authenticate))
+	apisrv.Post("/authenticate/mfa", http.HandlerFunc(apiCtx.AuthenticateMFA))
+	apisrv.Post("/token/refresh", http.HandlerFunc(apiCtx.RefreshToken))
+	apisrv.Post("/logout", http.HandlerFunc(apiCtx.Logout))
+	apisrv.Post("/password/forgot", http.HandlerFunc(apiCtx.ForgotPassword))
+	apisrv.Post("/password/reset", http.HandlerFunc(apiCtx.ResetPassword))
+	apisrv.Post("/accounts/:uid/verify", http.HandlerFunc(apiCtx.VerifyAccount))
+	apisrv.Post("/webauthn/login/begin", http.HandlerFunc(apiCtx.BeginWebAuthnLogin))
+	apisrv.Post("/webauthn/login/finish", http.HandlerFunc(apiCtx.FinishWebAuthnLogin))
+	apisrv.Post("/webauthn/mfa/begin", http.HandlerFunc(apiCtx.BeginWebAuthnMFA))
+	apisrv.Post("/webauthn/mfa/finish", http.HandlerFunc(apiCtx.FinishWebAuthnMFA))
+	apisrv.Get("/federation", http.HandlerFunc(apiCtx.GetFederationLogins))
+	apisrv.Get("/federation/:provider/login", http.HandlerFunc(apiCtx.FederationLogin))
+	apisrv.Get("/federation/:provider/callback", http.HandlerFunc(apiCtx.FederationCallback))
+}
+
+// setupProtectedRoutes añade al router los puntos de acceso que requieren autenticación
+func setupProtectedRoutes(authsrv *aloja.Subrouter) {
+	// accounts
+	authsrv.Get("/accounts", allow("accounts:read", apiCtx.GetAllAccounts))
+	authsrv.Get("/accounts/:uid", ownerOr("accounts:read", apiCtx.GetAccountByID))
+	authsrv.Post("/accounts", allow("accounts:write", apiCtx.NewAccount))
+	authsrv.Put("/accounts/:uid", ownerOr("accounts:write", apiCtx.UpdateAccount))
+	authsrv.Delete("/accounts/:uid", allow("accounts:write", apiCtx.DeleteAccount))
+
+	// api keys
+	authsrv.Get("/accounts/:uid/keys", http.HandlerFunc(apiCtx.GetAccountKeys))
+	authsrv.Post("/accounts/:uid/keys", http.HandlerFunc(apiCtx.NewAccountKey))
+	authsrv.Delete("/accounts/:uid/keys/:kid", http.HandlerFunc(apiCtx.RevokeAccountKey))
+
+	// sessions
+	authsrv.Get("/accounts/:uid/sessions", http.HandlerFunc(apiCtx.GetAccountSessions))
+	authsrv.Delete("/accounts/:uid/sessions", http.HandlerFunc(apiCtx.RevokeAllAccountSessions))
+	authsrv.Delete("/accounts/:uid/sessions/:sid", http.HandlerFunc(apiCtx.RevokeAccountSession))
+
+	// rbac
+	authsrv.Get("/roles", allow("roles:read", apiCtx.GetAllRoles))
+	authsrv.Get("/roles/:id", allow("roles:read", apiCtx.GetRoleByID))
+	authsrv.Post("/roles", allow("roles:write", apiCtx.NewRole))
+	authsrv.Put("/roles/:id", allow("roles:write", apiCtx.UpdateRole))
+	authsrv.Delete("/roles/:id", allow("roles:write", apiCtx.DeleteRole))
+	authsrv.Get("/roles/:id/grants", allow("roles:read", apiCtx.GetRoleGrants))
+	authsrv.Post("/roles/:id/grants", allow("roles:write", apiCtx.NewRoleGrant))
+	authsrv.Delete("/roles/:id/grants/:gid", allow("roles:write", apiCtx.DeleteRoleGrant))
+	authsrv.Get("/accounts/:uid/roles", allow("roles:read", apiCtx.GetAccountRoles))
+	authsrv.Post("/accounts/:uid/roles", allow("roles:write", apiCtx.AssignAccountRole))
+	authsrv.Delete("/accounts/:uid/roles/:rid", allow("roles:write", apiCtx.UnassignAccountRole))
+
+	// two-factor authentication of the current account
+	authsrv.Post("/mfa/totp", http.HandlerFunc(apiCtx.EnrollTOTP))
+	authsrv.Post("/mfa/totp/confirm", http.HandlerFunc(apiCtx.ConfirmTOTP))
+	authsrv.Post("/mfa/totp/disable", http.HandlerFunc(apiCtx.DisableTOTP))
+	authsrv.Post("/mfa/recovery-codes", http.HandlerFunc(apiCtx.NewRecoveryCodes))
+	authsrv.Delete("/accounts/:uid/mfa", allow("mfa:write", apiCtx.ResetAccountMFA))
+
+	// webauthn credentials of the current account
+	authsrv.Post("/webauthn/register/begin", http.HandlerFunc(apiCtx.BeginWebAuthnRegistration))
+	authsrv.Post("/webauthn/register/finish", http.HandlerFunc(apiCtx.FinishWebAuthnRegistration))
+	authsrv.Get("/webauthn/credentials", http.HandlerFunc(apiCtx.GetWebAuthnCredentials))
+	authsrv.Delete("/webauthn/credentials/:id", http.HandlerFunc(apiCtx.DeleteWebAuthnCredential))
+
+	// identities of the current account at the identity providers
+	authsrv.Get("/federation/:provider/link", http.HandlerFunc(apiCtx.LinkFederationIdentity))
+	authsrv.Get("/federation/identities", http.HandlerFunc(apiCtx.GetFederationIdentities))
+	authsrv.Delete("/federation/identities/:provider/:subject", http.HandlerFunc(apiCtx.DeleteFederationIdentity))
+
+	// failed authentications
+	authsrv.Get("/lockouts", allow("lockouts:read", apiCtx.GetLockouts))
+	authsrv.Delete("/lockouts/:key", allow("lockouts:write", apiCtx.DeleteLockout))
+
+	// oauth clients
+	authsrv.Get("/oauth/clients", allow("oauth:read", apiCtx.GetOAuthClients))
+	authsrv.Get("/oauth/clients/:id", allow("oauth:read", apiCtx.GetOAuthClient))
+	authsrv.Post("/oauth/clients", allow("oauth:write", apiCtx.NewOAuthClient))
+	authsrv.Delete("/oauth/clients/:id", allow("oauth:write", apiCtx.DeleteOAuthClient))
+
+	// identity providers
+	authsrv.Get("/federation/providers", allow("federation:read", apiCtx.GetFederationProviders))
+	authsrv.Get("/federation/providers/:id", allow("federation:read", apiCtx.GetFederationProvider))
+	authsrv.Post("/federation/providers", allow("federation:write", apiCtx.NewFederationProvider))
+	authsrv.Put("/federation/providers/:id", allow("federation:write", apiCtx.UpdateFederationProvider))
+	authsrv.Delete("/federation/providers/:id", allow("federation:write", apiCtx.DeleteFederationProvider))
+}
+
+// setupOAuthRoutes añade al router los puntos de acceso del servidor de autorización OAuth 2.0
+func setupOAuthRoutes(oauthsrv *aloja.Subrouter) {
+	oauthsrv.Get("/authorize", http.HandlerFunc(apiCtx.Authorize))
+	oauthsrv.Post("/token", http.HandlerFunc(apiCtx.OAuthToken))
+	oauthsrv.Post("/revoke", http.HandlerFunc(apiCtx.OAuthRevoke))
+	oauthsrv.Post("/introspect", http.HandlerFunc(apiCtx.OAuthIntrospect))
+}
+
+// allow protege el handler con el permiso indicado
+func allow(perm rbac.Permission, h http.HandlerFunc) http.Handler {
+	return apiCtx.RequirePermission(perm)(h)
+}
+
+// ownerOr deja pasar al propio account y protege con el permiso indicado el resto
+func ownerOr(perm rbac.Permission, h http.HandlerFunc) http.Handler {
+	return apiCtx.RequireOwnerOrPermission(perm)(h)
 }
 
 // setupSignals configura la captura de señales de sistema y actúa basándose en ellas
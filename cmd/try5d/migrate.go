@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jllopis/try5/store/backend/postgres"
+)
+
+// migrate gestiona las migraciones del esquema de PostgreSQL. Devuelve false si los
+// argumentos no son válidos.
+func migrate(args []string) bool {
+	rs, ok := apiCtx.DB.(*psql.PsqlStore)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "migrations are only available with TRY5_STORE_DRIVER=postgres")
+		return false
+	}
+	var err error
+	switch {
+	case len(args) == 0 || len(args) == 1 && args[0] == "up":
+		err = rs.Migrate()
+	case len(args) == 2 && args[0] == "to":
+		v, perr := strconv.Atoi(args[1])
+		if perr != nil {
+			fmt.Fprintln(os.Stderr, "invalid version:", args[1])
+			return false
+		}
+		err = rs.MigrateTo(v)
+	case len(args) == 1 && args[0] == "version":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: migrate %v\n", args)
+		fmt.Fprintln(os.Stderr, usage)
+		return false
+	}
+	if err != nil {
+		logger.Fatal("migrate", "error", err)
+	}
+	v, err := rs.SchemaVersion()
+	if err != nil {
+		logger.Fatal("migrate", "error", err)
+	}
+	logger.Info("migrate", "schema version", v, "latest", psql.LatestVersion())
+	return true
+}
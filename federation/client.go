@@ -0,0 +1,322 @@
+package federation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrUnavailable is returned when the provider can not be reached or answers
+	// with an error to the discovery, keys or token requests
+	ErrUnavailable = errors.New("identity provider unavailable")
+	// ErrRejected is returned when the provider refuses a request, ie. a used code
+	ErrRejected = errors.New("the identity provider rejected the request")
+	// ErrInvalidIDToken is returned for an ID token that does not verify
+	ErrInvalidIDToken = errors.New("invalid id token")
+
+	// MetadataTTL is how long the discovery documents and keys are cached
+	MetadataTTL = time.Hour
+	// ClockSkew is the allowed difference with the clock of the providers
+	ClockSkew = time.Minute
+)
+
+// Metadata are the endpoints of a provider, from its discovery document
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Audience is the aud claim, a single string or a list
+type Audience []string
+
+// UnmarshalJSON accepts both forms of the claim
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+func (a Audience) contains(v string) bool {
+	for _, x := range a {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the claims of the ID tokens of the providers used by try5
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      Audience `json:"aud"`
+	ExpiresAt     int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// Valid implements jwt.Claims, it checks the expiration
+func (c *Claims) Valid() error {
+	if time.Now().Add(-ClockSkew).Unix() >= c.ExpiresAt {
+		return ErrInvalidIDToken
+	}
+	return nil
+}
+
+type cached struct {
+	metadata *Metadata
+	keys     map[string]interface{}
+	expires  time.Time
+}
+
+// RelyingParty talks to the providers as an OpenID Connect client. The discovery
+// documents and keys are cached by issuer.
+type RelyingParty struct {
+	HTTP  *http.Client
+	mu    sync.Mutex
+	cache map[string]*cached
+}
+
+// NewRelyingParty returns a RelyingParty that makes its requests with a timeout
+func NewRelyingParty(timeout time.Duration) *RelyingParty {
+	return &RelyingParty{HTTP: &http.Client{Timeout: timeout}, cache: map[string]*cached{}}
+}
+
+// AuthURL returns the url of the provider the user is sent to
+func (rp *RelyingParty) AuthURL(p *Provider, redirectURI, state, nonce, challenge string) (string, error) {
+	m, err := rp.metadata(p.Issuer)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", ErrUnavailable
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", p.Scope)
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems the code at the token endpoint of the provider and returns the
+// claims of the verified ID token
+func (rp *RelyingParty) Exchange(p *Provider, code, redirectURI, verifier, nonce string) (*Claims, error) {
+	m, err := rp.metadata(p.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest("POST", m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, ErrUnavailable
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	var res struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := rp.do(req, &res); err != nil {
+		return nil, err
+	}
+	if res.IDToken == "" {
+		return nil, ErrInvalidIDToken
+	}
+	return rp.Verify(p, res.IDToken, nonce)
+}
+
+// Verify checks the signature, issuer, audience, expiration and nonce of an ID token
+func (rp *RelyingParty) Verify(p *Provider, raw, nonce string) (*Claims, error) {
+	c := &Claims{}
+	parser := &jwt.Parser{ValidMethods: []string{"RS256", "ES256"}}
+	_, err := parser.ParseWithClaims(raw, c, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return rp.key(p.Issuer, kid)
+	})
+	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Inner == ErrUnavailable {
+			return nil, ErrUnavailable
+		}
+		return nil, ErrInvalidIDToken
+	}
+	if c.Issuer != p.Issuer || !c.Audience.contains(p.ClientID) || c.Subject == "" || c.Nonce != nonce {
+		return nil, ErrInvalidIDToken
+	}
+	if c.IssuedAt > time.Now().Add(ClockSkew).Unix() {
+		return nil, ErrInvalidIDToken
+	}
+	return c, nil
+}
+
+// metadata returns the cached discovery document of the issuer or fetches it
+func (rp *RelyingParty) metadata(issuer string) (*Metadata, error) {
+	c, err := rp.entry(issuer)
+	if err != nil {
+		return nil, err
+	}
+	return c.metadata, nil
+}
+
+func (rp *RelyingParty) entry(issuer string) (*cached, error) {
+	rp.mu.Lock()
+	c := rp.cache[issuer]
+	rp.mu.Unlock()
+	if c != nil && time.Now().Before(c.expires) {
+		return c, nil
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, ErrUnavailable
+	}
+	m := &Metadata{}
+	if err := rp.do(req, m); err != nil {
+		return nil, err
+	}
+	// the document must be the one of the configured issuer (OpenID Connect Discovery section 4.3)
+	if m.Issuer != issuer || m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, ErrUnavailable
+	}
+	keys, err := rp.fetchKeys(m.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	c = &cached{metadata: m, keys: keys, expires: time.Now().Add(MetadataTTL)}
+	rp.mu.Lock()
+	rp.cache[issuer] = c
+	rp.mu.Unlock()
+	return c, nil
+}
+
+// key returns the key kid of the issuer. The keys are fetched again once when the
+// kid is not known, as the provider may have rotated them.
+func (rp *RelyingParty) key(issuer, kid string) (interface{}, error) {
+	c, err := rp.entry(issuer)
+	if err != nil {
+		return nil, err
+	}
+	rp.mu.Lock()
+	k, ok := c.keys[kid]
+	rp.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	keys, err := rp.fetchKeys(c.metadata.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	rp.mu.Lock()
+	c.keys = keys
+	rp.mu.Unlock()
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, ErrInvalidIDToken
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys reads the RSA and P-256 signing keys of a JWK set by kid
+func (rp *RelyingParty) fetchKeys(uri string) (map[string]interface{}, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, ErrUnavailable
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := rp.do(req, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, e := decodeInt(k.N), decodeInt(k.E)
+			if n == nil || e == nil || !e.IsInt64() {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, y := decodeInt(k.X), decodeInt(k.Y)
+			if x == nil || y == nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+func decodeInt(s string) *big.Int {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(b)
+}
+
+// do sends the request and decodes the JSON response into v
+func (rp *RelyingParty) do(req *http.Request, v interface{}) error {
+	res, err := rp.HTTP.Do(req)
+	if err != nil {
+		return ErrUnavailable
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, res.Body)
+		if res.StatusCode >= 400 && res.StatusCode < 500 {
+			return ErrRejected
+		}
+		return ErrUnavailable
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v); err != nil {
+		return ErrUnavailable
+	}
+	return nil
+}
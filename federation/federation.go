@@ -0,0 +1,123 @@
+// Package federation implements the login with upstream OpenID Connect providers:
+// the providers configured by the administrators, the identities of the providers
+// linked to the accounts and the relying party side of the authorization code flow.
+package federation
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultScope is asked to the providers without a scope
+const DefaultScope = "openid email profile"
+
+var (
+	ErrInvalidProviderID = errors.New("invalid provider id")
+	ErrInvalidIssuer     = errors.New("invalid issuer")
+	ErrInvalidClientID   = errors.New("invalid client id")
+	ErrInvalidScope      = errors.New("the scope must include openid")
+
+	// reserved are the ids that clash with the routes under /federation
+	reserved = map[string]bool{"providers": true, "identities": true}
+	idRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+)
+
+// Provider is an upstream OpenID Connect provider. ID is the slug of the provider
+// in the urls. With AllowSignup the unknown users get an account on their first
+// login; with LinkByEmail a user is linked to the account that has the verified
+// email given by the provider.
+type Provider struct {
+	ID           string    `json:"id" db:"id"`
+	Name         string    `json:"name" db:"name"`
+	Issuer       string    `json:"issuer" db:"issuer"`
+	ClientID     string    `json:"client_id" db:"client_id"`
+	ClientSecret string    `json:"-" db:"client_secret"`
+	Scope        string    `json:"scope" db:"scope"`
+	AllowSignup  bool      `json:"allow_signup" db:"allow_signup"`
+	LinkByEmail  bool      `json:"link_by_email" db:"link_by_email"`
+	Created      time.Time `json:"created" db:"created"`
+}
+
+// Check validates the provider and fills the defaults. The issuer must be https,
+// except on the loopback addresses.
+func (p *Provider) Check() error {
+	if !idRegexp.MatchString(p.ID) || reserved[p.ID] {
+		return ErrInvalidProviderID
+	}
+	if p.Name = strings.TrimSpace(p.Name); p.Name == "" {
+		p.Name = p.ID
+	}
+	u, err := url.Parse(p.Issuer)
+	if err != nil || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return ErrInvalidIssuer
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && loopback(u.Hostname())) {
+		return ErrInvalidIssuer
+	}
+	if p.ClientID == "" {
+		return ErrInvalidClientID
+	}
+	if strings.TrimSpace(p.Scope) == "" {
+		p.Scope = DefaultScope
+	}
+	p.Scope = strings.Join(strings.Fields(p.Scope), " ")
+	if !hasScope(p.Scope, "openid") {
+		return ErrInvalidScope
+	}
+	if p.Created.IsZero() {
+		p.Created = time.Now().UTC()
+	}
+	return nil
+}
+
+func loopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func hasScope(scope, s string) bool {
+	for _, x := range strings.Fields(scope) {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity links the subject of a provider to an account
+type Identity struct {
+	ProviderID string    `json:"provider" db:"provider_id"`
+	Subject    string    `json:"subject" db:"subject"`
+	AccountUID string    `json:"account_uid" db:"account_uid"`
+	Email      string    `json:"email,omitempty" db:"email"`
+	Created    time.Time `json:"created" db:"created"`
+	LastLogin  time.Time `json:"last_login" db:"last_login"`
+}
+
+// NewPKCE returns a random code verifier and its S256 code challenge
+func NewPKCE() (verifier, challenge string, err error) {
+	if verifier, err = Random(); err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// Random returns 32 random bytes base64url encoded, ie. for the state and nonce
+func Random() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,127 @@
+package federation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jllopis/try5/oidc"
+)
+
+// fakeIssuer is an OpenID Connect provider that issues an ID token for sub to any code
+type fakeIssuer struct {
+	*httptest.Server
+	keys  *oidc.KeySet
+	sub   string
+	aud   string
+	nonce string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	oidc.KeyBits = 1024
+	k, err := oidc.NewKey()
+	if err != nil {
+		t.Fatal("Error generating key: ", err)
+	}
+	f := &fakeIssuer{sub: "248289761001", aud: "try5"}
+	if f.keys, err = oidc.NewKeySet(k); err != nil {
+		t.Fatal("Error creating key set: ", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&Metadata{Issuer: f.URL, AuthorizationEndpoint: f.URL + "/authorize", TokenEndpoint: f.URL + "/token", JWKSURI: f.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(f.keys.JWKS())
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "try5" || secret != "s3cr3t" || r.PostFormValue("code") != "good" || r.PostFormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		tok, err := f.keys.Sign(&oidc.Claims{
+			Nonce:          f.nonce,
+			Email:          "jdoe@example.com",
+			Name:           "Jane Doe",
+			StandardClaims: jwt.StandardClaims{Issuer: f.URL, Subject: f.sub, Audience: f.aud, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()},
+		})
+		if err != nil {
+			t.Fatal("Error signing id token: ", err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "x", "token_type": "Bearer", "id_token": tok})
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func TestProviderCheck(t *testing.T) {
+	p := &Provider{ID: "corp", Issuer: "https://login.example.com", ClientID: "try5"}
+	if err := p.Check(); err != nil || p.Scope != DefaultScope || p.Name != "corp" {
+		t.Fatalf("Error checking provider: %v %+v", err, p)
+	}
+	for _, bad := range []*Provider{
+		{ID: "Corp", Issuer: "https://login.example.com", ClientID: "try5"},
+		{ID: "identities", Issuer: "https://login.example.com", ClientID: "try5"},
+		{ID: "corp", Issuer: "http://login.example.com", ClientID: "try5"},
+		{ID: "corp", Issuer: "https://login.example.com"},
+		{ID: "corp", Issuer: "https://login.example.com", ClientID: "try5", Scope: "email"},
+	} {
+		if err := bad.Check(); err == nil {
+			t.Fatalf("Invalid provider accepted: %+v", bad)
+		}
+	}
+	if err := (&Provider{ID: "dev", Issuer: "http://127.0.0.1:5556", ClientID: "try5"}).Check(); err != nil {
+		t.Fatal("Loopback issuer rejected: ", err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+	p := &Provider{ID: "fake", Issuer: f.URL, ClientID: "try5", ClientSecret: "s3cr3t"}
+	if err := p.Check(); err != nil {
+		t.Fatal("Error checking provider: ", err)
+	}
+	rp := NewRelyingParty(5 * time.Second)
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal("Error creating PKCE: ", err)
+	}
+	f.nonce = "n-0S6_WzA2Mj"
+	auth, err := rp.AuthURL(p, "https://try5.local/cb", "state", f.nonce, challenge)
+	if err != nil {
+		t.Fatal("Error building auth url: ", err)
+	}
+	u, _ := url.Parse(auth)
+	if q := u.Query(); u.Path != "/authorize" || q.Get("client_id") != "try5" || q.Get("nonce") != f.nonce || q.Get("code_challenge") != challenge {
+		t.Fatal("Wrong auth url: ", auth)
+	}
+
+	c, err := rp.Exchange(p, "good", "https://try5.local/cb", verifier, f.nonce)
+	if err != nil || c.Subject != f.sub || c.Email != "jdoe@example.com" || c.Name != "Jane Doe" {
+		t.Fatalf("Error exchanging code: %v %+v", err, c)
+	}
+	if _, err := rp.Exchange(p, "good", "https://try5.local/cb", verifier, "other"); err != ErrInvalidIDToken {
+		t.Fatal("Token with another nonce accepted: ", err)
+	}
+	if _, err := rp.Exchange(p, "used", "https://try5.local/cb", verifier, f.nonce); err != ErrRejected {
+		t.Fatal("Rejected code not reported: ", err)
+	}
+	f.aud = "other-client"
+	if _, err := rp.Exchange(p, "good", "https://try5.local/cb", verifier, f.nonce); err != ErrInvalidIDToken {
+		t.Fatal("Token for another client accepted: ", err)
+	}
+	f.aud = "try5"
+	// the keys rotated by the provider are fetched again
+	if _, err := f.keys.Rotate(); err != nil {
+		t.Fatal("Error rotating keys: ", err)
+	}
+	if _, err := rp.Exchange(p, "good", "https://try5.local/cb", verifier, f.nonce); err != nil {
+		t.Fatal("Token signed with a rotated key rejected: ", err)
+	}
+}
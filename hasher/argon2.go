@@ -0,0 +1,94 @@
+package hasher
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2ID = "argon2id"
+
+// Argon2id hashes with argon2id, encoded as
+// $argon2id$v=19$m=<memory KiB>,t=<time>,p=<threads>$<salt>$<hash>
+type Argon2id struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+// NewArgon2id returns an argon2id hasher. The zero values take the parameters
+// recommended by OWASP: 19 MiB of memory, 2 iterations and 1 thread.
+func NewArgon2id(memory, time uint32, threads uint8) *Argon2id {
+	h := &Argon2id{Memory: memory, Time: time, Threads: threads}
+	if h.Memory == 0 {
+		h.Memory = 19 * 1024
+	}
+	if h.Time == 0 {
+		h.Time = 2
+	}
+	if h.Threads == 0 {
+		h.Threads = 1
+	}
+	return h
+}
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+func (h *Argon2id) ID() string {
+	return argon2ID
+}
+
+func (h *Argon2id) Hash(password []byte) (string, error) {
+	s, err := salt(argon2SaltLen)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(password, s, h.Time, h.Memory, h.Threads, argon2KeyLen)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2ID, argon2.Version, h.Memory, h.Time, h.Threads, b64.EncodeToString(s), b64.EncodeToString(key)), nil
+}
+
+func (h *Argon2id) Verify(encoded string, password []byte) error {
+	p, s, key, err := decodeArgon2(encoded)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey(password, s, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (h *Argon2id) Outdated(encoded string) bool {
+	p, _, _, err := decodeArgon2(encoded)
+	return err != nil || *p != *h
+}
+
+func decodeArgon2(encoded string) (*Argon2id, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != argon2ID {
+		return nil, nil, nil, ErrMalformedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrMalformedHash
+	}
+	p := &Argon2id{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return nil, nil, nil, ErrMalformedHash
+	}
+	s, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrMalformedHash
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrMalformedHash
+	}
+	return p, s, key, nil
+}
@@ -0,0 +1,45 @@
+package hasher
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+const bcryptID = "bcrypt"
+
+// Bcrypt hashes with bcrypt. The hashes keep the usual $2a$<cost>$ encoding.
+type Bcrypt struct {
+	Cost int
+}
+
+// NewBcrypt returns a bcrypt hasher, a zero cost means bcrypt.DefaultCost
+func NewBcrypt(cost int) *Bcrypt {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return &Bcrypt{Cost: cost}
+}
+
+func (h *Bcrypt) ID() string {
+	return bcryptID
+}
+
+func (h *Bcrypt) Hash(password []byte) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(password, h.Cost)
+	return string(hash), err
+}
+
+func (h *Bcrypt) Verify(encoded string, password []byte) error {
+	switch err := bcrypt.CompareHashAndPassword([]byte(encoded), password); err {
+	case nil:
+		return nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return ErrMismatch
+	default:
+		return ErrMalformedHash
+	}
+}
+
+func (h *Bcrypt) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
@@ -0,0 +1,99 @@
+// Package hasher hashes and verifies passwords. The hashes are encoded in the PHC
+// string format, $<id>$<params>$<salt>$<hash>, so they carry the algorithm and its
+// parameters and can be upgraded when they get outdated.
+package hasher
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrMismatch      = errors.New("hashedPassword is not the hash of the given password")
+	ErrUnknownHash   = errors.New("unknown password hash algorithm")
+	ErrMalformedHash = errors.New("malformed password hash")
+)
+
+// Hasher is a password hashing algorithm with a set of parameters
+type Hasher interface {
+	// ID is the algorithm identifier used in the encoded hashes
+	ID() string
+	// Hash returns the encoded hash of the password with a random salt
+	Hash(password []byte) (string, error)
+	// Verify returns ErrMismatch if encoded is not the hash of password
+	Verify(encoded string, password []byte) error
+	// Outdated tells if encoded was made with other parameters than the hasher ones
+	Outdated(encoded string) bool
+}
+
+// Manager hashes the new passwords with the preferred hasher and verifies the
+// hashes made by any of the known ones.
+type Manager struct {
+	preferred Hasher
+	known     map[string]Hasher
+}
+
+// New returns a Manager that hashes with preferred and can also verify the hashes
+// of the others. The default bcrypt, scrypt and argon2id hashers are always known.
+func New(preferred Hasher, others ...Hasher) *Manager {
+	m := &Manager{preferred: preferred, known: make(map[string]Hasher)}
+	for _, h := range append([]Hasher{NewBcrypt(0), NewScrypt(0, 0, 0), NewArgon2id(0, 0, 0)}, others...) {
+		m.known[h.ID()] = h
+	}
+	m.known[preferred.ID()] = preferred
+	return m
+}
+
+// Default hashes with argon2id and the recommended parameters
+func Default() *Manager {
+	return New(NewArgon2id(0, 0, 0))
+}
+
+// Preferred returns the hasher used for the new passwords
+func (m *Manager) Preferred() Hasher {
+	return m.preferred
+}
+
+// Hash hashes the password with the preferred hasher
+func (m *Manager) Hash(password []byte) (string, error) {
+	return m.preferred.Hash(password)
+}
+
+// Verify checks the password against a hash made by any known hasher
+func (m *Manager) Verify(encoded string, password []byte) error {
+	h, ok := m.known[Identify(encoded)]
+	if !ok {
+		return ErrUnknownHash
+	}
+	return h.Verify(encoded, password)
+}
+
+// NeedsRehash tells if encoded must be replaced by a new hash because it was made
+// by another algorithm or with other parameters than the preferred hasher.
+func (m *Manager) NeedsRehash(encoded string) bool {
+	return Identify(encoded) != m.preferred.ID() || m.preferred.Outdated(encoded)
+}
+
+// Identify returns the algorithm of an encoded hash. The bcrypt hashes use their
+// own $2a$ style prefixes and are identified as bcrypt.
+func Identify(encoded string) string {
+	if strings.HasPrefix(encoded, "$2") {
+		return bcryptID
+	}
+	parts := strings.SplitN(encoded, "$", 3)
+	if len(parts) < 3 || parts[0] != "" {
+		return ""
+	}
+	return parts[1]
+}
+
+// b64 is the base64 variant of the PHC format: standard alphabet without padding
+var b64 = base64.RawStdEncoding
+
+func salt(n int) ([]byte, error) {
+	s := make([]byte, n)
+	_, err := rand.Read(s)
+	return s, err
+}
@@ -0,0 +1,52 @@
+package hasher
+
+import (
+	"testing"
+)
+
+func TestHashers(t *testing.T) {
+	password := []byte("SuperDifficultPass")
+	for _, h := range []Hasher{NewBcrypt(4), NewScrypt(10, 0, 0), NewArgon2id(1024, 1, 1)} {
+		encoded, err := h.Hash(password)
+		if err != nil {
+			t.Fatal("Error hashing with ", h.ID(), ": ", err)
+		}
+		if Identify(encoded) != h.ID() {
+			t.Fatalf("%s hash %q identified as %q", h.ID(), encoded, Identify(encoded))
+		}
+		if err := h.Verify(encoded, password); err != nil {
+			t.Fatal("Error verifying ", h.ID(), " hash: ", err)
+		}
+		if err := h.Verify(encoded, []byte("WrongPass")); err != ErrMismatch {
+			t.Fatal("Wrong password verified with ", h.ID(), ": ", err)
+		}
+		if h.Outdated(encoded) {
+			t.Fatal("Fresh ", h.ID(), " hash outdated")
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	password := []byte("SuperDifficultPass")
+	old := New(NewBcrypt(4))
+	encoded, err := old.Hash(password)
+	if err != nil {
+		t.Fatal("Error hashing: ", err)
+	}
+	if old.NeedsRehash(encoded) {
+		t.Fatal("Hash with the current parameters needs rehash")
+	}
+	if !New(NewBcrypt(5)).NeedsRehash(encoded) {
+		t.Fatal("Hash with a lower cost does not need rehash")
+	}
+	m := New(NewArgon2id(1024, 1, 1))
+	if !m.NeedsRehash(encoded) {
+		t.Fatal("bcrypt hash does not need rehash to argon2id")
+	}
+	if err := m.Verify(encoded, password); err != nil {
+		t.Fatal("Error verifying bcrypt hash: ", err)
+	}
+	if err := m.Verify("$md5$abc", password); err != ErrUnknownHash {
+		t.Fatal("Expected ErrUnknownHash, got: ", err)
+	}
+}
@@ -0,0 +1,95 @@
+package hasher
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const scryptID = "scrypt"
+
+// Scrypt hashes with scrypt, encoded as
+// $scrypt$ln=<log2 N>,r=<block size>,p=<parallelism>$<salt>$<hash>
+type Scrypt struct {
+	LogN uint8
+	R    int
+	P    int
+}
+
+// NewScrypt returns an scrypt hasher. The zero values take N=2^15, r=8 and p=1.
+func NewScrypt(logN uint8, r, p int) *Scrypt {
+	h := &Scrypt{LogN: logN, R: r, P: p}
+	if h.LogN == 0 {
+		h.LogN = 15
+	}
+	if h.R == 0 {
+		h.R = 8
+	}
+	if h.P == 0 {
+		h.P = 1
+	}
+	return h
+}
+
+const (
+	scryptSaltLen = 16
+	scryptKeyLen  = 32
+)
+
+func (h *Scrypt) ID() string {
+	return scryptID
+}
+
+func (h *Scrypt) Hash(password []byte) (string, error) {
+	s, err := salt(scryptSaltLen)
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key(password, s, 1<<h.LogN, h.R, h.P, scryptKeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s", scryptID, h.LogN, h.R, h.P, b64.EncodeToString(s), b64.EncodeToString(key)), nil
+}
+
+func (h *Scrypt) Verify(encoded string, password []byte) error {
+	p, s, key, err := decodeScrypt(encoded)
+	if err != nil {
+		return err
+	}
+	other, err := scrypt.Key(password, s, 1<<p.LogN, p.R, p.P, len(key))
+	if err != nil {
+		return ErrMalformedHash
+	}
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (h *Scrypt) Outdated(encoded string) bool {
+	p, _, _, err := decodeScrypt(encoded)
+	return err != nil || *p != *h
+}
+
+func decodeScrypt(encoded string) (*Scrypt, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != scryptID {
+		return nil, nil, nil, ErrMalformedHash
+	}
+	p := &Scrypt{}
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &p.LogN, &p.R, &p.P); err != nil || p.LogN > 30 {
+		return nil, nil, nil, ErrMalformedHash
+	}
+	s, err := b64.DecodeString(parts[3])
+	if err != nil {
+		return nil, nil, nil, ErrMalformedHash
+	}
+	key, err := b64.DecodeString(parts[4])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrMalformedHash
+	}
+	return p, s, key, nil
+}
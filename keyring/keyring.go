@@ -0,0 +1,183 @@
+package keyring
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/gorilla/securecookie"
+)
+
+// Key is a securecookie key pair. HashKey authenticates the cookie and BlockKey encrypts it.
+type Key struct {
+	ID       string    `json:"id" db:"id"`
+	HashKey  []byte    `json:"-" db:"hash_key"`
+	BlockKey []byte    `json:"-" db:"block_key"`
+	Created  time.Time `json:"created" db:"created"`
+}
+
+// Loader gets the keys from a persistent storage, newest first
+type Loader interface {
+	LoadCookieKeys() ([]*Key, error)
+}
+
+// Saver persists the keys, newest first
+type Saver interface {
+	SaveCookieKeys(keys []*Key) error
+}
+
+// Initializer persists the first keys of a storage, only if it holds none. The
+// replicas sharing the storage that start at the same time save one set at most.
+type Initializer interface {
+	InitCookieKeys(keys []*Key) error
+}
+
+// Ring is a list of cookie keys. The newest key is used to encode new cookies
+// while all of them are tried to decode, so rotating the keys does not invalidate
+// the cookies already issued. Ring implements securecookie.Codec.
+type Ring struct {
+	mu     sync.RWMutex
+	keys   []*Key
+	codecs []securecookie.Codec
+}
+
+var (
+	ErrEmptyRing  = errors.New("empty key ring")
+	ErrInvalidKey = errors.New("invalid cookie key")
+
+	// MaxKeys is the number of keys kept in the ring after a rotation
+	MaxKeys = 3
+)
+
+// NewKey generates a random key pair
+func NewKey() *Key {
+	return &Key{
+		ID:       uuid.New(),
+		HashKey:  securecookie.GenerateRandomKey(64),
+		BlockKey: securecookie.GenerateRandomKey(32),
+		Created:  time.Now().UTC(),
+	}
+}
+
+// New returns a ring holding keys, newest first
+func New(keys ...*Key) *Ring {
+	r := &Ring{}
+	r.set(keys)
+	return r
+}
+
+// FromConfig builds the ring from comma separated lists of base64 encoded hash and
+// block keys, newest first. Both lists must have the same length.
+func FromConfig(hashKeys, blockKeys string) (*Ring, error) {
+	hs := strings.Split(hashKeys, ",")
+	bs := strings.Split(blockKeys, ",")
+	if len(hs) != len(bs) {
+		return nil, ErrInvalidKey
+	}
+	var keys []*Key
+	for i := range hs {
+		h, err := base64.StdEncoding.DecodeString(strings.TrimSpace(hs[i]))
+		if err != nil || len(h) == 0 {
+			return nil, ErrInvalidKey
+		}
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(bs[i]))
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		switch len(b) {
+		case 16, 24, 32:
+		default:
+			return nil, ErrInvalidKey
+		}
+		keys = append(keys, &Key{ID: uuid.New(), HashKey: h, BlockKey: b})
+	}
+	return New(keys...), nil
+}
+
+// Load reads the ring from l. If there are no keys stored, a new one is generated
+// and saved with i, then the keys are read again so every replica sharing the store
+// uses the ones that were saved first.
+func Load(l Loader, i Initializer) (*Ring, error) {
+	keys, err := l.LoadCookieKeys()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		if err := i.InitCookieKeys([]*Key{NewKey()}); err != nil {
+			return nil, err
+		}
+		if keys, err = l.LoadCookieKeys(); err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			return nil, ErrEmptyRing
+		}
+	}
+	return New(keys...), nil
+}
+
+// Reload replaces the keys in the ring with the ones in l
+func (r *Ring) Reload(l Loader) error {
+	keys, err := l.LoadCookieKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return ErrEmptyRing
+	}
+	r.set(keys)
+	return nil
+}
+
+// Rotate adds a new key at the front of the ring and drops the oldest ones
+// beyond MaxKeys. It returns the new key.
+func (r *Ring) Rotate() *Key {
+	k := NewKey()
+	keys := append([]*Key{k}, r.Keys()...)
+	if len(keys) > MaxKeys {
+		keys = keys[:MaxKeys]
+	}
+	r.set(keys)
+	return k
+}
+
+// Keys returns the keys in the ring, newest first
+func (r *Ring) Keys() []*Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Key{}, r.keys...)
+}
+
+// Encode encodes the value using the newest key
+func (r *Ring) Encode(name string, value interface{}) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.codecs) == 0 {
+		return "", ErrEmptyRing
+	}
+	return r.codecs[0].Encode(name, value)
+}
+
+// Decode tries every key in the ring to decode the value
+func (r *Ring) Decode(name, value string, dst interface{}) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.codecs) == 0 {
+		return ErrEmptyRing
+	}
+	return securecookie.DecodeMulti(name, value, dst, r.codecs...)
+}
+
+func (r *Ring) set(keys []*Key) {
+	codecs := make([]securecookie.Codec, len(keys))
+	for i, k := range keys {
+		codecs[i] = securecookie.New(k.HashKey, k.BlockKey)
+	}
+	r.mu.Lock()
+	r.keys = keys
+	r.codecs = codecs
+	r.mu.Unlock()
+}
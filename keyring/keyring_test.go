@@ -0,0 +1,95 @@
+package keyring
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestRotate(t *testing.T) {
+	r := New(NewKey())
+	old, err := r.Encode("try5_session", "session-id")
+	if err != nil {
+		t.Fatal("Error encoding cookie: ", err)
+	}
+	for i := 0; i < MaxKeys+1; i++ {
+		r.Rotate()
+		if i == 0 {
+			var v string
+			if err := r.Decode("try5_session", old, &v); err != nil || v != "session-id" {
+				t.Fatal("Cookie encoded with the previous key must still decode: ", err)
+			}
+		}
+	}
+	if len(r.Keys()) != MaxKeys {
+		t.Fatalf("Ring should keep %d keys. Got %d", MaxKeys, len(r.Keys()))
+	}
+	var v string
+	if err := r.Decode("try5_session", old, &v); err == nil {
+		t.Fatal("Cookie encoded with a dropped key must not decode")
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	k1, k2 := NewKey(), NewKey()
+	enc := base64.StdEncoding.EncodeToString
+	r, err := FromConfig(enc(k1.HashKey)+","+enc(k2.HashKey), enc(k1.BlockKey)+","+enc(k2.BlockKey))
+	if err != nil {
+		t.Fatal("Error reading keys from config: ", err)
+	}
+	c, err := New(k2).Encode("c", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if err := r.Decode("c", c, &v); err != nil || v != 42 {
+		t.Fatal("Cookie encoded with the old configured key must decode: ", err)
+	}
+	if _, err := FromConfig(enc(k1.HashKey), enc([]byte("short"))); err != ErrInvalidKey {
+		t.Fatal("Invalid block key accepted")
+	}
+}
+
+// keyStore keeps the keys like a store. other, if set, is saved by InitCookieKeys
+// before the keys given, as if another replica started at the same time.
+type keyStore struct {
+	keys  []*Key
+	other []*Key
+}
+
+func (s *keyStore) LoadCookieKeys() ([]*Key, error) { return s.keys, nil }
+
+func (s *keyStore) InitCookieKeys(keys []*Key) error {
+	if s.other != nil {
+		s.keys = s.other
+	}
+	if len(s.keys) == 0 {
+		s.keys = keys
+	}
+	return nil
+}
+
+func TestLoad(t *testing.T) {
+	s := &keyStore{}
+	r, err := Load(s, s)
+	if err != nil || len(s.keys) != 1 || r.Keys()[0].ID != s.keys[0].ID {
+		t.Fatal("Error saving the first key: ", err)
+	}
+	if again, err := Load(s, s); err != nil || len(again.Keys()) != 1 || again.Keys()[0].ID != s.keys[0].ID {
+		t.Fatal("Stored key not loaded: ", err)
+	}
+
+	// another replica saved its keys first, they are the ones used
+	other := NewKey()
+	s = &keyStore{other: []*Key{other}}
+	if r, err = Load(s, s); err != nil || len(r.Keys()) != 1 || r.Keys()[0].ID != other.ID {
+		t.Fatal("Keys of the other replica not used: ", err)
+	}
+	c, err := New(other).Encode("c", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if err := r.Decode("c", c, &v); err != nil || v != 42 {
+		t.Fatal("Cookie encoded by the other replica must decode: ", err)
+	}
+}
@@ -0,0 +1,202 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// the subset of the Basic Encoding Rules (X.690) used by the LDAP messages
+
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+
+	tagBoolean     = 1
+	tagInteger     = 2
+	tagOctetString = 4
+	tagEnumerated  = 10
+	tagSequence    = 16
+	tagSet         = 17
+
+	// maxPacket limits the size of the messages read, the responses to the requests
+	// of try5 are small
+	maxPacket = 1 << 20
+)
+
+var errMalformed = errors.New("malformed ber packet")
+
+// packet is a BER element, primitive with its value or constructed with its children
+type packet struct {
+	class       byte
+	constructed bool
+	tag         int
+	value       []byte
+	children    []*packet
+}
+
+func newSequence(children ...*packet) *packet {
+	return &packet{class: classUniversal, constructed: true, tag: tagSequence, children: children}
+}
+
+func newString(s string) *packet {
+	return &packet{class: classUniversal, tag: tagOctetString, value: []byte(s)}
+}
+
+func newInteger(tag int, v int64) *packet {
+	return &packet{class: classUniversal, tag: tag, value: encodeInt(v)}
+}
+
+func newBoolean(v bool) *packet {
+	b := byte(0)
+	if v {
+		b = 0xff
+	}
+	return &packet{class: classUniversal, tag: tagBoolean, value: []byte{b}}
+}
+
+// encodeInt returns the shortest two's complement form of v
+func encodeInt(v int64) []byte {
+	n := 1
+	for x := v; x > 127 || x < -128; x >>= 8 {
+		n++
+	}
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return b
+}
+
+func decodeInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errMalformed
+	}
+	var v int64
+	if b[0]&0x80 != 0 {
+		v = -1
+	}
+	for _, x := range b {
+		v = v<<8 | int64(x)
+	}
+	return v, nil
+}
+
+func (p *packet) str() string {
+	return string(p.value)
+}
+
+func (p *packet) int() (int64, error) {
+	return decodeInt(p.value)
+}
+
+// child returns the child i or nil
+func (p *packet) child(i int) *packet {
+	if i < len(p.children) {
+		return p.children[i]
+	}
+	return nil
+}
+
+// bytes encodes the packet
+func (p *packet) bytes() []byte {
+	content := p.value
+	if p.constructed {
+		content = nil
+		for _, c := range p.children {
+			content = append(content, c.bytes()...)
+		}
+	}
+	id := p.class | byte(p.tag)
+	if p.constructed {
+		id |= 0x20
+	}
+	out := []byte{id}
+	if l := len(content); l < 0x80 {
+		out = append(out, byte(l))
+	} else {
+		var lb []byte
+		for ; l > 0; l >>= 8 {
+			lb = append([]byte{byte(l)}, lb...)
+		}
+		out = append(out, 0x80|byte(len(lb)))
+		out = append(out, lb...)
+	}
+	return append(out, content...)
+}
+
+// readPacket reads an element, only the tags below 31 are supported
+func readPacket(r *bufio.Reader) (*packet, error) {
+	id, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if id&0x1f == 0x1f {
+		return nil, errMalformed
+	}
+	l, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := int(l)
+	if l&0x80 != 0 {
+		n := int(l & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, errMalformed
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxPacket {
+		return nil, errMalformed
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return parsePacket(id, content)
+}
+
+func parsePacket(id byte, content []byte) (*packet, error) {
+	p := &packet{class: id & 0xc0, constructed: id&0x20 != 0, tag: int(id & 0x1f)}
+	if !p.constructed {
+		p.value = content
+		return p, nil
+	}
+	for len(content) > 0 {
+		if len(content) < 2 || content[0]&0x1f == 0x1f {
+			return nil, errMalformed
+		}
+		cid, l := content[0], int(content[1])
+		rest := content[2:]
+		if l&0x80 != 0 {
+			n := l & 0x7f
+			if n == 0 || n > 4 || len(rest) < n {
+				return nil, errMalformed
+			}
+			l = 0
+			for _, b := range rest[:n] {
+				l = l<<8 | int(b)
+			}
+			rest = rest[n:]
+		}
+		if l > len(rest) {
+			return nil, errMalformed
+		}
+		c, err := parsePacket(cid, rest[:l])
+		if err != nil {
+			return nil, err
+		}
+		p.children = append(p.children, c)
+		content = rest[l:]
+	}
+	return p, nil
+}
@@ -0,0 +1,251 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultUserFilter     = "(uid=%s)"
+	DefaultEmailAttribute = "mail"
+	DefaultNameAttribute  = "cn"
+)
+
+// Config is the directory and how its users are found. With UserDN the DN of the
+// users is built from the login, ie. "uid=%s,ou=people,dc=example,dc=com". Without
+// it the users are searched under BaseDN with UserFilter, ie. "(uid=%s)" or
+// "(sAMAccountName=%s)", binding first with BindDN when set.
+//
+// The groups of a user are the values of GroupAttribute (ie. memberOf) and, with
+// GroupFilter, the entries found under GroupBaseDN (BaseDN if empty) by the filter
+// with the DN of the user, ie. "(member=%s)". GroupRoles maps the DN of the groups
+// to the slugs of the try5 roles.
+type Config struct {
+	URL       string
+	StartTLS  bool
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+
+	UserDN       string
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	UserFilter   string
+
+	EmailAttribute string
+	NameAttribute  string
+	GroupAttribute string
+	GroupBaseDN    string
+	GroupFilter    string
+	GroupRoles     map[string]string
+}
+
+// User is an authenticated user of the directory
+type User struct {
+	DN     string
+	Login  string
+	Email  string
+	Name   string
+	Groups []string
+}
+
+// Directory authenticates the users of the directory of its Config
+type Directory struct {
+	config Config
+	roles  map[string]string
+}
+
+// New returns a Directory for the config, filling its defaults
+func New(config Config) (*Directory, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || u.Host == "" || u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, ErrInvalidURL
+	}
+	if config.UserDN == "" && config.BaseDN == "" {
+		return nil, fmt.Errorf("ldap: the user dn template or the base dn is required")
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = DefaultUserFilter
+	}
+	for _, f := range []string{config.UserFilter, config.GroupFilter} {
+		if f == "" {
+			continue
+		}
+		if _, err := compileFilter(fmt.Sprintf(f, "x")); err != nil {
+			return nil, fmt.Errorf("ldap: invalid filter %q", f)
+		}
+	}
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = DefaultEmailAttribute
+	}
+	if config.NameAttribute == "" {
+		config.NameAttribute = DefaultNameAttribute
+	}
+	if config.GroupBaseDN == "" {
+		config.GroupBaseDN = config.BaseDN
+	}
+	d := &Directory{config: config, roles: map[string]string{}}
+	for dn, role := range config.GroupRoles {
+		d.roles[NormalizeDN(dn)] = role
+	}
+	return d, nil
+}
+
+// NormalizeDN returns the form of a DN used to compare them: in lower case and
+// without spaces around the separators
+func NormalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, p := range parts {
+		kv := strings.SplitN(p, "=", 2)
+		for j := range kv {
+			kv[j] = strings.TrimSpace(kv[j])
+		}
+		parts[i] = strings.Join(kv, "=")
+	}
+	return strings.ToLower(strings.Join(parts, ","))
+}
+
+func (d *Directory) dial() (*Conn, error) {
+	c, err := Dial(d.config.URL, d.config.TLSConfig, d.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if d.config.StartTLS {
+		u, _ := url.Parse(d.config.URL)
+		if err := c.StartTLS(d.config.TLSConfig, u.Hostname()); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (d *Directory) attributes() []string {
+	attrs := []string{d.config.EmailAttribute, d.config.NameAttribute}
+	if d.config.GroupAttribute != "" {
+		attrs = append(attrs, d.config.GroupAttribute)
+	}
+	return attrs
+}
+
+// bindService binds with the service account, its wrong credentials are not the ones of the user
+func (d *Directory) bindService(c *Conn) error {
+	if err := c.Bind(d.config.BindDN, d.config.BindPassword); err != nil {
+		if err == ErrInvalidCredentials {
+			return ErrServiceBind
+		}
+		return err
+	}
+	return nil
+}
+
+// Authenticate finds the user with the login and binds with the password. It
+// returns ErrUserNotFound and ErrInvalidCredentials for the logins that do not
+// authenticate.
+func (d *Directory) Authenticate(login, password string) (*User, error) {
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	c, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	var entry *Entry
+	if d.config.UserDN != "" {
+		dn := fmt.Sprintf(d.config.UserDN, EscapeDN(login))
+		if err := c.Bind(dn, password); err != nil {
+			return nil, err
+		}
+		entries, err := c.Search(&SearchRequest{BaseDN: dn, Scope: ScopeBase, Filter: "(objectClass=*)", Attributes: d.attributes()})
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 1 {
+			return nil, ErrUserNotFound
+		}
+		entry = entries[0]
+	} else {
+		if d.config.BindDN != "" {
+			if err := d.bindService(c); err != nil {
+				return nil, err
+			}
+		}
+		// two entries are enough to tell an ambiguous login
+		entries, err := c.Search(&SearchRequest{
+			BaseDN:     d.config.BaseDN,
+			Scope:      ScopeSub,
+			Filter:     fmt.Sprintf(d.config.UserFilter, EscapeFilter(login)),
+			Attributes: d.attributes(),
+			SizeLimit:  2,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 1 {
+			return nil, ErrUserNotFound
+		}
+		entry = entries[0]
+		if err := c.Bind(entry.DN, password); err != nil {
+			return nil, err
+		}
+	}
+	u := &User{DN: entry.DN, Login: login, Email: entry.Get(d.config.EmailAttribute), Name: entry.Get(d.config.NameAttribute)}
+	if d.config.GroupAttribute != "" {
+		u.Groups = append(u.Groups, entry.Values(d.config.GroupAttribute)...)
+	}
+	if d.config.GroupFilter != "" {
+		// the groups are read with the service account when there is one
+		if d.config.BindDN != "" {
+			if err := d.bindService(c); err != nil {
+				return nil, err
+			}
+		}
+		groups, err := c.Search(&SearchRequest{
+			BaseDN:     d.config.GroupBaseDN,
+			Scope:      ScopeSub,
+			Filter:     fmt.Sprintf(d.config.GroupFilter, EscapeFilter(entry.DN)),
+			Attributes: []string{"1.1"}, // no attributes
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			u.Groups = append(u.Groups, g.DN)
+		}
+	}
+	return u, nil
+}
+
+// Roles returns the slugs of the roles mapped to the groups of the user
+func (d *Directory) Roles(u *User) []string {
+	seen := map[string]bool{}
+	var roles []string
+	for _, g := range u.Groups {
+		if r, ok := d.roles[NormalizeDN(g)]; ok && !seen[r] {
+			seen[r] = true
+			roles = append(roles, r)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// MappedRoles returns the slugs of all the roles mapped to a group, the roles
+// managed by the directory
+func (d *Directory) MappedRoles() []string {
+	seen := map[string]bool{}
+	var roles []string
+	for _, r := range d.roles {
+		if !seen[r] {
+			seen[r] = true
+			roles = append(roles, r)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
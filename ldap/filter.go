@@ -0,0 +1,190 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidFilter is returned for a search filter that is not valid
+var ErrInvalidFilter = errors.New("invalid ldap filter")
+
+const (
+	filterAnd = iota
+	filterOr
+	filterNot
+	filterEquality
+	filterSubstrings
+	filterGreaterOrEqual
+	filterLessOrEqual
+	filterPresent
+	filterApprox
+)
+
+// EscapeFilter escapes a value to put it in a search filter (RFC 4515 section 3)
+func EscapeFilter(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '*', '(', ')', '\\', 0:
+			b.WriteString("\\" + hex.EncodeToString([]byte{c}))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// EscapeDN escapes a value to put it in a distinguished name (RFC 4514 section 2.4)
+func EscapeDN(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == ',' || c == '+' || c == '"' || c == '\\' || c == '<' || c == '>' || c == ';' || c == '=':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString("\\00")
+		case (c == ' ' || c == '#') && i == 0, c == ' ' && i == len(v)-1:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter encodes the string form of a filter (RFC 4515)
+func compileFilter(f string) (*packet, error) {
+	p, rest, err := parseFilter(strings.TrimSpace(f))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, ErrInvalidFilter
+	}
+	return p, nil
+}
+
+func parseFilter(f string) (*packet, string, error) {
+	if len(f) < 3 || f[0] != '(' {
+		return nil, "", ErrInvalidFilter
+	}
+	f = f[1:]
+	switch f[0] {
+	case '&', '|':
+		tag := filterAnd
+		if f[0] == '|' {
+			tag = filterOr
+		}
+		p := &packet{class: classContext, constructed: true, tag: tag}
+		f = f[1:]
+		for len(f) > 0 && f[0] == '(' {
+			c, rest, err := parseFilter(f)
+			if err != nil {
+				return nil, "", err
+			}
+			p.children = append(p.children, c)
+			f = rest
+		}
+		if len(p.children) == 0 || len(f) == 0 || f[0] != ')' {
+			return nil, "", ErrInvalidFilter
+		}
+		return p, f[1:], nil
+	case '!':
+		c, rest, err := parseFilter(f[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", ErrInvalidFilter
+		}
+		return &packet{class: classContext, constructed: true, tag: filterNot, children: []*packet{c}}, rest[1:], nil
+	}
+	end := strings.IndexByte(f, ')')
+	if end < 0 {
+		return nil, "", ErrInvalidFilter
+	}
+	p, err := parseItem(f[:end])
+	if err != nil {
+		return nil, "", err
+	}
+	return p, f[end+1:], nil
+}
+
+// parseItem parses a simple filter, attribute operator value
+func parseItem(item string) (*packet, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, ErrInvalidFilter
+	}
+	attr, value := item[:eq], item[eq+1:]
+	tag := filterEquality
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = filterGreaterOrEqual, attr[:len(attr)-1]
+	case '<':
+		tag, attr = filterLessOrEqual, attr[:len(attr)-1]
+	case '~':
+		tag, attr = filterApprox, attr[:len(attr)-1]
+	}
+	if attr == "" || strings.ContainsAny(attr, "()*\\ ") {
+		return nil, ErrInvalidFilter
+	}
+	if tag == filterEquality && value == "*" {
+		return &packet{class: classContext, tag: filterPresent, value: []byte(attr)}, nil
+	}
+	if tag == filterEquality && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		subs := newSequence()
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			v, err := unescapeFilter(part)
+			if err != nil {
+				return nil, err
+			}
+			t := 1 // any
+			switch i {
+			case 0:
+				t = 0 // initial
+			case len(parts) - 1:
+				t = 2 // final
+			}
+			subs.children = append(subs.children, &packet{class: classContext, tag: t, value: []byte(v)})
+		}
+		return &packet{class: classContext, constructed: true, tag: filterSubstrings, children: []*packet{newString(attr), subs}}, nil
+	}
+	v, err := unescapeFilter(value)
+	if err != nil {
+		return nil, err
+	}
+	return &packet{class: classContext, constructed: true, tag: tag, children: []*packet{newString(attr), newString(v)}}, nil
+}
+
+// unescapeFilter decodes the \XX escapes of a filter value
+func unescapeFilter(v string) (string, error) {
+	if strings.ContainsAny(v, "()") {
+		return "", ErrInvalidFilter
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			b.WriteByte(v[i])
+			continue
+		}
+		if i+2 >= len(v) {
+			return "", ErrInvalidFilter
+		}
+		c, err := hex.DecodeString(v[i+1 : i+3])
+		if err != nil {
+			return "", ErrInvalidFilter
+		}
+		b.Write(c)
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,327 @@
+// Package ldap verifies the passwords of the users of an LDAP directory, ie. OpenLDAP
+// or Active Directory, with a simple bind. It reads the email, name and groups of
+// the users so they can be mapped to try5 accounts and roles. Only the operations
+// needed for that are implemented: bind, search and StartTLS.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ScopeBase = 0
+	ScopeOne  = 1
+	ScopeSub  = 2
+
+	// the result codes of RFC 4511 appendix A checked by try5
+	ResultSuccess            = 0
+	ResultSizeLimitExceeded  = 4
+	ResultInvalidCredentials = 49
+
+	appBindRequest       = 0
+	appBindResponse      = 1
+	appUnbindRequest     = 2
+	appSearchRequest     = 3
+	appSearchResultEntry = 4
+	appSearchResultDone  = 5
+	appExtendedRequest   = 23
+	appExtendedResponse  = 24
+
+	startTLSOID    = "1.3.6.1.4.1.1466.20037"
+	defaultTimeout = 10 * time.Second
+)
+
+var (
+	// ErrInvalidCredentials is returned for a wrong password or an empty one, which
+	// would be an unauthenticated bind
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUserNotFound is returned when no entry, or more than one, matches the login
+	ErrUserNotFound = errors.New("user not found in the directory")
+	// ErrServiceBind is returned when the directory refuses the BindDN and BindPassword
+	ErrServiceBind = errors.New("the directory refused the service account")
+	// ErrUnavailable is returned when the directory can not be reached
+	ErrUnavailable = errors.New("directory unavailable")
+	// ErrInvalidURL is returned for an URL that is not ldap:// or ldaps://
+	ErrInvalidURL = errors.New("invalid ldap url")
+)
+
+// Error is an operation that the directory answered with a result code other than success
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ldap result code %d: %s", e.Code, e.Message)
+}
+
+// Entry is an entry found by a search. The attribute names are kept in lower case
+// as they are case insensitive.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Values returns the values of the attribute
+func (e *Entry) Values(name string) []string {
+	return e.Attributes[strings.ToLower(name)]
+}
+
+// Get returns the first value of the attribute or an empty string
+func (e *Entry) Get(name string) string {
+	if v := e.Values(name); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Conn is a connection to a directory. The requests are sent one at a time.
+type Conn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	mu      sync.Mutex
+	msgID   int64
+}
+
+// Dial connects to an ldap:// or ldaps:// url. The tls config is used with ldaps
+// and StartTLS, nil takes the defaults for the host.
+func Dial(rawurl string, config *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	host := u.Host
+	d := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = d.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(d, "tcp", host, tlsConfig(config, u.Hostname()))
+	default:
+		return nil, ErrInvalidURL
+	}
+	if err != nil {
+		return nil, ErrUnavailable
+	}
+	return &Conn{conn: conn, r: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+func tlsConfig(config *tls.Config, host string) *tls.Config {
+	if config == nil {
+		return &tls.Config{ServerName: host}
+	}
+	c := config.Clone()
+	if c.ServerName == "" {
+		c.ServerName = host
+	}
+	return c
+}
+
+// Close sends an unbind request and closes the connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgID++
+	msg := newSequence(newInteger(tagInteger, c.msgID), &packet{class: classApplication, tag: appUnbindRequest})
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	c.conn.Write(msg.bytes())
+	return c.conn.Close()
+}
+
+// roundTrip sends op and returns the responses up to the one with a tag in done
+func (c *Conn) roundTrip(op *packet, done int) ([]*packet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgID++
+	id := c.msgID
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(newSequence(newInteger(tagInteger, id), op).bytes()); err != nil {
+		return nil, ErrUnavailable
+	}
+	var res []*packet
+	for {
+		msg, err := readPacket(c.r)
+		if err != nil {
+			if err == errMalformed {
+				return nil, err
+			}
+			return nil, ErrUnavailable
+		}
+		mid, err := msg.child(0).intOrErr()
+		op := msg.child(1)
+		if err != nil || op == nil || op.class != classApplication {
+			return nil, errMalformed
+		}
+		// the unsolicited notifications (message id 0) end the connection
+		if mid == 0 {
+			return nil, ErrUnavailable
+		}
+		if mid != id {
+			continue
+		}
+		res = append(res, op)
+		if op.tag == done {
+			return res, nil
+		}
+	}
+}
+
+func (p *packet) intOrErr() (int64, error) {
+	if p == nil {
+		return 0, errMalformed
+	}
+	return p.int()
+}
+
+// result checks the LDAPResult of a response
+func result(op *packet) error {
+	code, err := op.child(0).intOrErr()
+	if err != nil {
+		return errMalformed
+	}
+	if code == ResultSuccess {
+		return nil
+	}
+	msg := ""
+	if m := op.child(2); m != nil {
+		msg = m.str()
+	}
+	return &Error{Code: int(code), Message: msg}
+}
+
+// Bind authenticates the connection with a simple bind. An empty password is
+// refused, the directories take it as an anonymous bind.
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+	op := &packet{class: classApplication, constructed: true, tag: appBindRequest, children: []*packet{
+		newInteger(tagInteger, 3),
+		newString(dn),
+		{class: classContext, tag: 0, value: []byte(password)},
+	}}
+	res, err := c.roundTrip(op, appBindResponse)
+	if err != nil {
+		return err
+	}
+	if err := result(res[len(res)-1]); err != nil {
+		if e, ok := err.(*Error); ok && e.Code == ResultInvalidCredentials {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+	return nil
+}
+
+// StartTLS upgrades the connection to TLS (RFC 4511 section 4.14)
+func (c *Conn) StartTLS(config *tls.Config, host string) error {
+	op := &packet{class: classApplication, constructed: true, tag: appExtendedRequest, children: []*packet{
+		{class: classContext, tag: 0, value: []byte(startTLSOID)},
+	}}
+	res, err := c.roundTrip(op, appExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := result(res[len(res)-1]); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tc := tls.Client(c.conn, tlsConfig(config, host))
+	tc.SetDeadline(time.Now().Add(c.timeout))
+	if err := tc.Handshake(); err != nil {
+		return ErrUnavailable
+	}
+	c.conn, c.r = tc, bufio.NewReader(tc)
+	return nil
+}
+
+// SearchRequest is a search, Filter in its string form and SizeLimit 0 for no limit
+type SearchRequest struct {
+	BaseDN     string
+	Scope      int
+	Filter     string
+	Attributes []string
+	SizeLimit  int
+}
+
+// Search returns the entries found, the references to other servers are ignored
+func (c *Conn) Search(req *SearchRequest) ([]*Entry, error) {
+	filter, err := compileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	attrs := newSequence()
+	for _, a := range req.Attributes {
+		attrs.children = append(attrs.children, newString(a))
+	}
+	op := &packet{class: classApplication, constructed: true, tag: appSearchRequest, children: []*packet{
+		newString(req.BaseDN),
+		newInteger(tagEnumerated, int64(req.Scope)),
+		newInteger(tagEnumerated, 0), // never dereference aliases
+		newInteger(tagInteger, int64(req.SizeLimit)),
+		newInteger(tagInteger, int64(c.timeout/time.Second)),
+		newBoolean(false),
+		filter,
+		attrs,
+	}}
+	res, err := c.roundTrip(op, appSearchResultDone)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	for _, r := range res {
+		if r.tag != appSearchResultEntry {
+			continue
+		}
+		e, err := parseEntry(r)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := result(res[len(res)-1]); err != nil {
+		// the entries found before the limit are returned
+		if e, ok := err.(*Error); ok && e.Code == ResultSizeLimitExceeded {
+			return entries, nil
+		}
+		return nil, err
+	}
+	return entries, nil
+}
+
+func parseEntry(p *packet) (*Entry, error) {
+	if len(p.children) < 2 {
+		return nil, errMalformed
+	}
+	e := &Entry{DN: p.children[0].str(), Attributes: map[string][]string{}}
+	for _, a := range p.children[1].children {
+		if len(a.children) < 2 {
+			return nil, errMalformed
+		}
+		name := strings.ToLower(a.children[0].str())
+		for _, v := range a.children[1].children {
+			e.Attributes[name] = append(e.Attributes[name], v.str())
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,262 @@
+package ldap
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeServer is an in-process directory that answers binds and searches
+type fakeServer struct {
+	ln        net.Listener
+	entries   []*Entry
+	passwords map[string]string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Error listening: ", err)
+	}
+	s := &fakeServer{ln: ln, passwords: map[string]string{
+		"cn=admin,dc=example,dc=com":           "adminpw",
+		"uid=jdoe,ou=people,dc=example,dc=com": "secret",
+		"uid=rroe,ou=people,dc=example,dc=com": "other",
+	}}
+	s.entries = []*Entry{
+		{DN: "uid=jdoe,ou=people,dc=example,dc=com", Attributes: map[string][]string{
+			"objectclass": {"person"}, "uid": {"jdoe"}, "mail": {"jdoe@example.com"}, "cn": {"Jane Doe"},
+			"memberof": {"CN=Admins, OU=Groups, DC=example, DC=com"},
+		}},
+		{DN: "uid=rroe,ou=people,dc=example,dc=com", Attributes: map[string][]string{
+			"objectclass": {"person"}, "uid": {"rroe"}, "mail": {"rroe@example.com"}, "cn": {"Richard Roe"},
+		}},
+		{DN: "cn=devs,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+			"objectclass": {"groupOfNames"}, "member": {"uid=jdoe,ou=people,dc=example,dc=com", "uid=rroe,ou=people,dc=example,dc=com"},
+		}},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) URL() string {
+	return "ldap://" + s.ln.Addr().String()
+}
+
+func ldapResult(tag int, code int64) *packet {
+	return &packet{class: classApplication, constructed: true, tag: tag, children: []*packet{
+		newInteger(tagEnumerated, code), newString(""), newString(""),
+	}}
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	bound := false
+	for {
+		msg, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		id, op := msg.child(0), msg.child(1)
+		reply := func(p *packet) {
+			conn.Write(newSequence(id, p).bytes())
+		}
+		switch op.tag {
+		case appBindRequest:
+			dn, pw := op.child(1).str(), op.child(2).str()
+			if pw != "" && s.passwords[dn] == pw {
+				bound = true
+				reply(ldapResult(appBindResponse, ResultSuccess))
+			} else {
+				bound = false
+				reply(ldapResult(appBindResponse, ResultInvalidCredentials))
+			}
+		case appSearchRequest:
+			if !bound {
+				reply(ldapResult(appSearchResultDone, 50)) // insufficientAccessRights
+				continue
+			}
+			baseDN := op.child(0).str()
+			scope, _ := op.child(1).int()
+			limit, _ := op.child(3).int()
+			n := int64(0)
+			code := int64(ResultSuccess)
+			for _, e := range s.entries {
+				if scope == ScopeBase && e.DN != baseDN || scope != ScopeBase && !strings.HasSuffix(e.DN, ","+baseDN) {
+					continue
+				}
+				if !match(op.child(6), e) {
+					continue
+				}
+				if limit > 0 && n == limit {
+					code = ResultSizeLimitExceeded
+					break
+				}
+				n++
+				attrs := newSequence()
+				for _, a := range op.child(7).children {
+					vals := &packet{class: classUniversal, constructed: true, tag: tagSet}
+					for _, v := range e.Values(a.str()) {
+						vals.children = append(vals.children, newString(v))
+					}
+					if len(vals.children) > 0 {
+						attrs.children = append(attrs.children, newSequence(newString(a.str()), vals))
+					}
+				}
+				reply(&packet{class: classApplication, constructed: true, tag: appSearchResultEntry, children: []*packet{newString(e.DN), attrs}})
+			}
+			reply(ldapResult(appSearchResultDone, code))
+		case appUnbindRequest:
+			return
+		default:
+			reply(ldapResult(appExtendedResponse, 2)) // protocolError
+		}
+	}
+}
+
+// match evaluates the filters used by the tests
+func match(f *packet, e *Entry) bool {
+	switch f.tag {
+	case filterAnd:
+		for _, c := range f.children {
+			if !match(c, e) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, c := range f.children {
+			if match(c, e) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return !match(f.child(0), e)
+	case filterPresent:
+		return len(e.Values(f.str())) > 0
+	case filterEquality:
+		for _, v := range e.Values(f.child(0).str()) {
+			if strings.EqualFold(v, f.child(1).str()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestFilter(t *testing.T) {
+	for _, f := range []string{
+		"(uid=jdoe)",
+		"(objectClass=*)",
+		"(&(objectClass=person)(|(uid=jdoe)(mail=j*@example.*))(!(cn=x)))",
+		"(uidNumber>=1000)",
+		"(cn=\\2a\\28x\\29)",
+	} {
+		if _, err := compileFilter(f); err != nil {
+			t.Fatal("Valid filter rejected: ", f, err)
+		}
+	}
+	for _, f := range []string{"", "uid=jdoe", "(uid=jdoe", "(=jdoe)", "(&)", "(uid=jdoe))", "(cn=\\zz)", "(cn=\\2)"} {
+		if _, err := compileFilter(f); err != ErrInvalidFilter {
+			t.Fatal("Invalid filter accepted: ", f)
+		}
+	}
+	if e := EscapeFilter("*)(uid=*"); e != "\\2a\\29\\28uid=\\2a" {
+		t.Fatal("Wrong filter escaping: ", e)
+	}
+	if e := EscapeDN(" a,b+c"); e != "\\ a\\,b\\+c" {
+		t.Fatal("Wrong dn escaping: ", e)
+	}
+	// the packets survive an encoding round trip
+	p, _ := compileFilter("(&(uid=jdoe)(cn=*))")
+	q, err := parsePacket(p.bytes()[0], p.bytes()[2:])
+	if err != nil || !reflect.DeepEqual(p.bytes(), q.bytes()) {
+		t.Fatal("Error decoding a filter: ", err)
+	}
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, -1, -129, 1 << 40} {
+		if d, err := decodeInt(encodeInt(v)); err != nil || d != v {
+			t.Fatal("Error encoding integer: ", v, d, err)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.ln.Close()
+	d, err := New(Config{
+		URL:            s.URL(),
+		BindDN:         "cn=admin,dc=example,dc=com",
+		BindPassword:   "adminpw",
+		BaseDN:         "dc=example,dc=com",
+		GroupAttribute: "memberOf",
+		GroupFilter:    "(member=%s)",
+		GroupRoles: map[string]string{
+			"cn=admins,ou=groups,dc=example,dc=com": "admin",
+			"cn=devs,ou=groups,dc=example,dc=com":   "developer",
+			"cn=ops,ou=groups,dc=example,dc=com":    "developer",
+		},
+	})
+	if err != nil {
+		t.Fatal("Error creating directory: ", err)
+	}
+	u, err := d.Authenticate("jdoe", "secret")
+	if err != nil || u.Email != "jdoe@example.com" || u.Name != "Jane Doe" || len(u.Groups) != 2 {
+		t.Fatalf("Error authenticating: %v %+v", err, u)
+	}
+	if roles := d.Roles(u); !reflect.DeepEqual(roles, []string{"admin", "developer"}) {
+		t.Fatal("Wrong roles: ", roles)
+	}
+	if roles := d.MappedRoles(); !reflect.DeepEqual(roles, []string{"admin", "developer"}) {
+		t.Fatal("Wrong mapped roles: ", roles)
+	}
+	if _, err := d.Authenticate("jdoe", "wrong"); err != ErrInvalidCredentials {
+		t.Fatal("Wrong password accepted: ", err)
+	}
+	if _, err := d.Authenticate("jdoe", ""); err != ErrInvalidCredentials {
+		t.Fatal("Empty password accepted: ", err)
+	}
+	if _, err := d.Authenticate("nobody", "secret"); err != ErrUserNotFound {
+		t.Fatal("Unknown user found: ", err)
+	}
+	// the login can not change the filter
+	if _, err := d.Authenticate("*", "secret"); err != ErrUserNotFound {
+		t.Fatal("Wildcard login found a user: ", err)
+	}
+
+	d, _ = New(Config{URL: s.URL(), BindDN: "cn=admin,dc=example,dc=com", BindPassword: "wrong", BaseDN: "dc=example,dc=com"})
+	if _, err := d.Authenticate("jdoe", "secret"); err != ErrServiceBind {
+		t.Fatal("Refused service account not reported: ", err)
+	}
+
+	// the users are bound with their dn, without a service account
+	d, err = New(Config{URL: s.URL(), UserDN: "uid=%s,ou=people,dc=example,dc=com"})
+	if err != nil {
+		t.Fatal("Error creating directory: ", err)
+	}
+	if u, err := d.Authenticate("rroe", "other"); err != nil || u.DN != "uid=rroe,ou=people,dc=example,dc=com" || u.Email != "rroe@example.com" {
+		t.Fatalf("Error authenticating with the dn template: %v %+v", err, u)
+	}
+	if _, err := d.Authenticate("rroe", "secret"); err != ErrInvalidCredentials {
+		t.Fatal("Wrong password accepted: ", err)
+	}
+
+	if _, err := New(Config{URL: "http://ldap.example.com", BaseDN: "dc=example,dc=com"}); err != ErrInvalidURL {
+		t.Fatal("Invalid url accepted: ", err)
+	}
+	d, _ = New(Config{URL: "ldap://127.0.0.1:1", BaseDN: "dc=example,dc=com"})
+	if _, err := d.Authenticate("jdoe", "secret"); err != ErrUnavailable {
+		t.Fatal("Unreachable directory not reported: ", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Package lockout counts the failed authentications per account and per client
+// address and decides when to slow down or lock out further attempts.
+package lockout
+
+import (
+	"math"
+	"time"
+
+	"github.com/jllopis/try5/account"
+)
+
+// Attempts are the recent failed authentications for a key, see AccountKey and IPKey
+type Attempts struct {
+	Key         string     `json:"key" db:"key"`
+	Failures    int        `json:"failures" db:"failures"`
+	LastFailure time.Time  `json:"last_failure" db:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+}
+
+// AccountKey is the key of the attempts against an account. It uses the email so
+// the unknown emails are counted, and locked, as the existing ones.
+func AccountKey(email string) string {
+	return "account:" + account.NormalizeEmail(email)
+}
+
+// IPKey is the key of the attempts from a client address
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Policy sets the limits of the failed attempts
+type Policy struct {
+	// MaxFailures per account and IPMaxFailures per address lock out the key
+	MaxFailures   int
+	IPMaxFailures int
+	// Window is the time after the last failure when the count is forgotten
+	Window time.Duration
+	// Lockout is how long a key stays locked out
+	Lockout time.Duration
+	// BaseDelay is the delay after the first failure, it doubles with every failure
+	// up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultPolicy locks an account for 15 minutes after 5 failures and an address
+// after 20 failures
+var DefaultPolicy = Policy{
+	MaxFailures:   5,
+	IPMaxFailures: 20,
+	Window:        15 * time.Minute,
+	Lockout:       15 * time.Minute,
+	BaseDelay:     250 * time.Millisecond,
+	MaxDelay:      4 * time.Second,
+}
+
+// WithDefaults returns the policy with the zero values taken from DefaultPolicy
+func (p Policy) WithDefaults() Policy {
+	if p.MaxFailures == 0 {
+		p.MaxFailures = DefaultPolicy.MaxFailures
+	}
+	if p.IPMaxFailures == 0 {
+		p.IPMaxFailures = DefaultPolicy.IPMaxFailures
+	}
+	if p.Window == 0 {
+		p.Window = DefaultPolicy.Window
+	}
+	if p.Lockout == 0 {
+		p.Lockout = DefaultPolicy.Lockout
+	}
+	if p.BaseDelay == 0 {
+		p.BaseDelay = DefaultPolicy.BaseDelay
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = DefaultPolicy.MaxDelay
+	}
+	return p
+}
+
+// Locked returns the time the key stays locked out, 0 if it is not
+func (a *Attempts) Locked(now time.Time) time.Duration {
+	if a == nil || a.LockedUntil == nil || !now.Before(*a.LockedUntil) {
+		return 0
+	}
+	return a.LockedUntil.Sub(now)
+}
+
+// Fail records a failed attempt at now and locks the key out when it reaches max
+// failures. a may be nil for the first failure.
+func (p Policy) Fail(a *Attempts, key string, max int, now time.Time) *Attempts {
+	if a == nil || now.Sub(a.LastFailure) > p.Window || a.LockedUntil != nil && !now.Before(*a.LockedUntil) {
+		// the previous failures or the lockout expired
+		a = &Attempts{Key: key}
+	}
+	a.Failures++
+	a.LastFailure = now
+	if a.Failures >= max {
+		until := now.Add(p.Lockout)
+		a.LockedUntil = &until
+	}
+	return a
+}
+
+// Delay is the time to wait after a failed attempt before the next one, so every
+// failure makes the next guess slower
+func (p Policy) Delay(a *Attempts) time.Duration {
+	if a == nil || a.Failures == 0 {
+		return 0
+	}
+	d := float64(p.BaseDelay) * math.Pow(2, float64(a.Failures-1))
+	if d > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(d)
+}
+
+// Wait returns the time before the key accepts another attempt: the lockout or, if
+// it is not locked, the delay after its last failure. 0 if it accepts one now.
+func (p Policy) Wait(a *Attempts, now time.Time) time.Duration {
+	if d := a.Locked(now); d > 0 {
+		return d
+	}
+	if a == nil {
+		return 0
+	}
+	if d := a.LastFailure.Add(p.Delay(a)).Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
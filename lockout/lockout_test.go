@@ -0,0 +1,60 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFail(t *testing.T) {
+	p := Policy{MaxFailures: 3}.WithDefaults()
+	now := time.Now()
+	var a *Attempts
+	for i := 1; i <= 3; i++ {
+		if a.Locked(now) != 0 {
+			t.Fatal("Locked after ", i-1, " failures")
+		}
+		a = p.Fail(a, "account:test@dom.local", p.MaxFailures, now)
+		if a.Failures != i {
+			t.Fatal("Expected ", i, " failures, got ", a.Failures)
+		}
+	}
+	if a.Locked(now) != p.Lockout {
+		t.Fatal("Not locked after max failures")
+	}
+	if p.Delay(a) != 4*p.BaseDelay {
+		t.Fatal("Unexpected delay: ", p.Delay(a))
+	}
+	if p.Wait(a, now) != p.Lockout {
+		t.Fatal("Wait is not the lockout: ", p.Wait(a, now))
+	}
+
+	later := now.Add(p.Lockout)
+	if a.Locked(later) != 0 {
+		t.Fatal("Still locked after the lockout")
+	}
+	if a = p.Fail(a, a.Key, p.MaxFailures, later); a.Failures != 1 || a.LockedUntil != nil {
+		t.Fatal("Failures not reset after the lockout: ", a.Failures)
+	}
+	if a = p.Fail(a, a.Key, p.MaxFailures, later.Add(p.Window+time.Second)); a.Failures != 1 {
+		t.Fatal("Failures not reset after the window: ", a.Failures)
+	}
+}
+
+func TestWait(t *testing.T) {
+	p := DefaultPolicy
+	now := time.Now()
+	if p.Wait(nil, now) != 0 {
+		t.Fatal("Wait without failures")
+	}
+	a := p.Fail(nil, "ip:127.0.0.1", p.IPMaxFailures, now)
+	a = p.Fail(a, a.Key, p.IPMaxFailures, now)
+	if w := p.Wait(a, now); w != 2*p.BaseDelay {
+		t.Fatal("Unexpected wait after 2 failures: ", w)
+	}
+	if w := p.Wait(a, now.Add(p.BaseDelay)); w != p.BaseDelay {
+		t.Fatal("Wait does not decrease: ", w)
+	}
+	if w := p.Wait(a, now.Add(2*p.BaseDelay)); w != 0 {
+		t.Fatal("Wait after the delay: ", w)
+	}
+}
@@ -0,0 +1,162 @@
+// Package mailer sends the emails of the server, ie. the password reset links,
+// through a pluggable Mailer.
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mgutz/logxi/v1"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(m *Message) error
+}
+
+// Bytes returns the message in RFC 5322 format
+func (m *Message) Bytes(from string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+	return b.Bytes()
+}
+
+// SMTP sends the messages through an SMTP server. STARTTLS is used when the server
+// offers it and the credentials, if any, are sent with PLAIN auth.
+type SMTP struct {
+	// Addr is the host:port of the server
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s *SMTP) Send(m *Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := s.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, m.Bytes(s.From))
+}
+
+// File writes every message to a file in Dir, for development
+type File struct {
+	Dir  string
+	From string
+}
+
+func (f *File) Send(m *Message) error {
+	if err := os.MkdirAll(f.Dir, 0700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.Replace(m.To, "@", "_at_", -1))
+	return ioutil.WriteFile(filepath.Join(f.Dir, filepath.Base(name)), m.Bytes(f.From), 0600)
+}
+
+// Log writes the messages to the log instead of sending them. The bodies carry
+// secrets like the reset links, they are only logged with Body, for development.
+type Log struct {
+	Logger log.Logger
+	Body   bool
+}
+
+func (l *Log) Send(m *Message) error {
+	logger := l.Logger
+	if logger == nil {
+		logger = log.New("mailer")
+	}
+	body := "[redacted]"
+	if l.Body {
+		body = m.Body
+	}
+	logger.Info("mail", "to", m.To, "subject", m.Subject, "body", body)
+	return nil
+}
+
+// Recorder keeps the messages in memory, for the tests
+type Recorder struct {
+	mu       sync.Mutex
+	Messages []*Message
+}
+
+func (r *Recorder) Send(m *Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Messages = append(r.Messages, m)
+	return nil
+}
+
+// Last returns the last message sent, nil if none
+func (r *Recorder) Last() *Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Messages) == 0 {
+		return nil
+	}
+	return r.Messages[len(r.Messages)-1]
+}
+
+// Template builds messages from text templates for the subject and the body
+type Template struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// NewTemplate parses the subject and body templates
+func NewTemplate(name, subject, body string) (*Template, error) {
+	s, err := template.New(name + ".subject").Parse(subject)
+	if err != nil {
+		return nil, err
+	}
+	b, err := template.New(name + ".body").Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{subject: s, body: b}, nil
+}
+
+// MustTemplate is like NewTemplate but panics if the templates can not be parsed
+func MustTemplate(name, subject, body string) *Template {
+	t, err := NewTemplate(name, subject, body)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Message renders the templates with data into a message for to
+func (t *Template) Message(to string, data interface{}) (*Message, error) {
+	var s, b bytes.Buffer
+	if err := t.subject.Execute(&s, data); err != nil {
+		return nil, err
+	}
+	if err := t.body.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	return &Message{To: to, Subject: strings.TrimSpace(s.String()), Body: b.String()}, nil
+}
@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mgutz/logxi/v1"
+)
+
+func TestTemplate(t *testing.T) {
+	tpl := MustTemplate("test", "Hello {{.Name}}", "Follow {{.Link}}\n")
+	m, err := tpl.Message("user@dom.local", map[string]string{"Name": "User", "Link": "https://try5/reset?token=abc"})
+	if err != nil {
+		t.Fatal("Error rendering template: ", err)
+	}
+	if m.Subject != "Hello User" || !strings.Contains(m.Body, "token=abc") {
+		t.Fatalf("Unexpected message: %+v", m)
+	}
+	var r Recorder
+	if err := r.Send(m); err != nil || r.Last() != m {
+		t.Fatal("Message not recorded")
+	}
+	if data := string(m.Bytes("try5@dom.local")); !strings.Contains(data, "To: user@dom.local\r\n") {
+		t.Fatal("Missing To header: ", data)
+	}
+}
+
+// infoRecorder keeps the arguments of the last Info call
+type infoRecorder struct {
+	log.Logger
+	args []interface{}
+}
+
+func (l *infoRecorder) Info(msg string, args ...interface{}) {
+	l.args = args
+}
+
+func TestLogRedactsBody(t *testing.T) {
+	m := &Message{To: "user@dom.local", Subject: "Reset", Body: "Follow https://try5/reset?token=abc"}
+	l := &infoRecorder{}
+	if err := (&Log{Logger: l}).Send(m); err != nil {
+		t.Fatal("Error logging message: ", err)
+	}
+	if strings.Contains(fmt.Sprint(l.args...), "token=abc") {
+		t.Fatal("Body logged: ", l.args)
+	}
+	(&Log{Logger: l, Body: true}).Send(m)
+	if !strings.Contains(fmt.Sprint(l.args...), "token=abc") {
+		t.Fatal("Body not logged with Body: ", l.args)
+	}
+}
@@ -0,0 +1,300 @@
+// Package oauth implements the records and checks of the OAuth 2.0 authorization
+// server (RFC 6749): the registered clients, the authorization codes and tokens,
+// and the PKCE verification (RFC 7636). The codes and tokens are opaque random
+// strings, only their SHA-256 is stored.
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Grant types
+const (
+	AuthorizationCode = "authorization_code"
+	RefreshToken      = "refresh_token"
+	ClientCredentials = "client_credentials"
+)
+
+// TokenType tells what a stored token is
+type TokenType string
+
+const (
+	Code    TokenType = "code"
+	Access  TokenType = "access_token"
+	Refresh TokenType = "refresh_token"
+	// UsedCode is kept in place of an exchanged code, so its reuse is detected
+	UsedCode TokenType = "used_code"
+)
+
+// CodeTTL is how long an authorization code can be exchanged
+const CodeTTL = time.Minute
+
+var (
+	ErrInvalidClientName  = errors.New("invalid client name")
+	ErrInvalidRedirectURI = errors.New("invalid redirect uri")
+	ErrInvalidGrantType   = errors.New("invalid grant type")
+	ErrInvalidScopeName   = errors.New("invalid scope")
+)
+
+// Error is an error response of the authorization server, Code is one of the
+// codes defined by RFC 6749
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// WithDescription returns a copy of the error with another description
+func (e *Error) WithDescription(d string) *Error {
+	return &Error{Code: e.Code, Description: d}
+}
+
+var (
+	ErrInvalidRequest          = &Error{"invalid_request", "the request is missing a parameter or is malformed"}
+	ErrInvalidClient           = &Error{"invalid_client", "client authentication failed"}
+	ErrInvalidGrant            = &Error{"invalid_grant", "the grant is invalid, expired or revoked"}
+	ErrUnauthorizedClient      = &Error{"unauthorized_client", "the client is not allowed to use this grant"}
+	ErrUnsupportedGrantType    = &Error{"unsupported_grant_type", "unsupported grant type"}
+	ErrUnsupportedResponseType = &Error{"unsupported_response_type", "unsupported response type"}
+	ErrInvalidScope            = &Error{"invalid_scope", "the scope is invalid or exceeds the allowed one"}
+	ErrAccessDenied            = &Error{"access_denied", "access denied"}
+	ErrServerError             = &Error{"server_error", "internal error"}
+	// ErrLoginRequired is the OpenID Connect error for a request with prompt=none
+	// from a user without a session
+	ErrLoginRequired = &Error{"login_required", "the user is not authenticated"}
+)
+
+// Strings is a list of strings stored as a JSON array
+type Strings []string
+
+// Value implements driver.Valuer
+func (s Strings) Value() (driver.Value, error) {
+	if s == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal([]string(s))
+	return string(data), err
+}
+
+// Scan implements sql.Scanner
+func (s *Strings) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(s))
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(s))
+	}
+	return fmt.Errorf("can not scan %T into Strings", src)
+}
+
+func (s Strings) contains(v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Client is an application registered to get tokens. The public clients, ie. the
+// single page and mobile applications, can not keep a secret and have none.
+type Client struct {
+	ID           string    `json:"client_id" db:"id"`
+	Name         string    `json:"name" db:"name"`
+	Public       bool      `json:"public" db:"public"`
+	SecretHash   string    `json:"-" db:"secret_hash"`
+	RedirectURIs Strings   `json:"redirect_uris" db:"redirect_uris"`
+	GrantTypes   Strings   `json:"grant_types" db:"grant_types"`
+	Scopes       Strings   `json:"scopes" db:"scopes"`
+	Created      time.Time `json:"created" db:"created"`
+}
+
+// NewClient checks the metadata of a new client and returns it with its secret,
+// empty for the public clients. The grant types default to authorization_code
+// and refresh_token.
+func NewClient(name string, public bool, redirectURIs, grantTypes, scopes []string) (*Client, string, error) {
+	if name = strings.TrimSpace(name); name == "" || len(name) > 256 {
+		return nil, "", ErrInvalidClientName
+	}
+	if len(grantTypes) == 0 {
+		grantTypes = []string{AuthorizationCode, RefreshToken}
+	}
+	for _, g := range grantTypes {
+		switch {
+		case g == ClientCredentials && public:
+			return nil, "", ErrInvalidGrantType
+		case g != AuthorizationCode && g != RefreshToken && g != ClientCredentials:
+			return nil, "", ErrInvalidGrantType
+		}
+	}
+	if Strings(grantTypes).contains(AuthorizationCode) && len(redirectURIs) == 0 {
+		return nil, "", ErrInvalidRedirectURI
+	}
+	for _, u := range redirectURIs {
+		if p, err := url.Parse(u); err != nil || !p.IsAbs() || p.Fragment != "" {
+			return nil, "", ErrInvalidRedirectURI
+		}
+	}
+	for _, s := range scopes {
+		if s == "" || strings.ContainsAny(s, " \"\\") {
+			return nil, "", ErrInvalidScopeName
+		}
+	}
+	id, err := random(16, hex.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	c := &Client{ID: id, Name: name, Public: public, RedirectURIs: redirectURIs, GrantTypes: grantTypes, Scopes: scopes, Created: time.Now().UTC()}
+	if public {
+		return c, "", nil
+	}
+	secret, err := random(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	c.SecretHash = Hash(secret)
+	return c, secret, nil
+}
+
+// CheckSecret tells if secret is the secret of a confidential client
+func (c *Client) CheckSecret(secret string) bool {
+	if c.Public || c.SecretHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(Hash(secret)), []byte(c.SecretHash)) == 1
+}
+
+// AllowsGrant tells if the client can use the grant type
+func (c *Client) AllowsGrant(grant string) bool {
+	return c.GrantTypes.contains(grant)
+}
+
+// RedirectURI returns the registered redirect uri matching uri exactly. An empty
+// uri is only accepted when the client has a single one.
+func (c *Client) RedirectURI(uri string) (string, bool) {
+	if uri == "" {
+		if len(c.RedirectURIs) == 1 {
+			return c.RedirectURIs[0], true
+		}
+		return "", false
+	}
+	return uri, c.RedirectURIs.contains(uri)
+}
+
+// Scope checks the space separated scopes requested by the client, all of them
+// must be registered. An empty request gets every registered scope.
+func (c *Client) Scope(requested string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(c.Scopes, " "), nil
+	}
+	if !SubScope(requested, strings.Join(c.Scopes, " ")) {
+		return "", ErrInvalidScope
+	}
+	return strings.Join(strings.Fields(requested), " "), nil
+}
+
+// SubScope tells if every scope of requested is in granted
+func SubScope(requested, granted string) bool {
+	g := Strings(strings.Fields(granted))
+	for _, s := range strings.Fields(requested) {
+		if !g.contains(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Token is a stored authorization code, access token or refresh token. ID is the
+// hash of the value given to the client. The tokens issued from the same
+// authorization share the GrantID, so they can be revoked together. AccountUID
+// is empty for the tokens of the client credentials grant.
+type Token struct {
+	ID          string    `json:"id" db:"id"`
+	Type        TokenType `json:"type" db:"type"`
+	ClientID    string    `json:"client_id" db:"client_id"`
+	AccountUID  string    `json:"account_uid,omitempty" db:"account_uid"`
+	Scope       string    `json:"scope" db:"scope"`
+	GrantID     string    `json:"grant_id" db:"grant_id"`
+	RedirectURI string    `json:"redirect_uri,omitempty" db:"redirect_uri"`
+	Challenge   string    `json:"code_challenge,omitempty" db:"code_challenge"`
+	// Nonce is the OpenID Connect nonce of the authorization request, given back in the ID token
+	Nonce   string    `json:"nonce,omitempty" db:"nonce"`
+	Created time.Time `json:"created" db:"created"`
+	Expires time.Time `json:"expires" db:"expires"`
+}
+
+// NewToken creates a token valid for ttl. It returns the record to store and the
+// value to give to the client.
+func NewToken(typ TokenType, clientID, accountUID, scope, grantID string, ttl time.Duration) (*Token, string, error) {
+	value, err := random(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	return &Token{
+		ID:         Hash(value),
+		Type:       typ,
+		ClientID:   clientID,
+		AccountUID: accountUID,
+		Scope:      scope,
+		GrantID:    grantID,
+		Created:    now,
+		Expires:    now.Add(ttl),
+	}, value, nil
+}
+
+// NewGrantID returns the id shared by the tokens of a new authorization
+func NewGrantID() (string, error) {
+	return random(16, hex.EncodeToString)
+}
+
+// Valid tells if the token is of type typ and has not expired
+func (t *Token) Valid(typ TokenType) bool {
+	return t.Type == typ && time.Now().Before(t.Expires)
+}
+
+// Hash returns the hex encoded SHA-256 of a secret or token, the form they are stored in
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyPKCE checks the code_verifier against the S256 code_challenge of the
+// authorization request
+func VerifyPKCE(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, r := range verifier {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune("-._~", r)) {
+			return false
+		}
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+func random(n int, enc func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return enc(b), nil
+}
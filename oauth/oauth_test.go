@@ -0,0 +1,76 @@
+package oauth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewClient(t *testing.T) {
+	c, secret, err := NewClient("app", false, []string{"https://app.local/cb"}, nil, []string{"read", "write"})
+	if err != nil {
+		t.Fatal("Error creating client: ", err)
+	}
+	if secret == "" || !c.CheckSecret(secret) || c.CheckSecret("wrong") {
+		t.Fatal("Wrong secret check")
+	}
+	if !c.AllowsGrant(AuthorizationCode) || !c.AllowsGrant(RefreshToken) || c.AllowsGrant(ClientCredentials) {
+		t.Fatal("Wrong default grant types: ", c.GrantTypes)
+	}
+	if uri, ok := c.RedirectURI(""); !ok || uri != "https://app.local/cb" {
+		t.Fatal("Single redirect uri not taken by default")
+	}
+	if _, ok := c.RedirectURI("https://app.local/cb/other"); ok {
+		t.Fatal("Redirect uri not matched exactly")
+	}
+	if s, err := c.Scope(""); err != nil || s != "read write" {
+		t.Fatal("Wrong default scope: ", s, err)
+	}
+	if s, err := c.Scope(" read "); err != nil || s != "read" {
+		t.Fatal("Wrong scope: ", s, err)
+	}
+	if _, err := c.Scope("read admin"); err != ErrInvalidScope {
+		t.Fatal("Unregistered scope accepted: ", err)
+	}
+
+	p, secret, err := NewClient("spa", true, []string{"https://spa.local/cb"}, nil, nil)
+	if err != nil || secret != "" || p.CheckSecret("") {
+		t.Fatal("Public client with a secret: ", err)
+	}
+	if _, _, err := NewClient("spa", true, nil, []string{ClientCredentials}, nil); err != ErrInvalidGrantType {
+		t.Fatal("Public client with client credentials: ", err)
+	}
+	if _, _, err := NewClient("app", false, nil, nil, nil); err != ErrInvalidRedirectURI {
+		t.Fatal("Authorization code client without redirect uri: ", err)
+	}
+	if _, _, err := NewClient("app", false, []string{"/cb"}, nil, nil); err != ErrInvalidRedirectURI {
+		t.Fatal("Relative redirect uri accepted: ", err)
+	}
+}
+
+func TestToken(t *testing.T) {
+	tk, value, err := NewToken(Access, "client", "account", "read", "grant", time.Minute)
+	if err != nil {
+		t.Fatal("Error creating token: ", err)
+	}
+	if tk.ID != Hash(value) || !tk.Valid(Access) || tk.Valid(Refresh) {
+		t.Fatalf("Wrong token: %+v", tk)
+	}
+	expired, _, _ := NewToken(Access, "client", "account", "read", "grant", -time.Second)
+	if expired.Valid(Access) {
+		t.Fatal("Expired token valid")
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	if !VerifyPKCE("E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", verifier) {
+		t.Fatal("Valid verifier rejected")
+	}
+	if VerifyPKCE("E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", verifier[1:]+"x") {
+		t.Fatal("Wrong verifier accepted")
+	}
+	if VerifyPKCE("abc", "short") {
+		t.Fatal("Short verifier accepted")
+	}
+}
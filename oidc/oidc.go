@@ -0,0 +1,367 @@
+// Package oidc implements the OpenID Connect layer of the authorization server:
+// the RSA keys that sign the ID tokens, published as a JWK set and rotated like
+// the cookie keys, and the standard claims of an account.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/seal"
+)
+
+// Scopes that select the claims of the ID token and the userinfo response
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+var (
+	ErrEmptyKeySet = errors.New("empty signing key set")
+	ErrInvalidKey  = errors.New("invalid signing key")
+	ErrNoSealer    = errors.New("no key to encrypt the signing keys")
+
+	// MaxKeys is the number of keys kept in the set after a rotation, the old ones
+	// are still published so the ID tokens they signed can be verified
+	MaxKeys = 3
+	// KeyBits is the size of the new RSA keys
+	KeyBits = 2048
+	// PictureSize is the size in pixels of the gravatar given as picture
+	PictureSize = 200
+)
+
+// Key is an RSA key that signs ID tokens. ID is the kid of the tokens and of the
+// published JWK.
+type Key struct {
+	ID         string    `json:"kid" db:"id"`
+	PrivateKey []byte    `json:"-" db:"private_key"` // PKCS#1 DER, or sealed once stored
+	Created    time.Time `json:"created" db:"created"`
+}
+
+// Loader gets the keys from a persistent storage, newest first
+type Loader interface {
+	LoadSigningKeys() ([]*Key, error)
+}
+
+// Saver persists the keys, newest first
+type Saver interface {
+	SaveSigningKeys(keys []*Key) error
+}
+
+// Initializer persists the first keys of a storage, only if it holds none. The
+// replicas sharing the storage that start at the same time save one set at most.
+type Initializer interface {
+	InitSigningKeys(keys []*Key) error
+}
+
+// sealedPrefix marks the sealed private keys, the ones stored in clear by the older
+// versions are PKCS#1 DER
+const sealedPrefix = "sealed:"
+
+// SealKeys returns copies of the keys with the private keys encrypted by s, the
+// form they are stored in
+func SealKeys(keys []*Key, s *seal.Sealer) ([]*Key, error) {
+	if s == nil {
+		return nil, ErrNoSealer
+	}
+	sealed := make([]*Key, len(keys))
+	for i, k := range keys {
+		c := *k
+		if !k.Sealed() {
+			v, err := s.Seal(string(k.PrivateKey))
+			if err != nil {
+				return nil, err
+			}
+			c.PrivateKey = []byte(sealedPrefix + v)
+		}
+		sealed[i] = &c
+	}
+	return sealed, nil
+}
+
+// OpenKeys returns copies of the stored keys with the private keys decrypted by s.
+// The keys stored in clear are returned as they are.
+func OpenKeys(keys []*Key, s *seal.Sealer) ([]*Key, error) {
+	opened := make([]*Key, len(keys))
+	for i, k := range keys {
+		c := *k
+		if k.Sealed() {
+			if s == nil {
+				return nil, ErrNoSealer
+			}
+			v, err := s.Open(strings.TrimPrefix(string(k.PrivateKey), sealedPrefix))
+			if err != nil {
+				return nil, ErrInvalidKey
+			}
+			c.PrivateKey = []byte(v)
+		}
+		opened[i] = &c
+	}
+	return opened, nil
+}
+
+// Sealed tells if the private key is encrypted
+func (k *Key) Sealed() bool {
+	return strings.HasPrefix(string(k.PrivateKey), sealedPrefix)
+}
+
+// NewKey generates a random RSA key
+func NewKey() (*Key, error) {
+	k, err := rsa.GenerateKey(rand.Reader, KeyBits)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{ID: uuid.New(), PrivateKey: x509.MarshalPKCS1PrivateKey(k), Created: time.Now().UTC()}, nil
+}
+
+// KeySet is a list of signing keys. The newest key signs the new ID tokens while
+// all of them are published, so rotating the keys does not invalidate the tokens
+// already issued.
+type KeySet struct {
+	mu      sync.RWMutex
+	keys    []*Key
+	private []*rsa.PrivateKey
+}
+
+// NewKeySet returns a set holding keys, newest first
+func NewKeySet(keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{}
+	if err := ks.set(keys); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Load reads the set from l and opens its keys with s. If there are no keys stored,
+// a new one is generated and saved sealed by i, unless another replica saved its
+// own meanwhile, and the keys are read again so every replica sharing the store
+// uses the same keys.
+func Load(l Loader, i Initializer, s *seal.Sealer) (*KeySet, error) {
+	if s == nil {
+		return nil, ErrNoSealer
+	}
+	keys, err := l.LoadSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		k, err := NewKey()
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := SealKeys([]*Key{k}, s)
+		if err != nil {
+			return nil, err
+		}
+		if err := i.InitSigningKeys(sealed); err != nil {
+			return nil, err
+		}
+		if keys, err = l.LoadSigningKeys(); err != nil {
+			return nil, err
+		}
+	}
+	if keys, err = OpenKeys(keys, s); err != nil {
+		return nil, err
+	}
+	return NewKeySet(keys...)
+}
+
+// Reload replaces the keys in the set with the ones in l, opened with s
+func (ks *KeySet) Reload(l Loader, s *seal.Sealer) error {
+	keys, err := l.LoadSigningKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return ErrEmptyKeySet
+	}
+	if keys, err = OpenKeys(keys, s); err != nil {
+		return err
+	}
+	return ks.set(keys)
+}
+
+// Rotate adds a new key at the front of the set and drops the oldest ones beyond
+// MaxKeys. It returns the new key.
+func (ks *KeySet) Rotate() (*Key, error) {
+	k, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	keys := append([]*Key{k}, ks.Keys()...)
+	if len(keys) > MaxKeys {
+		keys = keys[:MaxKeys]
+	}
+	if err := ks.set(keys); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Keys returns the keys in the set, newest first
+func (ks *KeySet) Keys() []*Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return append([]*Key{}, ks.keys...)
+}
+
+// Sign returns the claims signed with RS256 by the newest key
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if len(ks.keys) == 0 {
+		return "", ErrEmptyKeySet
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = ks.keys[0].ID
+	return t.SignedString(ks.private[0])
+}
+
+// Verify parses a token signed by any key of the set into claims
+func (ks *KeySet) Verify(token string, claims jwt.Claims) error {
+	p := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg()}}
+	t, err := p.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		ks.mu.RLock()
+		defer ks.mu.RUnlock()
+		for i, k := range ks.keys {
+			if k.ID == kid {
+				return &ks.private[i].PublicKey, nil
+			}
+		}
+		return nil, ErrInvalidKey
+	})
+	if err != nil {
+		return err
+	}
+	if !t.Valid {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// JWK is the public part of a signing key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is the published JWK set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, newest first
+func (ks *KeySet) JWKS() *JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	set := &JWKS{Keys: make([]JWK, len(ks.keys))}
+	for i, k := range ks.keys {
+		pub := ks.private[i].PublicKey
+		set.Keys[i] = JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			Kid: k.ID,
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	}
+	return set
+}
+
+func (ks *KeySet) set(keys []*Key) error {
+	private := make([]*rsa.PrivateKey, len(keys))
+	for i, k := range keys {
+		p, err := x509.ParsePKCS1PrivateKey(k.PrivateKey)
+		if err != nil {
+			return ErrInvalidKey
+		}
+		private[i] = p
+	}
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.private = private
+	ks.mu.Unlock()
+	return nil
+}
+
+// Claims are the claims of the ID tokens and of the userinfo responses. The
+// userinfo responses only carry the account claims.
+type Claims struct {
+	Nonce         string `json:"nonce,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Picture       string `json:"picture,omitempty"`
+	UpdatedAt     int64  `json:"updated_at,omitempty"`
+	jwt.StandardClaims
+}
+
+// AccountClaims returns the claims of the account allowed by scope: the subject
+// always, the email with the email scope and the name and picture with the profile
+// scope. An account that never went through the email verification has no
+// email_verified claim.
+func AccountClaims(a *account.Account, scope string) *Claims {
+	c := &Claims{StandardClaims: jwt.StandardClaims{Subject: *a.UID}}
+	if HasScope(scope, ScopeEmail) && a.Email != nil {
+		c.Email = *a.Email
+		c.EmailVerified = a.EmailVerified
+	}
+	if HasScope(scope, ScopeProfile) {
+		if a.Name != nil {
+			c.Name = *a.Name
+		}
+		c.Picture = a.GravatarURL(PictureSize)
+		if a.Updated != nil {
+			c.UpdatedAt = a.Updated.Unix()
+		}
+	}
+	return c
+}
+
+// HasScope tells if the space separated scope includes s
+func HasScope(scope, s string) bool {
+	for _, x := range strings.Fields(scope) {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Provider issues the ID tokens of Issuer
+type Provider struct {
+	// Issuer is the https URL the discovery document is served under
+	Issuer string
+	Keys   *KeySet
+	// TTL is how long the ID tokens are valid
+	TTL time.Duration
+}
+
+// IDToken returns the signed ID token of the account for the client
+func (p *Provider) IDToken(a *account.Account, clientID, scope, nonce string) (string, error) {
+	c := AccountClaims(a, scope)
+	now := time.Now().UTC()
+	c.Issuer = p.Issuer
+	c.Audience = clientID
+	c.IssuedAt = now.Unix()
+	c.ExpiresAt = now.Add(p.TTL).Unix()
+	c.Nonce = nonce
+	return p.Keys.Sign(c)
+}
@@ -0,0 +1,122 @@
+package oidc
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/seal"
+)
+
+func TestKeySet(t *testing.T) {
+	KeyBits = 1024
+	k, err := NewKey()
+	if err != nil {
+		t.Fatal("Error generating key: ", err)
+	}
+	ks, err := NewKeySet(k)
+	if err != nil {
+		t.Fatal("Error creating key set: ", err)
+	}
+	uid := "eccd8c58-38ec-4385-9569-6eb26a83fa17"
+	p := &Provider{Issuer: "https://try5.local", Keys: ks, TTL: time.Minute}
+	old, err := p.IDToken(&account.Account{UID: &uid}, "client", "openid", "n-0S6_WzA2Mj")
+	if err != nil {
+		t.Fatal("Error signing ID token: ", err)
+	}
+	for i := 0; i < MaxKeys; i++ {
+		if _, err := ks.Rotate(); err != nil {
+			t.Fatal("Error rotating keys: ", err)
+		}
+		if i == 0 {
+			c := &Claims{}
+			if err := ks.Verify(old, c); err != nil || c.Subject != uid || c.Audience != "client" || c.Nonce != "n-0S6_WzA2Mj" {
+				t.Fatalf("Token signed with the previous key must still verify: %v %+v", err, c)
+			}
+		}
+	}
+	set := ks.JWKS()
+	if len(set.Keys) != MaxKeys || set.Keys[0].Kid != ks.Keys()[0].ID || set.Keys[0].E != "AQAB" {
+		t.Fatalf("Wrong JWK set: %+v", set)
+	}
+	if err := ks.Verify(old, &Claims{}); err == nil {
+		t.Fatal("Token signed with a dropped key must not verify")
+	}
+}
+
+func TestAccountClaims(t *testing.T) {
+	uid, email, name, verified := "eccd8c58", "MyEmailAddress@example.com ", "Some One", true
+	a := &account.Account{UID: &uid, Email: &email, Name: &name, EmailVerified: &verified}
+	c := AccountClaims(a, "openid")
+	if c.Subject != uid || c.Email != "" || c.Name != "" || c.Picture != "" {
+		t.Fatalf("Claims outside the scope: %+v", c)
+	}
+	c = AccountClaims(a, "openid email profile")
+	if c.Email != email || c.EmailVerified == nil || !*c.EmailVerified || c.Name != name {
+		t.Fatalf("Wrong claims: %+v", c)
+	}
+	if c.Picture != "https://gravatar.com/avatar/0bc83cb571cd1c50ba6f3e8a78ef1346?s=200" {
+		t.Fatal("Wrong picture: ", c.Picture)
+	}
+}
+
+// keyStore keeps the keys like a store. other, if set, is saved by InitSigningKeys
+// before the keys given, as if another replica started at the same time.
+type keyStore struct {
+	keys  []*Key
+	other []*Key
+}
+
+func (s *keyStore) LoadSigningKeys() ([]*Key, error) { return s.keys, nil }
+
+func (s *keyStore) InitSigningKeys(keys []*Key) error {
+	if s.other != nil {
+		s.keys = s.other
+	}
+	if len(s.keys) == 0 {
+		s.keys = keys
+	}
+	return nil
+}
+
+func TestLoadSealed(t *testing.T) {
+	KeyBits = 1024
+	sealer, err := seal.New([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal("Error creating sealer: ", err)
+	}
+	if _, err := Load(&keyStore{}, &keyStore{}, nil); err != ErrNoSealer {
+		t.Fatal("Keys loaded without a sealer: ", err)
+	}
+
+	s := &keyStore{}
+	ks, err := Load(s, s, sealer)
+	if err != nil {
+		t.Fatal("Error loading keys: ", err)
+	}
+	if len(s.keys) != 1 || !s.keys[0].Sealed() || bytes.Equal(s.keys[0].PrivateKey, ks.Keys()[0].PrivateKey) {
+		t.Fatal("Private key stored in clear")
+	}
+	again, err := Load(s, s, sealer)
+	if err != nil || again.Keys()[0].ID != ks.Keys()[0].ID || !bytes.Equal(again.Keys()[0].PrivateKey, ks.Keys()[0].PrivateKey) {
+		t.Fatal("Stored key not loaded: ", err)
+	}
+
+	// another replica saved its keys first, they are the ones used
+	other, _ := NewKey()
+	sealed, _ := SealKeys([]*Key{other}, sealer)
+	s = &keyStore{other: sealed}
+	if ks, err = Load(s, s, sealer); err != nil || len(ks.Keys()) != 1 || ks.Keys()[0].ID != other.ID {
+		t.Fatal("Keys of the other replica not used: ", err)
+	}
+
+	// the keys stored in clear by older versions are still loaded
+	s = &keyStore{keys: []*Key{other}}
+	if ks, err = Load(s, s, sealer); err != nil || ks.Keys()[0].ID != other.ID {
+		t.Fatal("Clear key not loaded: ", err)
+	}
+	if err := ks.Reload(&keyStore{keys: sealed}, nil); err != ErrNoSealer {
+		t.Fatal("Sealed keys opened without a sealer: ", err)
+	}
+}
@@ -0,0 +1,195 @@
+package rbac
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jllopis/try5/account"
+)
+
+// Permission is an action over a resource in the form "resource:action", ie. "accounts:read".
+// The wildcard "*" can be used as the resource, the action or the whole permission.
+type Permission string
+
+// Permissions is a list of permissions. It is stored as a JSON array.
+type Permissions []Permission
+
+// Role groups a set of permissions. Roles can inherit the permissions of other
+// roles through grants.
+type Role struct {
+	ID          *int64      `json:"id" db:"id"`
+	Slug        *string     `json:"slug" db:"slug"`
+	Name        *string     `json:"name,omitempty" db:"name"`
+	Description *string     `json:"description,omitempty" db:"description"`
+	Permissions Permissions `json:"permissions" db:"parameters"`
+	Created     *time.Time  `json:"created" db:"created"`
+	Updated     *time.Time  `json:"updated" db:"updated"`
+}
+
+// Grant makes ToRole inherit all the permissions of FromRole
+type Grant struct {
+	ID         *int64          `json:"id" db:"id"`
+	FromRole   *int64          `json:"from_role" db:"from_role"`
+	ToRole     *int64          `json:"to_role" db:"to_role"`
+	Assignment json.RawMessage `json:"assignment,omitempty" db:"assigment"`
+}
+
+// Loader is the subset of the store needed to resolve the permissions of an account
+type Loader interface {
+	LoadRole(id int64) (*Role, error)
+	LoadGrants(roleID int64) ([]*Grant, error)
+	LoadAccountRoles(uid string) ([]*Role, error)
+}
+
+const (
+	// AdminRole is the slug of the role that has every permission
+	AdminRole = "admin"
+	// Any matches every resource or action
+	Any = "*"
+)
+
+var (
+	ErrInvalidSlug       = errors.New("invalid role slug")
+	ErrInvalidPermission = errors.New("invalid permission")
+	ErrInvalidGrant      = errors.New("invalid grant")
+
+	RegexpSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+)
+
+// NewRole returns a role with the provided slug and permissions
+func NewRole(slug, name string, perms ...Permission) *Role {
+	return &Role{Slug: &slug, Name: &name, Permissions: Permissions(perms)}
+}
+
+// ValidateFields checks the role has a valid slug and permissions
+func (r *Role) ValidateFields() error {
+	if r.Slug == nil || len(*r.Slug) > 256 || !RegexpSlug.MatchString(*r.Slug) {
+		return ErrInvalidSlug
+	}
+	for _, p := range r.Permissions {
+		if !p.Valid() {
+			return ErrInvalidPermission
+		}
+	}
+	return nil
+}
+
+// Valid tells if the permission is well formed
+func (p Permission) Valid() bool {
+	if p == Any {
+		return true
+	}
+	parts := strings.Split(string(p), ":")
+	return len(parts) == 2 && parts[0] != "" && parts[1] != ""
+}
+
+// Match tells if the permission p (as held by a role) allows the requested permission
+func (p Permission) Match(requested Permission) bool {
+	if p == Any || p == requested {
+		return true
+	}
+	have := strings.SplitN(string(p), ":", 2)
+	want := strings.SplitN(string(requested), ":", 2)
+	if len(have) != 2 || len(want) != 2 {
+		return false
+	}
+	return (have[0] == Any || have[0] == want[0]) && (have[1] == Any || have[1] == want[1])
+}
+
+// Allows tells if any of the permissions matches the requested one
+func (ps Permissions) Allows(requested Permission) bool {
+	for _, p := range ps {
+		if p.Match(requested) {
+			return true
+		}
+	}
+	return false
+}
+
+// Value implements driver.Valuer to store the permissions as a JSON array
+func (ps Permissions) Value() (driver.Value, error) {
+	if ps == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(ps)
+}
+
+// Scan implements sql.Scanner to read the permissions from a JSON array
+func (ps *Permissions) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*ps = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, ps)
+	case string:
+		return json.Unmarshal([]byte(v), ps)
+	}
+	return ErrInvalidPermission
+}
+
+// Authorize tells if the account holds the permission through any of its roles
+// or the roles they inherit, transitively.
+func Authorize(l Loader, acc *account.Account, perm Permission) (bool, error) {
+	if acc == nil || acc.UID == nil {
+		return false, nil
+	}
+	roles, err := l.LoadAccountRoles(*acc.UID)
+	if err != nil {
+		return false, err
+	}
+	perms, err := resolve(l, roles)
+	if err != nil {
+		return false, err
+	}
+	return perms.Allows(perm), nil
+}
+
+// EffectivePermissions returns all the permissions the account holds, including
+// the inherited ones.
+func EffectivePermissions(l Loader, acc *account.Account) (Permissions, error) {
+	if acc == nil || acc.UID == nil {
+		return nil, nil
+	}
+	roles, err := l.LoadAccountRoles(*acc.UID)
+	if err != nil {
+		return nil, err
+	}
+	return resolve(l, roles)
+}
+
+// resolve walks the grant graph starting at roles and collects all the permissions.
+// Every role is only visited once so cycles in the grants are harmless.
+func resolve(l Loader, roles []*Role) (Permissions, error) {
+	var perms Permissions
+	visited := make(map[int64]bool)
+	pending := roles
+	for len(pending) > 0 {
+		r := pending[0]
+		pending = pending[1:]
+		if r == nil || r.ID == nil || visited[*r.ID] {
+			continue
+		}
+		visited[*r.ID] = true
+		perms = append(perms, r.Permissions...)
+		grants, err := l.LoadGrants(*r.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range grants {
+			if g.FromRole == nil || visited[*g.FromRole] {
+				continue
+			}
+			parent, err := l.LoadRole(*g.FromRole)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, parent)
+		}
+	}
+	return perms, nil
+}
@@ -0,0 +1,94 @@
+package rbac
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jllopis/try5/account"
+)
+
+type testLoader struct {
+	roles   map[int64]*Role
+	grants  []*Grant
+	members map[string][]int64
+}
+
+func (l *testLoader) LoadRole(id int64) (*Role, error) {
+	if r, ok := l.roles[id]; ok {
+		return r, nil
+	}
+	return nil, errors.New("role not found")
+}
+
+func (l *testLoader) LoadGrants(roleID int64) ([]*Grant, error) {
+	var res []*Grant
+	for _, g := range l.grants {
+		if *g.ToRole == roleID {
+			res = append(res, g)
+		}
+	}
+	return res, nil
+}
+
+func (l *testLoader) LoadAccountRoles(uid string) ([]*Role, error) {
+	var res []*Role
+	for _, id := range l.members[uid] {
+		res = append(res, l.roles[id])
+	}
+	return res, nil
+}
+
+func role(id int64, slug string, perms ...Permission) *Role {
+	r := NewRole(slug, slug, perms...)
+	r.ID = &id
+	return r
+}
+
+func grant(from, to int64) *Grant {
+	return &Grant{FromRole: &from, ToRole: &to}
+}
+
+func TestAuthorize(t *testing.T) {
+	uid := "7ecee355-537b-492c-ab23-6a41219959d1"
+	acc := &account.Account{UID: &uid}
+	l := &testLoader{
+		roles: map[int64]*Role{
+			1: role(1, "reader", "accounts:read"),
+			2: role(2, "editor", "accounts:write"),
+			3: role(3, "operator", "keys:*"),
+		},
+		// operator inherits editor that inherits reader, and reader inherits operator (cycle)
+		grants:  []*Grant{grant(2, 3), grant(1, 2), grant(3, 1)},
+		members: map[string][]int64{uid: {3}},
+	}
+	for _, p := range []Permission{"accounts:read", "accounts:write", "keys:delete"} {
+		ok, err := Authorize(l, acc, p)
+		if err != nil {
+			t.Fatal("Error authorizing: ", err)
+		}
+		if !ok {
+			t.Fatalf("Permission %s should be granted", p)
+		}
+	}
+	if ok, _ := Authorize(l, acc, "roles:write"); ok {
+		t.Fatal("Permission roles:write should not be granted")
+	}
+	if ok, _ := Authorize(l, nil, "accounts:read"); ok {
+		t.Fatal("Nil account should not be authorized")
+	}
+}
+
+func TestPermissionMatch(t *testing.T) {
+	if !Permission(Any).Match("accounts:read") {
+		t.Fatal("* should match everything")
+	}
+	if !Permission("*:read").Match("roles:read") {
+		t.Fatal("*:read should match roles:read")
+	}
+	if Permission("accounts:read").Match("accounts:write") {
+		t.Fatal("accounts:read should not match accounts:write")
+	}
+	if Permission("accounts").Valid() {
+		t.Fatal("accounts should not be a valid permission")
+	}
+}
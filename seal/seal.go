@@ -0,0 +1,54 @@
+// Package seal encrypts with AES-GCM the secrets the server keeps in the store: the
+// TOTP secrets, the signing keys of the api keys and the ID token signing keys.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+var ErrSealed = errors.New("can not open sealed secret")
+
+// Sealer encrypts the secrets before storing them, with AES-GCM
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// New returns a Sealer using key, of 16, 24 or 32 bytes
+func New(key []byte) (*Sealer, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// Seal encrypts the secret. The result is base64 encoded and holds the nonce.
+func (s *Sealer) Seal(secret string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(s.aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+// Open decrypts a secret encrypted by Seal
+func (s *Sealer) Open(sealed string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return "", ErrSealed
+	}
+	n := s.aead.NonceSize()
+	secret, err := s.aead.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return "", ErrSealed
+	}
+	return string(secret), nil
+}
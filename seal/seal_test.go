@@ -0,0 +1,21 @@
+package seal
+
+import "testing"
+
+func TestSealer(t *testing.T) {
+	s, err := New(make([]byte, 32))
+	if err != nil {
+		t.Fatal("Error creating sealer: ", err)
+	}
+	sealed, err := s.Seal("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal("Error sealing: ", err)
+	}
+	if secret, err := s.Open(sealed); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatal("Error opening sealed secret: ", err)
+	}
+	other, _ := New(append(make([]byte, 31), 1))
+	if _, err := other.Open(sealed); err != ErrSealed {
+		t.Fatal("Secret opened with another key")
+	}
+}
@@ -0,0 +1,97 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// Session is a server side login session. The ID is sent to the browser inside an
+// encrypted cookie and everything else stays in the store.
+type Session struct {
+	ID         *string    `json:"id" db:"id"`
+	AccountUID *string    `json:"account_uid" db:"account_uid"`
+	RemoteAddr *string    `json:"remote_addr,omitempty" db:"remote_addr"`
+	UserAgent  *string    `json:"user_agent,omitempty" db:"user_agent"`
+	Created    *time.Time `json:"created" db:"created"`
+	LastSeen   *time.Time `json:"last_seen" db:"last_seen"`
+	Expires    *time.Time `json:"expires" db:"expires"`
+}
+
+// Options configure the lifetime of the sessions
+type Options struct {
+	// MaxAge is the absolute lifetime of a session
+	MaxAge time.Duration
+	// IdleTimeout expires the session when it has not been used for this long
+	IdleTimeout time.Duration
+}
+
+var (
+	ErrExpired        = errors.New("session expired")
+	ErrInvalidSession = errors.New("invalid session")
+
+	// DefaultMaxAge is used when no MaxAge is configured
+	DefaultMaxAge = 7 * 24 * time.Hour
+	// DefaultIdleTimeout is used when no IdleTimeout is configured
+	DefaultIdleTimeout = 2 * time.Hour
+)
+
+// WithDefaults returns a copy of the options with the zero values set to the defaults
+func (o Options) WithDefaults() Options {
+	if o.MaxAge == 0 {
+		o.MaxAge = DefaultMaxAge
+	}
+	if o.IdleTimeout == 0 {
+		o.IdleTimeout = DefaultIdleTimeout
+	}
+	return o
+}
+
+// New creates a session for the account
+func New(accountUID, remoteAddr, userAgent string, opts Options) (*Session, error) {
+	if accountUID == "" {
+		return nil, ErrInvalidSession
+	}
+	opts = opts.WithDefaults()
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now().UTC()
+	expires := now.Add(opts.MaxAge)
+	s := &Session{ID: &id, AccountUID: &accountUID, Created: &now, LastSeen: &now, Expires: &expires}
+	if remoteAddr != "" {
+		s.RemoteAddr = &remoteAddr
+	}
+	if userAgent != "" {
+		s.UserAgent = &userAgent
+	}
+	return s, nil
+}
+
+// Check returns ErrExpired if the session is past its expiration or has been idle
+// for longer than the idle timeout.
+func (s *Session) Check(opts Options) error {
+	opts = opts.WithDefaults()
+	now := time.Now().UTC()
+	if s.Expires == nil || now.After(*s.Expires) {
+		return ErrExpired
+	}
+	if s.LastSeen == nil || now.Sub(*s.LastSeen) > opts.IdleTimeout {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Touch updates LastSeen. It returns true if the session has to be saved, which only
+// happens once a minute to avoid writing to the store on every request.
+func (s *Session) Touch() bool {
+	now := time.Now().UTC()
+	if s.LastSeen != nil && now.Sub(*s.LastSeen) < time.Minute {
+		return false
+	}
+	s.LastSeen = &now
+	return true
+}
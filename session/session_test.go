@@ -0,0 +1,30 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionCheck(t *testing.T) {
+	opts := Options{MaxAge: time.Hour, IdleTimeout: 10 * time.Minute}
+	s, err := New("7ecee355-537b-492c-ab23-6a41219959d1", "127.0.0.1:5555", "test", opts)
+	if err != nil {
+		t.Fatal("Error creating session: ", err)
+	}
+	if err := s.Check(opts); err != nil {
+		t.Fatal("New session should be valid: ", err)
+	}
+	idle := time.Now().UTC().Add(-11 * time.Minute)
+	s.LastSeen = &idle
+	if err := s.Check(opts); err != ErrExpired {
+		t.Fatal("Idle session should be expired")
+	}
+	if !s.Touch() {
+		t.Fatal("Touch should update an idle session")
+	}
+	expired := time.Now().UTC().Add(-time.Second)
+	s.Expires = &expired
+	if err := s.Check(opts); err != ErrExpired {
+		t.Fatal("Session past its max age should be expired")
+	}
+}
@@ -2,19 +2,28 @@ package bolt
 
 import (
 	"bytes"
-	"encoding/gob"
-	"errors"
-	"fmt"
 	"time"
 
 	"code.google.com/p/go-uuid/uuid"
 
 	"github.com/boltdb/bolt"
 	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/keyring"
+	"github.com/jllopis/try5/oidc"
 	"github.com/jllopis/try5/store"
 	"github.com/mgutz/logxi/v1"
 )
 
+var (
+	// emailIndex maps the normalized email of every account to its uid
+	emailIndex = []byte("accounts_by_email")
+	// createdIndex and nameIndex map the sort key of every account followed by
+	// its uid to the uid, see sortIndexKey
+	createdIndex = []byte("accounts_by_created")
+	nameIndex    = []byte("accounts_by_name")
+)
+
 type BoltStore struct {
 	C      *bolt.DB
 	status int
@@ -35,17 +44,21 @@ func NewBoltStore(options *BoltStoreOptions) *BoltStore {
 		b.logger.Fatal("NewBoltStore", "error", err.Error())
 	}
 	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("accounts"))
-		return err
+		for _, name := range []string{"accounts", "accounts_by_email", "accounts_by_created", "accounts_by_name", "sessions", "apikeys", "roles", "grants", "account_roles", "keyring", "attempts", "tickets", "credentials", "credentials_by_account", "oauth_clients", "oauth_tokens", "oauth_tokens_by_grant", "federation_providers", "identities", "identities_by_account", "signatures"} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 	if err != nil {
+		b.logger.Error("NewBoltStore", "error", err)
+		db.Close()
 		return nil
 	}
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("sessions"))
-		return err
-	})
-	if err != nil {
+	if err := migrate(db); err != nil {
+		b.logger.Error("NewBoltStore", "migration error", err)
+		db.Close()
 		return nil
 	}
 	b.C = db
@@ -53,26 +66,41 @@ func NewBoltStore(options *BoltStoreOptions) *BoltStore {
 	return b
 }
 
+// view runs fn in a read only transaction translating the bolt errors into store errors
+func (s *BoltStore) view(fn func(tx *bolt.Tx) error) error {
+	return storeError(s.C.View(fn))
+}
+
+// update runs fn in a read-write transaction translating the bolt errors into store errors
+func (s *BoltStore) update(fn func(tx *bolt.Tx) error) error {
+	return storeError(s.C.Update(fn))
+}
+
+func storeError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case bolt.ErrDatabaseNotOpen, bolt.ErrTimeout:
+		return store.UnavailableError(err)
+	}
+	return err
+}
+
 func (s *BoltStore) Status() (int, string) {
 	return s.status, store.StatusStr[s.status]
 }
 
 func (s *BoltStore) LoadAllAccounts() ([]*account.Account, error) {
 	var accounts []*account.Account
-	err := s.C.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("accounts"))
-		bs := bucket.Stats()
-		fmt.Printf("\nBoltDB STATS:\n%#v\n", bs)
-		bucket.ForEach(func(k, v []byte) error {
-			var a *account.Account
-			dec := gob.NewDecoder(bytes.NewBuffer(v))
-			err := dec.Decode(&a)
-			if err == nil && a != nil {
-				accounts = append(accounts, a)
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("accounts")).ForEach(func(k, v []byte) error {
+			a, err := decodeAccount(v)
+			if err != nil {
+				return err
 			}
+			accounts = append(accounts, a)
 			return nil
 		})
-		return nil
 	})
 	if err != nil {
 		return nil, err
@@ -82,13 +110,14 @@ func (s *BoltStore) LoadAllAccounts() ([]*account.Account, error) {
 
 func (s *BoltStore) LoadAccount(uuid string) (*account.Account, error) {
 	var a *account.Account
-	err := s.C.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		data := tx.Bucket([]byte("accounts")).Get([]byte(uuid))
 		if data == nil {
-			return errors.New("account not found")
+			return store.ErrAccountNotFound
 		}
-		dec := gob.NewDecoder(bytes.NewBuffer(data))
-		return dec.Decode(&a)
+		var err error
+		a, err = decodeAccount(data)
+		return err
 	})
 	if err != nil {
 		return nil, err
@@ -96,108 +125,524 @@ func (s *BoltStore) LoadAccount(uuid string) (*account.Account, error) {
 	return a, nil
 }
 
+// GetAccountByEmail finds the account through the accounts_by_email index
 func (s *BoltStore) GetAccountByEmail(email string) (*account.Account, error) {
-	var found *account.Account
-	err := s.C.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("accounts"))
-		bucket.ForEach(func(k, v []byte) error {
-			var a *account.Account
-			dec := gob.NewDecoder(bytes.NewBuffer(v))
-			err := dec.Decode(&a)
-			if err == nil && a != nil {
-				if email == *a.Email {
-					found = a
-					return nil
-				}
-			}
-			return nil
-		})
-		return nil
+	var a *account.Account
+	err := s.view(func(tx *bolt.Tx) error {
+		uid := tx.Bucket(emailIndex).Get(emailKey(email))
+		if uid == nil {
+			return store.ErrAccountNotFound
+		}
+		data := tx.Bucket([]byte("accounts")).Get(uid)
+		if data == nil {
+			return store.ErrAccountNotFound
+		}
+		var err error
+		a, err = decodeAccount(data)
+		return err
 	})
 	if err != nil {
 		return nil, err
 	}
-	if found != nil {
-		return found, nil
-	}
-	return nil, errors.New("email not found")
+	return a, nil
 }
 
+// SaveAccount creates the account if it has no UID or updates it otherwise. The
+// email index is updated in the same transaction and store.ErrEmailTaken is
+// returned if the email belongs to another account.
 func (s *BoltStore) SaveAccount(acc *account.Account) (*account.Account, error) {
+	if acc.Email == nil {
+		return nil, account.ErrInvalidEmail
+	}
 	now := time.Now().UTC()
 	acc.Updated = &now
-	// Check if we have an id. If we do, it "could" be an update (check if account exist first)
-	// If don't, its a new account
-	if acc.UID == nil {
-		u := uuid.New()
-		acc.UID = &u
-		acc.Created = &now
-		if ok := acc.Password; ok == nil {
-			return nil, errors.New("nil password")
-		}
-		acc.UpdatePassword(*acc.Password)
-		if acc.Active == nil {
-			t := true
-			acc.Active = &t
-		}
-	} else {
-		savedAcc, err := s.LoadAccount(*acc.UID)
-		if err != nil {
-			return nil, err
-		}
-		if savedAcc == nil {
-			s.logger.Info("SaveAccount", "cant retrieve account from db", "uid", *acc.UID)
-			return nil, errors.New("error getting account from db")
-		}
-		// copy immutable data, that we are not allowed to modify
-		acc.Created = savedAcc.Created
-		if acc.Password != nil {
-			if savedAcc.Password != nil {
-				s.logger.Info("SaveAccount", "change passord required", *acc.UID, "old", *savedAcc.Password, "new", *acc.Password)
-				if *acc.Password != *savedAcc.Password {
-					acc.UpdatePassword(*acc.Password)
-					s.logger.Info("SaveAccount", "passord changed", *acc.UID, "old", *savedAcc.Password, "new", *acc.Password)
-				}
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("accounts"))
+		idx := tx.Bucket(emailIndex)
+		var saved *account.Account
+		// Check if we have an id. If we do, it "could" be an update (check if account exist first)
+		// If don't, its a new account
+		if acc.UID != nil {
+			data := b.Get([]byte(*acc.UID))
+			if data == nil {
+				return store.ErrAccountNotFound
 			}
-		} else {
-			if savedAcc.Password != nil {
-				acc.Password = savedAcc.Password
+			var err error
+			if saved, err = decodeAccount(data); err != nil {
+				return err
 			}
 		}
-		if acc.Active == nil {
-			if savedAcc.Active != nil {
-				acc.Active = savedAcc.Active
-			} else {
-				// active is true by default
+		key := emailKey(*acc.Email)
+		if owner := idx.Get(key); owner != nil && (acc.UID == nil || string(owner) != *acc.UID) {
+			return store.ErrEmailTaken
+		}
+		if saved == nil {
+			// the accounts authenticated somewhere else are created without a password
+			if acc.Password != nil {
+				if err := acc.UpdatePassword(*acc.Password); err != nil {
+					return err
+				}
+			}
+			u := uuid.New()
+			acc.UID = &u
+			acc.Created = &now
+			if acc.Active == nil {
 				t := true
 				acc.Active = &t
 			}
+		} else {
+			// copy immutable data, that we are not allowed to modify
+			acc.Created = saved.Created
+			acc.PasswordHistory = saved.PasswordHistory
+			switch {
+			case acc.Password == nil:
+				acc.Password = saved.Password
+			case saved.Password == nil || *acc.Password != *saved.Password:
+				acc.RememberPassword(saved.Password)
+				if err := acc.UpdatePassword(*acc.Password); err != nil {
+					return err
+				}
+			}
+			if acc.Active == nil {
+				if saved.Active != nil {
+					acc.Active = saved.Active
+				} else {
+					// active is true by default
+					t := true
+					acc.Active = &t
+				}
+			}
+			if acc.EmailVerified == nil {
+				acc.EmailVerified = saved.EmailVerified
+			}
+			// the second factor only changes with SetMFA
+			acc.MFA = saved.MFA
+			if saved.Email != nil {
+				if old := emailKey(*saved.Email); !bytes.Equal(old, key) {
+					if err := idx.Delete(old); err != nil {
+						return err
+					}
+				}
+			}
+			if err := unindexAccount(tx, saved); err != nil {
+				return err
+			}
+		}
+		data, err := encodeAccount(acc)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(*acc.UID), data); err != nil {
+			return err
+		}
+		if err := indexAccount(tx, acc); err != nil {
+			return err
+		}
+		return idx.Put(key, []byte(*acc.UID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// SetPasswordHash replaces the password hash, ie. after upgrading it, without
+// touching the password history
+func (s *BoltStore) SetPasswordHash(uuid string, hash string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("accounts"))
+		data := b.Get([]byte(uuid))
+		if data == nil {
+			return store.ErrAccountNotFound
+		}
+		a, err := decodeAccount(data)
+		if err != nil {
+			return err
+		}
+		a.Password = &hash
+		if data, err = encodeAccount(a); err != nil {
+			return err
+		}
+		return b.Put([]byte(uuid), data)
+	})
+}
+
+// SetMFA replaces the second factor of the account, nil removes it
+func (s *BoltStore) SetMFA(uuid string, m *account.MFA) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("accounts"))
+		data := b.Get([]byte(uuid))
+		if data == nil {
+			return store.ErrAccountNotFound
+		}
+		a, err := decodeAccount(data)
+		if err != nil {
+			return err
+		}
+		a.MFA = m
+		if data, err = encodeAccount(a); err != nil {
+			return err
+		}
+		return b.Put([]byte(uuid), data)
+	})
+}
+
+// SwapMFA replaces the second factor of the account with m if it is still old, in
+// the same transaction that reads it
+func (s *BoltStore) SwapMFA(uuid string, old, m *account.MFA) (bool, error) {
+	swapped := false
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("accounts"))
+		data := b.Get([]byte(uuid))
+		if data == nil {
+			return store.ErrAccountNotFound
+		}
+		a, err := decodeAccount(data)
+		if err != nil {
+			return err
+		}
+		if !a.MFA.Same(old) {
+			return nil
+		}
+		a.MFA = m
+		if data, err = encodeAccount(a); err != nil {
+			return err
+		}
+		swapped = true
+		return b.Put([]byte(uuid), data)
+	})
+	if err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
+
+func (s *BoltStore) DeleteAccount(uuid string) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("accounts"))
+		data := b.Get([]byte(uuid))
+		if data == nil {
+			return nil
+		}
+		a, err := decodeAccount(data)
+		if err != nil {
+			return err
+		}
+		if a.Email != nil {
+			idx := tx.Bucket(emailIndex)
+			if owner := idx.Get(emailKey(*a.Email)); string(owner) == uuid {
+				if err := idx.Delete(emailKey(*a.Email)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := unindexAccount(tx, a); err != nil {
+			return err
+		}
+		n = 1
+		return b.Delete([]byte(uuid))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ListAccounts walks the index of the sort field from the cursor, so only the
+// accounts of the page and those discarded by the filters are read.
+func (s *BoltStore) ListAccounts(q *store.AccountQuery) (*store.AccountPage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	cursor, err := store.DecodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	page := &store.AccountPage{}
+	err = s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("accounts"))
+		c := tx.Bucket(sortIndex(q.Sort)).Cursor()
+		lower, upper := indexBounds(q)
+		var k, v []byte
+		switch {
+		case cursor != nil:
+			k, v = seek(c, sortIndexKey(q.Sort, cursor.Key, cursor.UID), q.Desc)
+		case q.Desc && upper != nil:
+			k, v = seek(c, upper, true)
+		case q.Desc:
+			k, v = c.Last()
+		default:
+			k, v = c.Seek(lower)
+		}
+		for ; k != nil; k, v = step(c, q.Desc) {
+			if q.Desc && lower != nil && bytes.Compare(k, lower) < 0 || !q.Desc && upper != nil && bytes.Compare(k, upper) > 0 {
+				break
+			}
+			data := b.Get(v)
+			if data == nil {
+				continue
+			}
+			a, err := decodeAccount(data)
+			if err != nil {
+				return err
+			}
+			if !q.Match(a) || cursor != nil && !q.After(a, cursor) {
+				continue
+			}
+			if len(page.Accounts) == q.Limit {
+				page.NextCursor = q.PageCursor(page.Accounts[q.Limit-1])
+				break
+			}
+			page.Accounts = append(page.Accounts, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// seek positions c on the first key at or after key, or at or before it when
+// walking backwards
+func seek(c *bolt.Cursor, key []byte, desc bool) ([]byte, []byte) {
+	k, v := c.Seek(key)
+	if !desc {
+		return k, v
+	}
+	if k == nil {
+		return c.Last()
+	}
+	if bytes.Compare(k, key) > 0 {
+		return c.Prev()
+	}
+	return k, v
+}
+
+func step(c *bolt.Cursor, desc bool) ([]byte, []byte) {
+	if desc {
+		return c.Prev()
+	}
+	return c.Next()
+}
+
+// indexBounds returns the range of index keys that can satisfy the filters of q,
+// nil means unbounded
+func indexBounds(q *store.AccountQuery) (lower, upper []byte) {
+	switch q.Sort {
+	case store.SortEmail:
+		if q.EmailPrefix != "" {
+			lower = []byte(q.EmailPrefix)
+			upper = []byte(q.EmailPrefix + "\xff")
+		}
+	case store.SortCreated:
+		if q.CreatedAfter != nil {
+			lower = []byte(store.TimeKey(*q.CreatedAfter))
+		}
+		if q.CreatedBefore != nil {
+			upper = []byte(store.TimeKey(*q.CreatedBefore) + "\xff")
+		}
+	}
+	return lower, upper
+}
+
+func sortIndex(sort store.SortField) []byte {
+	switch sort {
+	case store.SortEmail:
+		return emailIndex
+	case store.SortName:
+		return nameIndex
+	}
+	return createdIndex
+}
+
+// sortIndexKey is the key of an account in the index of the sort field. The
+// emails are unique so the uid is only appended to the other keys.
+func sortIndexKey(sort store.SortField, key, uid string) []byte {
+	if sort == store.SortEmail {
+		return []byte(key)
+	}
+	return []byte(key + "\x00" + uid)
+}
+
+// indexAccount adds the account to the created and name indexes
+func indexAccount(tx *bolt.Tx, a *account.Account) error {
+	for _, sort := range []store.SortField{store.SortCreated, store.SortName} {
+		q := &store.AccountQuery{Sort: sort}
+		if err := tx.Bucket(sortIndex(sort)).Put(sortIndexKey(sort, q.SortKey(a), *a.UID), []byte(*a.UID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexAccount removes the account from the created and name indexes
+func unindexAccount(tx *bolt.Tx, a *account.Account) error {
+	for _, sort := range []store.SortField{store.SortCreated, store.SortName} {
+		q := &store.AccountQuery{Sort: sort}
+		if err := tx.Bucket(sortIndex(sort)).Delete(sortIndexKey(sort, q.SortKey(a), *a.UID)); err != nil {
+			return err
 		}
 	}
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(acc)
+	return nil
+}
+
+// emailKey is the key of the email in the accounts_by_email index
+func emailKey(email string) []byte {
+	return []byte(account.NormalizeEmail(email))
+}
+
+func (s *BoltStore) LoadKey(id string) (*apikey.Key, error) {
+	var k *apikey.Key
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte("apikeys")).Get([]byte(id))
+		if data == nil {
+			return store.ErrKeyNotFound
+		}
+		var err error
+		k, err = decodeKey(data)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	err = s.C.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket([]byte("accounts")).Put([]byte(*acc.UID), buf.Bytes())
+	return k, nil
+}
+
+func (s *BoltStore) LoadAccountKeys(accountUID string) ([]*apikey.Key, error) {
+	var keys []*apikey.Key
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("apikeys")).ForEach(func(k, v []byte) error {
+			key, err := decodeKey(v)
+			if err != nil {
+				return err
+			}
+			if key.AccountUID != nil && *key.AccountUID == accountUID {
+				keys = append(keys, key)
+			}
+			return nil
+		})
 	})
 	if err != nil {
 		return nil, err
 	}
+	return keys, nil
+}
 
-	return acc, nil
+func (s *BoltStore) SaveKey(key *apikey.Key) (*apikey.Key, error) {
+	if key.ID == nil {
+		return nil, store.ErrMissingID
+	}
+	data, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("apikeys")).Put([]byte(*key.ID), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
 }
 
-func (s *BoltStore) DeleteAccount(uuid string) (int, error) {
-	err := s.C.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket([]byte("accounts")).Delete([]byte(uuid))
+func (s *BoltStore) DeleteKey(id string) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("apikeys"))
+		if b.Get([]byte(id)) == nil {
+			return nil
+		}
+		n = 1
+		return b.Delete([]byte(id))
 	})
 	if err != nil {
 		return 0, err
 	}
-	return 1, nil
+	return n, nil
+}
+
+func (s *BoltStore) LoadCookieKeys() ([]*keyring.Key, error) {
+	var keys []*keyring.Key
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte("keyring")).Get([]byte("cookie"))
+		if data == nil {
+			return nil
+		}
+		var err error
+		keys, err = decodeCookieKeys(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *BoltStore) SaveCookieKeys(keys []*keyring.Key) error {
+	data, err := encodeCookieKeys(keys)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("keyring")).Put([]byte("cookie"), data)
+	})
+}
+
+func (s *BoltStore) InitCookieKeys(keys []*keyring.Key) error {
+	data, err := encodeCookieKeys(keys)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("keyring"))
+		if b.Get([]byte("cookie")) != nil {
+			return nil
+		}
+		return b.Put([]byte("cookie"), data)
+	})
+}
+
+func (s *BoltStore) LoadSigningKeys() ([]*oidc.Key, error) {
+	var keys []*oidc.Key
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte("keyring")).Get([]byte("signing"))
+		if data == nil {
+			return nil
+		}
+		var err error
+		keys, err = decodeSigningKeys(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *BoltStore) SaveSigningKeys(keys []*oidc.Key) error {
+	data, err := encodeSigningKeys(keys)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("keyring")).Put([]byte("signing"), data)
+	})
+}
+
+func (s *BoltStore) InitSigningKeys(keys []*oidc.Key) error {
+	data, err := encodeSigningKeys(keys)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("keyring"))
+		if b.Get([]byte("signing")) != nil {
+			return nil
+		}
+		return b.Put([]byte("signing"), data)
+	})
 }
 
 func (s *BoltStore) Close() error {
@@ -1,18 +1,36 @@
 package bolt
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/hasher"
+	"github.com/jllopis/try5/keyring"
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/oauth"
+	"github.com/jllopis/try5/oidc"
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/ticket"
+	"github.com/jllopis/try5/webauthn"
 )
 
 func TestAccount(t *testing.T) {
 	opts := &BoltStoreOptions{
-		Dbpath:  "/tmp/test.db",
+		Dbpath:  filepath.Join(os.TempDir(), "try5_test.db"),
 		Timeout: 5 * time.Second,
 	}
+	os.Remove(opts.Dbpath)
+	defer os.Remove(opts.Dbpath)
 
 	account, err := account.NewAccount("testaccount@dom.local", "Test account", "SuperDifficultPass")
 	if err != nil {
@@ -32,7 +50,7 @@ func TestAccount(t *testing.T) {
 
 	u, err := m.LoadAccount(*savedAccount.UID)
 	if err != nil {
-		t.Fatal("Error from boltdb store: %v", err)
+		t.Fatal("Error from boltdb store: ", err)
 	}
 	if u == nil {
 		t.Fatal("Error getting account from boltdb store")
@@ -40,12 +58,627 @@ func TestAccount(t *testing.T) {
 	fmt.Printf("Got from store: %#v\n", u)
 
 	u2, err := m.LoadAccount("")
-	if err != nil {
-		t.Fatal("Error from boltdb store: %v", err)
-	}
-	if u2 != nil {
+	if err == nil || u2 != nil {
 		t.Fatal("Got inexistent account from boltdb store")
 		fmt.Printf("Got from store: %#v\n", u2)
 	}
+	m.Close()
+}
 
+func TestUniqueEmail(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_email_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 1})
+	if m == nil {
+		t.Fatal("Error opening boltdb store")
+	}
+	defer m.Close()
+
+	a1, _ := account.NewAccount("Unique@Dom.local", "First", "SuperDifficultPass")
+	if _, err := m.SaveAccount(a1); err != nil {
+		t.Fatal("Error saving account: ", err)
+	}
+	a2, _ := account.NewAccount(" unique@dom.LOCAL", "Second", "SuperDifficultPass")
+	if _, err := m.SaveAccount(a2); err != store.ErrEmailTaken {
+		t.Fatal("Expected ErrEmailTaken, got: ", err)
+	}
+	found, err := m.GetAccountByEmail("UNIQUE@dom.local")
+	if err != nil || *found.UID != *a1.UID {
+		t.Fatal("Account not found by email: ", err)
+	}
+
+	// changing the email frees the old one
+	email := "other@dom.local"
+	a1.Email = &email
+	if _, err := m.SaveAccount(a1); err != nil {
+		t.Fatal("Error updating account: ", err)
+	}
+	if _, err := m.GetAccountByEmail("unique@dom.local"); err == nil {
+		t.Fatal("Old email still indexed")
+	}
+	if _, err := m.SaveAccount(a2); err != nil {
+		t.Fatal("Error saving account with a freed email: ", err)
+	}
+	if n, err := m.DeleteAccount(*a2.UID); err != nil || n != 1 {
+		t.Fatal("Error deleting account: ", err)
+	}
+	if _, err := m.GetAccountByEmail("unique@dom.local"); err == nil {
+		t.Fatal("Deleted account still indexed")
+	}
+}
+
+func TestListAccounts(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_list_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 1})
+	if m == nil {
+		t.Fatal("Error opening boltdb store")
+	}
+	defer m.Close()
+
+	names := []string{"Carol", "alice", "Bob", "dave", "Eve"}
+	for _, name := range names {
+		a, _ := account.NewAccount(name+"@dom.local", name, "SuperDifficultPass")
+		if _, err := m.SaveAccount(a); err != nil {
+			t.Fatal("Error saving account: ", err)
+		}
+	}
+	f := false
+	eve, _ := m.GetAccountByEmail("eve@dom.local")
+	eve.Active = &f
+	if _, err := m.SaveAccount(eve); err != nil {
+		t.Fatal("Error updating account: ", err)
+	}
+
+	list := func(q *store.AccountQuery) []string {
+		var got []string
+		for {
+			page, err := m.ListAccounts(q)
+			if err != nil {
+				t.Fatal("Error listing accounts: ", err)
+			}
+			for _, a := range page.Accounts {
+				got = append(got, *a.Name)
+			}
+			if page.NextCursor == "" {
+				return got
+			}
+			q.Cursor = page.NextCursor
+		}
+	}
+	tests := []struct {
+		q    *store.AccountQuery
+		want string
+	}{
+		{&store.AccountQuery{Limit: 2}, "[Carol alice Bob dave Eve]"},
+		{&store.AccountQuery{Limit: 2, Desc: true}, "[Eve dave Bob alice Carol]"},
+		{&store.AccountQuery{Limit: 2, Sort: store.SortName}, "[alice Bob Carol dave Eve]"},
+		{&store.AccountQuery{Limit: 3, Sort: store.SortEmail, Desc: true}, "[Eve dave Carol Bob alice]"},
+		{&store.AccountQuery{Limit: 1, Sort: store.SortEmail, EmailPrefix: "D"}, "[dave]"},
+		{&store.AccountQuery{Limit: 2, Active: &f}, "[Eve]"},
+		{&store.AccountQuery{Limit: 1, Sort: store.SortName, Name: "A"}, "[alice Carol dave]"},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprint(list(test.q)); got != test.want {
+			t.Fatalf("ListAccounts(%+v) = %s, want %s", test.q, got, test.want)
+		}
+	}
+	if _, err := m.ListAccounts(&store.AccountQuery{Cursor: "not a cursor"}); err != store.ErrInvalidCursor {
+		t.Fatal("Expected ErrInvalidCursor, got: ", err)
+	}
+}
+
+func TestMigrateGob(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_migrate_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+
+	// write an account the way the first versions of the store did
+	acc, err := account.NewAccount("gob@dom.local", "Gob account", "SuperDifficultPass")
+	if err != nil {
+		t.Fatal("Error creating account: ", err)
+	}
+	uid := "7ecee355-537b-492c-ab23-6a41219959d1"
+	acc.UID = &uid
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(acc); err != nil {
+		t.Fatal("Error encoding account: ", err)
+	}
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal("Error opening bolt file: ", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("accounts"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(uid), buf.Bytes())
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal("Error writing gob account: ", err)
+	}
+
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 1})
+	if m == nil {
+		t.Fatal("Error opening boltdb store")
+	}
+	defer m.Close()
+	if v, err := m.DataVersion(); err != nil || v != migrations[len(migrations)-1].version {
+		t.Fatal("Wrong data version: ", v, err)
+	}
+	u, err := m.LoadAccount(uid)
+	if err != nil {
+		t.Fatal("Error loading migrated account: ", err)
+	}
+	if *u.Email != *acc.Email || *u.Password != *acc.Password {
+		t.Fatal("Migrated account does not match: ", *u.Email)
+	}
+}
+
+func TestTickets(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_tickets_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 5 * time.Second})
+	if m == nil {
+		t.Fatal("Error creating boltdb store")
+	}
+	defer m.Close()
+
+	tk, token, err := ticket.New(ticket.PasswordReset, "account-1", time.Hour)
+	if err != nil {
+		t.Fatal("Error creating ticket: ", err)
+	}
+	if err := m.SaveTicket(tk); err != nil {
+		t.Fatal("Error saving ticket: ", err)
+	}
+	other, _, _ := ticket.New(ticket.PasswordReset, "account-2", time.Hour)
+	if err := m.SaveTicket(other); err != nil {
+		t.Fatal("Error saving ticket: ", err)
+	}
+	loaded, err := m.LoadTicket(ticket.ID(token))
+	if err != nil || loaded.AccountUID != "account-1" || loaded.Check(ticket.PasswordReset) != nil {
+		t.Fatal("Ticket not found by its token: ", err)
+	}
+	if n, err := m.DeleteAccountTickets("account-1", ticket.PasswordReset); err != nil || n != 1 {
+		t.Fatal("Expected 1 ticket deleted, got ", n, err)
+	}
+	if _, err := m.LoadTicket(tk.ID); err != store.ErrTicketNotFound {
+		t.Fatal("Ticket not deleted: ", err)
+	}
+	if n, _ := m.DeleteTicket(other.ID); n != 1 {
+		t.Fatal("Ticket of another account deleted")
+	}
+	if n, _ := m.DeleteTicket(other.ID); n != 0 {
+		t.Fatal("Ticket deleted twice")
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_signatures_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 5 * time.Second})
+	if m == nil {
+		t.Fatal("Error creating boltdb store")
+	}
+	defer m.Close()
+
+	if ok, err := m.RecordSignature("sig-1", time.Now().Add(time.Hour)); err != nil || !ok {
+		t.Fatal("Error recording signature: ", err)
+	}
+	if ok, _ := m.RecordSignature("sig-1", time.Now().Add(time.Hour)); ok {
+		t.Fatal("Signature recorded twice")
+	}
+	if ok, _ := m.RecordSignature("sig-2", time.Now().Add(-time.Second)); !ok {
+		t.Fatal("Error recording signature")
+	}
+	if ok, _ := m.RecordSignature("sig-2", time.Now().Add(time.Hour)); !ok {
+		t.Fatal("Expired signature still recorded")
+	}
+	m.RecordSignature("sig-3", time.Now().Add(-time.Second))
+	if n, err := m.DeleteExpiredSignatures(); err != nil || n != 1 {
+		t.Fatal("Expected 1 expired signature deleted, got ", n, err)
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_attempts_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 5 * time.Second})
+	if m == nil {
+		t.Fatal("Error creating boltdb store")
+	}
+	defer m.Close()
+
+	p, key, now := lockout.DefaultPolicy, lockout.AccountKey("jdoe@example.com"), time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.IncrementAttempts(p, key, p.MaxFailures, now); err != nil {
+				t.Error("Error incrementing attempts: ", err)
+			}
+		}()
+	}
+	wg.Wait()
+	a, err := m.LoadAttempts(key)
+	if err != nil || a.Failures != 10 || a.Locked(now) != p.Lockout {
+		t.Fatal("Failed attempts lost or not locked: ", a, err)
+	}
+	if a, _ = m.IncrementAttempts(p, key, p.MaxFailures, now.Add(p.Lockout)); a.Failures != 1 || a.LockedUntil != nil {
+		t.Fatal("Failures not reset after the lockout: ", a.Failures)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_credentials_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 5 * time.Second})
+	if m == nil {
+		t.Fatal("Error creating boltdb store")
+	}
+	defer m.Close()
+
+	for i, uid := range []string{"account-1", "account-1", "account-2"} {
+		c := &webauthn.Credential{ID: fmt.Sprintf("cred-%d", i), AccountUID: uid, PublicKey: []byte{1, 2, 3}, SignCount: 1, Created: time.Now().UTC()}
+		if err := m.SaveCredential(c); err != nil {
+			t.Fatal("Error saving credential: ", err)
+		}
+	}
+	creds, err := m.LoadAccountCredentials("account-1")
+	if err != nil || len(creds) != 2 {
+		t.Fatal("Expected 2 credentials, got ", len(creds), err)
+	}
+	creds[0].SignCount = 7
+	if err := m.SaveCredential(creds[0]); err != nil {
+		t.Fatal("Error updating credential: ", err)
+	}
+	c, err := m.LoadCredential(creds[0].ID)
+	if err != nil || c.SignCount != 7 || !bytes.Equal(c.PublicKey, []byte{1, 2, 3}) {
+		t.Fatalf("Credential not updated: %+v %v", c, err)
+	}
+	if n, err := m.DeleteCredential(c.ID); err != nil || n != 1 {
+		t.Fatal("Expected 1 credential deleted, got ", n, err)
+	}
+	if creds, _ := m.LoadAccountCredentials("account-1"); len(creds) != 1 {
+		t.Fatal("Credential still indexed: ", len(creds))
+	}
+	if _, err := m.LoadCredential(c.ID); err != store.ErrCredentialNotFound {
+		t.Fatal("Credential not deleted: ", err)
+	}
+
+	expired, _, _ := ticket.New(ticket.WebAuthnLogin, "", -time.Minute)
+	valid, _, _ := ticket.New(ticket.WebAuthnLogin, "", time.Minute)
+	m.SaveTicket(expired)
+	m.SaveTicket(valid)
+	if n, err := m.DeleteExpiredTickets(); err != nil || n != 1 {
+		t.Fatal("Expected 1 expired ticket deleted, got ", n, err)
+	}
+	if _, err := m.LoadTicket(valid.ID); err != nil {
+		t.Fatal("Valid ticket deleted: ", err)
+	}
+}
+
+func TestOAuth(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_oauth_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 5 * time.Second})
+	if m == nil {
+		t.Fatal("Error creating boltdb store")
+	}
+	defer m.Close()
+
+	c, _, err := oauth.NewClient("app", false, []string{"https://app.local/cb"}, nil, []string{"read"})
+	if err != nil {
+		t.Fatal("Error creating client: ", err)
+	}
+	if err := m.SaveClient(c); err != nil {
+		t.Fatal("Error saving client: ", err)
+	}
+	if l, err := m.LoadClient(c.ID); err != nil || l.SecretHash != c.SecretHash || len(l.RedirectURIs) != 1 {
+		t.Fatal("Error loading client: ", err)
+	}
+	save := func(typ oauth.TokenType, grant string, ttl time.Duration) *oauth.Token {
+		tk, _, err := oauth.NewToken(typ, c.ID, "account-1", "read", grant, ttl)
+		if err != nil {
+			t.Fatal("Error creating token: ", err)
+		}
+		if err := m.SaveOAuthToken(tk); err != nil {
+			t.Fatal("Error saving token: ", err)
+		}
+		return tk
+	}
+	access := save(oauth.Access, "grant-1", time.Hour)
+	save(oauth.Refresh, "grant-1", time.Hour)
+	other := save(oauth.Access, "grant-2", time.Hour)
+	save(oauth.Access, "grant-2", -time.Second)
+
+	if n, err := m.DeleteExpiredOAuthTokens(); err != nil || n != 1 {
+		t.Fatal("Expected 1 expired token deleted, got ", n, err)
+	}
+	if n, err := m.DeleteOAuthGrant("grant-1"); err != nil || n != 2 {
+		t.Fatal("Expected 2 tokens of the grant deleted, got ", n, err)
+	}
+	if _, err := m.LoadOAuthToken(access.ID); !store.IsNotFound(err) {
+		t.Fatal("Token of a deleted grant found: ", err)
+	}
+	if _, err := m.LoadOAuthToken(other.ID); err != nil {
+		t.Fatal("Token of another grant deleted: ", err)
+	}
+	if n, err := m.DeleteClient(c.ID); err != nil || n != 1 {
+		t.Fatal("Error deleting client: ", n, err)
+	}
+	if _, err := m.LoadOAuthToken(other.ID); !store.IsNotFound(err) {
+		t.Fatal("Token of a deleted client found: ", err)
+	}
+
+	// the tokens of an account are revoked together, whatever their grant
+	save(oauth.Access, "grant-3", time.Hour)
+	save(oauth.Refresh, "grant-4", time.Hour)
+	if n, err := m.DeleteAccountOAuthTokens("account-2"); err != nil || n != 0 {
+		t.Fatal("Tokens of another account deleted: ", n, err)
+	}
+	if n, err := m.DeleteAccountOAuthTokens("account-1"); err != nil || n != 2 {
+		t.Fatal("Expected 2 tokens of the account deleted, got ", n, err)
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_federation_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 5 * time.Second})
+	if m == nil {
+		t.Fatal("Error creating boltdb store")
+	}
+	defer m.Close()
+
+	p := &federation.Provider{ID: "corp", Issuer: "https://login.example.com", ClientID: "try5", ClientSecret: "s3cr3t"}
+	if err := p.Check(); err != nil {
+		t.Fatal("Error checking provider: ", err)
+	}
+	if err := m.SaveFederationProvider(p); err != nil {
+		t.Fatal("Error saving provider: ", err)
+	}
+	if l, err := m.LoadFederationProvider("corp"); err != nil || l.ClientSecret != "s3cr3t" || l.Scope != federation.DefaultScope {
+		t.Fatal("Error loading provider: ", err)
+	}
+	now := time.Now().UTC()
+	for i, sub := range []string{"sub-1", "sub-2"} {
+		ident := &federation.Identity{ProviderID: "corp", Subject: sub, AccountUID: "account-1", Created: now.Add(time.Duration(i) * time.Second)}
+		if err := m.SaveIdentity(ident); err != nil {
+			t.Fatal("Error saving identity: ", err)
+		}
+	}
+	if l, err := m.LoadAccountIdentities("account-1"); err != nil || len(l) != 2 || l[0].Subject != "sub-1" {
+		t.Fatal("Error loading account identities: ", err, l)
+	}
+	// linking an identity to another account moves it
+	if err := m.SaveIdentity(&federation.Identity{ProviderID: "corp", Subject: "sub-2", AccountUID: "account-2", Created: now}); err != nil {
+		t.Fatal("Error saving identity: ", err)
+	}
+	if l, _ := m.LoadAccountIdentities("account-1"); len(l) != 1 {
+		t.Fatal("Identity not moved: ", l)
+	}
+	if n, err := m.DeleteIdentity("corp", "sub-1"); err != nil || n != 1 {
+		t.Fatal("Error deleting identity: ", err)
+	}
+	if _, err := m.LoadIdentity("corp", "sub-1"); err != store.ErrIdentityNotFound {
+		t.Fatal("Deleted identity found: ", err)
+	}
+	// the identities go away with their provider
+	if n, err := m.DeleteFederationProvider("corp"); err != nil || n != 1 {
+		t.Fatal("Error deleting provider: ", err)
+	}
+	if _, err := m.LoadIdentity("corp", "sub-2"); err != store.ErrIdentityNotFound {
+		t.Fatal("Identity of a deleted provider found: ", err)
+	}
+	if l, _ := m.LoadAccountIdentities("account-2"); len(l) != 0 {
+		t.Fatal("Index of a deleted provider found: ", l)
+	}
+}
+
+func TestMigrateDuplicatedEmails(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_migrate_dups_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+
+	// older versions stored accounts sharing an email, up to data version 1
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal("Error opening bolt file: ", err)
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		v := make([]byte, 8)
+		v[7] = 1
+		if err := meta.Put(versionKey, v); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucketIfNotExists([]byte("accounts"))
+		if err != nil {
+			return err
+		}
+		for _, a := range []struct{ uid, email string }{{"uid-1", "dup@dom.local"}, {"uid-2", "other@dom.local"}, {"uid-3", "Dup@Dom.local"}} {
+			acc, _ := account.NewAccount(a.email, "Account", "SuperDifficultPass")
+			data, err := encodeAccount(acc)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(a.uid), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Error writing accounts: ", err)
+	}
+
+	err = migrate(db)
+	if err == nil || !strings.Contains(err.Error(), "dup@dom.local (uid-1, uid-3)") || strings.Contains(err.Error(), "other@") {
+		t.Fatal("Expected the duplicated email and its accounts, got: ", err)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if v := dataVersion(tx); v != 1 {
+			t.Fatal("Data version changed by a failed migration: ", v)
+		}
+		return nil
+	})
+}
+
+func TestSaveAccountHashError(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_hash_error_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 5 * time.Second})
+	if m == nil {
+		t.Fatal("Error creating boltdb store")
+	}
+	defer m.Close()
+	acc, _ := account.NewAccount("jdoe@dom.local", "Jane Doe", "SuperDifficultPass")
+	if _, err := m.SaveAccount(acc); err != nil {
+		t.Fatal("Error saving account: ", err)
+	}
+
+	// scrypt refuses N = 1
+	defer func(h *hasher.Manager) { account.PasswordHasher = h }(account.PasswordHasher)
+	account.PasswordHasher = hasher.New(&hasher.Scrypt{LogN: 0, R: 8, P: 1})
+	email, name, password := "other@dom.local", "Other", "AnotherDifficultPass"
+	if _, err := m.SaveAccount(&account.Account{Email: &email, Name: &name, Password: &password}); err == nil {
+		t.Fatal("Account saved with a failed hash")
+	}
+	if _, err := m.GetAccountByEmail(email); !store.IsNotFound(err) {
+		t.Fatal("Account stored with a failed hash: ", err)
+	}
+	update := &account.Account{UID: acc.UID, Email: acc.Email, Name: acc.Name, Password: &password}
+	if _, err := m.SaveAccount(update); err == nil {
+		t.Fatal("Password updated with a failed hash")
+	}
+	if loaded, _ := m.LoadAccount(*acc.UID); *loaded.Password != *acc.Password {
+		t.Fatal("Password changed by a failed hash: ", *loaded.Password)
+	}
+}
+
+func TestSaveAccountWithoutPassword(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_no_password_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 5 * time.Second})
+	if m == nil {
+		t.Fatal("Error creating boltdb store")
+	}
+	defer m.Close()
+
+	email, name := "jdoe@dom.local", "Jane Doe"
+	acc, err := m.SaveAccount(&account.Account{Email: &email, Name: &name})
+	if err != nil {
+		t.Fatal("Error saving account without password: ", err)
+	}
+	loaded, err := m.GetAccountByEmail(email)
+	if err != nil || loaded.Password != nil || *loaded.UID != *acc.UID {
+		t.Fatal("Account saved with a password: ", err)
+	}
+	// the password set later, ie. with a reset, leaves the history empty
+	password := "SuperDifficultPass"
+	if _, err := m.SaveAccount(&account.Account{UID: acc.UID, Email: &email, Name: &name, Password: &password}); err != nil {
+		t.Fatal("Error setting password: ", err)
+	}
+	if loaded, _ = m.LoadAccount(*acc.UID); len(loaded.PasswordHistory) != 0 {
+		t.Fatal("Missing password remembered: ", loaded.PasswordHistory)
+	}
+	if _, err := loaded.MatchPassword(password); err != nil {
+		t.Fatal("Password not set: ", err)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_not_found_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 5 * time.Second})
+	if m == nil {
+		t.Fatal("Error creating boltdb store")
+	}
+	defer m.Close()
+
+	tests := []struct {
+		name string
+		err  func() error
+		want error
+	}{
+		{"LoadAccount", func() error { _, err := m.LoadAccount("unknown"); return err }, store.ErrAccountNotFound},
+		{"GetAccountByEmail", func() error { _, err := m.GetAccountByEmail("unknown@dom.local"); return err }, store.ErrAccountNotFound},
+		{"SetMFA", func() error { return m.SetMFA("unknown", nil) }, store.ErrAccountNotFound},
+		{"LoadKey", func() error { _, err := m.LoadKey("unknown"); return err }, store.ErrKeyNotFound},
+		{"LoadRole", func() error { _, err := m.LoadRole(99); return err }, store.ErrRoleNotFound},
+		{"LoadSession", func() error { _, err := m.LoadSession("unknown"); return err }, store.ErrSessionNotFound},
+		{"LoadTicket", func() error { _, err := m.LoadTicket("unknown"); return err }, store.ErrTicketNotFound},
+	}
+	for _, tt := range tests {
+		if err := tt.err(); !store.IsNotFound(err) || err != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestInitSigningKeys(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_signing_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 5 * time.Second})
+	if m == nil {
+		t.Fatal("Error creating boltdb store")
+	}
+	defer m.Close()
+
+	first := []*oidc.Key{{ID: "first", PrivateKey: []byte("sealed:first"), Created: time.Now()}}
+	if err := m.InitSigningKeys(first); err != nil {
+		t.Fatal("Error saving signing keys: ", err)
+	}
+	// the keys of a replica that started later are not saved
+	if err := m.InitSigningKeys([]*oidc.Key{{ID: "second", PrivateKey: []byte("sealed:second")}}); err != nil {
+		t.Fatal("Error saving signing keys: ", err)
+	}
+	keys, err := m.LoadSigningKeys()
+	if err != nil || len(keys) != 1 || keys[0].ID != "first" || string(keys[0].PrivateKey) != "sealed:first" {
+		t.Fatal("Signing keys replaced: ", keys, err)
+	}
+}
+
+func TestInitCookieKeys(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "try5_cookie_test.db")
+	os.Remove(path)
+	defer os.Remove(path)
+	m := NewBoltStore(&BoltStoreOptions{Dbpath: path, Timeout: 5 * time.Second})
+	if m == nil {
+		t.Fatal("Error creating boltdb store")
+	}
+	defer m.Close()
+
+	first := keyring.NewKey()
+	if err := m.InitCookieKeys([]*keyring.Key{first}); err != nil {
+		t.Fatal("Error saving cookie keys: ", err)
+	}
+	// the keys of a replica that started later are not saved
+	if err := m.InitCookieKeys([]*keyring.Key{keyring.NewKey()}); err != nil {
+		t.Fatal("Error saving cookie keys: ", err)
+	}
+	keys, err := m.LoadCookieKeys()
+	if err != nil || len(keys) != 1 || keys[0].ID != first.ID || !bytes.Equal(keys[0].HashKey, first.HashKey) {
+		t.Fatal("Cookie keys replaced: ", keys, err)
+	}
 }
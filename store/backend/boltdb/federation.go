@@ -0,0 +1,212 @@
+package bolt
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/store"
+)
+
+var (
+	providersBucket  = []byte("federation_providers")
+	identitiesBucket = []byte("identities")
+	// identitiesByAccount indexes the identities by account, the keys are the account
+	// uid and the key of the identity separated by a zero byte
+	identitiesByAccount = []byte("identities_by_account")
+)
+
+// identityKey is the key of an identity, the provider id and the subject separated by a zero byte
+func identityKey(providerID, subject string) []byte {
+	return []byte(providerID + "\x00" + subject)
+}
+
+func identityIndexKey(uid string, key []byte) []byte {
+	return append([]byte(uid+"\x00"), key...)
+}
+
+func (s *BoltStore) LoadFederationProvider(id string) (*federation.Provider, error) {
+	var p *federation.Provider
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket(providersBucket).Get([]byte(id))
+		if data == nil {
+			return store.ErrProviderNotFound
+		}
+		var err error
+		p, err = decodeProvider(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *BoltStore) LoadAllFederationProviders() ([]*federation.Provider, error) {
+	var providers []*federation.Provider
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(providersBucket).ForEach(func(k, v []byte) error {
+			p, err := decodeProvider(v)
+			if err != nil {
+				return err
+			}
+			providers = append(providers, p)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return providers, nil
+}
+
+func (s *BoltStore) SaveFederationProvider(p *federation.Provider) error {
+	if p.ID == "" {
+		return store.ErrMissingID
+	}
+	data, err := encodeProvider(p)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(providersBucket).Put([]byte(p.ID), data)
+	})
+}
+
+// DeleteFederationProvider also deletes the identities linked from the provider
+func (s *BoltStore) DeleteFederationProvider(id string) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(providersBucket)
+		if b.Get([]byte(id)) == nil {
+			return nil
+		}
+		n = 1
+		if err := b.Delete([]byte(id)); err != nil {
+			return err
+		}
+		ib, idx := tx.Bucket(identitiesBucket), tx.Bucket(identitiesByAccount)
+		prefix := []byte(id + "\x00")
+		var identities []*federation.Identity
+		c := ib.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			i, err := decodeIdentity(v)
+			if err != nil {
+				return err
+			}
+			identities = append(identities, i)
+		}
+		for _, i := range identities {
+			key := identityKey(i.ProviderID, i.Subject)
+			if err := ib.Delete(key); err != nil {
+				return err
+			}
+			if err := idx.Delete(identityIndexKey(i.AccountUID, key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *BoltStore) LoadIdentity(providerID, subject string) (*federation.Identity, error) {
+	var i *federation.Identity
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket(identitiesBucket).Get(identityKey(providerID, subject))
+		if data == nil {
+			return store.ErrIdentityNotFound
+		}
+		var err error
+		i, err = decodeIdentity(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (s *BoltStore) LoadAccountIdentities(uid string) ([]*federation.Identity, error) {
+	var res []*federation.Identity
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(identitiesBucket)
+		prefix := []byte(uid + "\x00")
+		c := tx.Bucket(identitiesByAccount).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			data := b.Get(k[len(prefix):])
+			if data == nil {
+				continue
+			}
+			i, err := decodeIdentity(data)
+			if err != nil {
+				return err
+			}
+			res = append(res, i)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Created.Before(res[b].Created) })
+	return res, nil
+}
+
+// SaveIdentity creates or updates the identity, moving its index entry if it is
+// linked to another account
+func (s *BoltStore) SaveIdentity(i *federation.Identity) error {
+	if i.ProviderID == "" || i.Subject == "" {
+		return store.ErrMissingID
+	}
+	data, err := encodeIdentity(i)
+	if err != nil {
+		return err
+	}
+	key := identityKey(i.ProviderID, i.Subject)
+	return s.update(func(tx *bolt.Tx) error {
+		b, idx := tx.Bucket(identitiesBucket), tx.Bucket(identitiesByAccount)
+		if old := b.Get(key); old != nil {
+			prev, err := decodeIdentity(old)
+			if err != nil {
+				return err
+			}
+			if err := idx.Delete(identityIndexKey(prev.AccountUID, key)); err != nil {
+				return err
+			}
+		}
+		if err := b.Put(key, data); err != nil {
+			return err
+		}
+		return idx.Put(identityIndexKey(i.AccountUID, key), []byte{})
+	})
+}
+
+func (s *BoltStore) DeleteIdentity(providerID, subject string) (int, error) {
+	n := 0
+	key := identityKey(providerID, subject)
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(identitiesBucket)
+		data := b.Get(key)
+		if data == nil {
+			return nil
+		}
+		i, err := decodeIdentity(data)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(identitiesByAccount).Delete(identityIndexKey(i.AccountUID, key)); err != nil {
+			return err
+		}
+		n = 1
+		return b.Delete(key)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
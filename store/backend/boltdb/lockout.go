@@ -0,0 +1,103 @@
+package bolt
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/store"
+)
+
+var attemptsBucket = []byte("attempts")
+
+func (s *BoltStore) LoadAttempts(key string) (*lockout.Attempts, error) {
+	var a *lockout.Attempts
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket(attemptsBucket).Get([]byte(key))
+		if data == nil {
+			return store.ErrLockoutNotFound
+		}
+		var err error
+		a, err = decodeAttempts(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *BoltStore) LoadAllAttempts() ([]*lockout.Attempts, error) {
+	var attempts []*lockout.Attempts
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(attemptsBucket).ForEach(func(k, v []byte) error {
+			a, err := decodeAttempts(v)
+			if err != nil {
+				return err
+			}
+			attempts = append(attempts, a)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+func (s *BoltStore) SaveAttempts(a *lockout.Attempts) error {
+	if a.Key == "" {
+		return store.ErrMissingID
+	}
+	data, err := encodeAttempts(a)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(attemptsBucket).Put([]byte(a.Key), data)
+	})
+}
+
+// IncrementAttempts reads and writes the attempts in the same transaction, bolt
+// serializes them
+func (s *BoltStore) IncrementAttempts(p lockout.Policy, key string, max int, now time.Time) (*lockout.Attempts, error) {
+	if key == "" {
+		return nil, store.ErrMissingID
+	}
+	var a *lockout.Attempts
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(attemptsBucket)
+		if data := b.Get([]byte(key)); data != nil {
+			var err error
+			if a, err = decodeAttempts(data); err != nil {
+				return err
+			}
+		}
+		a = p.Fail(a, key, max, now)
+		data, err := encodeAttempts(a)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *BoltStore) DeleteAttempts(key string) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(attemptsBucket)
+		if b.Get([]byte(key)) == nil {
+			return nil
+		}
+		n = 1
+		return b.Delete([]byte(key))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
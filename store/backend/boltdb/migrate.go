@@ -0,0 +1,233 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/keyring"
+	"github.com/jllopis/try5/rbac"
+	"github.com/jllopis/try5/session"
+)
+
+// migration rewrites the stored data from the previous version to version
+type migration struct {
+	version int
+	name    string
+	up      func(tx *bolt.Tx) error
+}
+
+// migrations are applied in order when the store is opened. The data version is
+// kept in the meta bucket.
+var migrations = []migration{
+	{1, "gob values to versioned json records", gobToRecords},
+	{2, "accounts_by_email index", indexEmails},
+	{3, "accounts_by_created and accounts_by_name indexes", indexSortKeys},
+}
+
+var (
+	metaBucket = []byte("meta")
+	versionKey = []byte("version")
+
+	ErrNewerData = errors.New("data written by a newer version of the store")
+)
+
+// DataVersion returns the version of the data in the file
+func (s *BoltStore) DataVersion() (int, error) {
+	v := 0
+	err := s.C.View(func(tx *bolt.Tx) error {
+		v = dataVersion(tx)
+		return nil
+	})
+	return v, err
+}
+
+func dataVersion(tx *bolt.Tx) int {
+	b := tx.Bucket(metaBucket)
+	if b == nil {
+		return 0
+	}
+	data := b.Get(versionKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(data))
+}
+
+// migrate applies the pending migrations in a single transaction, so a failure
+// leaves the file untouched.
+func migrate(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		current := dataVersion(tx)
+		if current > migrations[len(migrations)-1].version {
+			return ErrNewerData
+		}
+		for _, m := range migrations {
+			if m.version <= current {
+				continue
+			}
+			if err := m.up(tx); err != nil {
+				return fmt.Errorf("migration %d %s: %v", m.version, m.name, err)
+			}
+			if err := meta.Put(versionKey, itob(int64(m.version))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// gobToRecords converts the values gob encoded by the first versions of the store
+func gobToRecords(tx *bolt.Tx) error {
+	convert := func(bucket string, fn func(v []byte) ([]byte, error)) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		updated := make(map[string][]byte)
+		err := b.ForEach(func(k, v []byte) error {
+			data, err := fn(v)
+			if err != nil {
+				return fmt.Errorf("bucket %s key %x: %v", bucket, k, err)
+			}
+			updated[string(k)] = data
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range updated {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	steps := map[string]func(v []byte) ([]byte, error){
+		"accounts": func(v []byte) ([]byte, error) {
+			var a *account.Account
+			if err := gobDecode(v, &a); err != nil {
+				return nil, err
+			}
+			return encodeAccount(a)
+		},
+		"apikeys": func(v []byte) ([]byte, error) {
+			var k *apikey.Key
+			if err := gobDecode(v, &k); err != nil {
+				return nil, err
+			}
+			return encodeKey(k)
+		},
+		"sessions": func(v []byte) ([]byte, error) {
+			var s *session.Session
+			if err := gobDecode(v, &s); err != nil {
+				return nil, err
+			}
+			return encodeSession(s)
+		},
+		"roles": func(v []byte) ([]byte, error) {
+			var r *rbac.Role
+			if err := gobDecode(v, &r); err != nil {
+				return nil, err
+			}
+			return encodeRole(r)
+		},
+		"grants": func(v []byte) ([]byte, error) {
+			var g *rbac.Grant
+			if err := gobDecode(v, &g); err != nil {
+				return nil, err
+			}
+			return encodeGrant(g)
+		},
+		"account_roles": func(v []byte) ([]byte, error) {
+			var ids []int64
+			if err := gobDecode(v, &ids); err != nil {
+				return nil, err
+			}
+			return encode(ids)
+		},
+		"keyring": func(v []byte) ([]byte, error) {
+			var keys []*keyring.Key
+			if err := gobDecode(v, &keys); err != nil {
+				return nil, err
+			}
+			return encodeCookieKeys(keys)
+		},
+	}
+	for bucket, fn := range steps {
+		if err := convert(bucket, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexEmails fills the accounts_by_email index. Older versions did not enforce unique
+// emails, if several accounts share one the migration fails listing them, so they are
+// fixed with the previous version instead of leaving all but one out of the index.
+func indexEmails(tx *bolt.Tx) error {
+	idx, err := tx.CreateBucketIfNotExists(emailIndex)
+	if err != nil {
+		return err
+	}
+	uids := map[string][]string{}
+	err = tx.Bucket([]byte("accounts")).ForEach(func(k, v []byte) error {
+		a, err := decodeAccount(v)
+		if err != nil {
+			return err
+		}
+		if a.Email == nil {
+			return nil
+		}
+		key := emailKey(*a.Email)
+		uids[string(key)] = append(uids[string(key)], string(k))
+		return idx.Put(key, append([]byte{}, k...))
+	})
+	if err != nil {
+		return err
+	}
+	var dups []string
+	for email, accounts := range uids {
+		if len(accounts) > 1 {
+			dups = append(dups, fmt.Sprintf("%s (%s)", email, strings.Join(accounts, ", ")))
+		}
+	}
+	if len(dups) > 0 {
+		sort.Strings(dups)
+		return fmt.Errorf("accounts sharing an email: %s", strings.Join(dups, "; "))
+	}
+	return nil
+}
+
+// indexSortKeys fills the indexes used to list the accounts sorted
+func indexSortKeys(tx *bolt.Tx) error {
+	for _, name := range [][]byte{createdIndex, nameIndex} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket([]byte("accounts")).ForEach(func(k, v []byte) error {
+		a, err := decodeAccount(v)
+		if err != nil {
+			return err
+		}
+		uid := string(k)
+		a.UID = &uid
+		return indexAccount(tx, a)
+	})
+}
+
+func gobDecode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(v)
+}
@@ -0,0 +1,235 @@
+package bolt
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/jllopis/try5/oauth"
+	"github.com/jllopis/try5/store"
+)
+
+var (
+	clientsBucket     = []byte("oauth_clients")
+	oauthTokensBucket = []byte("oauth_tokens")
+	// oauthTokensByGrant indexes the tokens by grant, the keys are the grant id
+	// and the token id separated by a zero byte
+	oauthTokensByGrant = []byte("oauth_tokens_by_grant")
+)
+
+func grantIndexKey(grantID, id string) []byte {
+	return []byte(grantID + "\x00" + id)
+}
+
+func (s *BoltStore) LoadClient(id string) (*oauth.Client, error) {
+	var c *oauth.Client
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket(clientsBucket).Get([]byte(id))
+		if data == nil {
+			return store.ErrClientNotFound
+		}
+		var err error
+		c, err = decodeClient(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *BoltStore) LoadAllClients() ([]*oauth.Client, error) {
+	var clients []*oauth.Client
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(clientsBucket).ForEach(func(k, v []byte) error {
+			c, err := decodeClient(v)
+			if err != nil {
+				return err
+			}
+			clients = append(clients, c)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Created.Before(clients[j].Created) })
+	return clients, nil
+}
+
+func (s *BoltStore) SaveClient(c *oauth.Client) error {
+	if c.ID == "" {
+		return store.ErrMissingID
+	}
+	data, err := encodeClient(c)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(clientsBucket).Put([]byte(c.ID), data)
+	})
+}
+
+// DeleteClient also deletes the codes and tokens issued to the client
+func (s *BoltStore) DeleteClient(id string) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(clientsBucket)
+		if b.Get([]byte(id)) == nil {
+			return nil
+		}
+		n = 1
+		if err := b.Delete([]byte(id)); err != nil {
+			return err
+		}
+		_, err := deleteOAuthTokens(tx, func(t *oauth.Token) bool { return t.ClientID == id })
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *BoltStore) LoadOAuthToken(id string) (*oauth.Token, error) {
+	var t *oauth.Token
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket(oauthTokensBucket).Get([]byte(id))
+		if data == nil {
+			return store.ErrOAuthTokenNotFound
+		}
+		var err error
+		t, err = decodeOAuthToken(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *BoltStore) SaveOAuthToken(t *oauth.Token) error {
+	if t.ID == "" {
+		return store.ErrMissingID
+	}
+	data, err := encodeOAuthToken(t)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(oauthTokensBucket).Put([]byte(t.ID), data); err != nil {
+			return err
+		}
+		return tx.Bucket(oauthTokensByGrant).Put(grantIndexKey(t.GrantID, t.ID), []byte{})
+	})
+}
+
+func (s *BoltStore) DeleteOAuthToken(id string) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(oauthTokensBucket)
+		data := b.Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		t, err := decodeOAuthToken(data)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(oauthTokensByGrant).Delete(grantIndexKey(t.GrantID, t.ID)); err != nil {
+			return err
+		}
+		n = 1
+		return b.Delete([]byte(id))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *BoltStore) DeleteOAuthGrant(grantID string) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b, idx := tx.Bucket(oauthTokensBucket), tx.Bucket(oauthTokensByGrant)
+		prefix := []byte(grantID + "\x00")
+		var keys [][]byte
+		c := idx.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			id := k[len(prefix):]
+			if b.Get(id) != nil {
+				if err := b.Delete(id); err != nil {
+					return err
+				}
+				n++
+			}
+			if err := idx.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *BoltStore) DeleteAccountOAuthTokens(uid string) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		var err error
+		n, err = deleteOAuthTokens(tx, func(t *oauth.Token) bool { return t.AccountUID == uid })
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *BoltStore) DeleteExpiredOAuthTokens() (int, error) {
+	n := 0
+	now := time.Now()
+	err := s.update(func(tx *bolt.Tx) error {
+		var err error
+		n, err = deleteOAuthTokens(tx, func(t *oauth.Token) bool { return !now.Before(t.Expires) })
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// deleteOAuthTokens scans the tokens and deletes those matching del with their index entries
+func deleteOAuthTokens(tx *bolt.Tx, del func(t *oauth.Token) bool) (int, error) {
+	b, idx := tx.Bucket(oauthTokensBucket), tx.Bucket(oauthTokensByGrant)
+	var tokens []*oauth.Token
+	err := b.ForEach(func(k, v []byte) error {
+		t, err := decodeOAuthToken(v)
+		if err != nil {
+			return err
+		}
+		if del(t) {
+			tokens = append(tokens, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range tokens {
+		if err := b.Delete([]byte(t.ID)); err != nil {
+			return 0, err
+		}
+		if err := idx.Delete(grantIndexKey(t.GrantID, t.ID)); err != nil {
+			return 0, err
+		}
+	}
+	return len(tokens), nil
+}
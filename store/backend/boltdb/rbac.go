@@ -0,0 +1,344 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/jllopis/try5/rbac"
+	"github.com/jllopis/try5/store"
+)
+
+// itob returns an 8-byte big endian representation of v so the keys keep their order
+func itob(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func (s *BoltStore) LoadAllRoles() ([]*rbac.Role, error) {
+	var roles []*rbac.Role
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("roles")).ForEach(func(k, v []byte) error {
+			r, err := decodeRole(v)
+			if err != nil {
+				return err
+			}
+			roles = append(roles, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (s *BoltStore) LoadRole(id int64) (*rbac.Role, error) {
+	var r *rbac.Role
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte("roles")).Get(itob(id))
+		if data == nil {
+			return store.ErrRoleNotFound
+		}
+		var err error
+		r, err = decodeRole(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *BoltStore) GetRoleBySlug(slug string) (*rbac.Role, error) {
+	roles, err := s.LoadAllRoles()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range roles {
+		if r.Slug != nil && *r.Slug == slug {
+			return r, nil
+		}
+	}
+	return nil, store.ErrRoleNotFound
+}
+
+func (s *BoltStore) SaveRole(role *rbac.Role) (*rbac.Role, error) {
+	now := time.Now().UTC()
+	role.Updated = &now
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("roles"))
+		// slugs are unique
+		err := b.ForEach(func(k, v []byte) error {
+			r, err := decodeRole(v)
+			if err != nil {
+				return err
+			}
+			if *r.Slug == *role.Slug && (role.ID == nil || *r.ID != *role.ID) {
+				return store.ErrSlugTaken
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if role.ID == nil {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			id := int64(seq)
+			role.ID = &id
+			role.Created = &now
+		} else {
+			data := b.Get(itob(*role.ID))
+			if data == nil {
+				return store.ErrRoleNotFound
+			}
+			saved, err := decodeRole(data)
+			if err != nil {
+				return err
+			}
+			role.Created = saved.Created
+		}
+		data, err := encodeRole(role)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(*role.ID), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// DeleteRole removes the role along with the grants and account assignments that reference it
+func (s *BoltStore) DeleteRole(id int64) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("roles"))
+		if b.Get(itob(id)) == nil {
+			return nil
+		}
+		n = 1
+		if err := b.Delete(itob(id)); err != nil {
+			return err
+		}
+		gb := tx.Bucket([]byte("grants"))
+		var stale [][]byte
+		err := gb.ForEach(func(k, v []byte) error {
+			g, err := decodeGrant(v)
+			if err != nil {
+				return err
+			}
+			if *g.FromRole == id || *g.ToRole == id {
+				stale = append(stale, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := gb.Delete(k); err != nil {
+				return err
+			}
+		}
+		ab := tx.Bucket([]byte("account_roles"))
+		updated := make(map[string][]int64)
+		err = ab.ForEach(func(k, v []byte) error {
+			ids, err := decodeIDs(v)
+			if err != nil {
+				return err
+			}
+			if keep := removeID(ids, id); len(keep) != len(ids) {
+				updated[string(k)] = keep
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for uid, ids := range updated {
+			if err := putIDs(ab, uid, ids); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// LoadGrants returns the grants whose ToRole is roleID, that is, the roles inherited by roleID
+func (s *BoltStore) LoadGrants(roleID int64) ([]*rbac.Grant, error) {
+	var grants []*rbac.Grant
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("grants")).ForEach(func(k, v []byte) error {
+			g, err := decodeGrant(v)
+			if err != nil {
+				return err
+			}
+			if g.ToRole != nil && *g.ToRole == roleID {
+				grants = append(grants, g)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+func (s *BoltStore) SaveGrant(grant *rbac.Grant) (*rbac.Grant, error) {
+	if grant.FromRole == nil || grant.ToRole == nil {
+		return nil, rbac.ErrInvalidGrant
+	}
+	err := s.update(func(tx *bolt.Tx) error {
+		rb := tx.Bucket([]byte("roles"))
+		if rb.Get(itob(*grant.FromRole)) == nil || rb.Get(itob(*grant.ToRole)) == nil {
+			return store.ErrRoleNotFound
+		}
+		b := tx.Bucket([]byte("grants"))
+		if grant.ID == nil {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			id := int64(seq)
+			grant.ID = &id
+		}
+		data, err := encodeGrant(grant)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(*grant.ID), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+func (s *BoltStore) DeleteGrant(id int64) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("grants"))
+		if b.Get(itob(id)) == nil {
+			return nil
+		}
+		n = 1
+		return b.Delete(itob(id))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *BoltStore) LoadAccountRoles(uid string) ([]*rbac.Role, error) {
+	var roles []*rbac.Role
+	err := s.view(func(tx *bolt.Tx) error {
+		ids, err := decodeIDs(tx.Bucket([]byte("account_roles")).Get([]byte(uid)))
+		if err != nil {
+			return err
+		}
+		rb := tx.Bucket([]byte("roles"))
+		for _, id := range ids {
+			data := rb.Get(itob(id))
+			if data == nil {
+				continue
+			}
+			r, err := decodeRole(data)
+			if err != nil {
+				return err
+			}
+			roles = append(roles, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (s *BoltStore) AssignRole(uid string, roleID int64) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("roles")).Get(itob(roleID)) == nil {
+			return store.ErrRoleNotFound
+		}
+		if tx.Bucket([]byte("accounts")).Get([]byte(uid)) == nil {
+			return store.ErrAccountNotFound
+		}
+		b := tx.Bucket([]byte("account_roles"))
+		ids, err := decodeIDs(b.Get([]byte(uid)))
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if id == roleID {
+				return nil
+			}
+		}
+		return putIDs(b, uid, append(ids, roleID))
+	})
+}
+
+func (s *BoltStore) UnassignRole(uid string, roleID int64) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("account_roles"))
+		ids, err := decodeIDs(b.Get([]byte(uid)))
+		if err != nil {
+			return err
+		}
+		keep := removeID(ids, roleID)
+		if len(keep) == len(ids) {
+			return nil
+		}
+		n = 1
+		return putIDs(b, uid, keep)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func decodeIDs(data []byte) ([]int64, error) {
+	var ids []int64
+	if data == nil {
+		return ids, nil
+	}
+	if err := decode(data, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func putIDs(b *bolt.Bucket, uid string, ids []int64) error {
+	if len(ids) == 0 {
+		return b.Delete([]byte(uid))
+	}
+	data, err := encode(ids)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(uid), data)
+}
+
+func removeID(ids []int64, id int64) []int64 {
+	var keep []int64
+	for _, v := range ids {
+		if v != id {
+			keep = append(keep, v)
+		}
+	}
+	return keep
+}
@@ -0,0 +1,583 @@
+package bolt
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/keyring"
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/oauth"
+	"github.com/jllopis/try5/oidc"
+	"github.com/jllopis/try5/rbac"
+	"github.com/jllopis/try5/session"
+	"github.com/jllopis/try5/ticket"
+	"github.com/jllopis/try5/webauthn"
+)
+
+// The values are stored as an envelope: one byte with the record format followed by
+// the record encoded as JSON. The records are private to the store so the API
+// representation of the models (json tags, hidden fields) can change freely.
+// Changing a record in an incompatible way requires a new format and a migration
+// (see migrate.go) that rewrites the existing values.
+const recordFormat byte = 1
+
+var ErrRecordFormat = errors.New("unknown record format")
+
+func encode(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{recordFormat}, data...), nil
+}
+
+func decode(data []byte, v interface{}) error {
+	if len(data) == 0 || data[0] != recordFormat {
+		return ErrRecordFormat
+	}
+	return json.Unmarshal(data[1:], v)
+}
+
+type accountRecord struct {
+	UID      *string    `json:"uid"`
+	Email    *string    `json:"email"`
+	Name     *string    `json:"name,omitempty"`
+	Password *string    `json:"password,omitempty"`
+	Active   *bool      `json:"active,omitempty"`
+	Gravatar *string    `json:"gravatar,omitempty"`
+	Created  *time.Time `json:"created,omitempty"`
+	Updated  *time.Time `json:"updated,omitempty"`
+	Deleted  *bool      `json:"deleted,omitempty"`
+	// PasswordHistory was added after format 1, older records just lack it
+	PasswordHistory []string   `json:"password_history,omitempty"`
+	EmailVerified   *bool      `json:"email_verified,omitempty"`
+	MFA             *mfaRecord `json:"mfa,omitempty"`
+}
+
+type mfaRecord struct {
+	Secret        string     `json:"secret"`
+	Enabled       bool       `json:"enabled"`
+	Confirmed     *time.Time `json:"confirmed,omitempty"`
+	LastCounter   int64      `json:"last_counter"`
+	RecoveryCodes []string   `json:"recovery_codes,omitempty"`
+}
+
+func newMFARecord(m *account.MFA) *mfaRecord {
+	if m == nil {
+		return nil
+	}
+	return &mfaRecord{Secret: m.Secret, Enabled: m.Enabled, Confirmed: m.Confirmed, LastCounter: m.LastCounter, RecoveryCodes: m.RecoveryCodes}
+}
+
+func (r *mfaRecord) mfa() *account.MFA {
+	if r == nil {
+		return nil
+	}
+	return &account.MFA{Secret: r.Secret, Enabled: r.Enabled, Confirmed: r.Confirmed, LastCounter: r.LastCounter, RecoveryCodes: r.RecoveryCodes}
+}
+
+func newAccountRecord(a *account.Account) *accountRecord {
+	return &accountRecord{
+		UID:      a.UID,
+		Email:    a.Email,
+		Name:     a.Name,
+		Password: a.Password,
+		Active:   a.Active,
+		Gravatar: a.Gravatar,
+		Created:  a.Created,
+		Updated:  a.Updated,
+		Deleted:  a.Deleted,
+
+		PasswordHistory: a.PasswordHistory,
+		EmailVerified:   a.EmailVerified,
+		MFA:             newMFARecord(a.MFA),
+	}
+}
+
+func (r *accountRecord) account() *account.Account {
+	return &account.Account{
+		UID:      r.UID,
+		Email:    r.Email,
+		Name:     r.Name,
+		Password: r.Password,
+		Active:   r.Active,
+		Gravatar: r.Gravatar,
+		Created:  r.Created,
+		Updated:  r.Updated,
+		Deleted:  r.Deleted,
+
+		PasswordHistory: r.PasswordHistory,
+		EmailVerified:   r.EmailVerified,
+		MFA:             r.MFA.mfa(),
+	}
+}
+
+func encodeAccount(a *account.Account) ([]byte, error) {
+	return encode(newAccountRecord(a))
+}
+
+func decodeAccount(data []byte) (*account.Account, error) {
+	var r accountRecord
+	if err := decode(data, &r); err != nil {
+		return nil, err
+	}
+	return r.account(), nil
+}
+
+type keyRecord struct {
+	ID         *string    `json:"id"`
+	AccountUID *string    `json:"account_uid"`
+	Name       *string    `json:"name,omitempty"`
+	SecretHash *string    `json:"secret_hash,omitempty"`
+	SealedKey  *string    `json:"sealed_key,omitempty"`
+	Created    *time.Time `json:"created,omitempty"`
+	LastUsed   *time.Time `json:"last_used,omitempty"`
+	Revoked    *time.Time `json:"revoked,omitempty"`
+}
+
+func encodeKey(k *apikey.Key) ([]byte, error) {
+	return encode(&keyRecord{
+		ID:         k.ID,
+		AccountUID: k.AccountUID,
+		Name:       k.Name,
+		SecretHash: k.SecretHash,
+		SealedKey:  k.SealedKey,
+		Created:    k.Created,
+		LastUsed:   k.LastUsed,
+		Revoked:    k.Revoked,
+	})
+}
+
+func decodeKey(data []byte) (*apikey.Key, error) {
+	var r keyRecord
+	if err := decode(data, &r); err != nil {
+		return nil, err
+	}
+	return &apikey.Key{
+		ID:         r.ID,
+		AccountUID: r.AccountUID,
+		Name:       r.Name,
+		SecretHash: r.SecretHash,
+		SealedKey:  r.SealedKey,
+		Created:    r.Created,
+		LastUsed:   r.LastUsed,
+		Revoked:    r.Revoked,
+	}, nil
+}
+
+type sessionRecord struct {
+	ID         *string    `json:"id"`
+	AccountUID *string    `json:"account_uid"`
+	RemoteAddr *string    `json:"remote_addr,omitempty"`
+	UserAgent  *string    `json:"user_agent,omitempty"`
+	Created    *time.Time `json:"created,omitempty"`
+	LastSeen   *time.Time `json:"last_seen,omitempty"`
+	Expires    *time.Time `json:"expires,omitempty"`
+}
+
+func encodeSession(s *session.Session) ([]byte, error) {
+	return encode(&sessionRecord{
+		ID:         s.ID,
+		AccountUID: s.AccountUID,
+		RemoteAddr: s.RemoteAddr,
+		UserAgent:  s.UserAgent,
+		Created:    s.Created,
+		LastSeen:   s.LastSeen,
+		Expires:    s.Expires,
+	})
+}
+
+func decodeSession(data []byte) (*session.Session, error) {
+	var r sessionRecord
+	if err := decode(data, &r); err != nil {
+		return nil, err
+	}
+	return &session.Session{
+		ID:         r.ID,
+		AccountUID: r.AccountUID,
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent,
+		Created:    r.Created,
+		LastSeen:   r.LastSeen,
+		Expires:    r.Expires,
+	}, nil
+}
+
+type roleRecord struct {
+	ID          *int64     `json:"id"`
+	Slug        *string    `json:"slug"`
+	Name        *string    `json:"name,omitempty"`
+	Description *string    `json:"description,omitempty"`
+	Permissions []string   `json:"permissions,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+	Updated     *time.Time `json:"updated,omitempty"`
+}
+
+func encodeRole(role *rbac.Role) ([]byte, error) {
+	r := &roleRecord{
+		ID:          role.ID,
+		Slug:        role.Slug,
+		Name:        role.Name,
+		Description: role.Description,
+		Created:     role.Created,
+		Updated:     role.Updated,
+	}
+	for _, p := range role.Permissions {
+		r.Permissions = append(r.Permissions, string(p))
+	}
+	return encode(r)
+}
+
+func decodeRole(data []byte) (*rbac.Role, error) {
+	var r roleRecord
+	if err := decode(data, &r); err != nil {
+		return nil, err
+	}
+	role := &rbac.Role{
+		ID:          r.ID,
+		Slug:        r.Slug,
+		Name:        r.Name,
+		Description: r.Description,
+		Created:     r.Created,
+		Updated:     r.Updated,
+	}
+	for _, p := range r.Permissions {
+		role.Permissions = append(role.Permissions, rbac.Permission(p))
+	}
+	return role, nil
+}
+
+type grantRecord struct {
+	ID         *int64          `json:"id"`
+	FromRole   *int64          `json:"from_role"`
+	ToRole     *int64          `json:"to_role"`
+	Assignment json.RawMessage `json:"assignment,omitempty"`
+}
+
+func encodeGrant(g *rbac.Grant) ([]byte, error) {
+	return encode(&grantRecord{ID: g.ID, FromRole: g.FromRole, ToRole: g.ToRole, Assignment: g.Assignment})
+}
+
+func decodeGrant(data []byte) (*rbac.Grant, error) {
+	var r grantRecord
+	if err := decode(data, &r); err != nil {
+		return nil, err
+	}
+	return &rbac.Grant{ID: r.ID, FromRole: r.FromRole, ToRole: r.ToRole, Assignment: r.Assignment}, nil
+}
+
+type cookieKeyRecord struct {
+	ID       string    `json:"id"`
+	HashKey  []byte    `json:"hash_key"`
+	BlockKey []byte    `json:"block_key"`
+	Created  time.Time `json:"created"`
+}
+
+func encodeCookieKeys(keys []*keyring.Key) ([]byte, error) {
+	rs := make([]cookieKeyRecord, len(keys))
+	for i, k := range keys {
+		rs[i] = cookieKeyRecord{ID: k.ID, HashKey: k.HashKey, BlockKey: k.BlockKey, Created: k.Created}
+	}
+	return encode(rs)
+}
+
+func decodeCookieKeys(data []byte) ([]*keyring.Key, error) {
+	var rs []cookieKeyRecord
+	if err := decode(data, &rs); err != nil {
+		return nil, err
+	}
+	keys := make([]*keyring.Key, len(rs))
+	for i, r := range rs {
+		keys[i] = &keyring.Key{ID: r.ID, HashKey: r.HashKey, BlockKey: r.BlockKey, Created: r.Created}
+	}
+	return keys, nil
+}
+
+type signingKeyRecord struct {
+	ID         string    `json:"id"`
+	PrivateKey []byte    `json:"private_key"`
+	Created    time.Time `json:"created"`
+}
+
+func encodeSigningKeys(keys []*oidc.Key) ([]byte, error) {
+	rs := make([]signingKeyRecord, len(keys))
+	for i, k := range keys {
+		rs[i] = signingKeyRecord{ID: k.ID, PrivateKey: k.PrivateKey, Created: k.Created}
+	}
+	return encode(rs)
+}
+
+func decodeSigningKeys(data []byte) ([]*oidc.Key, error) {
+	var rs []signingKeyRecord
+	if err := decode(data, &rs); err != nil {
+		return nil, err
+	}
+	keys := make([]*oidc.Key, len(rs))
+	for i, r := range rs {
+		keys[i] = &oidc.Key{ID: r.ID, PrivateKey: r.PrivateKey, Created: r.Created}
+	}
+	return keys, nil
+}
+
+type attemptsRecord struct {
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	LastFailure time.Time  `json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+func encodeAttempts(a *lockout.Attempts) ([]byte, error) {
+	return encode(&attemptsRecord{Key: a.Key, Failures: a.Failures, LastFailure: a.LastFailure, LockedUntil: a.LockedUntil})
+}
+
+func decodeAttempts(data []byte) (*lockout.Attempts, error) {
+	var r attemptsRecord
+	if err := decode(data, &r); err != nil {
+		return nil, err
+	}
+	return &lockout.Attempts{Key: r.Key, Failures: r.Failures, LastFailure: r.LastFailure, LockedUntil: r.LockedUntil}, nil
+}
+
+type ticketRecord struct {
+	ID         string    `json:"id"`
+	Purpose    string    `json:"purpose"`
+	AccountUID string    `json:"account_uid"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+}
+
+func encodeTicket(t *ticket.Ticket) ([]byte, error) {
+	return encode(&ticketRecord{ID: t.ID, Purpose: string(t.Purpose), AccountUID: t.AccountUID, Created: t.Created, Expires: t.Expires})
+}
+
+func decodeTicket(data []byte) (*ticket.Ticket, error) {
+	var r ticketRecord
+	if err := decode(data, &r); err != nil {
+		return nil, err
+	}
+	return &ticket.Ticket{ID: r.ID, Purpose: ticket.Purpose(r.Purpose), AccountUID: r.AccountUID, Created: r.Created, Expires: r.Expires}, nil
+}
+
+type credentialRecord struct {
+	ID             string     `json:"id"`
+	AccountUID     string     `json:"account_uid"`
+	Name           string     `json:"name,omitempty"`
+	PublicKey      []byte     `json:"public_key"`
+	Algorithm      int        `json:"algorithm"`
+	SignCount      int64      `json:"sign_count"`
+	AAGUID         string     `json:"aaguid,omitempty"`
+	Transports     string     `json:"transports,omitempty"`
+	BackupEligible bool       `json:"backup_eligible,omitempty"`
+	Created        time.Time  `json:"created"`
+	LastUsed       *time.Time `json:"last_used,omitempty"`
+}
+
+func encodeCredential(c *webauthn.Credential) ([]byte, error) {
+	return encode(&credentialRecord{
+		ID:             c.ID,
+		AccountUID:     c.AccountUID,
+		Name:           c.Name,
+		PublicKey:      c.PublicKey,
+		Algorithm:      c.Algorithm,
+		SignCount:      c.SignCount,
+		AAGUID:         c.AAGUID,
+		Transports:     c.Transports,
+		BackupEligible: c.BackupEligible,
+		Created:        c.Created,
+		LastUsed:       c.LastUsed,
+	})
+}
+
+func decodeCredential(data []byte) (*webauthn.Credential, error) {
+	var r credentialRecord
+	if err := decode(data, &r); err != nil {
+		return nil, err
+	}
+	return &webauthn.Credential{
+		ID:             r.ID,
+		AccountUID:     r.AccountUID,
+		Name:           r.Name,
+		PublicKey:      r.PublicKey,
+		Algorithm:      r.Algorithm,
+		SignCount:      r.SignCount,
+		AAGUID:         r.AAGUID,
+		Transports:     r.Transports,
+		BackupEligible: r.BackupEligible,
+		Created:        r.Created,
+		LastUsed:       r.LastUsed,
+	}, nil
+}
+
+type clientRecord struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Public       bool      `json:"public,omitempty"`
+	SecretHash   string    `json:"secret_hash,omitempty"`
+	RedirectURIs []string  `json:"redirect_uris,omitempty"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+func encodeClient(c *oauth.Client) ([]byte, error) {
+	return encode(&clientRecord{
+		ID:           c.ID,
+		Name:         c.Name,
+		Public:       c.Public,
+		SecretHash:   c.SecretHash,
+		RedirectURIs: c.RedirectURIs,
+		GrantTypes:   c.GrantTypes,
+		Scopes:       c.Scopes,
+		Created:      c.Created,
+	})
+}
+
+func decodeClient(data []byte) (*oauth.Client, error) {
+	var r clientRecord
+	if err := decode(data, &r); err != nil {
+		return nil, err
+	}
+	return &oauth.Client{
+		ID:           r.ID,
+		Name:         r.Name,
+		Public:       r.Public,
+		SecretHash:   r.SecretHash,
+		RedirectURIs: r.RedirectURIs,
+		GrantTypes:   r.GrantTypes,
+		Scopes:       r.Scopes,
+		Created:      r.Created,
+	}, nil
+}
+
+type oauthTokenRecord struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	ClientID    string    `json:"client_id"`
+	AccountUID  string    `json:"account_uid,omitempty"`
+	Scope       string    `json:"scope,omitempty"`
+	GrantID     string    `json:"grant_id"`
+	RedirectURI string    `json:"redirect_uri,omitempty"`
+	Challenge   string    `json:"code_challenge,omitempty"`
+	Nonce       string    `json:"nonce,omitempty"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires"`
+}
+
+func encodeOAuthToken(t *oauth.Token) ([]byte, error) {
+	return encode(&oauthTokenRecord{
+		ID:          t.ID,
+		Type:        string(t.Type),
+		ClientID:    t.ClientID,
+		AccountUID:  t.AccountUID,
+		Scope:       t.Scope,
+		GrantID:     t.GrantID,
+		RedirectURI: t.RedirectURI,
+		Challenge:   t.Challenge,
+		Nonce:       t.Nonce,
+		Created:     t.Created,
+		Expires:     t.Expires,
+	})
+}
+
+func decodeOAuthToken(data []byte) (*oauth.Token, error) {
+	var r oauthTokenRecord
+	if err := decode(data, &r); err != nil {
+		return nil, err
+	}
+	return &oauth.Token{
+		ID:          r.ID,
+		Type:        oauth.TokenType(r.Type),
+		ClientID:    r.ClientID,
+		AccountUID:  r.AccountUID,
+		Scope:       r.Scope,
+		GrantID:     r.GrantID,
+		RedirectURI: r.RedirectURI,
+		Challenge:   r.Challenge,
+		Nonce:       r.Nonce,
+		Created:     r.Created,
+		Expires:     r.Expires,
+	}, nil
+}
+
+type providerRecord struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Issuer       string    `json:"issuer"`
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+	Scope        string    `json:"scope"`
+	AllowSignup  bool      `json:"allow_signup,omitempty"`
+	LinkByEmail  bool      `json:"link_by_email,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+func encodeProvider(p *federation.Provider) ([]byte, error) {
+	return encode(&providerRecord{
+		ID:           p.ID,
+		Name:         p.Name,
+		Issuer:       p.Issuer,
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Scope:        p.Scope,
+		AllowSignup:  p.AllowSignup,
+		LinkByEmail:  p.LinkByEmail,
+		Created:      p.Created,
+	})
+}
+
+func decodeProvider(data []byte) (*federation.Provider, error) {
+	var r providerRecord
+	if err := decode(data, &r); err != nil {
+		return nil, err
+	}
+	return &federation.Provider{
+		ID:           r.ID,
+		Name:         r.Name,
+		Issuer:       r.Issuer,
+		ClientID:     r.ClientID,
+		ClientSecret: r.ClientSecret,
+		Scope:        r.Scope,
+		AllowSignup:  r.AllowSignup,
+		LinkByEmail:  r.LinkByEmail,
+		Created:      r.Created,
+	}, nil
+}
+
+type identityRecord struct {
+	ProviderID string    `json:"provider_id"`
+	Subject    string    `json:"subject"`
+	AccountUID string    `json:"account_uid"`
+	Email      string    `json:"email,omitempty"`
+	Created    time.Time `json:"created"`
+	LastLogin  time.Time `json:"last_login"`
+}
+
+func encodeIdentity(i *federation.Identity) ([]byte, error) {
+	return encode(&identityRecord{
+		ProviderID: i.ProviderID,
+		Subject:    i.Subject,
+		AccountUID: i.AccountUID,
+		Email:      i.Email,
+		Created:    i.Created,
+		LastLogin:  i.LastLogin,
+	})
+}
+
+func decodeIdentity(data []byte) (*federation.Identity, error) {
+	var r identityRecord
+	if err := decode(data, &r); err != nil {
+		return nil, err
+	}
+	return &federation.Identity{
+		ProviderID: r.ProviderID,
+		Subject:    r.Subject,
+		AccountUID: r.AccountUID,
+		Email:      r.Email,
+		Created:    r.Created,
+		LastLogin:  r.LastLogin,
+	}, nil
+}
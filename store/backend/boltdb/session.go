@@ -0,0 +1,99 @@
+package bolt
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/jllopis/try5/session"
+	"github.com/jllopis/try5/store"
+)
+
+func (s *BoltStore) LoadSession(id string) (*session.Session, error) {
+	var sess *session.Session
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte("sessions")).Get([]byte(id))
+		if data == nil {
+			return store.ErrSessionNotFound
+		}
+		var err error
+		sess, err = decodeSession(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (s *BoltStore) LoadAccountSessions(uid string) ([]*session.Session, error) {
+	var sessions []*session.Session
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("sessions")).ForEach(func(k, v []byte) error {
+			sess, err := decodeSession(v)
+			if err != nil {
+				return err
+			}
+			if sess.AccountUID != nil && *sess.AccountUID == uid {
+				sessions = append(sessions, sess)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *BoltStore) SaveSession(sess *session.Session) (*session.Session, error) {
+	if sess.ID == nil {
+		return nil, store.ErrMissingID
+	}
+	data, err := encodeSession(sess)
+	if err != nil {
+		return nil, err
+	}
+	err = s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("sessions")).Put([]byte(*sess.ID), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (s *BoltStore) DeleteSession(id string) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sessions"))
+		if b.Get([]byte(id)) == nil {
+			return nil
+		}
+		n = 1
+		return b.Delete([]byte(id))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *BoltStore) DeleteAccountSessions(uid string) (int, error) {
+	sessions, err := s.LoadAccountSessions(uid)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sessions"))
+		for _, sess := range sessions {
+			if err := b.Delete([]byte(*sess.ID)); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
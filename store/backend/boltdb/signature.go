@@ -0,0 +1,60 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var signaturesBucket = []byte("signatures")
+
+// RecordSignature keeps the expiration of the signature, as unix nanoseconds. The
+// check and the write happen in the same transaction.
+func (s *BoltStore) RecordSignature(id string, expires time.Time) (bool, error) {
+	recorded := false
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(signaturesBucket)
+		if v := b.Get([]byte(id)); len(v) == 8 && time.Now().UnixNano() < int64(binary.BigEndian.Uint64(v)) {
+			return nil
+		}
+		recorded = true
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, uint64(expires.UnixNano()))
+		return b.Put([]byte(id), v)
+	})
+	if err != nil {
+		return false, err
+	}
+	return recorded, nil
+}
+
+// DeleteExpiredSignatures removes the signatures past their expiration date
+func (s *BoltStore) DeleteExpiredSignatures() (int, error) {
+	n := 0
+	now := time.Now().UnixNano()
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(signaturesBucket)
+		var ids [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if len(v) != 8 || now >= int64(binary.BigEndian.Uint64(v)) {
+				ids = append(ids, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := b.Delete(id); err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
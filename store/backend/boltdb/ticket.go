@@ -0,0 +1,124 @@
+package bolt
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/ticket"
+)
+
+var ticketsBucket = []byte("tickets")
+
+func (s *BoltStore) LoadTicket(id string) (*ticket.Ticket, error) {
+	var t *ticket.Ticket
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket(ticketsBucket).Get([]byte(id))
+		if data == nil {
+			return store.ErrTicketNotFound
+		}
+		var err error
+		t, err = decodeTicket(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *BoltStore) SaveTicket(t *ticket.Ticket) error {
+	if t.ID == "" {
+		return store.ErrMissingID
+	}
+	data, err := encodeTicket(t)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ticketsBucket).Put([]byte(t.ID), data)
+	})
+}
+
+func (s *BoltStore) DeleteTicket(id string) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ticketsBucket)
+		if b.Get([]byte(id)) == nil {
+			return nil
+		}
+		n = 1
+		return b.Delete([]byte(id))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// DeleteExpiredTickets removes the tickets past their expiration date
+func (s *BoltStore) DeleteExpiredTickets() (int, error) {
+	n := 0
+	now := time.Now()
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ticketsBucket)
+		var ids [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			t, err := decodeTicket(v)
+			if err != nil {
+				return err
+			}
+			if !now.Before(t.Expires) {
+				ids = append(ids, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := b.Delete(id); err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// DeleteAccountTickets scans the bucket, there are few tickets alive at any time
+func (s *BoltStore) DeleteAccountTickets(uid string, purpose ticket.Purpose) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ticketsBucket)
+		var ids [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			t, err := decodeTicket(v)
+			if err != nil {
+				return err
+			}
+			if t.AccountUID == uid && t.Purpose == purpose {
+				ids = append(ids, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := b.Delete(id); err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,102 @@
+package bolt
+
+import (
+	"bytes"
+
+	"github.com/boltdb/bolt"
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/webauthn"
+)
+
+var (
+	credentialsBucket = []byte("credentials")
+	// credentialsByAccount indexes the credentials by account, the keys are the
+	// account uid and the credential id separated by a zero byte
+	credentialsByAccount = []byte("credentials_by_account")
+)
+
+func credentialIndexKey(uid, id string) []byte {
+	return []byte(uid + "\x00" + id)
+}
+
+func (s *BoltStore) LoadCredential(id string) (*webauthn.Credential, error) {
+	var c *webauthn.Credential
+	err := s.view(func(tx *bolt.Tx) error {
+		data := tx.Bucket(credentialsBucket).Get([]byte(id))
+		if data == nil {
+			return store.ErrCredentialNotFound
+		}
+		var err error
+		c, err = decodeCredential(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *BoltStore) LoadAccountCredentials(uid string) ([]*webauthn.Credential, error) {
+	var creds []*webauthn.Credential
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(credentialsBucket)
+		prefix := []byte(uid + "\x00")
+		c := tx.Bucket(credentialsByAccount).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			data := b.Get(k[len(prefix):])
+			if data == nil {
+				continue
+			}
+			cred, err := decodeCredential(data)
+			if err != nil {
+				return err
+			}
+			creds = append(creds, cred)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+func (s *BoltStore) SaveCredential(c *webauthn.Credential) error {
+	if c.ID == "" {
+		return store.ErrMissingID
+	}
+	data, err := encodeCredential(c)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(credentialsBucket).Put([]byte(c.ID), data); err != nil {
+			return err
+		}
+		return tx.Bucket(credentialsByAccount).Put(credentialIndexKey(c.AccountUID, c.ID), []byte{})
+	})
+}
+
+func (s *BoltStore) DeleteCredential(id string) (int, error) {
+	n := 0
+	err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(credentialsBucket)
+		data := b.Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		c, err := decodeCredential(data)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(credentialsByAccount).Delete(credentialIndexKey(c.AccountUID, c.ID)); err != nil {
+			return err
+		}
+		n = 1
+		return b.Delete([]byte(id))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
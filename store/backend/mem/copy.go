@@ -0,0 +1,128 @@
+package mem
+
+import (
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/keyring"
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/oauth"
+	"github.com/jllopis/try5/oidc"
+	"github.com/jllopis/try5/rbac"
+	"github.com/jllopis/try5/session"
+	"github.com/jllopis/try5/ticket"
+	"github.com/jllopis/try5/webauthn"
+)
+
+// The store keeps its own copies of the records, the ones returned and saved belong
+// to the callers and can be changed without touching the store. The pointer fields
+// are shared, they are replaced and not written through.
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string(nil), s...)
+}
+
+func copyAccount(a *account.Account) *account.Account {
+	c := *a
+	c.PasswordHistory = account.Hashes(copyStrings(a.PasswordHistory))
+	c.MFA = copyMFA(a.MFA)
+	return &c
+}
+
+func copyMFA(m *account.MFA) *account.MFA {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	c.RecoveryCodes = copyStrings(m.RecoveryCodes)
+	return &c
+}
+
+func copyKey(k *apikey.Key) *apikey.Key {
+	c := *k
+	return &c
+}
+
+func copyRole(r *rbac.Role) *rbac.Role {
+	c := *r
+	if r.Permissions != nil {
+		c.Permissions = append(rbac.Permissions(nil), r.Permissions...)
+	}
+	return &c
+}
+
+func copyGrant(g *rbac.Grant) *rbac.Grant {
+	c := *g
+	return &c
+}
+
+func copySession(s *session.Session) *session.Session {
+	c := *s
+	return &c
+}
+
+func copyAttempts(a *lockout.Attempts) *lockout.Attempts {
+	c := *a
+	return &c
+}
+
+func copyTicket(t *ticket.Ticket) *ticket.Ticket {
+	c := *t
+	return &c
+}
+
+func copyCredential(cr *webauthn.Credential) *webauthn.Credential {
+	c := *cr
+	c.PublicKey = append([]byte(nil), cr.PublicKey...)
+	return &c
+}
+
+func copyClient(cl *oauth.Client) *oauth.Client {
+	c := *cl
+	c.RedirectURIs = oauth.Strings(copyStrings(cl.RedirectURIs))
+	c.GrantTypes = oauth.Strings(copyStrings(cl.GrantTypes))
+	c.Scopes = oauth.Strings(copyStrings(cl.Scopes))
+	return &c
+}
+
+func copyOAuthToken(t *oauth.Token) *oauth.Token {
+	c := *t
+	return &c
+}
+
+func copyProvider(p *federation.Provider) *federation.Provider {
+	c := *p
+	return &c
+}
+
+func copyIdentity(i *federation.Identity) *federation.Identity {
+	c := *i
+	return &c
+}
+
+func copyCookieKeys(keys []*keyring.Key) []*keyring.Key {
+	if keys == nil {
+		return nil
+	}
+	res := make([]*keyring.Key, len(keys))
+	for i, k := range keys {
+		c := *k
+		res[i] = &c
+	}
+	return res
+}
+
+func copySigningKeys(keys []*oidc.Key) []*oidc.Key {
+	if keys == nil {
+		return nil
+	}
+	res := make([]*oidc.Key, len(keys))
+	for i, k := range keys {
+		c := *k
+		res[i] = &c
+	}
+	return res
+}
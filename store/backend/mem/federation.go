@@ -0,0 +1,101 @@
+package mem
+
+import (
+	"sort"
+
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/store"
+)
+
+func identityKey(providerID, subject string) string {
+	return providerID + "\x00" + subject
+}
+
+func (s *MemStore) LoadFederationProvider(id string) (*federation.Provider, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if p, ok := s.providers[id]; ok {
+		return copyProvider(p), nil
+	}
+	return nil, store.ErrProviderNotFound
+}
+
+func (s *MemStore) LoadAllFederationProviders() ([]*federation.Provider, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	providers := make([]*federation.Provider, 0, len(s.providers))
+	for _, p := range s.providers {
+		providers = append(providers, copyProvider(p))
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].ID < providers[j].ID })
+	return providers, nil
+}
+
+func (s *MemStore) SaveFederationProvider(p *federation.Provider) error {
+	if p.ID == "" {
+		return store.ErrMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.providers[p.ID] = copyProvider(p)
+	return nil
+}
+
+// DeleteFederationProvider also deletes the identities linked from the provider
+func (s *MemStore) DeleteFederationProvider(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.providers[id]; !ok {
+		return 0, nil
+	}
+	delete(s.providers, id)
+	for k, i := range s.identities {
+		if i.ProviderID == id {
+			delete(s.identities, k)
+		}
+	}
+	return 1, nil
+}
+
+func (s *MemStore) LoadIdentity(providerID, subject string) (*federation.Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i, ok := s.identities[identityKey(providerID, subject)]; ok {
+		return copyIdentity(i), nil
+	}
+	return nil, store.ErrIdentityNotFound
+}
+
+func (s *MemStore) LoadAccountIdentities(uid string) ([]*federation.Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var res []*federation.Identity
+	for _, i := range s.identities {
+		if i.AccountUID == uid {
+			res = append(res, copyIdentity(i))
+		}
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Created.Before(res[b].Created) })
+	return res, nil
+}
+
+func (s *MemStore) SaveIdentity(i *federation.Identity) error {
+	if i.ProviderID == "" || i.Subject == "" {
+		return store.ErrMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identities[identityKey(i.ProviderID, i.Subject)] = copyIdentity(i)
+	return nil
+}
+
+func (s *MemStore) DeleteIdentity(providerID, subject string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := identityKey(providerID, subject)
+	if _, ok := s.identities[k]; !ok {
+		return 0, nil
+	}
+	delete(s.identities, k)
+	return 1, nil
+}
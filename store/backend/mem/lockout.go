@@ -0,0 +1,62 @@
+package mem
+
+import (
+	"time"
+
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/store"
+)
+
+func (s *MemStore) LoadAttempts(key string) (*lockout.Attempts, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if a, ok := s.attempts[key]; ok {
+		return copyAttempts(a), nil
+	}
+	return nil, store.ErrLockoutNotFound
+}
+
+func (s *MemStore) LoadAllAttempts() ([]*lockout.Attempts, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	attempts := make([]*lockout.Attempts, 0, len(s.attempts))
+	for _, a := range s.attempts {
+		attempts = append(attempts, copyAttempts(a))
+	}
+	return attempts, nil
+}
+
+func (s *MemStore) SaveAttempts(a *lockout.Attempts) error {
+	if a.Key == "" {
+		return store.ErrMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts[a.Key] = copyAttempts(a)
+	return nil
+}
+
+func (s *MemStore) IncrementAttempts(p lockout.Policy, key string, max int, now time.Time) (*lockout.Attempts, error) {
+	if key == "" {
+		return nil, store.ErrMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var a *lockout.Attempts
+	if old, ok := s.attempts[key]; ok {
+		a = copyAttempts(old)
+	}
+	a = p.Fail(a, key, max, now)
+	s.attempts[key] = copyAttempts(a)
+	return a, nil
+}
+
+func (s *MemStore) DeleteAttempts(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.attempts[key]; !ok {
+		return 0, nil
+	}
+	delete(s.attempts, key)
+	return 1, nil
+}
@@ -1,51 +1,361 @@
 package mem
 
 import (
+	"sort"
+	"sync"
+	"time"
+
 	"code.google.com/p/go-uuid/uuid"
+
 	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/keyring"
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/oauth"
+	"github.com/jllopis/try5/oidc"
+	"github.com/jllopis/try5/rbac"
+	"github.com/jllopis/try5/session"
 	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/ticket"
+	"github.com/jllopis/try5/webauthn"
 )
 
+// MemStore keeps the records in memory, it is safe for concurrent use. The records
+// are copied in and out, see copy.go.
 type MemStore struct {
-	accounts map[string]*account.Account
-	status   int
+	mu           sync.RWMutex
+	accounts     map[string]*account.Account
+	keys         map[string]*apikey.Key
+	roles        map[int64]*rbac.Role
+	grants       map[int64]*rbac.Grant
+	accountRoles map[string][]int64
+	sessions     map[string]*session.Session
+	attempts     map[string]*lockout.Attempts
+	tickets      map[string]*ticket.Ticket
+	credentials  map[string]*webauthn.Credential
+	clients      map[string]*oauth.Client
+	oauthTokens  map[string]*oauth.Token
+	providers    map[string]*federation.Provider
+	identities   map[string]*federation.Identity
+	signatures   map[string]time.Time
+	cookieKeys   []*keyring.Key
+	signingKeys  []*oidc.Key
+	seq          int64
+	status       int
 }
 
 func NewMemStore() *MemStore {
-	return &MemStore{accounts: make(map[string]*account.Account, 10), status: store.CONNECTED}
+	return &MemStore{
+		accounts:     make(map[string]*account.Account, 10),
+		keys:         make(map[string]*apikey.Key, 10),
+		roles:        make(map[int64]*rbac.Role),
+		grants:       make(map[int64]*rbac.Grant),
+		accountRoles: make(map[string][]int64),
+		sessions:     make(map[string]*session.Session),
+		attempts:     make(map[string]*lockout.Attempts),
+		tickets:      make(map[string]*ticket.Ticket),
+		credentials:  make(map[string]*webauthn.Credential),
+		clients:      make(map[string]*oauth.Client),
+		oauthTokens:  make(map[string]*oauth.Token),
+		providers:    make(map[string]*federation.Provider),
+		identities:   make(map[string]*federation.Identity),
+		signatures:   make(map[string]time.Time),
+		status:       store.CONNECTED,
+	}
 }
 
 func (s *MemStore) Status() (int, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.status, store.StatusStr[s.status]
 }
 
 func (s *MemStore) LoadAllAccounts() ([]*account.Account, error) {
-	accounts := make([]*account.Account, len(s.accounts))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	accounts := make([]*account.Account, 0, len(s.accounts))
 	for _, v := range s.accounts {
-		accounts = append(accounts, v)
+		accounts = append(accounts, copyAccount(v))
 	}
 	return accounts, nil
 }
 
+// ListAccounts filters and sorts all the accounts and returns the page after the cursor
+func (s *MemStore) ListAccounts(q *store.AccountQuery) (*store.AccountPage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	cursor, err := store.DecodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var accounts []*account.Account
+	for _, a := range s.accounts {
+		if q.Match(a) && (cursor == nil || q.After(a, cursor)) {
+			accounts = append(accounts, copyAccount(a))
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return q.Less(accounts[i], accounts[j]) })
+	page := &store.AccountPage{Accounts: accounts}
+	if len(accounts) > q.Limit {
+		page.Accounts = accounts[:q.Limit]
+		page.NextCursor = q.PageCursor(page.Accounts[q.Limit-1])
+	}
+	return page, nil
+}
+
 func (s *MemStore) LoadAccount(uuid string) (*account.Account, error) {
-	return s.accounts[uuid], nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if a, ok := s.accounts[uuid]; ok {
+		return copyAccount(a), nil
+	}
+	return nil, store.ErrAccountNotFound
 }
 
-func (s *MemStore) SaveAccount(account *account.Account) (*account.Account, error) {
-	if account.UID == nil {
-		*account.UID = uuid.New()
+func (s *MemStore) GetAccountByEmail(email string) (*account.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if a := s.accountByEmail(email); a != nil {
+		return copyAccount(a), nil
 	}
-	s.accounts[*account.UID] = account
-	return account, nil
+	return nil, store.ErrAccountNotFound
+}
+
+// accountByEmail returns the stored account of the email, nil if none
+func (s *MemStore) accountByEmail(email string) *account.Account {
+	email = account.NormalizeEmail(email)
+	for _, a := range s.accounts {
+		if a.Email != nil && account.NormalizeEmail(*a.Email) == email {
+			return a
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) SaveAccount(acc *account.Account) (*account.Account, error) {
+	if acc.Email == nil {
+		return nil, account.ErrInvalidEmail
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if owner := s.accountByEmail(*acc.Email); owner != nil && (acc.UID == nil || *owner.UID != *acc.UID) {
+		return nil, store.ErrEmailTaken
+	}
+	now := time.Now().UTC()
+	acc.Updated = &now
+	if acc.UID == nil {
+		// the accounts authenticated somewhere else are created without a password
+		if acc.Password != nil {
+			if err := acc.UpdatePassword(*acc.Password); err != nil {
+				return nil, err
+			}
+		}
+		u := uuid.New()
+		acc.UID = &u
+		acc.Created = &now
+		if acc.Active == nil {
+			t := true
+			acc.Active = &t
+		}
+	} else {
+		saved, ok := s.accounts[*acc.UID]
+		if !ok {
+			return nil, store.ErrAccountNotFound
+		}
+		acc.Created = saved.Created
+		acc.PasswordHistory = account.Hashes(copyStrings(saved.PasswordHistory))
+		switch {
+		case acc.Password == nil:
+			acc.Password = saved.Password
+		case saved.Password == nil || *acc.Password != *saved.Password:
+			acc.RememberPassword(saved.Password)
+			if err := acc.UpdatePassword(*acc.Password); err != nil {
+				return nil, err
+			}
+		}
+		if acc.Active == nil {
+			acc.Active = saved.Active
+		}
+		if acc.EmailVerified == nil {
+			acc.EmailVerified = saved.EmailVerified
+		}
+		// the second factor only changes with SetMFA
+		acc.MFA = copyMFA(saved.MFA)
+	}
+	s.accounts[*acc.UID] = copyAccount(acc)
+	return acc, nil
+}
+
+// SetPasswordHash replaces the password hash without touching the history
+func (s *MemStore) SetPasswordHash(uuid string, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accounts[uuid]
+	if !ok {
+		return store.ErrAccountNotFound
+	}
+	a.Password = &hash
+	return nil
+}
+
+// SetMFA replaces the second factor of the account, nil removes it
+func (s *MemStore) SetMFA(uuid string, m *account.MFA) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accounts[uuid]
+	if !ok {
+		return store.ErrAccountNotFound
+	}
+	a.MFA = copyMFA(m)
+	return nil
+}
+
+// SwapMFA replaces the second factor of the account with m if it is still old
+func (s *MemStore) SwapMFA(uuid string, old, m *account.MFA) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accounts[uuid]
+	if !ok {
+		return false, store.ErrAccountNotFound
+	}
+	if !a.MFA.Same(old) {
+		return false, nil
+	}
+	a.MFA = copyMFA(m)
+	return true, nil
 }
 
 func (s *MemStore) DeleteAccount(uuid string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[uuid]; !ok {
+		return 0, nil
+	}
 	delete(s.accounts, uuid)
 	return 1, nil
 }
 
+func (s *MemStore) LoadKey(id string) (*apikey.Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if k, ok := s.keys[id]; ok {
+		return copyKey(k), nil
+	}
+	return nil, store.ErrKeyNotFound
+}
+
+func (s *MemStore) LoadAccountKeys(accountUID string) ([]*apikey.Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []*apikey.Key
+	for _, k := range s.keys {
+		if k.AccountUID != nil && *k.AccountUID == accountUID {
+			keys = append(keys, copyKey(k))
+		}
+	}
+	return keys, nil
+}
+
+func (s *MemStore) SaveKey(key *apikey.Key) (*apikey.Key, error) {
+	if key.ID == nil {
+		return nil, store.ErrMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[*key.ID] = copyKey(key)
+	return key, nil
+}
+
+func (s *MemStore) DeleteKey(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[id]; !ok {
+		return 0, nil
+	}
+	delete(s.keys, id)
+	return 1, nil
+}
+
+func (s *MemStore) RecordSignature(id string, expires time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.signatures[id]; ok && time.Now().Before(e) {
+		return false, nil
+	}
+	s.signatures[id] = expires
+	return true, nil
+}
+
+func (s *MemStore) DeleteExpiredSignatures() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, now := 0, time.Now()
+	for id, e := range s.signatures {
+		if !now.Before(e) {
+			delete(s.signatures, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemStore) LoadCookieKeys() ([]*keyring.Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyCookieKeys(s.cookieKeys), nil
+}
+
+func (s *MemStore) SaveCookieKeys(keys []*keyring.Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cookieKeys = copyCookieKeys(keys)
+	return nil
+}
+
+func (s *MemStore) InitCookieKeys(keys []*keyring.Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cookieKeys) == 0 {
+		s.cookieKeys = copyCookieKeys(keys)
+	}
+	return nil
+}
+
+func (s *MemStore) LoadSigningKeys() ([]*oidc.Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copySigningKeys(s.signingKeys), nil
+}
+
+func (s *MemStore) SaveSigningKeys(keys []*oidc.Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signingKeys = copySigningKeys(keys)
+	return nil
+}
+
+func (s *MemStore) InitSigningKeys(keys []*oidc.Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.signingKeys) == 0 {
+		s.signingKeys = copySigningKeys(keys)
+	}
+	return nil
+}
+
 func (s *MemStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.accounts = nil
+	s.keys = nil
+	s.roles = nil
+	s.grants = nil
+	s.accountRoles = nil
+	s.sessions = nil
 	s.status = store.DISCONNECTED
 	return nil
 }
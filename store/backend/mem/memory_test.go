@@ -2,9 +2,15 @@ package mem
 
 import (
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/hasher"
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/session"
+	"github.com/jllopis/try5/store"
 )
 
 func TestAccount(t *testing.T) {
@@ -24,16 +30,185 @@ func TestAccount(t *testing.T) {
 	}
 	fmt.Printf("Saved account: %#v\n", savedAccount)
 
-	u := m.LoadAccount(savedAccount.UID)
-	if u == nil {
+	u, err := m.LoadAccount(*savedAccount.UID)
+	if err != nil || u == nil {
 		t.Fatal("Error getting account from mem store")
 	}
 	fmt.Printf("Got from store: %#v\n", u)
 
-	u2 := m.LoadAccount("")
-	if u2 != nil {
+	u2, err := m.LoadAccount("")
+	if err == nil || u2 != nil {
 		t.Fatal("Got inexistent account from mem store")
 		fmt.Printf("Got from store: %#v\n", u2)
 	}
 
 }
+
+func TestConcurrentAccess(t *testing.T) {
+	m := NewMemStore()
+	email, name, password := "jdoe@example.com", "Jane Doe", "SuperDifficultPass"
+	acc, err := m.SaveAccount(&account.Account{Email: &email, Name: &name, Password: &password})
+	if err != nil {
+		t.Fatal("Error saving account: ", err)
+	}
+	uid := *acc.UID
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := session.New(uid, "127.0.0.1", "test", session.Options{})
+			if err != nil {
+				t.Error("Error creating session: ", err)
+				return
+			}
+			if _, err := m.SaveSession(s); err != nil {
+				t.Error("Error saving session: ", err)
+			}
+			if _, err := m.LoadAccount(uid); err != nil {
+				t.Error("Error loading account: ", err)
+			}
+			n := fmt.Sprintf("Name %d", i)
+			if _, err := m.SaveAccount(&account.Account{UID: &uid, Email: &email, Name: &n}); err != nil {
+				t.Error("Error saving account: ", err)
+			}
+			if _, err := m.LoadAccountSessions(uid); err != nil {
+				t.Error("Error loading sessions: ", err)
+			}
+			if _, err := m.IncrementAttempts(lockout.DefaultPolicy, "ip:127.0.0.1", 100, time.Now()); err != nil {
+				t.Error("Error incrementing attempts: ", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if sessions, _ := m.LoadAccountSessions(uid); len(sessions) != 20 {
+		t.Fatal("Sessions lost: ", len(sessions))
+	}
+	if a, _ := m.LoadAttempts("ip:127.0.0.1"); a == nil || a.Failures != 20 {
+		t.Fatal("Failed attempts lost: ", a)
+	}
+}
+
+func TestCopies(t *testing.T) {
+	m := NewMemStore()
+	email, name, password := "jdoe@example.com", "Jane Doe", "SuperDifficultPass"
+	acc, err := m.SaveAccount(&account.Account{Email: &email, Name: &name, Password: &password})
+	if err != nil {
+		t.Fatal("Error saving account: ", err)
+	}
+	if err := m.SetMFA(*acc.UID, &account.MFA{Secret: "s", RecoveryCodes: []string{"a", "b"}}); err != nil {
+		t.Fatal("Error saving mfa: ", err)
+	}
+
+	// the changes to the records returned and saved stay out of the store
+	loaded, _ := m.LoadAccount(*acc.UID)
+	other := "other@example.com"
+	loaded.Email, loaded.Password = &other, nil
+	loaded.MFA.RecoveryCodes[0] = "x"
+	acc.Name = nil
+	again, _ := m.LoadAccount(*acc.UID)
+	if *again.Email != email || again.Password == nil || again.Name == nil || again.MFA.RecoveryCodes[0] != "a" {
+		t.Fatalf("Store changed through a returned record: %+v", again)
+	}
+}
+
+func TestSwapMFA(t *testing.T) {
+	m := NewMemStore()
+	email, name, password := "jdoe@example.com", "Jane Doe", "SuperDifficultPass"
+	acc, err := m.SaveAccount(&account.Account{Email: &email, Name: &name, Password: &password})
+	if err != nil {
+		t.Fatal("Error saving account: ", err)
+	}
+	old := &account.MFA{Secret: "s", Enabled: true, RecoveryCodes: []string{"a", "b"}}
+	if ok, err := m.SwapMFA(*acc.UID, nil, old); err != nil || !ok {
+		t.Fatal("Error setting mfa: ", err)
+	}
+	used := &account.MFA{Secret: "s", Enabled: true, RecoveryCodes: []string{"b"}}
+	if ok, err := m.SwapMFA(*acc.UID, old, used); err != nil || !ok {
+		t.Fatal("Error swapping mfa: ", err)
+	}
+	// a second request that loaded the same second factor can not use the code again
+	if ok, _ := m.SwapMFA(*acc.UID, old, used); ok {
+		t.Fatal("Second factor swapped from a stale one")
+	}
+	if _, err := m.SwapMFA("unknown", nil, old); err != store.ErrAccountNotFound {
+		t.Fatal("Expected ErrAccountNotFound, got ", err)
+	}
+}
+
+func TestSaveAccountHashError(t *testing.T) {
+	defer func(h *hasher.Manager) { account.PasswordHasher = h }(account.PasswordHasher)
+	m := NewMemStore()
+	email, name, password := "jdoe@example.com", "Jane Doe", "SuperDifficultPass"
+	acc, err := m.SaveAccount(&account.Account{Email: &email, Name: &name, Password: &password})
+	if err != nil {
+		t.Fatal("Error saving account: ", err)
+	}
+	// scrypt refuses N = 1
+	account.PasswordHasher = hasher.New(&hasher.Scrypt{LogN: 0, R: 8, P: 1})
+
+	other, newPassword := "other@example.com", "AnotherDifficultPass"
+	if _, err := m.SaveAccount(&account.Account{Email: &other, Name: &name, Password: &newPassword}); err == nil {
+		t.Fatal("Account saved with a failed hash")
+	}
+	if _, err := m.GetAccountByEmail(other); err != store.ErrAccountNotFound {
+		t.Fatal("Account stored with a failed hash: ", err)
+	}
+	update := &account.Account{UID: acc.UID, Email: &email, Name: &name, Password: &newPassword}
+	if _, err := m.SaveAccount(update); err == nil {
+		t.Fatal("Password updated with a failed hash")
+	}
+	if loaded, _ := m.LoadAccount(*acc.UID); *loaded.Password != *acc.Password {
+		t.Fatal("Password changed by a failed hash: ", *loaded.Password)
+	}
+}
+
+func TestSaveAccountWithoutPassword(t *testing.T) {
+	m := NewMemStore()
+	email, name := "jdoe@example.com", "Jane Doe"
+	acc, err := m.SaveAccount(&account.Account{Email: &email, Name: &name})
+	if err != nil {
+		t.Fatal("Error saving account without password: ", err)
+	}
+	loaded, err := m.LoadAccount(*acc.UID)
+	if err != nil || loaded.Password != nil {
+		t.Fatal("Account saved with a password: ", err)
+	}
+	if _, err := loaded.MatchPassword(""); err == nil {
+		t.Fatal("Empty password matched")
+	}
+	// the password set later, ie. with a reset, leaves the history empty
+	password := "SuperDifficultPass"
+	if _, err := m.SaveAccount(&account.Account{UID: acc.UID, Email: &email, Name: &name, Password: &password}); err != nil {
+		t.Fatal("Error setting password: ", err)
+	}
+	if loaded, _ = m.LoadAccount(*acc.UID); len(loaded.PasswordHistory) != 0 {
+		t.Fatal("Missing password remembered: ", loaded.PasswordHistory)
+	}
+	if _, err := loaded.MatchPassword(password); err != nil {
+		t.Fatal("Password not set: ", err)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	m := NewMemStore()
+	tests := []struct {
+		name string
+		err  func() error
+		want error
+	}{
+		{"LoadAccount", func() error { _, err := m.LoadAccount("unknown"); return err }, store.ErrAccountNotFound},
+		{"GetAccountByEmail", func() error { _, err := m.GetAccountByEmail("unknown@example.com"); return err }, store.ErrAccountNotFound},
+		{"SetMFA", func() error { return m.SetMFA("unknown", nil) }, store.ErrAccountNotFound},
+		{"LoadKey", func() error { _, err := m.LoadKey("unknown"); return err }, store.ErrKeyNotFound},
+		{"LoadRole", func() error { _, err := m.LoadRole(99); return err }, store.ErrRoleNotFound},
+		{"LoadSession", func() error { _, err := m.LoadSession("unknown"); return err }, store.ErrSessionNotFound},
+		{"LoadTicket", func() error { _, err := m.LoadTicket("unknown"); return err }, store.ErrTicketNotFound},
+	}
+	for _, tt := range tests {
+		if err := tt.err(); !store.IsNotFound(err) || err != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
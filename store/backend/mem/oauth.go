@@ -0,0 +1,123 @@
+package mem
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jllopis/try5/oauth"
+	"github.com/jllopis/try5/store"
+)
+
+func (s *MemStore) LoadClient(id string) (*oauth.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if c, ok := s.clients[id]; ok {
+		return copyClient(c), nil
+	}
+	return nil, store.ErrClientNotFound
+}
+
+func (s *MemStore) LoadAllClients() ([]*oauth.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	clients := make([]*oauth.Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, copyClient(c))
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Created.Before(clients[j].Created) })
+	return clients, nil
+}
+
+func (s *MemStore) SaveClient(c *oauth.Client) error {
+	if c.ID == "" {
+		return store.ErrMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[c.ID] = copyClient(c)
+	return nil
+}
+
+// DeleteClient also deletes the codes and tokens issued to the client
+func (s *MemStore) DeleteClient(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[id]; !ok {
+		return 0, nil
+	}
+	delete(s.clients, id)
+	for k, t := range s.oauthTokens {
+		if t.ClientID == id {
+			delete(s.oauthTokens, k)
+		}
+	}
+	return 1, nil
+}
+
+func (s *MemStore) LoadOAuthToken(id string) (*oauth.Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, ok := s.oauthTokens[id]; ok {
+		return copyOAuthToken(t), nil
+	}
+	return nil, store.ErrOAuthTokenNotFound
+}
+
+func (s *MemStore) SaveOAuthToken(t *oauth.Token) error {
+	if t.ID == "" {
+		return store.ErrMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.oauthTokens[t.ID] = copyOAuthToken(t)
+	return nil
+}
+
+func (s *MemStore) DeleteOAuthToken(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.oauthTokens[id]; !ok {
+		return 0, nil
+	}
+	delete(s.oauthTokens, id)
+	return 1, nil
+}
+
+func (s *MemStore) DeleteOAuthGrant(grantID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, t := range s.oauthTokens {
+		if t.GrantID == grantID {
+			delete(s.oauthTokens, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemStore) DeleteAccountOAuthTokens(uid string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, t := range s.oauthTokens {
+		if t.AccountUID == uid {
+			delete(s.oauthTokens, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemStore) DeleteExpiredOAuthTokens() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, now := 0, time.Now()
+	for id, t := range s.oauthTokens {
+		if !now.Before(t.Expires) {
+			delete(s.oauthTokens, id)
+			n++
+		}
+	}
+	return n, nil
+}
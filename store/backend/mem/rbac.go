@@ -0,0 +1,177 @@
+package mem
+
+import (
+	"time"
+
+	"github.com/jllopis/try5/rbac"
+	"github.com/jllopis/try5/store"
+)
+
+// nextID must be called with the lock held
+func (s *MemStore) nextID() int64 {
+	s.seq++
+	return s.seq
+}
+
+func (s *MemStore) LoadAllRoles() ([]*rbac.Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var roles []*rbac.Role
+	for _, r := range s.roles {
+		roles = append(roles, copyRole(r))
+	}
+	return roles, nil
+}
+
+func (s *MemStore) LoadRole(id int64) (*rbac.Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if r, ok := s.roles[id]; ok {
+		return copyRole(r), nil
+	}
+	return nil, store.ErrRoleNotFound
+}
+
+func (s *MemStore) GetRoleBySlug(slug string) (*rbac.Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.roles {
+		if *r.Slug == slug {
+			return copyRole(r), nil
+		}
+	}
+	return nil, store.ErrRoleNotFound
+}
+
+func (s *MemStore) SaveRole(role *rbac.Role) (*rbac.Role, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.roles {
+		if *r.Slug == *role.Slug && (role.ID == nil || *r.ID != *role.ID) {
+			return nil, store.ErrSlugTaken
+		}
+	}
+	now := time.Now().UTC()
+	role.Updated = &now
+	if role.ID == nil {
+		id := s.nextID()
+		role.ID = &id
+		role.Created = &now
+	} else {
+		saved, ok := s.roles[*role.ID]
+		if !ok {
+			return nil, store.ErrRoleNotFound
+		}
+		role.Created = saved.Created
+	}
+	s.roles[*role.ID] = copyRole(role)
+	return role, nil
+}
+
+func (s *MemStore) DeleteRole(id int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.roles[id]; !ok {
+		return 0, nil
+	}
+	delete(s.roles, id)
+	for gid, g := range s.grants {
+		if *g.FromRole == id || *g.ToRole == id {
+			delete(s.grants, gid)
+		}
+	}
+	for uid := range s.accountRoles {
+		s.unassignRole(uid, id)
+	}
+	return 1, nil
+}
+
+func (s *MemStore) LoadGrants(roleID int64) ([]*rbac.Grant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var grants []*rbac.Grant
+	for _, g := range s.grants {
+		if *g.ToRole == roleID {
+			grants = append(grants, copyGrant(g))
+		}
+	}
+	return grants, nil
+}
+
+func (s *MemStore) SaveGrant(grant *rbac.Grant) (*rbac.Grant, error) {
+	if grant.FromRole == nil || grant.ToRole == nil {
+		return nil, rbac.ErrInvalidGrant
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.roles[*grant.FromRole] == nil || s.roles[*grant.ToRole] == nil {
+		return nil, store.ErrRoleNotFound
+	}
+	if grant.ID == nil {
+		id := s.nextID()
+		grant.ID = &id
+	}
+	s.grants[*grant.ID] = copyGrant(grant)
+	return grant, nil
+}
+
+func (s *MemStore) DeleteGrant(id int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.grants[id]; !ok {
+		return 0, nil
+	}
+	delete(s.grants, id)
+	return 1, nil
+}
+
+func (s *MemStore) LoadAccountRoles(uid string) ([]*rbac.Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var roles []*rbac.Role
+	for _, id := range s.accountRoles[uid] {
+		if r, ok := s.roles[id]; ok {
+			roles = append(roles, copyRole(r))
+		}
+	}
+	return roles, nil
+}
+
+func (s *MemStore) AssignRole(uid string, roleID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.roles[roleID] == nil {
+		return store.ErrRoleNotFound
+	}
+	if s.accounts[uid] == nil {
+		return store.ErrAccountNotFound
+	}
+	for _, id := range s.accountRoles[uid] {
+		if id == roleID {
+			return nil
+		}
+	}
+	s.accountRoles[uid] = append(s.accountRoles[uid], roleID)
+	return nil
+}
+
+func (s *MemStore) UnassignRole(uid string, roleID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unassignRole(uid, roleID), nil
+}
+
+// unassignRole must be called with the lock held
+func (s *MemStore) unassignRole(uid string, roleID int64) int {
+	ids := s.accountRoles[uid]
+	for i, id := range ids {
+		if id == roleID {
+			s.accountRoles[uid] = append(ids[:i], ids[i+1:]...)
+			if len(s.accountRoles[uid]) == 0 {
+				delete(s.accountRoles, uid)
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package mem
+
+import (
+	"github.com/jllopis/try5/session"
+	"github.com/jllopis/try5/store"
+)
+
+func (s *MemStore) LoadSession(id string) (*session.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if sess, ok := s.sessions[id]; ok {
+		return copySession(sess), nil
+	}
+	return nil, store.ErrSessionNotFound
+}
+
+func (s *MemStore) LoadAccountSessions(uid string) ([]*session.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var sessions []*session.Session
+	for _, sess := range s.sessions {
+		if sess.AccountUID != nil && *sess.AccountUID == uid {
+			sessions = append(sessions, copySession(sess))
+		}
+	}
+	return sessions, nil
+}
+
+func (s *MemStore) SaveSession(sess *session.Session) (*session.Session, error) {
+	if sess.ID == nil {
+		return nil, store.ErrMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[*sess.ID] = copySession(sess)
+	return sess, nil
+}
+
+func (s *MemStore) DeleteSession(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[id]; !ok {
+		return 0, nil
+	}
+	delete(s.sessions, id)
+	return 1, nil
+}
+
+func (s *MemStore) DeleteAccountSessions(uid string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, sess := range s.sessions {
+		if sess.AccountUID != nil && *sess.AccountUID == uid {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,63 @@
+package mem
+
+import (
+	"time"
+
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/ticket"
+)
+
+func (s *MemStore) LoadTicket(id string) (*ticket.Ticket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, ok := s.tickets[id]; ok {
+		return copyTicket(t), nil
+	}
+	return nil, store.ErrTicketNotFound
+}
+
+func (s *MemStore) SaveTicket(t *ticket.Ticket) error {
+	if t.ID == "" {
+		return store.ErrMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tickets[t.ID] = copyTicket(t)
+	return nil
+}
+
+func (s *MemStore) DeleteTicket(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tickets[id]; !ok {
+		return 0, nil
+	}
+	delete(s.tickets, id)
+	return 1, nil
+}
+
+func (s *MemStore) DeleteAccountTickets(uid string, purpose ticket.Purpose) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, t := range s.tickets {
+		if t.AccountUID == uid && t.Purpose == purpose {
+			delete(s.tickets, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemStore) DeleteExpiredTickets() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, now := 0, time.Now()
+	for id, t := range s.tickets {
+		if !now.Before(t.Expires) {
+			delete(s.tickets, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,50 @@
+package mem
+
+import (
+	"sort"
+
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/webauthn"
+)
+
+func (s *MemStore) LoadCredential(id string) (*webauthn.Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if c, ok := s.credentials[id]; ok {
+		return copyCredential(c), nil
+	}
+	return nil, store.ErrCredentialNotFound
+}
+
+func (s *MemStore) LoadAccountCredentials(uid string) ([]*webauthn.Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var creds []*webauthn.Credential
+	for _, c := range s.credentials {
+		if c.AccountUID == uid {
+			creds = append(creds, copyCredential(c))
+		}
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].Created.Before(creds[j].Created) })
+	return creds, nil
+}
+
+func (s *MemStore) SaveCredential(c *webauthn.Credential) error {
+	if c.ID == "" {
+		return store.ErrMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credentials[c.ID] = copyCredential(c)
+	return nil
+}
+
+func (s *MemStore) DeleteCredential(id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.credentials[id]; !ok {
+		return 0, nil
+	}
+	delete(s.credentials, id)
+	return 1, nil
+}
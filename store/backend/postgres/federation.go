@@ -0,0 +1,87 @@
+package psql
+
+import (
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/store"
+)
+
+// LoadFederationProvider devuelve el proveedor de identidad cuyo id coincide con id
+func (s *PsqlStore) LoadFederationProvider(id string) (*federation.Provider, error) {
+	res := &federation.Provider{}
+	if err := s.C.Select("*").From("federation_providers").Where("id=$1", id).QueryStruct(res); err != nil {
+		return nil, storeError(err, store.ErrProviderNotFound)
+	}
+	return res, nil
+}
+
+// LoadAllFederationProviders devuelve todos los proveedores de identidad ordenados por fecha de creación
+func (s *PsqlStore) LoadAllFederationProviders() ([]*federation.Provider, error) {
+	var res []*federation.Provider
+	if err := s.C.Select("*").From("federation_providers").OrderBy("created").QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// SaveFederationProvider crea el proveedor o actualiza sus datos
+func (s *PsqlStore) SaveFederationProvider(p *federation.Provider) error {
+	if p.ID == "" {
+		return store.ErrMissingID
+	}
+	_, err := s.C.SQL(`INSERT INTO federation_providers (id, name, issuer, client_id, client_secret, scope, allow_signup, link_by_email, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, issuer = EXCLUDED.issuer, client_id = EXCLUDED.client_id,
+		client_secret = EXCLUDED.client_secret, scope = EXCLUDED.scope, allow_signup = EXCLUDED.allow_signup,
+		link_by_email = EXCLUDED.link_by_email`,
+		p.ID, p.Name, p.Issuer, p.ClientID, p.ClientSecret, p.Scope, p.AllowSignup, p.LinkByEmail, p.Created.UTC()).Exec()
+	return storeError(err, nil)
+}
+
+// DeleteFederationProvider elimina el proveedor y, en cascada, sus identidades
+func (s *PsqlStore) DeleteFederationProvider(id string) (int, error) {
+	res, err := s.C.DeleteFrom("federation_providers").Where("id=$1", id).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
+
+// LoadIdentity devuelve la identidad del sujeto subject en el proveedor providerID
+func (s *PsqlStore) LoadIdentity(providerID, subject string) (*federation.Identity, error) {
+	res := &federation.Identity{}
+	if err := s.C.Select("*").From("identities").Where("provider_id=$1 AND subject=$2", providerID, subject).QueryStruct(res); err != nil {
+		return nil, storeError(err, store.ErrIdentityNotFound)
+	}
+	return res, nil
+}
+
+// LoadAccountIdentities devuelve las identidades vinculadas al account ordenadas por fecha de creación
+func (s *PsqlStore) LoadAccountIdentities(uid string) ([]*federation.Identity, error) {
+	var res []*federation.Identity
+	if err := s.C.Select("*").From("identities").Where("account_uid=$1", uid).OrderBy("created").QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// SaveIdentity crea la identidad o actualiza sus datos
+func (s *PsqlStore) SaveIdentity(i *federation.Identity) error {
+	if i.ProviderID == "" || i.Subject == "" {
+		return store.ErrMissingID
+	}
+	_, err := s.C.SQL(`INSERT INTO identities (provider_id, subject, account_uid, email, created, last_login)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (provider_id, subject) DO UPDATE SET account_uid = EXCLUDED.account_uid, email = EXCLUDED.email,
+		last_login = EXCLUDED.last_login`,
+		i.ProviderID, i.Subject, i.AccountUID, i.Email, i.Created.UTC(), i.LastLogin.UTC()).Exec()
+	return storeError(err, nil)
+}
+
+// DeleteIdentity elimina la identidad del sujeto subject en el proveedor providerID
+func (s *PsqlStore) DeleteIdentity(providerID, subject string) (int, error) {
+	res, err := s.C.DeleteFrom("identities").Where("provider_id=$1 AND subject=$2", providerID, subject).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
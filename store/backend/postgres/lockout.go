@@ -0,0 +1,78 @@
+package psql
+
+import (
+	"time"
+
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/store"
+)
+
+// LoadAttempts devuelve los intentos fallidos registrados para key
+func (s *PsqlStore) LoadAttempts(key string) (*lockout.Attempts, error) {
+	res := &lockout.Attempts{}
+	if err := s.C.Select("*").From("login_attempts").Where("key=$1", key).QueryStruct(res); err != nil {
+		return nil, storeError(err, store.ErrLockoutNotFound)
+	}
+	return res, nil
+}
+
+// LoadAllAttempts devuelve todos los intentos fallidos registrados
+func (s *PsqlStore) LoadAllAttempts() ([]*lockout.Attempts, error) {
+	var res []*lockout.Attempts
+	if err := s.C.Select("*").From("login_attempts").OrderBy("last_failure DESC").QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// SaveAttempts crea o reemplaza los intentos fallidos de a.Key
+func (s *PsqlStore) SaveAttempts(a *lockout.Attempts) error {
+	if a.Key == "" {
+		return store.ErrMissingID
+	}
+	// the columns have no time zone, the times are stored in UTC
+	var until interface{}
+	if a.LockedUntil != nil {
+		until = a.LockedUntil.UTC()
+	}
+	_, err := s.C.SQL(`INSERT INTO login_attempts (key, failures, last_failure, locked_until)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET failures = EXCLUDED.failures,
+			last_failure = EXCLUDED.last_failure, locked_until = EXCLUDED.locked_until`,
+		a.Key, a.Failures, a.LastFailure.UTC(), until).Exec()
+	return storeError(err, nil)
+}
+
+// IncrementAttempts registra un intento fallido de key en una sola sentencia, de modo
+// que los intentos simultáneos se cuentan todos. Reproduce lockout.Policy.Fail: la
+// cuenta vuelve a 1 pasada la ventana o el bloqueo y se bloquea al llegar a max.
+func (s *PsqlStore) IncrementAttempts(p lockout.Policy, key string, max int, now time.Time) (*lockout.Attempts, error) {
+	if key == "" {
+		return nil, store.ErrMissingID
+	}
+	now = now.UTC()
+	res := &lockout.Attempts{}
+	err := s.C.SQL(`INSERT INTO login_attempts (key, failures, last_failure, locked_until)
+		VALUES ($1, 1, $2, CASE WHEN 1 >= $4 THEN $5::timestamp END)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure < $3 OR login_attempts.locked_until <= $2
+				THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure = $2,
+			locked_until = CASE WHEN (CASE WHEN login_attempts.last_failure < $3 OR login_attempts.locked_until <= $2
+				THEN 1 ELSE login_attempts.failures + 1 END) >= $4 THEN $5::timestamp END
+		RETURNING key, failures, last_failure, locked_until`,
+		key, now, now.Add(-p.Window), max, now.Add(p.Lockout)).QueryStruct(res)
+	if err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// DeleteAttempts elimina los intentos fallidos de key y devuelve el número de registros eliminados
+func (s *PsqlStore) DeleteAttempts(key string) (int, error) {
+	res, err := s.C.DeleteFrom("login_attempts").Where("key=$1", key).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
@@ -0,0 +1,127 @@
+package psql
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Migration is a versioned change of the database schema. Up applies the change and
+// Down reverts it. Both can hold several statements separated by ";".
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrationLock is the key of the advisory lock taken while migrating so only one
+// replica changes the schema at a time.
+const migrationLock = 0x74727935
+
+var (
+	ErrUnknownVersion = errors.New("unknown schema version")
+	ErrDirtySchema    = errors.New("schema has versions not known by this build")
+)
+
+// LatestVersion returns the version of the newest migration
+func LatestVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrations returns the migrations embedded in the binary, sorted by version
+func Migrations() []Migration {
+	return append([]Migration{}, migrations...)
+}
+
+// SchemaVersion returns the newest migration applied to the database or 0 if none
+func (s *PsqlStore) SchemaVersion() (int, error) {
+	var exists bool
+	if err := s.C.SQL("SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name='schema_migrations')").QueryScalar(&exists); err != nil || !exists {
+		return 0, err
+	}
+	var v int
+	if err := s.C.SQL("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").QueryScalar(&v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// Migrate applies all the pending migrations
+func (s *PsqlStore) Migrate() error {
+	return s.MigrateTo(LatestVersion())
+}
+
+// MigrateTo moves the schema to version, applying the pending migrations up to it or
+// reverting the ones above it. Everything runs in a single transaction holding an
+// advisory lock, so replicas starting at the same time wait for the first one and
+// then find nothing left to do.
+func (s *PsqlStore) MigrateTo(version int) error {
+	if version != 0 && findMigration(version) < 0 {
+		return ErrUnknownVersion
+	}
+	tx, err := s.C.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.AutoRollback()
+	if _, err := tx.SQL("SELECT pg_advisory_xact_lock($1)", migrationLock).Exec(); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(createMigrationsTable); err != nil {
+		return err
+	}
+	var applied []int64
+	if err := tx.SQL("SELECT version FROM schema_migrations ORDER BY version").QuerySlice(&applied); err != nil {
+		return err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, v := range applied {
+		if findMigration(int(v)) < 0 {
+			return ErrDirtySchema
+		}
+		done[int(v)] = true
+	}
+	// revert the newest first
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= version || !done[m.Version] {
+			continue
+		}
+		if _, err := tx.Exec(m.Down); err != nil {
+			return fmt.Errorf("migration %d %s down: %v", m.Version, m.Name, err)
+		}
+		if _, err := tx.SQL("DELETE FROM schema_migrations WHERE version=$1", m.Version).Exec(); err != nil {
+			return err
+		}
+	}
+	for _, m := range migrations {
+		if m.Version > version || done[m.Version] {
+			continue
+		}
+		if _, err := tx.Exec(m.Up); err != nil {
+			return fmt.Errorf("migration %d %s up: %v", m.Version, m.Name, err)
+		}
+		if _, err := tx.SQL("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name).Exec(); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func findMigration(version int) int {
+	i := sort.Search(len(migrations), func(i int) bool { return migrations[i].Version >= version })
+	if i < len(migrations) && migrations[i].Version == version {
+		return i
+	}
+	return -1
+}
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INT NOT NULL PRIMARY KEY,
+    name    VARCHAR(200) NOT NULL,
+    applied TIMESTAMP NOT NULL DEFAULT NOW()
+)`
@@ -0,0 +1,29 @@
+package psql
+
+import "testing"
+
+func TestMigrations(t *testing.T) {
+	ms := Migrations()
+	if len(ms) == 0 {
+		t.Fatal("No migrations embedded")
+	}
+	prev := 0
+	for _, m := range ms {
+		if m.Version <= prev {
+			t.Fatal("Migrations must be sorted by version without duplicates: ", m.Version)
+		}
+		if m.Name == "" || m.Up == "" || m.Down == "" {
+			t.Fatal("Migration needs a name, an up and a down: ", m.Version)
+		}
+		if findMigration(m.Version) < 0 {
+			t.Fatal("Migration not found: ", m.Version)
+		}
+		prev = m.Version
+	}
+	if LatestVersion() != prev {
+		t.Fatal("Wrong latest version: ", LatestVersion())
+	}
+	if findMigration(prev+1) >= 0 {
+		t.Fatal("Found an unknown version")
+	}
+}
@@ -0,0 +1,299 @@
+package psql
+
+// migrations holds the schema history, sorted by version. Never edit a migration that
+// has been released, add a new one instead.
+// The first ones use IF NOT EXISTS so databases created with the old
+// dbschema/schema.pgsql can be brought under migrations.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "accounts",
+		Up: `
+CREATE TABLE IF NOT EXISTS accounts (
+    id        SERIAL,
+    uid       VARCHAR(36),
+    email     VARCHAR(100),
+    name      VARCHAR(200),
+    password  VARCHAR(60),
+    active    BOOLEAN,
+    gravatar  VARCHAR(60),
+    created   TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated   TIMESTAMP NOT NULL DEFAULT NOW(),
+    deleted   TIMESTAMP,
+
+    CONSTRAINT accounts_pkey PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS account_idx ON accounts USING btree (id);
+CREATE INDEX IF NOT EXISTS account_email_idx ON accounts USING btree (email);`,
+		Down: `DROP TABLE IF EXISTS accounts;`,
+	},
+	{
+		Version: 2,
+		Name:    "rbac",
+		Up: `
+CREATE TABLE IF NOT EXISTS rbac_role (
+    id          SERIAL NOT NULL PRIMARY KEY,
+    slug        VARCHAR(256) UNIQUE NOT NULL,
+    name        VARCHAR(256),
+    description TEXT DEFAULT '',
+    parameters  JSONB DEFAULT '[]',
+    created     TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated     TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS rbac_role_idx ON rbac_role USING btree (id, name);
+
+CREATE TABLE IF NOT EXISTS rbac_grant (
+    id        SERIAL NOT NULL PRIMARY KEY,
+    from_role INT,
+    to_role   INT,
+    assigment JSONB NOT NULL DEFAULT '{}',
+
+    CONSTRAINT memberships_granted_fkey
+        FOREIGN KEY (from_role)
+        REFERENCES rbac_role (id)
+        ON DELETE CASCADE NOT DEFERRABLE,
+    CONSTRAINT members_fkey
+        FOREIGN KEY (to_role)
+        REFERENCES rbac_role (id)
+        ON DELETE CASCADE NOT DEFERRABLE
+);
+
+CREATE TABLE IF NOT EXISTS account_roles (
+    account_uid VARCHAR(36) NOT NULL,
+    role_id     INT NOT NULL,
+
+    CONSTRAINT account_roles_pkey PRIMARY KEY (account_uid, role_id),
+    CONSTRAINT account_roles_role_fkey
+        FOREIGN KEY (role_id)
+        REFERENCES rbac_role (id)
+        ON DELETE CASCADE NOT DEFERRABLE
+);
+
+INSERT INTO rbac_role (slug, name, description, parameters, updated)
+    SELECT 'admin', 'Administrator', 'Has every permission', '["*"]', NOW()
+    WHERE NOT EXISTS (SELECT 1 FROM rbac_role WHERE slug = 'admin');`,
+		Down: `
+DROP TABLE IF EXISTS account_roles;
+DROP TABLE IF EXISTS rbac_grant;
+DROP TABLE IF EXISTS rbac_role;`,
+	},
+	{
+		Version: 3,
+		Name:    "api_keys",
+		Up: `
+CREATE TABLE IF NOT EXISTS api_keys (
+    id          VARCHAR(32) NOT NULL PRIMARY KEY,
+    account_uid VARCHAR(36) NOT NULL,
+    name        VARCHAR(200),
+    secret_hash VARCHAR(64) NOT NULL,
+    created     TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used   TIMESTAMP,
+    revoked     TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS api_keys_account_idx ON api_keys USING btree (account_uid);`,
+		Down: `DROP TABLE IF EXISTS api_keys;`,
+	},
+	{
+		Version: 4,
+		Name:    "sessions",
+		Up: `
+CREATE TABLE IF NOT EXISTS sessions (
+    id          VARCHAR(64) NOT NULL PRIMARY KEY,
+    account_uid VARCHAR(36) NOT NULL,
+    remote_addr VARCHAR(64),
+    user_agent  TEXT,
+    created     TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_seen   TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires     TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_account_idx ON sessions USING btree (account_uid);`,
+		Down: `DROP TABLE IF EXISTS sessions;`,
+	},
+	{
+		Version: 5,
+		Name:    "cookie_keys",
+		Up: `
+CREATE TABLE IF NOT EXISTS cookie_keys (
+    id        VARCHAR(36) NOT NULL PRIMARY KEY,
+    hash_key  BYTEA NOT NULL,
+    block_key BYTEA NOT NULL,
+    created   TIMESTAMP NOT NULL DEFAULT NOW()
+);`,
+		Down: `DROP TABLE IF EXISTS cookie_keys;`,
+	},
+	{
+		Version: 6,
+		Name:    "unique_account_email",
+		Up: `
+CREATE UNIQUE INDEX accounts_email_key ON accounts (lower(email)) WHERE deleted IS NULL;
+DROP INDEX IF EXISTS account_email_idx;`,
+		Down: `
+CREATE INDEX IF NOT EXISTS account_email_idx ON accounts USING btree (email);
+DROP INDEX IF EXISTS accounts_email_key;`,
+	},
+	{
+		Version: 7,
+		Name:    "password_history",
+		Up:      `ALTER TABLE accounts ADD COLUMN password_history JSONB NOT NULL DEFAULT '[]';`,
+		Down:    `ALTER TABLE accounts DROP COLUMN password_history;`,
+	},
+	{
+		Version: 8,
+		Name:    "password_hash_text",
+		Up:      `ALTER TABLE accounts ALTER COLUMN password TYPE TEXT;`,
+		Down:    `ALTER TABLE accounts ALTER COLUMN password TYPE VARCHAR(60);`,
+	},
+	{
+		Version: 9,
+		Name:    "login_attempts",
+		Up: `
+CREATE TABLE login_attempts (
+    key          VARCHAR(300) NOT NULL PRIMARY KEY,
+    failures     INT NOT NULL DEFAULT 0,
+    last_failure TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+);`,
+		Down: `DROP TABLE IF EXISTS login_attempts;`,
+	},
+	{
+		Version: 10,
+		Name:    "tickets",
+		Up: `
+CREATE TABLE tickets (
+    id          VARCHAR(64) NOT NULL PRIMARY KEY,
+    purpose     VARCHAR(32) NOT NULL,
+    account_uid VARCHAR(36) NOT NULL,
+    created     TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires     TIMESTAMP NOT NULL
+);
+CREATE INDEX tickets_account_idx ON tickets USING btree (account_uid, purpose);`,
+		Down: `DROP TABLE IF EXISTS tickets;`,
+	},
+	{
+		Version: 11,
+		Name:    "email_verified",
+		Up:      `ALTER TABLE accounts ADD COLUMN email_verified BOOLEAN;`,
+		Down:    `ALTER TABLE accounts DROP COLUMN email_verified;`,
+	},
+	{
+		Version: 12,
+		Name:    "account_mfa",
+		Up:      `ALTER TABLE accounts ADD COLUMN mfa JSONB;`,
+		Down:    `ALTER TABLE accounts DROP COLUMN mfa;`,
+	},
+	{
+		Version: 13,
+		Name:    "webauthn_credentials",
+		Up: `
+CREATE TABLE webauthn_credentials (
+    id              VARCHAR(1400) NOT NULL PRIMARY KEY,
+    account_uid     VARCHAR(36) NOT NULL,
+    name            VARCHAR(256) NOT NULL DEFAULT '',
+    public_key      BYTEA NOT NULL,
+    algorithm       INT NOT NULL,
+    sign_count      BIGINT NOT NULL DEFAULT 0,
+    aaguid          VARCHAR(32) NOT NULL DEFAULT '',
+    transports      VARCHAR(256) NOT NULL DEFAULT '',
+    backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+    created         TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used       TIMESTAMP
+);
+CREATE INDEX webauthn_credentials_account_idx ON webauthn_credentials USING btree (account_uid);`,
+		Down: `DROP TABLE IF EXISTS webauthn_credentials;`,
+	},
+	{
+		Version: 14,
+		Name:    "oauth",
+		Up: `
+CREATE TABLE oauth_clients (
+    id            VARCHAR(32) NOT NULL PRIMARY KEY,
+    name          VARCHAR(256) NOT NULL,
+    public        BOOLEAN NOT NULL DEFAULT FALSE,
+    secret_hash   VARCHAR(64) NOT NULL DEFAULT '',
+    redirect_uris JSONB NOT NULL DEFAULT '[]',
+    grant_types   JSONB NOT NULL DEFAULT '[]',
+    scopes        JSONB NOT NULL DEFAULT '[]',
+    created       TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE TABLE oauth_tokens (
+    id             VARCHAR(64) NOT NULL PRIMARY KEY,
+    type           VARCHAR(16) NOT NULL,
+    client_id      VARCHAR(32) NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    account_uid    VARCHAR(36) NOT NULL DEFAULT '',
+    scope          TEXT NOT NULL DEFAULT '',
+    grant_id       VARCHAR(32) NOT NULL,
+    redirect_uri   TEXT NOT NULL DEFAULT '',
+    code_challenge VARCHAR(128) NOT NULL DEFAULT '',
+    created        TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires        TIMESTAMP NOT NULL
+);
+CREATE INDEX oauth_tokens_grant_idx ON oauth_tokens USING btree (grant_id);
+CREATE INDEX oauth_tokens_expires_idx ON oauth_tokens USING btree (expires);`,
+		Down: `DROP TABLE IF EXISTS oauth_tokens;
+DROP TABLE IF EXISTS oauth_clients;`,
+	},
+	{
+		Version: 15,
+		Name:    "oidc",
+		Up: `
+CREATE TABLE signing_keys (
+    id          VARCHAR(36) NOT NULL PRIMARY KEY,
+    private_key BYTEA NOT NULL,
+    created     TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ALTER TABLE oauth_tokens ADD COLUMN nonce TEXT NOT NULL DEFAULT '';`,
+		Down: `ALTER TABLE oauth_tokens DROP COLUMN IF EXISTS nonce;
+DROP TABLE IF EXISTS signing_keys;`,
+	},
+	{
+		Version: 16,
+		Name:    "federation",
+		Up: `
+CREATE TABLE federation_providers (
+    id            VARCHAR(32) NOT NULL PRIMARY KEY,
+    name          VARCHAR(256) NOT NULL,
+    issuer        TEXT NOT NULL,
+    client_id     VARCHAR(256) NOT NULL,
+    client_secret TEXT NOT NULL DEFAULT '',
+    scope         TEXT NOT NULL DEFAULT '',
+    allow_signup  BOOLEAN NOT NULL DEFAULT FALSE,
+    link_by_email BOOLEAN NOT NULL DEFAULT FALSE,
+    created       TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE TABLE identities (
+    provider_id VARCHAR(32) NOT NULL REFERENCES federation_providers (id) ON DELETE CASCADE,
+    subject     VARCHAR(256) NOT NULL,
+    account_uid VARCHAR(36) NOT NULL,
+    email       VARCHAR(256) NOT NULL DEFAULT '',
+    created     TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_login  TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT identities_pkey PRIMARY KEY (provider_id, subject)
+);
+CREATE INDEX identities_account_idx ON identities USING btree (account_uid);`,
+		Down: `DROP TABLE IF EXISTS identities;
+DROP TABLE IF EXISTS federation_providers;`,
+	},
+	{
+		Version: 17,
+		Name:    "sealed_api_keys",
+		Up: `
+ALTER TABLE api_keys ALTER COLUMN secret_hash DROP NOT NULL;
+ALTER TABLE api_keys ADD COLUMN sealed_key TEXT;
+CREATE TABLE request_signatures (
+    id      VARCHAR(64) NOT NULL PRIMARY KEY,
+    expires TIMESTAMP NOT NULL
+);
+CREATE INDEX request_signatures_expires_idx ON request_signatures USING btree (expires);`,
+		// the sealed keys can not be used by the older versions
+		Down: `DROP TABLE IF EXISTS request_signatures;
+DELETE FROM api_keys WHERE secret_hash IS NULL;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS sealed_key;
+ALTER TABLE api_keys ALTER COLUMN secret_hash SET NOT NULL;`,
+	},
+	{
+		Version: 18,
+		Name:    "oauth_tokens_account",
+		Up:      `CREATE INDEX oauth_tokens_account_idx ON oauth_tokens USING btree (account_uid);`,
+		Down:    `DROP INDEX IF EXISTS oauth_tokens_account_idx;`,
+	},
+}
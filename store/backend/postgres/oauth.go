@@ -0,0 +1,105 @@
+package psql
+
+import (
+	"time"
+
+	"github.com/jllopis/try5/oauth"
+	"github.com/jllopis/try5/store"
+)
+
+// LoadClient devuelve el cliente OAuth cuyo id coincide con id
+func (s *PsqlStore) LoadClient(id string) (*oauth.Client, error) {
+	res := &oauth.Client{}
+	if err := s.C.Select("*").From("oauth_clients").Where("id=$1", id).QueryStruct(res); err != nil {
+		return nil, storeError(err, store.ErrClientNotFound)
+	}
+	return res, nil
+}
+
+// LoadAllClients devuelve todos los clientes OAuth ordenados por fecha de creación
+func (s *PsqlStore) LoadAllClients() ([]*oauth.Client, error) {
+	var res []*oauth.Client
+	if err := s.C.Select("*").From("oauth_clients").OrderBy("created").QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// SaveClient crea el cliente o actualiza sus datos
+func (s *PsqlStore) SaveClient(c *oauth.Client) error {
+	if c.ID == "" {
+		return store.ErrMissingID
+	}
+	// the columns have no time zone, the times are stored in UTC
+	_, err := s.C.SQL(`INSERT INTO oauth_clients (id, name, public, secret_hash, redirect_uris, grant_types, scopes, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, public = EXCLUDED.public, secret_hash = EXCLUDED.secret_hash,
+		redirect_uris = EXCLUDED.redirect_uris, grant_types = EXCLUDED.grant_types, scopes = EXCLUDED.scopes`,
+		c.ID, c.Name, c.Public, c.SecretHash, c.RedirectURIs, c.GrantTypes, c.Scopes, c.Created.UTC()).Exec()
+	return storeError(err, nil)
+}
+
+// DeleteClient elimina el cliente y, en cascada, sus códigos y tokens
+func (s *PsqlStore) DeleteClient(id string) (int, error) {
+	res, err := s.C.DeleteFrom("oauth_clients").Where("id=$1", id).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
+
+// LoadOAuthToken devuelve el código o token OAuth cuyo id coincide con id
+func (s *PsqlStore) LoadOAuthToken(id string) (*oauth.Token, error) {
+	res := &oauth.Token{}
+	if err := s.C.Select("*").From("oauth_tokens").Where("id=$1", id).QueryStruct(res); err != nil {
+		return nil, storeError(err, store.ErrOAuthTokenNotFound)
+	}
+	return res, nil
+}
+
+// SaveOAuthToken guarda un nuevo código o token OAuth
+func (s *PsqlStore) SaveOAuthToken(t *oauth.Token) error {
+	if t.ID == "" {
+		return store.ErrMissingID
+	}
+	_, err := s.C.SQL(`INSERT INTO oauth_tokens (id, type, client_id, account_uid, scope, grant_id, redirect_uri, code_challenge, nonce, created, expires)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		t.ID, string(t.Type), t.ClientID, t.AccountUID, t.Scope, t.GrantID, t.RedirectURI, t.Challenge, t.Nonce, t.Created.UTC(), t.Expires.UTC()).Exec()
+	return storeError(err, nil)
+}
+
+// DeleteOAuthToken elimina el código o token y devuelve el número de registros eliminados
+func (s *PsqlStore) DeleteOAuthToken(id string) (int, error) {
+	res, err := s.C.DeleteFrom("oauth_tokens").Where("id=$1", id).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
+
+// DeleteOAuthGrant elimina todos los tokens emitidos en una misma autorización
+func (s *PsqlStore) DeleteOAuthGrant(grantID string) (int, error) {
+	res, err := s.C.DeleteFrom("oauth_tokens").Where("grant_id=$1", grantID).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
+
+// DeleteAccountOAuthTokens elimina todos los códigos y tokens emitidos para el account
+func (s *PsqlStore) DeleteAccountOAuthTokens(uid string) (int, error) {
+	res, err := s.C.DeleteFrom("oauth_tokens").Where("account_uid=$1", uid).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
+
+// DeleteExpiredOAuthTokens elimina los códigos y tokens caducados
+func (s *PsqlStore) DeleteExpiredOAuthTokens() (int, error) {
+	res, err := s.C.DeleteFrom("oauth_tokens").Where("expires <= $1", time.Now().UTC()).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
@@ -2,12 +2,21 @@ package psql
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
+	"net"
+	"strings"
 	"time"
 
 	"code.google.com/p/go-uuid/uuid"
 
 	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/keyring"
+	"github.com/jllopis/try5/oidc"
+	"github.com/jllopis/try5/store"
+	"github.com/lib/pq"
 	"github.com/mgutz/dat/v1"
 	"github.com/mgutz/dat/v1/sqlx-runner"
 )
@@ -16,7 +25,7 @@ import (
 // to connect to it.
 type PsqlStore struct {
 	C      *runner.Connection
-	Status int
+	status int
 	PsqlStoreOptions
 }
 
@@ -30,12 +39,14 @@ type PsqlStoreOptions struct {
 	SSLMode      string
 	MaxIdleConns int
 	MaxOpenConns int
+	// SkipMigrations does not apply the pending migrations when the store is opened
+	SkipMigrations bool
 }
 
 // OpenPgSQLStore inicializa la conexión con la base de datos utilizando la configuración por defecto. Es una función variádica que acepta el paso de funciones del tipo func(*PsqlStore) error para la configuración
 func OpenPgSQLStore(opts *PsqlStoreOptions) (*PsqlStore, error) {
 	r := &PsqlStore{
-		Status: 0,
+		status: store.DISCONNECTED,
 	}
 	r.PsqlStoreOptions = *opts
 	if r.SSLMode == "" {
@@ -45,7 +56,7 @@ func OpenPgSQLStore(opts *PsqlStoreOptions) (*PsqlStore, error) {
 		r.MaxIdleConns = 20
 	}
 	if r.MaxOpenConns == 0 {
-		r.MaxIdleConns = 30
+		r.MaxOpenConns = 30
 	}
 	ds := fmt.Sprintf("user=%s dbname=%s sslmode=%s password=%s host=%s port=%d", r.User, r.DBName, r.SSLMode, r.Password, r.Host, r.Port)
 	db, err := sql.Open("postgres", ds)
@@ -66,10 +77,28 @@ func OpenPgSQLStore(opts *PsqlStoreOptions) (*PsqlStore, error) {
 	// Should be disabled in production/release builds.
 	dat.Strict = false
 	r.C = runner.NewConnection(db, "postgres")
+	if !r.SkipMigrations {
+		if err := r.Migrate(); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	r.status = store.CONNECTED
 
 	return r, nil
 }
 
+// Status devuelve el estado de la conexión con la base de datos
+func (s *PsqlStore) Status() (int, string) {
+	return s.status, store.StatusStr[s.status]
+}
+
+// Close cierra la conexión con la base de datos
+func (s *PsqlStore) Close() error {
+	s.status = store.DISCONNECTED
+	return s.C.DB.Close()
+}
+
 var (
 	notDeleted = dat.NewScope(
 		"WHERE deleted IS NULL", nil)
@@ -79,7 +108,7 @@ func (s *PsqlStore) LoadAccount(uuid string) (*account.Account, error) {
 	//var res *account.Account
 	res := &account.Account{}
 	if err := s.C.Select("*").From("accounts").Where("uid=$1 AND deleted IS NULL", uuid).QueryStruct(res); err != nil {
-		return nil, err
+		return nil, storeError(err, store.ErrAccountNotFound)
 	}
 	return res, nil
 }
@@ -87,47 +116,446 @@ func (s *PsqlStore) LoadAccount(uuid string) (*account.Account, error) {
 func (s *PsqlStore) LoadAllAccounts() ([]*account.Account, error) {
 	var res []*account.Account
 	if err := s.C.Select("*").From("accounts").ScopeMap(notDeleted, nil).QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// sortColumns es la expresión por la que se ordena cada SortField. Debe dar el mismo
+// valor que AccountQuery.SortKey para que el cursor sea válido.
+var sortColumns = map[store.SortField]string{
+	store.SortCreated: "created",
+	store.SortEmail:   "lower(email)",
+	store.SortName:    "lower(coalesce(name, ''))",
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// ListAccounts devuelve una página de accounts usando paginación keyset: el cursor
+// se traduce en una condición sobre (columna de orden, uid) y no se usa OFFSET.
+func (s *PsqlStore) ListAccounts(q *store.AccountQuery) (*store.AccountPage, error) {
+	if err := q.Normalize(); err != nil {
 		return nil, err
 	}
+	cursor, err := store.DecodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	col := sortColumns[q.Sort]
+	where := []string{"deleted IS NULL"}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if q.Active != nil {
+		where = append(where, "active="+arg(*q.Active))
+	}
+	if q.EmailPrefix != "" {
+		where = append(where, "lower(email) LIKE "+arg(likeEscaper.Replace(q.EmailPrefix)+"%"))
+	}
+	if q.CreatedAfter != nil {
+		where = append(where, "created>="+arg(q.CreatedAfter.UTC()))
+	}
+	if q.CreatedBefore != nil {
+		where = append(where, "created<="+arg(q.CreatedBefore.UTC()))
+	}
+	if q.Name != "" {
+		where = append(where, "lower(name) LIKE "+arg("%"+likeEscaper.Replace(q.Name)+"%"))
+	}
+	order, op := "ASC", ">"
+	if q.Desc {
+		order, op = "DESC", "<"
+	}
+	if cursor != nil {
+		var key interface{} = cursor.Key
+		if q.Sort == store.SortCreated {
+			if key, err = store.ParseTimeKey(cursor.Key); err != nil {
+				return nil, err
+			}
+		}
+		where = append(where, fmt.Sprintf("(%s, uid) %s (%s, %s)", col, op, arg(key), arg(cursor.UID)))
+	}
+	query := fmt.Sprintf("SELECT * FROM accounts WHERE %s ORDER BY %s %s, uid %s LIMIT %d",
+		strings.Join(where, " AND "), col, order, order, q.Limit+1)
+
+	var res []*account.Account
+	if err := s.C.SQL(query, args...).QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	page := &store.AccountPage{Accounts: res}
+	if len(res) > q.Limit {
+		page.Accounts = res[:q.Limit]
+		page.NextCursor = q.PageCursor(page.Accounts[q.Limit-1])
+	}
+	return page, nil
+}
+
+// GetAccountByEmail devuelve el account no eliminado cuyo email coincide con email
+func (s *PsqlStore) GetAccountByEmail(email string) (*account.Account, error) {
+	res := &account.Account{}
+	if err := s.C.Select("*").From("accounts").Where("lower(email)=lower($1) AND deleted IS NULL", account.NormalizeEmail(email)).QueryStruct(res); err != nil {
+		return nil, storeError(err, store.ErrAccountNotFound)
+	}
 	return res, nil
 }
 
 // Saveaccount creates a new account if account.UID has zero value or updates the account otherwise.
+// The password is only hashed when it changes and the deleted accounts can not be updated.
 func (s *PsqlStore) SaveAccount(account *account.Account) (*account.Account, error) {
 	now := time.Now().UTC()
 	account.Updated = &now
-	if account.Password != nil {
-		account.UpdatePassword(*account.Password)
-	}
 	switch account.UID {
 	case nil:
+		// los accounts autenticados en otro sitio se crean sin password
+		if account.Password != nil {
+			if err := account.UpdatePassword(*account.Password); err != nil {
+				return nil, err
+			}
+		}
+		if account.Active == nil {
+			t := true
+			account.Active = &t
+		}
 		u := uuid.New()
 		account.UID = &u
 		account.Created = &now
-		if err := s.C.InsertInto("accounts").Blacklist("id").Record(account).Returning("id").QueryScalar(&account.ID); err != nil {
-			return account, err
+		if err := s.C.InsertInto("accounts").Blacklist("id", "deleted").Record(account).Returning("id").QueryScalar(&account.ID); err != nil {
+			return nil, storeError(err, store.ErrAccountNotFound)
 		}
 	default:
-		// TODO check if the provided uid effectively exists in the database prior to update
-		if _, err := s.C.Update("accounts").SetBlacklist(account, "id", "uid", "created").Where("uid=$1", *account.UID).Exec(); err != nil {
-			return nil, err
+		saved, err := s.LoadAccount(*account.UID)
+		if err != nil {
+			return nil, storeError(err, store.ErrAccountNotFound)
+		}
+		account.ID = saved.ID
+		account.Created = saved.Created
+		account.PasswordHistory = saved.PasswordHistory
+		switch {
+		case account.Password == nil:
+			account.Password = saved.Password
+		case saved.Password == nil || *account.Password != *saved.Password:
+			account.RememberPassword(saved.Password)
+			if err := account.UpdatePassword(*account.Password); err != nil {
+				return nil, err
+			}
+		}
+		if account.Active == nil {
+			account.Active = saved.Active
+		}
+		if account.EmailVerified == nil {
+			account.EmailVerified = saved.EmailVerified
+		}
+		// el segundo factor solo se modifica con SetMFA
+		account.MFA = saved.MFA
+		res, err := s.C.Update("accounts").SetBlacklist(account, "id", "uid", "created", "deleted").Where("uid=$1 AND deleted IS NULL", *account.UID).Exec()
+		if err != nil {
+			return nil, storeError(err, store.ErrAccountNotFound)
+		}
+		if res.RowsAffected == 0 {
+			return nil, store.ErrAccountNotFound
 		}
 	}
 	return account, nil
 }
 
-// Deleteaccount elimina de la base de datos el account cuyo id coincide con id.
+// constraintErrors maps the violations of the constraints to the store errors
+var constraintErrors = map[string]*store.Error{
+	"accounts_email_key":       store.ErrEmailTaken,
+	"rbac_role_slug_key":       store.ErrSlugTaken,
+	"account_roles_role_fkey":  store.ErrRoleNotFound,
+	"memberships_granted_fkey": store.ErrRoleNotFound,
+	"members_fkey":             store.ErrRoleNotFound,
+}
+
+// storeError translates the database errors into store errors so no PostgreSQL
+// details leak to the callers. notFound is returned when there are no rows.
+func storeError(err error, notFound *store.Error) error {
+	if err == nil {
+		return nil
+	}
+	if err == sql.ErrNoRows && notFound != nil {
+		return notFound
+	}
+	if err == driver.ErrBadConn {
+		return store.UnavailableError(err)
+	}
+	if _, ok := err.(net.Error); ok {
+		return store.UnavailableError(err)
+	}
+	if pqErr, ok := err.(*pq.Error); ok {
+		if e, ok := constraintErrors[pqErr.Constraint]; ok {
+			return e
+		}
+		switch pqErr.Code.Class() {
+		case "23":
+			if pqErr.Code == "23505" {
+				return &store.Error{Kind: store.Conflict, Code: "conflict", Message: "duplicated record", Err: err}
+			}
+			return store.InvalidError(err)
+		case "22":
+			return store.InvalidError(err)
+		case "08", "53", "57":
+			return store.UnavailableError(err)
+		}
+	}
+	return err
+}
+
+// SetPasswordHash reemplaza el hash de la password sin modificar el histórico
+func (s *PsqlStore) SetPasswordHash(uuid string, hash string) error {
+	res, err := s.C.Update("accounts").Set("password", hash).Where("uid=$1 AND deleted IS NULL", uuid).Exec()
+	if err != nil {
+		return storeError(err, store.ErrAccountNotFound)
+	}
+	if res.RowsAffected == 0 {
+		return store.ErrAccountNotFound
+	}
+	return nil
+}
+
+// SetMFA reemplaza el segundo factor del account, nil lo elimina
+func (s *PsqlStore) SetMFA(uuid string, m *account.MFA) error {
+	res, err := s.C.Update("accounts").Set("mfa", m).Where("uid=$1 AND deleted IS NULL", uuid).Exec()
+	if err != nil {
+		return storeError(err, store.ErrAccountNotFound)
+	}
+	if res.RowsAffected == 0 {
+		return store.ErrAccountNotFound
+	}
+	return nil
+}
+
+// SwapMFA reemplaza el segundo factor del account por m solo si sigue siendo old,
+// comparando en la misma sentencia el secreto, el contador y los códigos de
+// recuperación. Devuelve false si ha cambiado.
+func (s *PsqlStore) SwapMFA(uuid string, old, m *account.MFA) (bool, error) {
+	if old == nil {
+		res, err := s.C.Update("accounts").Set("mfa", m).Where("uid=$1 AND deleted IS NULL AND mfa IS NULL", uuid).Exec()
+		if err != nil {
+			return false, storeError(err, store.ErrAccountNotFound)
+		}
+		return s.swapped(uuid, res.RowsAffected)
+	}
+	codes := old.RecoveryCodes
+	if codes == nil {
+		codes = []string{}
+	}
+	data, err := json.Marshal(codes)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.C.Update("accounts").Set("mfa", m).
+		Where(`uid=$1 AND deleted IS NULL AND mfa->>'secret' = $2 AND (mfa->>'enabled')::boolean = $3
+			AND (mfa->>'last_counter')::bigint = $4 AND COALESCE(mfa->'recovery_codes', '[]'::jsonb) = $5::jsonb`,
+			uuid, old.Secret, old.Enabled, old.LastCounter, string(data)).Exec()
+	if err != nil {
+		return false, storeError(err, store.ErrAccountNotFound)
+	}
+	return s.swapped(uuid, res.RowsAffected)
+}
+
+// swapped distingue, si no se ha modificado ningún registro, el account inexistente
+// del segundo factor que ha cambiado
+func (s *PsqlStore) swapped(uuid string, n int64) (bool, error) {
+	if n > 0 {
+		return true, nil
+	}
+	if _, err := s.LoadAccount(uuid); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Deleteaccount marca como eliminado el account cuyo uid coincide con uuid. Los
+// registros no se borran, se les asigna la fecha en la columna deleted y dejan de
+// ser visibles para el resto de operaciones.
 // Si la petición tiene éxito, devuelve el número de registros eliminados.
 //
-// Si aparece un error, devuelve el error del tipo *pq.Error
+// Si aparece un error, devuelve un error del tipo *store.Error
 func (s *PsqlStore) DeleteAccount(uuid string) (int, error) {
 	var err error
 	var res *dat.Result
-	if res, err = s.C.DeleteFrom("accounts").Where("uid = $1", uuid).Exec(); err != nil {
-		return 0, err
+	if res, err = s.C.Update("accounts").Set("deleted", dat.NOW).Where("uid = $1 AND deleted IS NULL", uuid).Exec(); err != nil {
+		return 0, storeError(err, nil)
 	}
-	if res.RowsAffected == 0 {
-		return 0, nil
+	return int(res.RowsAffected), nil
+}
+
+// LoadKey devuelve la api key cuyo id coincide con id
+func (s *PsqlStore) LoadKey(id string) (*apikey.Key, error) {
+	res := &apikey.Key{}
+	if err := s.C.Select("*").From("api_keys").Where("id=$1", id).QueryStruct(res); err != nil {
+		return nil, storeError(err, store.ErrKeyNotFound)
+	}
+	return res, nil
+}
+
+// RecordSignature registra la firma id hasta expires. Devuelve false si ya estaba registrada
+// y no ha caducado, la inserción es atómica entre servidores
+func (s *PsqlStore) RecordSignature(id string, expires time.Time) (bool, error) {
+	// the columns have no time zone, the times are stored in UTC
+	res, err := s.C.SQL(`INSERT INTO request_signatures (id, expires) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET expires = EXCLUDED.expires
+		WHERE request_signatures.expires <= $3`,
+		id, expires.UTC(), time.Now().UTC()).Exec()
+	if err != nil {
+		return false, storeError(err, nil)
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// DeleteExpiredSignatures elimina las firmas caducadas
+func (s *PsqlStore) DeleteExpiredSignatures() (int, error) {
+	res, err := s.C.DeleteFrom("request_signatures").Where("expires <= $1", time.Now().UTC()).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
 	}
 	return int(res.RowsAffected), nil
 }
+
+// LoadAccountKeys devuelve todas las api keys del account con uid accountUID
+func (s *PsqlStore) LoadAccountKeys(accountUID string) ([]*apikey.Key, error) {
+	var res []*apikey.Key
+	if err := s.C.Select("*").From("api_keys").Where("account_uid=$1", accountUID).QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// SaveKey crea la api key si no existe o actualiza los datos mutables (last_used, revoked y la
+// clave de firma al cifrarla) en caso contrario
+func (s *PsqlStore) SaveKey(key *apikey.Key) (*apikey.Key, error) {
+	if key.ID == nil {
+		return nil, store.ErrMissingID
+	}
+	var n int
+	if err := s.C.Select("count(*)").From("api_keys").Where("id=$1", *key.ID).QueryScalar(&n); err != nil {
+		return nil, storeError(err, nil)
+	}
+	if n == 0 {
+		if _, err := s.C.InsertInto("api_keys").Whitelist("*").Record(key).Exec(); err != nil {
+			return nil, storeError(err, nil)
+		}
+		return key, nil
+	}
+	if _, err := s.C.Update("api_keys").SetWhitelist(key, "name", "secret_hash", "sealed_key", "last_used", "revoked").Where("id=$1", *key.ID).Exec(); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return key, nil
+}
+
+// DeleteKey elimina la api key cuyo id coincide con id y devuelve el número de registros eliminados
+func (s *PsqlStore) DeleteKey(id string) (int, error) {
+	res, err := s.C.DeleteFrom("api_keys").Where("id=$1", id).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
+
+// LoadCookieKeys devuelve las claves de las cookies, de la más nueva a la más antigua
+func (s *PsqlStore) LoadCookieKeys() ([]*keyring.Key, error) {
+	var res []*keyring.Key
+	if err := s.C.Select("*").From("cookie_keys").OrderBy("created DESC").QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// LoadSigningKeys devuelve las claves de firma de los ID tokens, de la más nueva a la más antigua
+func (s *PsqlStore) LoadSigningKeys() ([]*oidc.Key, error) {
+	var res []*oidc.Key
+	if err := s.C.Select("*").From("signing_keys").OrderBy("created DESC").QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// SaveSigningKeys reemplaza las claves de firma almacenadas por keys en una única transacción
+func (s *PsqlStore) SaveSigningKeys(keys []*oidc.Key) error {
+	tx, err := s.C.Begin()
+	if err != nil {
+		return storeError(err, nil)
+	}
+	defer tx.AutoRollback()
+	if _, err := tx.DeleteFrom("signing_keys").Exec(); err != nil {
+		return storeError(err, nil)
+	}
+	for _, k := range keys {
+		if _, err := tx.InsertInto("signing_keys").Whitelist("*").Record(k).Exec(); err != nil {
+			return storeError(err, nil)
+		}
+	}
+	return storeError(tx.Commit(), nil)
+}
+
+// InitSigningKeys guarda keys sólo si no hay claves de firma almacenadas. La tabla se
+// bloquea para que las réplicas que arrancan a la vez guarden un único conjunto.
+func (s *PsqlStore) InitSigningKeys(keys []*oidc.Key) error {
+	tx, err := s.C.Begin()
+	if err != nil {
+		return storeError(err, nil)
+	}
+	defer tx.AutoRollback()
+	if _, err := tx.SQL("LOCK TABLE signing_keys IN SHARE ROW EXCLUSIVE MODE").Exec(); err != nil {
+		return storeError(err, nil)
+	}
+	var n int
+	if err := tx.SQL("SELECT count(*) FROM signing_keys").QueryScalar(&n); err != nil {
+		return storeError(err, nil)
+	}
+	if n > 0 {
+		return nil
+	}
+	for _, k := range keys {
+		if _, err := tx.InsertInto("signing_keys").Whitelist("*").Record(k).Exec(); err != nil {
+			return storeError(err, nil)
+		}
+	}
+	return storeError(tx.Commit(), nil)
+}
+
+// SaveCookieKeys reemplaza las claves almacenadas por keys en una única transacción
+func (s *PsqlStore) SaveCookieKeys(keys []*keyring.Key) error {
+	tx, err := s.C.Begin()
+	if err != nil {
+		return storeError(err, nil)
+	}
+	defer tx.AutoRollback()
+	if _, err := tx.DeleteFrom("cookie_keys").Exec(); err != nil {
+		return storeError(err, nil)
+	}
+	for _, k := range keys {
+		if _, err := tx.InsertInto("cookie_keys").Whitelist("*").Record(k).Exec(); err != nil {
+			return storeError(err, nil)
+		}
+	}
+	return storeError(tx.Commit(), nil)
+}
+
+// InitCookieKeys guarda keys sólo si no hay claves de cookies almacenadas. La tabla se
+// bloquea para que las réplicas que arrancan a la vez guarden un único conjunto.
+func (s *PsqlStore) InitCookieKeys(keys []*keyring.Key) error {
+	tx, err := s.C.Begin()
+	if err != nil {
+		return storeError(err, nil)
+	}
+	defer tx.AutoRollback()
+	if _, err := tx.SQL("LOCK TABLE cookie_keys IN SHARE ROW EXCLUSIVE MODE").Exec(); err != nil {
+		return storeError(err, nil)
+	}
+	var n int
+	if err := tx.SQL("SELECT count(*) FROM cookie_keys").QueryScalar(&n); err != nil {
+		return storeError(err, nil)
+	}
+	if n > 0 {
+		return nil
+	}
+	for _, k := range keys {
+		if _, err := tx.InsertInto("cookie_keys").Whitelist("*").Record(k).Exec(); err != nil {
+			return storeError(err, nil)
+		}
+	}
+	return storeError(tx.Commit(), nil)
+}
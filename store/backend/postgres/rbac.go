@@ -0,0 +1,131 @@
+package psql
+
+import (
+	"time"
+
+	"github.com/jllopis/try5/rbac"
+	"github.com/jllopis/try5/store"
+)
+
+// LoadAllRoles devuelve todos los roles definidos
+func (s *PsqlStore) LoadAllRoles() ([]*rbac.Role, error) {
+	var res []*rbac.Role
+	if err := s.C.Select("*").From("rbac_role").OrderBy("id").QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// LoadRole devuelve el rol cuyo id coincide con id
+func (s *PsqlStore) LoadRole(id int64) (*rbac.Role, error) {
+	res := &rbac.Role{}
+	if err := s.C.Select("*").From("rbac_role").Where("id=$1", id).QueryStruct(res); err != nil {
+		return nil, storeError(err, store.ErrRoleNotFound)
+	}
+	return res, nil
+}
+
+// GetRoleBySlug devuelve el rol cuyo slug coincide con slug
+func (s *PsqlStore) GetRoleBySlug(slug string) (*rbac.Role, error) {
+	res := &rbac.Role{}
+	if err := s.C.Select("*").From("rbac_role").Where("slug=$1", slug).QueryStruct(res); err != nil {
+		return nil, storeError(err, store.ErrRoleNotFound)
+	}
+	return res, nil
+}
+
+// SaveRole crea el rol si role.ID es nil o lo actualiza en caso contrario
+func (s *PsqlStore) SaveRole(role *rbac.Role) (*rbac.Role, error) {
+	now := time.Now().UTC()
+	role.Updated = &now
+	switch role.ID {
+	case nil:
+		role.Created = &now
+		if err := s.C.InsertInto("rbac_role").Blacklist("id").Record(role).Returning("id").QueryScalar(&role.ID); err != nil {
+			return nil, storeError(err, store.ErrRoleNotFound)
+		}
+	default:
+		res, err := s.C.Update("rbac_role").SetBlacklist(role, "id", "created").Where("id=$1", *role.ID).Exec()
+		if err != nil {
+			return nil, storeError(err, store.ErrRoleNotFound)
+		}
+		if res.RowsAffected == 0 {
+			return nil, store.ErrRoleNotFound
+		}
+	}
+	return role, nil
+}
+
+// DeleteRole elimina el rol. Los grants se eliminan en cascada por las foreign keys
+// y las asignaciones a accounts también.
+func (s *PsqlStore) DeleteRole(id int64) (int, error) {
+	res, err := s.C.DeleteFrom("rbac_role").Where("id=$1", id).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
+
+// LoadGrants devuelve los grants cuyo to_role es roleID, es decir, los roles que hereda roleID
+func (s *PsqlStore) LoadGrants(roleID int64) ([]*rbac.Grant, error) {
+	var res []*rbac.Grant
+	if err := s.C.Select("*").From("rbac_grant").Where("to_role=$1", roleID).QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// SaveGrant crea un nuevo grant entre dos roles
+func (s *PsqlStore) SaveGrant(grant *rbac.Grant) (*rbac.Grant, error) {
+	if grant.FromRole == nil || grant.ToRole == nil {
+		return nil, rbac.ErrInvalidGrant
+	}
+	assignment := []byte("{}")
+	if len(grant.Assignment) > 0 {
+		assignment = grant.Assignment
+	}
+	if err := s.C.InsertInto("rbac_grant").
+		Columns("from_role", "to_role", "assigment").
+		Values(*grant.FromRole, *grant.ToRole, string(assignment)).
+		Returning("id").QueryScalar(&grant.ID); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return grant, nil
+}
+
+// DeleteGrant elimina el grant cuyo id coincide con id
+func (s *PsqlStore) DeleteGrant(id int64) (int, error) {
+	res, err := s.C.DeleteFrom("rbac_grant").Where("id=$1", id).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
+
+// LoadAccountRoles devuelve los roles asignados directamente al account
+func (s *PsqlStore) LoadAccountRoles(uid string) ([]*rbac.Role, error) {
+	var res []*rbac.Role
+	if err := s.C.SQL(`SELECT r.* FROM rbac_role r
+		JOIN account_roles ar ON ar.role_id = r.id
+		WHERE ar.account_uid = $1 ORDER BY r.id`, uid).QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// AssignRole asigna el rol al account. Si ya estaba asignado no hace nada.
+func (s *PsqlStore) AssignRole(uid string, roleID int64) error {
+	_, err := s.C.SQL(`INSERT INTO account_roles (account_uid, role_id)
+		SELECT $1, $2 WHERE NOT EXISTS
+		(SELECT 1 FROM account_roles WHERE account_uid = $1 AND role_id = $2)`, uid, roleID).Exec()
+	return storeError(err, nil)
+}
+
+// UnassignRole elimina la asignación del rol al account
+func (s *PsqlStore) UnassignRole(uid string, roleID int64) (int, error) {
+	res, err := s.C.DeleteFrom("account_roles").Where("account_uid=$1 AND role_id=$2", uid, roleID).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
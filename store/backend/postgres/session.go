@@ -0,0 +1,59 @@
+package psql
+
+import (
+	"github.com/jllopis/try5/session"
+	"github.com/jllopis/try5/store"
+)
+
+// LoadSession devuelve la sesión cuyo id coincide con id
+func (s *PsqlStore) LoadSession(id string) (*session.Session, error) {
+	res := &session.Session{}
+	if err := s.C.Select("*").From("sessions").Where("id=$1", id).QueryStruct(res); err != nil {
+		return nil, storeError(err, store.ErrSessionNotFound)
+	}
+	return res, nil
+}
+
+// LoadAccountSessions devuelve las sesiones del account
+func (s *PsqlStore) LoadAccountSessions(uid string) ([]*session.Session, error) {
+	var res []*session.Session
+	if err := s.C.Select("*").From("sessions").Where("account_uid=$1", uid).OrderBy("created").QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// SaveSession crea la sesión si no existe o actualiza last_seen en caso contrario
+func (s *PsqlStore) SaveSession(sess *session.Session) (*session.Session, error) {
+	if sess.ID == nil {
+		return nil, store.ErrMissingID
+	}
+	res, err := s.C.Update("sessions").SetWhitelist(sess, "last_seen", "expires").Where("id=$1", *sess.ID).Exec()
+	if err != nil {
+		return nil, storeError(err, nil)
+	}
+	if res.RowsAffected == 0 {
+		if _, err := s.C.InsertInto("sessions").Whitelist("*").Record(sess).Exec(); err != nil {
+			return nil, storeError(err, nil)
+		}
+	}
+	return sess, nil
+}
+
+// DeleteSession elimina la sesión y devuelve el número de registros eliminados
+func (s *PsqlStore) DeleteSession(id string) (int, error) {
+	res, err := s.C.DeleteFrom("sessions").Where("id=$1", id).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
+
+// DeleteAccountSessions elimina todas las sesiones del account
+func (s *PsqlStore) DeleteAccountSessions(uid string) (int, error) {
+	res, err := s.C.DeleteFrom("sessions").Where("account_uid=$1", uid).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
@@ -0,0 +1,55 @@
+package psql
+
+import (
+	"time"
+
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/ticket"
+)
+
+// LoadTicket devuelve el ticket cuyo id coincide con id
+func (s *PsqlStore) LoadTicket(id string) (*ticket.Ticket, error) {
+	res := &ticket.Ticket{}
+	if err := s.C.Select("*").From("tickets").Where("id=$1", id).QueryStruct(res); err != nil {
+		return nil, storeError(err, store.ErrTicketNotFound)
+	}
+	return res, nil
+}
+
+// SaveTicket guarda un nuevo ticket
+func (s *PsqlStore) SaveTicket(t *ticket.Ticket) error {
+	if t.ID == "" {
+		return store.ErrMissingID
+	}
+	// the columns have no time zone, the times are stored in UTC
+	_, err := s.C.SQL(`INSERT INTO tickets (id, purpose, account_uid, created, expires) VALUES ($1, $2, $3, $4, $5)`,
+		t.ID, string(t.Purpose), t.AccountUID, t.Created.UTC(), t.Expires.UTC()).Exec()
+	return storeError(err, nil)
+}
+
+// DeleteTicket elimina el ticket y devuelve el número de registros eliminados
+func (s *PsqlStore) DeleteTicket(id string) (int, error) {
+	res, err := s.C.DeleteFrom("tickets").Where("id=$1", id).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
+
+// DeleteAccountTickets elimina los tickets del account para purpose
+func (s *PsqlStore) DeleteAccountTickets(uid string, purpose ticket.Purpose) (int, error) {
+	res, err := s.C.DeleteFrom("tickets").Where("account_uid=$1 AND purpose=$2", uid, string(purpose)).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
+
+// DeleteExpiredTickets elimina los tickets caducados
+func (s *PsqlStore) DeleteExpiredTickets() (int, error) {
+	res, err := s.C.DeleteFrom("tickets").Where("expires <= $1", time.Now().UTC()).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
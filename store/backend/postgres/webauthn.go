@@ -0,0 +1,50 @@
+package psql
+
+import (
+	"github.com/jllopis/try5/store"
+	"github.com/jllopis/try5/webauthn"
+)
+
+// LoadCredential devuelve la credencial WebAuthn cuyo id coincide con id
+func (s *PsqlStore) LoadCredential(id string) (*webauthn.Credential, error) {
+	res := &webauthn.Credential{}
+	if err := s.C.Select("*").From("webauthn_credentials").Where("id=$1", id).QueryStruct(res); err != nil {
+		return nil, storeError(err, store.ErrCredentialNotFound)
+	}
+	return res, nil
+}
+
+// LoadAccountCredentials devuelve las credenciales WebAuthn del account
+func (s *PsqlStore) LoadAccountCredentials(uid string) ([]*webauthn.Credential, error) {
+	var res []*webauthn.Credential
+	if err := s.C.Select("*").From("webauthn_credentials").Where("account_uid=$1", uid).OrderBy("created").QueryStructs(&res); err != nil {
+		return nil, storeError(err, nil)
+	}
+	return res, nil
+}
+
+// SaveCredential crea la credencial o actualiza su nombre, contador y último uso
+func (s *PsqlStore) SaveCredential(c *webauthn.Credential) error {
+	if c.ID == "" {
+		return store.ErrMissingID
+	}
+	// the columns have no time zone, the times are stored in UTC
+	var lastUsed interface{}
+	if c.LastUsed != nil {
+		lastUsed = c.LastUsed.UTC()
+	}
+	_, err := s.C.SQL(`INSERT INTO webauthn_credentials (id, account_uid, name, public_key, algorithm, sign_count, aaguid, transports, backup_eligible, created, last_used)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, sign_count = EXCLUDED.sign_count, last_used = EXCLUDED.last_used`,
+		c.ID, c.AccountUID, c.Name, c.PublicKey, c.Algorithm, c.SignCount, c.AAGUID, c.Transports, c.BackupEligible, c.Created.UTC(), lastUsed).Exec()
+	return storeError(err, nil)
+}
+
+// DeleteCredential elimina la credencial y devuelve el número de registros eliminados
+func (s *PsqlStore) DeleteCredential(id string) (int, error) {
+	res, err := s.C.DeleteFrom("webauthn_credentials").Where("id=$1", id).Exec()
+	if err != nil {
+		return 0, storeError(err, nil)
+	}
+	return int(res.RowsAffected), nil
+}
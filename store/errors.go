@@ -0,0 +1,81 @@
+package store
+
+import "fmt"
+
+// Kind classifies the errors returned by the backends so the callers can react
+// without knowing which backend is in use.
+type Kind int
+
+const (
+	// Internal is any unexpected error
+	Internal Kind = iota
+	// NotFound means the requested record does not exist
+	NotFound
+	// Conflict means the record clashes with another one, ie. a duplicated unique field
+	Conflict
+	// Unavailable means the backend can not be reached
+	Unavailable
+	// Invalid means the record can not be stored as provided
+	Invalid
+)
+
+// Error is the error returned by the backends. Code is a stable, machine readable
+// identifier of the error and Err the underlying backend error, if any.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+var (
+	ErrAccountNotFound    = &Error{Kind: NotFound, Code: "account_not_found", Message: "account not found"}
+	ErrKeyNotFound        = &Error{Kind: NotFound, Code: "key_not_found", Message: "key not found"}
+	ErrRoleNotFound       = &Error{Kind: NotFound, Code: "role_not_found", Message: "role not found"}
+	ErrSessionNotFound    = &Error{Kind: NotFound, Code: "session_not_found", Message: "session not found"}
+	ErrLockoutNotFound    = &Error{Kind: NotFound, Code: "lockout_not_found", Message: "no failed attempts recorded"}
+	ErrTicketNotFound     = &Error{Kind: NotFound, Code: "ticket_not_found", Message: "ticket not found"}
+	ErrCredentialNotFound = &Error{Kind: NotFound, Code: "credential_not_found", Message: "credential not found"}
+	ErrClientNotFound     = &Error{Kind: NotFound, Code: "client_not_found", Message: "oauth client not found"}
+	ErrOAuthTokenNotFound = &Error{Kind: NotFound, Code: "oauth_token_not_found", Message: "oauth token not found"}
+	ErrProviderNotFound   = &Error{Kind: NotFound, Code: "provider_not_found", Message: "identity provider not found"}
+	ErrIdentityNotFound   = &Error{Kind: NotFound, Code: "identity_not_found", Message: "linked identity not found"}
+
+	// ErrEmailTaken is returned when saving an account whose email, compared
+	// case insensitively, already belongs to another account
+	ErrEmailTaken = &Error{Kind: Conflict, Code: "email_taken", Message: "email already in use"}
+	ErrSlugTaken  = &Error{Kind: Conflict, Code: "role_slug_taken", Message: "duplicated role slug"}
+
+	ErrMissingID       = &Error{Kind: Invalid, Code: "missing_id", Message: "missing record id"}
+	ErrMissingPassword = &Error{Kind: Invalid, Code: "missing_password", Message: "nil password"}
+)
+
+// UnavailableError wraps err, returned by a backend that can not be reached
+func UnavailableError(err error) *Error {
+	return &Error{Kind: Unavailable, Code: "store_unavailable", Message: "store unavailable", Err: err}
+}
+
+// InvalidError wraps err, returned by a backend that rejects the data
+func InvalidError(err error) *Error {
+	return &Error{Kind: Invalid, Code: "invalid_data", Message: "invalid data", Err: err}
+}
+
+// KindOf returns the kind of err or Internal if it is not a store error
+func KindOf(err error) Kind {
+	if e, ok := err.(*Error); ok {
+		return e.Kind
+	}
+	return Internal
+}
+
+// IsNotFound tells if err means the record does not exist
+func IsNotFound(err error) bool {
+	return KindOf(err) == NotFound
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jllopis/try5/account"
+)
+
+const (
+	// DefaultPageSize is the number of accounts returned when the query has no limit
+	DefaultPageSize = 50
+	// MaxPageSize is the maximum number of accounts returned in a single page
+	MaxPageSize = 500
+)
+
+// SortField is the account field the results are ordered by. The ties are broken
+// by the account uid so the order is always total.
+type SortField string
+
+const (
+	SortCreated SortField = "created"
+	SortEmail   SortField = "email"
+	SortName    SortField = "name"
+)
+
+var (
+	ErrInvalidCursor = &Error{Kind: Invalid, Code: "invalid_cursor", Message: "invalid cursor"}
+	ErrInvalidSort   = &Error{Kind: Invalid, Code: "invalid_sort", Message: "invalid sort field"}
+	ErrInvalidLimit  = &Error{Kind: Invalid, Code: "invalid_limit", Message: "invalid limit"}
+)
+
+// AccountQuery selects a page of accounts. The zero value returns the first
+// DefaultPageSize accounts sorted by creation date.
+type AccountQuery struct {
+	// Limit is the page size, 0 means DefaultPageSize
+	Limit int
+	// Cursor is the NextCursor of the previous page, empty for the first one
+	Cursor string
+	// Sort is the field to order by and Desc reverses the order
+	Sort SortField
+	Desc bool
+
+	// Active filters by the active flag when not nil
+	Active *bool
+	// EmailPrefix matches the emails starting with it, case insensitively
+	EmailPrefix string
+	// CreatedAfter and CreatedBefore limit the creation date, both inclusive
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// Name matches the names containing it, case insensitively
+	Name string
+}
+
+// AccountPage is a page of accounts. NextCursor is empty on the last page.
+type AccountPage struct {
+	Accounts   []*account.Account
+	NextCursor string
+}
+
+// Cursor is the position of the last account of a page: its sort key and uid.
+type Cursor struct {
+	Key string `json:"k"`
+	UID string `json:"u"`
+}
+
+// Normalize checks the query and fills the defaults. The backends call it before
+// running the query.
+func (q *AccountQuery) Normalize() error {
+	switch {
+	case q.Limit < 0 || q.Limit > MaxPageSize:
+		return ErrInvalidLimit
+	case q.Limit == 0:
+		q.Limit = DefaultPageSize
+	}
+	switch q.Sort {
+	case "":
+		q.Sort = SortCreated
+	case SortCreated, SortEmail, SortName:
+	default:
+		return ErrInvalidSort
+	}
+	q.EmailPrefix = account.NormalizeEmail(q.EmailPrefix)
+	q.Name = strings.ToLower(strings.TrimSpace(q.Name))
+	return nil
+}
+
+// Match tells if a satisfies the filters of the query
+func (q *AccountQuery) Match(a *account.Account) bool {
+	if a.Deleted != nil && *a.Deleted {
+		return false
+	}
+	if q.Active != nil && (a.Active == nil || *a.Active != *q.Active) {
+		return false
+	}
+	if q.EmailPrefix != "" && (a.Email == nil || !strings.HasPrefix(account.NormalizeEmail(*a.Email), q.EmailPrefix)) {
+		return false
+	}
+	if q.CreatedAfter != nil && (a.Created == nil || a.Created.Before(*q.CreatedAfter)) {
+		return false
+	}
+	if q.CreatedBefore != nil && (a.Created == nil || a.Created.After(*q.CreatedBefore)) {
+		return false
+	}
+	if q.Name != "" && (a.Name == nil || !strings.Contains(strings.ToLower(*a.Name), q.Name)) {
+		return false
+	}
+	return true
+}
+
+// SortKey returns the value of the sort field of a as a string that sorts
+// byte-wise in the same order as the field.
+func (q *AccountQuery) SortKey(a *account.Account) string {
+	switch q.Sort {
+	case SortEmail:
+		if a.Email != nil {
+			return account.NormalizeEmail(*a.Email)
+		}
+	case SortName:
+		if a.Name != nil {
+			return strings.ToLower(*a.Name)
+		}
+	default:
+		if a.Created != nil {
+			return TimeKey(*a.Created)
+		}
+	}
+	return ""
+}
+
+// After tells if a comes after the cursor c in the order of the query
+func (q *AccountQuery) After(a *account.Account, c *Cursor) bool {
+	key, uid := q.SortKey(a), ""
+	if a.UID != nil {
+		uid = *a.UID
+	}
+	if q.Desc {
+		return key < c.Key || key == c.Key && uid < c.UID
+	}
+	return key > c.Key || key == c.Key && uid > c.UID
+}
+
+// Less tells if a comes before b in the order of the query
+func (q *AccountQuery) Less(a, b *account.Account) bool {
+	return q.After(b, q.cursor(a))
+}
+
+// PageCursor returns the cursor pointing to a, the last account of a page
+func (q *AccountQuery) PageCursor(a *account.Account) string {
+	return q.cursor(a).Encode()
+}
+
+func (q *AccountQuery) cursor(a *account.Account) *Cursor {
+	c := &Cursor{Key: q.SortKey(a)}
+	if a.UID != nil {
+		c.UID = *a.UID
+	}
+	return c
+}
+
+// Encode returns the opaque form of the cursor handed to the clients
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor returned by Encode. An empty string is the start of
+// the results and returns nil.
+func DecodeCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &Cursor{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// timeKeyLayout has a fixed width so the keys sort as the dates they represent
+const timeKeyLayout = "2006-01-02T15:04:05.000000000Z"
+
+// TimeKey returns the sort key of a date
+func TimeKey(t time.Time) string {
+	return t.UTC().Format(timeKeyLayout)
+}
+
+// ParseTimeKey returns the date of a key returned by TimeKey
+func ParseTimeKey(key string) (time.Time, error) {
+	t, err := time.Parse(timeKeyLayout, key)
+	if err != nil {
+		return t, ErrInvalidCursor
+	}
+	return t, nil
+}
@@ -1,15 +1,106 @@
 package store
 
-import "github.com/jllopis/try5/account"
+import (
+	"time"
+
+	"github.com/jllopis/try5/account"
+	"github.com/jllopis/try5/apikey"
+	"github.com/jllopis/try5/federation"
+	"github.com/jllopis/try5/keyring"
+	"github.com/jllopis/try5/lockout"
+	"github.com/jllopis/try5/oauth"
+	"github.com/jllopis/try5/oidc"
+	"github.com/jllopis/try5/rbac"
+	"github.com/jllopis/try5/session"
+	"github.com/jllopis/try5/ticket"
+	"github.com/jllopis/try5/webauthn"
+)
 
 type Storer interface {
 	Status() (int, string)
 	Close() error
 	LoadAllAccounts() ([]*account.Account, error)
+	ListAccounts(query *AccountQuery) (*AccountPage, error)
 	LoadAccount(uuid string) (*account.Account, error)
+	// SaveAccount creates the account if it has no UID, without a password if Password
+	// is nil, or else updates it
 	SaveAccount(account *account.Account) (*account.Account, error)
 	DeleteAccount(uuid string) (int, error)
+	SetPasswordHash(uuid string, hash string) error
+	SetMFA(uuid string, m *account.MFA) error
+	// SwapMFA replaces the second factor of the account with m only if it is still
+	// the same as old, see account.MFA.Same, and returns false if it is not
+	SwapMFA(uuid string, old, m *account.MFA) (bool, error)
 	GetAccountByEmail(email string) (*account.Account, error)
+	LoadKey(id string) (*apikey.Key, error)
+	LoadAccountKeys(accountUID string) ([]*apikey.Key, error)
+	SaveKey(key *apikey.Key) (*apikey.Key, error)
+	DeleteKey(id string) (int, error)
+	// RecordSignature records the signature id of a request until expires and returns
+	// false if it was already recorded, atomically across the servers
+	RecordSignature(id string, expires time.Time) (bool, error)
+	DeleteExpiredSignatures() (int, error)
+	LoadAllRoles() ([]*rbac.Role, error)
+	LoadRole(id int64) (*rbac.Role, error)
+	GetRoleBySlug(slug string) (*rbac.Role, error)
+	SaveRole(role *rbac.Role) (*rbac.Role, error)
+	DeleteRole(id int64) (int, error)
+	LoadGrants(roleID int64) ([]*rbac.Grant, error)
+	SaveGrant(grant *rbac.Grant) (*rbac.Grant, error)
+	DeleteGrant(id int64) (int, error)
+	LoadAccountRoles(uid string) ([]*rbac.Role, error)
+	AssignRole(uid string, roleID int64) error
+	UnassignRole(uid string, roleID int64) (int, error)
+	LoadSession(id string) (*session.Session, error)
+	LoadAccountSessions(uid string) ([]*session.Session, error)
+	SaveSession(s *session.Session) (*session.Session, error)
+	DeleteSession(id string) (int, error)
+	DeleteAccountSessions(uid string) (int, error)
+	LoadAttempts(key string) (*lockout.Attempts, error)
+	LoadAllAttempts() ([]*lockout.Attempts, error)
+	SaveAttempts(a *lockout.Attempts) error
+	// IncrementAttempts records a failed attempt of key at now, as p.Fail does, and
+	// returns the attempts updated, atomically across the servers
+	IncrementAttempts(p lockout.Policy, key string, max int, now time.Time) (*lockout.Attempts, error)
+	DeleteAttempts(key string) (int, error)
+	LoadTicket(id string) (*ticket.Ticket, error)
+	SaveTicket(t *ticket.Ticket) error
+	DeleteTicket(id string) (int, error)
+	DeleteAccountTickets(uid string, purpose ticket.Purpose) (int, error)
+	DeleteExpiredTickets() (int, error)
+	LoadCredential(id string) (*webauthn.Credential, error)
+	LoadAccountCredentials(uid string) ([]*webauthn.Credential, error)
+	SaveCredential(c *webauthn.Credential) error
+	DeleteCredential(id string) (int, error)
+	LoadClient(id string) (*oauth.Client, error)
+	LoadAllClients() ([]*oauth.Client, error)
+	SaveClient(c *oauth.Client) error
+	DeleteClient(id string) (int, error)
+	LoadOAuthToken(id string) (*oauth.Token, error)
+	SaveOAuthToken(t *oauth.Token) error
+	DeleteOAuthToken(id string) (int, error)
+	DeleteOAuthGrant(grantID string) (int, error)
+	// DeleteAccountOAuthTokens deletes every code and token issued for the account
+	DeleteAccountOAuthTokens(uid string) (int, error)
+	DeleteExpiredOAuthTokens() (int, error)
+	LoadCookieKeys() ([]*keyring.Key, error)
+	SaveCookieKeys(keys []*keyring.Key) error
+	// InitCookieKeys saves the keys only if there are none stored, atomically across
+	// the servers
+	InitCookieKeys(keys []*keyring.Key) error
+	LoadSigningKeys() ([]*oidc.Key, error)
+	SaveSigningKeys(keys []*oidc.Key) error
+	// InitSigningKeys saves the keys only if there are none stored, atomically across
+	// the servers
+	InitSigningKeys(keys []*oidc.Key) error
+	LoadFederationProvider(id string) (*federation.Provider, error)
+	LoadAllFederationProviders() ([]*federation.Provider, error)
+	SaveFederationProvider(p *federation.Provider) error
+	DeleteFederationProvider(id string) (int, error)
+	LoadIdentity(providerID, subject string) (*federation.Identity, error)
+	LoadAccountIdentities(uid string) ([]*federation.Identity, error)
+	SaveIdentity(i *federation.Identity) error
+	DeleteIdentity(providerID, subject string) (int, error)
 }
 
 const (
@@ -0,0 +1,67 @@
+// Package ticket implements single use tokens sent to the users by email, ie. to
+// reset a password. Only the SHA-256 of the token is stored, so the stored tickets
+// can not be used to build valid links.
+package ticket
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Purpose tells what a ticket can be used for
+type Purpose string
+
+const (
+	PasswordReset     Purpose = "password_reset"
+	EmailVerification Purpose = "email_verification"
+	// The WebAuthn challenges are tickets too, so every ceremony can only be completed once
+	WebAuthnRegistration Purpose = "webauthn_registration"
+	WebAuthnLogin        Purpose = "webauthn_login"
+	WebAuthnMFA          Purpose = "webauthn_mfa"
+	// The refresh tokens are recorded as tickets with the hash of their jti, so each one
+	// can be used once and they can be revoked
+	RefreshToken Purpose = "refresh_token"
+)
+
+// Ticket is a stored single use token. ID is the hash of the token given to the user.
+type Ticket struct {
+	ID         string    `json:"id" db:"id"`
+	Purpose    Purpose   `json:"purpose" db:"purpose"`
+	AccountUID string    `json:"account_uid" db:"account_uid"`
+	Created    time.Time `json:"created" db:"created"`
+	Expires    time.Time `json:"expires" db:"expires"`
+}
+
+var (
+	ErrInvalidTicket = errors.New("invalid or expired token")
+)
+
+// New creates a ticket for the account valid for ttl. It returns the ticket to
+// store and the token to send to the user.
+func New(purpose Purpose, accountUID string, ttl time.Duration) (*Ticket, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now().UTC()
+	return &Ticket{ID: ID(token), Purpose: purpose, AccountUID: accountUID, Created: now, Expires: now.Add(ttl)}, token, nil
+}
+
+// ID returns the id of the ticket of a token
+func ID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Check returns ErrInvalidTicket if the ticket is for another purpose or expired
+func (t *Ticket) Check(purpose Purpose) error {
+	if t.Purpose != purpose || !time.Now().Before(t.Expires) {
+		return ErrInvalidTicket
+	}
+	return nil
+}
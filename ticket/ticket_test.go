@@ -0,0 +1,26 @@
+package ticket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTicket(t *testing.T) {
+	tk, token, err := New(PasswordReset, "uid", time.Hour)
+	if err != nil {
+		t.Fatal("Error creating ticket: ", err)
+	}
+	if tk.ID != ID(token) || tk.ID == token {
+		t.Fatal("Ticket id is not the hash of the token")
+	}
+	if err := tk.Check(PasswordReset); err != nil {
+		t.Fatal("Valid ticket rejected: ", err)
+	}
+	if err := tk.Check("other"); err != ErrInvalidTicket {
+		t.Fatal("Ticket accepted for another purpose")
+	}
+	tk.Expires = time.Now().Add(-time.Second)
+	if err := tk.Check(PasswordReset); err != ErrInvalidTicket {
+		t.Fatal("Expired ticket accepted")
+	}
+}
@@ -0,0 +1,201 @@
+package token
+
+import (
+	"errors"
+	"io/ioutil"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/securecookie"
+	"github.com/jllopis/try5/account"
+)
+
+const (
+	// AccessToken is the value of the typ claim for short lived access tokens
+	AccessToken = "access"
+	// RefreshToken is the value of the typ claim for long lived refresh tokens
+	RefreshToken = "refresh"
+	// MFAToken is the value of the typ claim for the tokens given after the password
+	// of an account with a second factor, they are only good to present the second factor
+	MFAToken = "mfa"
+)
+
+var (
+	ErrInvalidToken     = errors.New("invalid token")
+	ErrInvalidTokenType = errors.New("invalid token type")
+	ErrUnsupportedAlg   = errors.New("unsupported signing method")
+	ErrMissingKey       = errors.New("missing signing key")
+	ErrInvalidAccount   = errors.New("invalid account")
+
+	// DefaultAccessTTL is used when no access token TTL is configured
+	DefaultAccessTTL = 15 * time.Minute
+	// DefaultRefreshTTL is used when no refresh token TTL is configured
+	DefaultRefreshTTL = 7 * 24 * time.Hour
+	// MFATTL is the time given to present the second factor
+	MFATTL = 5 * time.Minute
+)
+
+// Options holds the configuration for the token Manager.
+// Method can be one of HS256, RS256 or ES256. HS256 needs Secret to be set
+// while RS256 and ES256 need KeyFile to point to a PEM encoded private key.
+type Options struct {
+	Method     string
+	Secret     []byte
+	KeyFile    string
+	Issuer     string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// Claims are the claims carried by the tokens issued by try5.
+// Subject holds the account UID.
+type Claims struct {
+	Email string `json:"email,omitempty"`
+	Type  string `json:"typ"`
+	jwt.StandardClaims
+}
+
+// Pair is the result of a successful token issuance. RefreshID, the jti of the
+// refresh token, and RefreshExpires are for the server to record the refresh token,
+// so it can be used once and revoked.
+type Pair struct {
+	AccessToken    string    `json:"access_token"`
+	RefreshToken   string    `json:"refresh_token"`
+	TokenType      string    `json:"token_type"`
+	ExpiresIn      int64     `json:"expires_in"`
+	RefreshID      string    `json:"-"`
+	RefreshExpires time.Time `json:"-"`
+}
+
+// Manager signs and validates the JWT tokens
+type Manager struct {
+	Options
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewManager returns a Manager configured with opts. If the method is HS256 and
+// no secret is provided, a random one is generated so tokens will not survive a restart.
+func NewManager(opts *Options) (*Manager, error) {
+	m := &Manager{Options: *opts}
+	if m.Method == "" {
+		m.Method = "HS256"
+	}
+	if m.AccessTTL == 0 {
+		m.AccessTTL = DefaultAccessTTL
+	}
+	if m.RefreshTTL == 0 {
+		m.RefreshTTL = DefaultRefreshTTL
+	}
+	switch m.Method {
+	case "HS256":
+		if len(m.Secret) == 0 {
+			m.Secret = securecookie.GenerateRandomKey(64)
+		}
+		m.method = jwt.SigningMethodHS256
+		m.signKey = m.Secret
+		m.verifyKey = m.Secret
+	case "RS256", "ES256":
+		if m.KeyFile == "" {
+			return nil, ErrMissingKey
+		}
+		pem, err := ioutil.ReadFile(m.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if m.Method == "RS256" {
+			key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			m.method = jwt.SigningMethodRS256
+			m.signKey = key
+			m.verifyKey = &key.PublicKey
+		} else {
+			key, err := jwt.ParseECPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			m.method = jwt.SigningMethodES256
+			m.signKey = key
+			m.verifyKey = &key.PublicKey
+		}
+	default:
+		return nil, ErrUnsupportedAlg
+	}
+	return m, nil
+}
+
+// Issue creates a new access and refresh token pair for the account
+func (m *Manager) Issue(a *account.Account) (*Pair, error) {
+	if a == nil || a.UID == nil {
+		return nil, ErrInvalidAccount
+	}
+	now := time.Now().UTC()
+	access, err := m.sign(a, AccessToken, uuid.New(), now, m.AccessTTL)
+	if err != nil {
+		return nil, err
+	}
+	refreshID := uuid.New()
+	refresh, err := m.sign(a, RefreshToken, refreshID, now, m.RefreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &Pair{
+		AccessToken:    access,
+		RefreshToken:   refresh,
+		TokenType:      "Bearer",
+		ExpiresIn:      int64(m.AccessTTL / time.Second),
+		RefreshID:      refreshID,
+		RefreshExpires: now.Add(m.RefreshTTL),
+	}, nil
+}
+
+// IssueMFA creates the token that proves the account presented its password and has
+// to present the second factor
+func (m *Manager) IssueMFA(a *account.Account) (string, error) {
+	if a == nil || a.UID == nil {
+		return "", ErrInvalidAccount
+	}
+	return m.sign(a, MFAToken, uuid.New(), time.Now().UTC(), MFATTL)
+}
+
+func (m *Manager) sign(a *account.Account, typ, id string, now time.Time, ttl time.Duration) (string, error) {
+	c := &Claims{
+		Type: typ,
+		StandardClaims: jwt.StandardClaims{
+			Id:        id,
+			Subject:   *a.UID,
+			Issuer:    m.Issuer,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+	if a.Email != nil {
+		c.Email = *a.Email
+	}
+	return jwt.NewWithClaims(m.method, c).SignedString(m.signKey)
+}
+
+// Validate parses and verifies the token signature, expiration and type.
+// It returns the token claims if everything is ok.
+func (m *Manager) Validate(tokenString, typ string) (*Claims, error) {
+	c := &Claims{}
+	p := &jwt.Parser{ValidMethods: []string{m.method.Alg()}}
+	t, err := p.ParseWithClaims(tokenString, c, func(t *jwt.Token) (interface{}, error) {
+		return m.verifyKey, nil
+	})
+	if err != nil || !t.Valid {
+		return nil, ErrInvalidToken
+	}
+	if m.Issuer != "" && c.Issuer != m.Issuer {
+		return nil, ErrInvalidToken
+	}
+	if c.Type != typ {
+		return nil, ErrInvalidTokenType
+	}
+	return c, nil
+}
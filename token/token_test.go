@@ -0,0 +1,79 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jllopis/try5/account"
+)
+
+func testAccount() *account.Account {
+	uid := "7ecee355-537b-492c-ab23-6a41219959d1"
+	email := "testuser@dom.local"
+	return &account.Account{UID: &uid, Email: &email}
+}
+
+func TestIssueAndValidate(t *testing.T) {
+	m, err := NewManager(&Options{Secret: []byte("SuperDifficultSecret"), Issuer: "try5"})
+	if err != nil {
+		t.Fatal("Error creating token manager: ", err)
+	}
+	pair, err := m.Issue(testAccount())
+	if err != nil {
+		t.Fatal("Error issuing tokens: ", err)
+	}
+	c, err := m.Validate(pair.AccessToken, AccessToken)
+	if err != nil {
+		t.Fatal("Error validating access token: ", err)
+	}
+	if c.Subject != *testAccount().UID {
+		t.Fatalf("Wrong subject. Got %s", c.Subject)
+	}
+	if _, err := m.Validate(pair.AccessToken, RefreshToken); err != ErrInvalidTokenType {
+		t.Fatal("Access token accepted as refresh token")
+	}
+	if c, err := m.Validate(pair.RefreshToken, RefreshToken); err != nil || c.Id != pair.RefreshID {
+		t.Fatal("Error validating refresh token or wrong id: ", err)
+	}
+
+	other, _ := NewManager(&Options{Secret: []byte("AnotherSecret")})
+	if _, err := other.Validate(pair.AccessToken, AccessToken); err != ErrInvalidToken {
+		t.Fatal("Token signed with another secret accepted")
+	}
+}
+
+func TestES256(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "try5-es256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	f.Close()
+
+	m, err := NewManager(&Options{Method: "ES256", KeyFile: f.Name()})
+	if err != nil {
+		t.Fatal("Error creating token manager: ", err)
+	}
+	pair, err := m.Issue(testAccount())
+	if err != nil {
+		t.Fatal("Error issuing tokens: ", err)
+	}
+	if _, err := m.Validate(pair.AccessToken, AccessToken); err != nil {
+		t.Fatal("Error validating access token: ", err)
+	}
+}
@@ -0,0 +1,28 @@
+package totp
+
+import (
+	"time"
+
+	"github.com/jllopis/try5/seal"
+)
+
+// NewSealedSecret returns a new secret, to show to the user, and the same secret
+// encrypted with s, the form it is stored in
+func NewSealedSecret(s *seal.Sealer) (secret, sealed string, err error) {
+	if secret, err = NewSecret(); err != nil {
+		return "", "", err
+	}
+	if sealed, err = s.Seal(secret); err != nil {
+		return "", "", err
+	}
+	return secret, sealed, nil
+}
+
+// ValidateSealed opens with s the stored secret and validates the code like Validate
+func ValidateSealed(s *seal.Sealer, sealed, code string, t time.Time, last int64) (int64, error) {
+	secret, err := s.Open(sealed)
+	if err != nil {
+		return 0, err
+	}
+	return Validate(secret, code, t, last)
+}
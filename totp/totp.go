@@ -0,0 +1,121 @@
+// Package totp implements the time based one time passwords of RFC 6238 as used by
+// the authenticator apps: HMAC-SHA1, 6 digits and a 30 seconds step.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the length of the codes
+	Digits = 6
+	// Period is the time step, every code is valid during a period
+	Period = 30 * time.Second
+	// Skew is the number of periods before and after the current one also accepted,
+	// to allow for clock drift and typing time
+	Skew = 1
+	// SecretSize is the length in bytes of the generated secrets
+	SecretSize = 20
+)
+
+var (
+	ErrInvalidCode   = errors.New("invalid code")
+	ErrInvalidSecret = errors.New("invalid secret")
+
+	encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// NewSecret returns a random secret encoded in base32, the form shown to the users
+func NewSecret() (string, error) {
+	b := make([]byte, SecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(strings.Replace(secret, " ", "", -1), "=")))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
+
+// Counter returns the number of the period of t
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code of the secret for the period counter
+func Code(secret string, counter int64) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod), nil
+}
+
+// Validate checks the code at the time t and returns the counter of the period it
+// belongs to. The codes of the periods up to last are refused, so the caller can
+// store the counter returned and prevent a code from being used twice.
+func Validate(secret, code string, t time.Time, last int64) (int64, error) {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != Digits {
+		return 0, ErrInvalidCode
+	}
+	now := Counter(t)
+	for c := now - Skew; c <= now+Skew; c++ {
+		if c <= last {
+			continue
+		}
+		want, err := Code(secret, c)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return c, nil
+		}
+	}
+	return 0, ErrInvalidCode
+}
+
+// URI returns the otpauth:// URI of the secret, usually shown as a QR code to be
+// scanned by the authenticator app
+func URI(issuer, accountName, secret string) string {
+	label := url.PathEscape(accountName)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+	q := url.Values{}
+	q.Set("secret", secret)
+	if issuer != "" {
+		q.Set("issuer", issuer)
+	}
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(int(Period/time.Second)))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
@@ -0,0 +1,41 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1 test vectors truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		code, err := Code(secret, Counter(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal("Error computing code: ", err)
+		}
+		if code != want {
+			t.Fatalf("Code at %d: got %s, want %s", unix, code, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal("Error creating secret: ", err)
+	}
+	now := time.Now()
+	code, _ := Code(secret, Counter(now.Add(-Period)))
+	c, err := Validate(secret, code, now, 0)
+	if err != nil || c != Counter(now)-1 {
+		t.Fatal("Previous period code refused: ", err)
+	}
+	if _, err := Validate(secret, code, now, c); err != ErrInvalidCode {
+		t.Fatal("Code accepted twice")
+	}
+	old, _ := Code(secret, Counter(now.Add(-3*Period)))
+	if _, err := Validate(secret, old, now, 0); err != ErrInvalidCode {
+		t.Fatal("Expired code accepted")
+	}
+}
@@ -0,0 +1,133 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// The authenticators encode their data in CBOR (RFC 8949). decodeCBOR reads the
+// subset they use: integers, byte and text strings, arrays, maps, tags, booleans,
+// null and floats. Indefinite lengths are not allowed by the CTAP2 canonical form.
+
+var ErrCBOR = errors.New("malformed cbor data")
+
+// maxDepth limits the nesting of the decoded values
+const maxDepth = 16
+
+// decodeCBOR decodes the first value of data and returns it with the bytes that
+// follow it. The maps are returned as map[interface{}]interface{} with int64 or
+// string keys, the integers as int64.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeItem(data, 0)
+}
+
+func decodeItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxDepth || len(data) == 0 {
+		return nil, nil, ErrCBOR
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+	if major == 7 {
+		return decodeSimple(info, data)
+	}
+	n, data, err := decodeLength(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, nil, ErrCBOR
+		}
+		return int64(n), data, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, nil, ErrCBOR
+		}
+		return -1 - int64(n), data, nil
+	case 2, 3:
+		if n > uint64(len(data)) {
+			return nil, nil, ErrCBOR
+		}
+		b := data[:n]
+		if major == 3 {
+			return string(b), data[n:], nil
+		}
+		return append([]byte(nil), b...), data[n:], nil
+	case 4:
+		if n > uint64(len(data)) {
+			return nil, nil, ErrCBOR
+		}
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var v interface{}
+			if v, data, err = decodeItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, v)
+		}
+		return items, data, nil
+	case 5:
+		if n > uint64(len(data)) {
+			return nil, nil, ErrCBOR
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var k, v interface{}
+			if k, data, err = decodeItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, ErrCBOR
+			}
+			if v, data, err = decodeItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, data, nil
+	case 6:
+		// the tags only qualify the value that follows
+		return decodeItem(data, depth+1)
+	}
+	return nil, nil, ErrCBOR
+}
+
+func decodeLength(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	}
+	return 0, nil, ErrCBOR
+}
+
+func decodeSimple(info byte, data []byte) (interface{}, []byte, error) {
+	switch info {
+	case 20:
+		return false, data, nil
+	case 21:
+		return true, data, nil
+	case 22, 23:
+		return nil, data, nil
+	case 26:
+		if len(data) >= 4 {
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), data[4:], nil
+		}
+	case 27:
+		if len(data) >= 8 {
+			return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+		}
+	}
+	return nil, nil, ErrCBOR
+}
@@ -0,0 +1,11 @@
+package webauthn
+
+import "testing"
+
+func TestMalformedCBOR(t *testing.T) {
+	for _, data := range [][]byte{{}, {0x5a, 0xff, 0xff, 0xff, 0xff}, {0xa1, 0x01}, {0x9f}, {0xa1, 0x40, 0x01}} {
+		if _, _, err := decodeCBOR(data); err == nil {
+			t.Fatalf("Malformed cbor %x decoded", data)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers supported, in order of preference
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgPS256 = -37
+	AlgRS256 = -257
+)
+
+// Algorithms are the algorithms offered to the authenticators
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgPS256, AlgRS256}
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported credential public key")
+	ErrSignature      = errors.New("invalid signature")
+)
+
+// COSE key parameters, RFC 8152 section 7
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// PublicKey is a credential public key decoded from its COSE form
+type PublicKey struct {
+	Algorithm int
+	Key       crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key. It returns the key and the bytes after it.
+func ParsePublicKey(data []byte) (*PublicKey, []byte, error) {
+	v, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, nil, ErrUnsupportedKey
+	}
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+	pk := &PublicKey{Algorithm: int(alg)}
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, nil, ErrUnsupportedKey
+		}
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, nil, ErrUnsupportedKey
+		}
+		pk.Key = key
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, nil, ErrUnsupportedKey
+		}
+		pk.Key = ed25519.PublicKey(x)
+	case kty == ktyRSA && (alg == AlgRS256 || alg == AlgPS256):
+		// for RSA keys -1 is the modulus and -2 the exponent
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, nil, ErrUnsupportedKey
+		}
+		exp := int(new(big.Int).SetBytes(e).Int64())
+		pk.Key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+	default:
+		return nil, nil, ErrUnsupportedKey
+	}
+	return pk, rest, nil
+}
+
+// Verify checks the signature of data
+func (pk *PublicKey) Verify(data, sig []byte) error {
+	ok := false
+	switch key := pk.Key.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(key, h[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		h := sha256.Sum256(data)
+		if pk.Algorithm == AlgPS256 {
+			ok = rsa.VerifyPSS(key, crypto.SHA256, h[:], sig, nil) == nil
+		} else {
+			ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig) == nil
+		}
+	}
+	if !ok {
+		return ErrSignature
+	}
+	return nil
+}
@@ -0,0 +1,459 @@
+// Package webauthn implements the relying party of the Web Authentication API
+// (https://www.w3.org/TR/webauthn-2/): the options of the registration and
+// authentication ceremonies and the verification of the authenticator responses.
+// The attestation statements accepted are "none" and "packed", the attestation
+// certificates are not checked against any trust anchor.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the time given to complete a ceremony when the RelyingParty has no Timeout
+const DefaultTimeout = 5 * time.Minute
+
+var (
+	ErrInvalidResponse    = errors.New("invalid authenticator response")
+	ErrInvalidClientData  = errors.New("invalid client data")
+	ErrInvalidOrigin      = errors.New("invalid origin")
+	ErrInvalidChallenge   = errors.New("invalid challenge")
+	ErrInvalidRPID        = errors.New("invalid relying party id")
+	ErrUserNotPresent     = errors.New("user not present")
+	ErrUserNotVerified    = errors.New("user not verified")
+	ErrAttestation        = errors.New("unsupported or invalid attestation")
+	ErrSignCount          = errors.New("signature counter did not increase, the authenticator may be cloned")
+	ErrCredentialMismatch = errors.New("credential does not match")
+)
+
+// Flags of the authenticator data
+const (
+	flagUserPresent   = 0x01
+	flagUserVerified  = 0x04
+	flagBackupElig    = 0x08
+	flagAttestedData  = 0x40
+	flagExtensionData = 0x80
+)
+
+// RelyingParty is the server side of the ceremonies. ID is the domain of the
+// credentials and Origins the origins of the pages allowed to use them.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+	Timeout time.Duration
+}
+
+// Credential is a public key credential registered by an account. ID is the
+// credential id encoded in base64url and PublicKey its COSE key.
+type Credential struct {
+	ID         string `json:"id" db:"id"`
+	AccountUID string `json:"account_uid" db:"account_uid"`
+	Name       string `json:"name" db:"name"`
+	PublicKey  []byte `json:"-" db:"public_key"`
+	Algorithm  int    `json:"algorithm" db:"algorithm"`
+	SignCount  int64  `json:"sign_count" db:"sign_count"`
+	AAGUID     string `json:"aaguid" db:"aaguid"`
+	Transports string `json:"transports,omitempty" db:"transports"`
+	// BackupEligible is true for the passkeys that can be synced to other devices
+	BackupEligible bool       `json:"backup_eligible" db:"backup_eligible"`
+	Created        time.Time  `json:"created" db:"created"`
+	LastUsed       *time.Time `json:"last_used,omitempty" db:"last_used"`
+}
+
+// User identifies the account in the registration options. ID is the user handle
+// returned by the authenticators in the assertions.
+type User struct {
+	ID          string
+	Name        string
+	DisplayName string
+}
+
+// descriptor is a PublicKeyCredentialDescriptor
+type descriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// CreationOptions are the PublicKeyCredentialCreationOptions given to navigator.credentials.create
+type CreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64        `json:"timeout"`
+	ExcludeCredentials     []descriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions are the PublicKeyCredentialRequestOptions given to navigator.credentials.get
+type RequestOptions struct {
+	Challenge        string       `json:"challenge"`
+	Timeout          int64        `json:"timeout"`
+	RPID             string       `json:"rpId"`
+	AllowCredentials []descriptor `json:"allowCredentials"`
+	UserVerification string       `json:"userVerification"`
+}
+
+// Response is the PublicKeyCredential returned by the browser, with the binary
+// fields encoded in base64url. AttestationObject is set by the registrations and
+// AuthenticatorData, Signature and UserHandle by the assertions.
+type Response struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject,omitempty"`
+		AuthenticatorData string   `json:"authenticatorData,omitempty"`
+		Signature         string   `json:"signature,omitempty"`
+		UserHandle        string   `json:"userHandle,omitempty"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// clientData is the CollectedClientData signed by the authenticator
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// authenticatorData is the parsed authenticator data
+type authenticatorData struct {
+	raw          []byte
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// decode accepts base64url with or without padding, the browsers differ
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (rp *RelyingParty) timeout() time.Duration {
+	if rp.Timeout == 0 {
+		return DefaultTimeout
+	}
+	return rp.Timeout
+}
+
+// TTL is how long the challenges of the ceremonies are valid
+func (rp *RelyingParty) TTL() time.Duration {
+	return rp.timeout()
+}
+
+func descriptors(creds []*Credential) []descriptor {
+	res := make([]descriptor, 0, len(creds))
+	for _, c := range creds {
+		d := descriptor{Type: "public-key", ID: c.ID}
+		if c.Transports != "" {
+			d.Transports = strings.Split(c.Transports, ",")
+		}
+		res = append(res, d)
+	}
+	return res
+}
+
+// CreationOptions returns the options to register a new credential for the user.
+// The credentials already registered are excluded so an authenticator is not
+// registered twice.
+func (rp *RelyingParty) CreationOptions(challenge string, user User, exclude []*Credential) *CreationOptions {
+	o := &CreationOptions{Challenge: challenge, Timeout: int64(rp.timeout() / time.Millisecond), Attestation: "none"}
+	o.RP.ID, o.RP.Name = rp.ID, rp.Name
+	o.User.ID, o.User.Name, o.User.DisplayName = encode([]byte(user.ID)), user.Name, user.DisplayName
+	for _, alg := range Algorithms {
+		o.PubKeyCredParams = append(o.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{"public-key", alg})
+	}
+	o.ExcludeCredentials = descriptors(exclude)
+	o.AuthenticatorSelection.ResidentKey = "preferred"
+	o.AuthenticatorSelection.UserVerification = "preferred"
+	return o
+}
+
+// RequestOptions returns the options to get an assertion. With no allowed
+// credentials the browser offers the discoverable credentials (passkeys) of the
+// relying party. userVerification is "required", "preferred" or "discouraged".
+func (rp *RelyingParty) RequestOptions(challenge string, allow []*Credential, userVerification string) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          int64(rp.timeout() / time.Millisecond),
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: userVerification,
+	}
+}
+
+// Challenge returns the challenge of the response, so the caller can find the
+// ceremony it belongs to
+func Challenge(r *Response) (string, error) {
+	raw, err := decode(r.Response.ClientDataJSON)
+	if err != nil {
+		return "", ErrInvalidClientData
+	}
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return "", ErrInvalidClientData
+	}
+	return cd.Challenge, nil
+}
+
+// checkClientData verifies the client data and returns its hash
+func (rp *RelyingParty) checkClientData(r *Response, typ, challenge string) ([]byte, error) {
+	if r.Type != "public-key" {
+		return nil, ErrInvalidResponse
+	}
+	raw, err := decode(r.Response.ClientDataJSON)
+	if err != nil {
+		return nil, ErrInvalidClientData
+	}
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil || cd.Type != typ {
+		return nil, ErrInvalidClientData
+	}
+	if cd.Challenge == "" || strings.TrimRight(cd.Challenge, "=") != strings.TrimRight(challenge, "=") {
+		return nil, ErrInvalidChallenge
+	}
+	valid := false
+	for _, o := range rp.Origins {
+		if cd.Origin == o {
+			valid = true
+			break
+		}
+	}
+	if !valid || cd.CrossOrigin {
+		return nil, ErrInvalidOrigin
+	}
+	h := sha256.Sum256(raw)
+	return h[:], nil
+}
+
+// parseAuthenticatorData decodes the authenticator data and checks the relying
+// party and the user flags
+func (rp *RelyingParty) parseAuthenticatorData(raw []byte, requireUV bool) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, ErrInvalidResponse
+	}
+	ad := &authenticatorData{raw: raw, rpIDHash: raw[:32], flags: raw[32], signCount: binary.BigEndian.Uint32(raw[33:37])}
+	want := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.rpIDHash, want[:]) {
+		return nil, ErrInvalidRPID
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return nil, ErrUserNotPresent
+	}
+	if requireUV && ad.flags&flagUserVerified == 0 {
+		return nil, ErrUserNotVerified
+	}
+	rest := raw[37:]
+	if ad.flags&flagAttestedData != 0 {
+		if len(rest) < 18 {
+			return nil, ErrInvalidResponse
+		}
+		ad.aaguid = rest[:16]
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > 1023 || len(rest) < n {
+			return nil, ErrInvalidResponse
+		}
+		ad.credentialID, rest = rest[:n], rest[n:]
+		_, after, err := ParsePublicKey(rest)
+		if err != nil {
+			return nil, err
+		}
+		ad.publicKey, rest = rest[:len(rest)-len(after)], after
+	}
+	if ad.flags&flagExtensionData != 0 {
+		var err error
+		if _, rest, err = decodeCBOR(rest); err != nil {
+			return nil, ErrInvalidResponse
+		}
+	}
+	if len(rest) != 0 {
+		return nil, ErrInvalidResponse
+	}
+	return ad, nil
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create for the
+// challenge and returns the new credential, without account nor name
+func (rp *RelyingParty) VerifyRegistration(r *Response, challenge string, requireUV bool) (*Credential, error) {
+	hash, err := rp.checkClientData(r, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := decode(r.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	v, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	att, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+	format, _ := att["fmt"].(string)
+	stmt, _ := att["attStmt"].(map[interface{}]interface{})
+	authData, _ := att["authData"].([]byte)
+	ad, err := rp.parseAuthenticatorData(authData, requireUV)
+	if err != nil {
+		return nil, err
+	}
+	if ad.credentialID == nil {
+		return nil, ErrInvalidResponse
+	}
+	pk, _, err := ParsePublicKey(ad.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyAttestation(format, stmt, pk, append(append([]byte(nil), authData...), hash...)); err != nil {
+		return nil, err
+	}
+	if id, err := decode(r.RawID); err != nil || !bytes.Equal(id, ad.credentialID) {
+		return nil, ErrCredentialMismatch
+	}
+	return &Credential{
+		ID:             encode(ad.credentialID),
+		PublicKey:      ad.publicKey,
+		Algorithm:      pk.Algorithm,
+		SignCount:      int64(ad.signCount),
+		AAGUID:         hex.EncodeToString(ad.aaguid),
+		Transports:     strings.Join(r.Response.Transports, ","),
+		BackupEligible: ad.flags&flagBackupElig != 0,
+		Created:        time.Now().UTC(),
+	}, nil
+}
+
+// verifyAttestation checks the attestation statement over signed, the
+// authenticator data followed by the client data hash
+func verifyAttestation(format string, stmt map[interface{}]interface{}, pk *PublicKey, signed []byte) error {
+	switch format {
+	case "none":
+		if len(stmt) != 0 {
+			return ErrAttestation
+		}
+		return nil
+	case "packed":
+		alg, _ := stmt["alg"].(int64)
+		sig, _ := stmt["sig"].([]byte)
+		x5c, _ := stmt["x5c"].([]interface{})
+		if len(x5c) == 0 {
+			// self attestation, signed with the credential key
+			if int(alg) != pk.Algorithm {
+				return ErrAttestation
+			}
+			if pk.Verify(signed, sig) != nil {
+				return ErrAttestation
+			}
+			return nil
+		}
+		der, _ := x5c[0].([]byte)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return ErrAttestation
+		}
+		attKey := &PublicKey{Algorithm: int(alg), Key: cert.PublicKey}
+		if attKey.Verify(signed, sig) != nil {
+			return ErrAttestation
+		}
+		return nil
+	}
+	return ErrAttestation
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get for the
+// challenge against the stored credential. It returns the new signature counter,
+// which the caller must store.
+func (rp *RelyingParty) VerifyAssertion(r *Response, challenge string, cred *Credential, requireUV bool) (int64, error) {
+	if id, err := decode(r.RawID); err != nil || encode(id) != cred.ID {
+		return 0, ErrCredentialMismatch
+	}
+	hash, err := rp.checkClientData(r, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+	authData, err := decode(r.Response.AuthenticatorData)
+	if err != nil {
+		return 0, ErrInvalidResponse
+	}
+	ad, err := rp.parseAuthenticatorData(authData, requireUV)
+	if err != nil {
+		return 0, err
+	}
+	sig, err := decode(r.Response.Signature)
+	if err != nil {
+		return 0, ErrInvalidResponse
+	}
+	pk, _, err := ParsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	if err := pk.Verify(append(append([]byte(nil), authData...), hash...), sig); err != nil {
+		return 0, err
+	}
+	// the authenticators without counter always send 0
+	count := int64(ad.signCount)
+	if (count != 0 || cred.SignCount != 0) && count <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+	return count, nil
+}
+
+// UserHandle returns the user handle of an assertion, empty if the authenticator
+// did not return it
+func UserHandle(r *Response) (string, error) {
+	if r.Response.UserHandle == "" {
+		return "", nil
+	}
+	b, err := decode(r.Response.UserHandle)
+	if err != nil {
+		return "", ErrInvalidResponse
+	}
+	return string(b), nil
+}
+
+// CredentialID returns the credential id of a response in the form stored in Credential.ID
+func CredentialID(r *Response) (string, error) {
+	b, err := decode(r.RawID)
+	if err != nil || len(b) == 0 {
+		return "", ErrInvalidResponse
+	}
+	return encode(b), nil
+}
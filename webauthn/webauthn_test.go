@@ -0,0 +1,83 @@
+package webauthn_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/jllopis/try5/webauthn"
+	"github.com/jllopis/try5/webauthn/webauthntest"
+)
+
+const (
+	flagUserPresent  = webauthntest.FlagUserPresent
+	flagUserVerified = webauthntest.FlagUserVerified
+)
+
+var rp = &webauthn.RelyingParty{ID: "try5.local", Name: "try5", Origins: []string{"https://try5.local"}}
+
+func newAuthenticator(t *testing.T, ed bool) *webauthntest.Authenticator {
+	a, err := webauthntest.New(ed)
+	if err != nil {
+		t.Fatal("Error generating key: ", err)
+	}
+	return a
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	for i, a := range []*webauthntest.Authenticator{newAuthenticator(t, false), newAuthenticator(t, true)} {
+		a.Packed = i == 1
+		r := a.Create(rp.ID, rp.Origins[0], "challenge-1")
+		if c, err := webauthn.Challenge(r); err != nil || c != "challenge-1" {
+			t.Fatal("Wrong challenge: ", c, err)
+		}
+		cred, err := rp.VerifyRegistration(r, "challenge-1", true)
+		if err != nil {
+			t.Fatal("Error verifying registration: ", err)
+		}
+		if id, _ := webauthn.CredentialID(r); cred.ID != id || cred.ID != a.CredentialID() || cred.SignCount != 1 {
+			t.Fatalf("Unexpected credential: %+v", cred)
+		}
+
+		count, err := rp.VerifyAssertion(a.Get(rp.ID, rp.Origins[0], "challenge-2", flagUserPresent|flagUserVerified), "challenge-2", cred, true)
+		if err != nil || count != 2 {
+			t.Fatal("Error verifying assertion: ", count, err)
+		}
+		cred.SignCount = count
+		if _, err := rp.VerifyAssertion(a.Get(rp.ID, rp.Origins[0], "challenge-3", flagUserPresent), "challenge-3", cred, true); err != webauthn.ErrUserNotVerified {
+			t.Fatal("Assertion without user verification accepted: ", err)
+		}
+		if _, err := rp.VerifyAssertion(a.Get(rp.ID, "https://evil.local", "challenge-4", flagUserPresent), "challenge-4", cred, false); err != webauthn.ErrInvalidOrigin {
+			t.Fatal("Assertion from another origin accepted: ", err)
+		}
+		if _, err := rp.VerifyAssertion(a.Get("evil.local", rp.Origins[0], "challenge-5", flagUserPresent), "challenge-5", cred, false); err != webauthn.ErrInvalidRPID {
+			t.Fatal("Assertion for another relying party accepted: ", err)
+		}
+		if _, err := rp.VerifyAssertion(a.Get(rp.ID, rp.Origins[0], "challenge-6", flagUserPresent), "another", cred, false); err != webauthn.ErrInvalidChallenge {
+			t.Fatal("Assertion for another challenge accepted: ", err)
+		}
+		tampered := a.Get(rp.ID, rp.Origins[0], "challenge-7", flagUserPresent)
+		tampered.Response.Signature = base64.RawURLEncoding.EncodeToString(a.Sign([]byte("something else")))
+		if _, err := rp.VerifyAssertion(tampered, "challenge-7", cred, false); err != webauthn.ErrSignature {
+			t.Fatal("Assertion with a wrong signature accepted: ", err)
+		}
+		// a clone replays an older counter
+		a.Count = 1
+		if _, err := rp.VerifyAssertion(a.Get(rp.ID, rp.Origins[0], "challenge-8", flagUserPresent), "challenge-8", cred, false); err != webauthn.ErrSignCount {
+			t.Fatal("Counter regression accepted: ", err)
+		}
+	}
+}
+
+func TestNoSignCount(t *testing.T) {
+	a := newAuthenticator(t, false)
+	a.NoCount = true
+	cred, err := rp.VerifyRegistration(a.Create(rp.ID, rp.Origins[0], "c1"), "c1", false)
+	if err != nil {
+		t.Fatal("Error verifying registration: ", err)
+	}
+	for _, c := range []string{"c2", "c3"} {
+		if _, err := rp.VerifyAssertion(a.Get(rp.ID, rp.Origins[0], c, flagUserPresent), c, cred, false); err != nil {
+			t.Fatal("Assertion of an authenticator without counter refused: ", err)
+		}
+	}
+}
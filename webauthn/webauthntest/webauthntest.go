@@ -0,0 +1,190 @@
+// Package webauthntest provides a software authenticator to test the relying party
+// of the webauthn package: it answers the registration and authentication
+// ceremonies like a browser with a security key would.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/jllopis/try5/webauthn"
+)
+
+// Flags of the authenticator data
+const (
+	FlagUserPresent  = 0x01
+	FlagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// Authenticator is a software authenticator holding a single credential. Count is
+// its signature counter, increased on every ceremony unless NoCount is set. Packed
+// makes the registrations carry a packed self attestation and UserHandle is given
+// back by the assertions.
+type Authenticator struct {
+	ID         []byte
+	Count      uint32
+	NoCount    bool
+	Packed     bool
+	UserHandle string
+	ec         *ecdsa.PrivateKey
+	ed         ed25519.PrivateKey
+}
+
+// New returns an authenticator with a new P-256 credential, or Ed25519 if ed is set
+func New(ed bool) (*Authenticator, error) {
+	a := &Authenticator{ID: make([]byte, 16)}
+	if _, err := rand.Read(a.ID); err != nil {
+		return nil, err
+	}
+	var err error
+	if ed {
+		_, a.ed, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		a.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// CredentialID returns the id of the credential as the relying party stores it
+func (a *Authenticator) CredentialID() string {
+	return encode(a.ID)
+}
+
+// Create answers navigator.credentials.create for the challenge, as called from origin
+func (a *Authenticator) Create(rpID, origin, challenge string) *webauthn.Response {
+	cd := clientDataJSON("webauthn.create", challenge, origin)
+	ad := a.authData(rpID, FlagUserPresent|FlagUserVerified, true)
+	stmt, format := []pair{}, "none"
+	if a.Packed {
+		h := sha256.Sum256(cd)
+		alg := webauthn.AlgES256
+		if a.ed != nil {
+			alg = webauthn.AlgEdDSA
+		}
+		stmt, format = []pair{{"alg", alg}, {"sig", a.Sign(append(append([]byte(nil), ad...), h[:]...))}}, "packed"
+	}
+	r := &webauthn.Response{ID: encode(a.ID), RawID: encode(a.ID), Type: "public-key"}
+	r.Response.ClientDataJSON = encode(cd)
+	r.Response.AttestationObject = encode(encodeCBOR([]pair{{"fmt", format}, {"attStmt", stmt}, {"authData", ad}}))
+	r.Response.Transports = []string{"internal"}
+	return r
+}
+
+// Get answers navigator.credentials.get for the challenge, as called from origin,
+// with the flags given
+func (a *Authenticator) Get(rpID, origin, challenge string, flags byte) *webauthn.Response {
+	cd := clientDataJSON("webauthn.get", challenge, origin)
+	ad := a.authData(rpID, flags, false)
+	h := sha256.Sum256(cd)
+	r := &webauthn.Response{ID: encode(a.ID), RawID: encode(a.ID), Type: "public-key"}
+	r.Response.ClientDataJSON = encode(cd)
+	r.Response.AuthenticatorData = encode(ad)
+	r.Response.Signature = encode(a.Sign(append(append([]byte(nil), ad...), h[:]...)))
+	if a.UserHandle != "" {
+		r.Response.UserHandle = encode([]byte(a.UserHandle))
+	}
+	return r
+}
+
+// Sign signs data with the private key of the credential
+func (a *Authenticator) Sign(data []byte) []byte {
+	if a.ed != nil {
+		return ed25519.Sign(a.ed, data)
+	}
+	h := sha256.Sum256(data)
+	sig, _ := ecdsa.SignASN1(rand.Reader, a.ec, h[:])
+	return sig
+}
+
+func (a *Authenticator) coseKey() []byte {
+	if a.ed != nil {
+		return encodeCBOR([]pair{{1, 1}, {3, webauthn.AlgEdDSA}, {-1, 6}, {-2, []byte(a.ed.Public().(ed25519.PublicKey))}})
+	}
+	x, y := make([]byte, 32), make([]byte, 32)
+	a.ec.X.FillBytes(x)
+	a.ec.Y.FillBytes(y)
+	return encodeCBOR([]pair{{1, 2}, {3, webauthn.AlgES256}, {-1, 1}, {-2, x}, {-3, y}})
+}
+
+func (a *Authenticator) authData(rpID string, flags byte, attested bool) []byte {
+	if !a.NoCount {
+		a.Count++
+	}
+	h := sha256.Sum256([]byte(rpID))
+	b := append(h[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[33:], a.Count)
+	if attested {
+		b[32] |= flagAttestedData
+		b = append(b, make([]byte, 16)...)
+		b = append(b, byte(len(a.ID)>>8), byte(len(a.ID)))
+		b = append(b, a.ID...)
+		b = append(b, a.coseKey()...)
+	}
+	return b
+}
+
+func clientDataJSON(typ, challenge, origin string) []byte {
+	b, _ := json.Marshal(map[string]interface{}{"type": typ, "challenge": challenge, "origin": origin, "crossOrigin": false})
+	return b
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// pair is a map entry, the encoder keeps their order
+type pair struct {
+	k, v interface{}
+}
+
+// encodeCBOR encodes the values used by the authenticator
+func encodeCBOR(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 65536:
+			return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+		}
+		b := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+	switch x := v.(type) {
+	case int:
+		if x < 0 {
+			return head(1, uint64(-1-x))
+		}
+		return head(0, uint64(x))
+	case []byte:
+		return append(head(2, uint64(len(x))), x...)
+	case string:
+		return append(head(3, uint64(len(x))), x...)
+	case []pair:
+		b := head(5, uint64(len(x)))
+		for _, p := range x {
+			b = append(b, encodeCBOR(p.k)...)
+			b = append(b, encodeCBOR(p.v)...)
+		}
+		return b
+	case []interface{}:
+		b := head(4, uint64(len(x)))
+		for _, i := range x {
+			b = append(b, encodeCBOR(i)...)
+		}
+		return b
+	}
+	panic("unsupported type")
+}